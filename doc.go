@@ -27,7 +27,7 @@
 //	func main() {
 //	    // 1. 初始化数据库
 //	    db, _ := gorm.Open(sqlite.Open("workflow.db"), &gorm.Config{})
-//	    db.AutoMigrate(&workflow.WorkflowInstancePo{}, &workflow.WorkflowTaskInstancePo{})
+//	    db.AutoMigrate(&workflow.WorkflowInstancePo{}, &workflow.WorkflowTaskInstancePo{}, &workflow.WorkflowDefinitionPo{})
 //
 //	    // 2. 创建工作流服务
 //	    workflowRepo := workflow.NewWorkflowRepo(db)
@@ -6,6 +6,7 @@ import (
 	"context"
 	"encoding/csv"
 	"os"
+	"sort"
 	"strconv"
 	"sync"
 	"time"
@@ -21,6 +22,8 @@ type CsvRepo struct {
 	workflowInstanceFile string
 	taskInstanceFile     string
 	mu                   sync.RWMutex
+	// 工作流配置只保存在内存中, 示例不需要持久化
+	workflowDefinitions []*workflow.WorkflowDefinitionPo
 }
 
 // NewCsvRepo 创建 CSV 存储实现
@@ -61,7 +64,7 @@ func (c *CsvRepo) writeWorkflowInstanceHeader() {
 	defer file.Close()
 	writer := csv.NewWriter(file)
 	defer writer.Flush()
-	writer.Write([]string{"id", "workflow_type", "business_id", "status", "workflow_context", "task_id", "created_at", "updated_at"})
+	writer.Write([]string{"id", "workflow_type", "business_id", "status", "workflow_context", "task_id", "created_at", "updated_at", "parent_workflow_instance_id", "deadline_at", "workflow_context_history", "idempotency_key", "workflow_version", "next_wake_up_at"})
 }
 
 // writeTaskInstanceHeader 写入 task_instance.csv 表头
@@ -73,7 +76,7 @@ func (c *CsvRepo) writeTaskInstanceHeader() {
 	defer file.Close()
 	writer := csv.NewWriter(file)
 	defer writer.Flush()
-	writer.Write([]string{"id", "workflow_instance_id", "task_type", "status", "fail_count", "node_context", "created_at", "updated_at"})
+	writer.Write([]string{"id", "workflow_instance_id", "task_type", "status", "fail_count", "node_context", "created_at", "updated_at", "next_retry_at", "wake_up_at", "item_index", "generation", "completed_at", "compensation_status"})
 }
 
 // readWorkflowInstances 读取所有 workflow instance
@@ -105,6 +108,31 @@ func (c *CsvRepo) readWorkflowInstances() ([]*workflow.WorkflowInstancePo, error
 		taskID, _ := strconv.ParseInt(record[5], 10, 64)
 		createdAt, _ := strconv.ParseInt(record[6], 10, 64)
 		updatedAt, _ := strconv.ParseInt(record[7], 10, 64)
+		// 兼容旧文件, 新增的列可能不存在
+		parentWorkflowInstanceID := int64(0)
+		if len(record) > 8 {
+			parentWorkflowInstanceID, _ = strconv.ParseInt(record[8], 10, 64)
+		}
+		deadlineAt := int64(0)
+		if len(record) > 9 {
+			deadlineAt, _ = strconv.ParseInt(record[9], 10, 64)
+		}
+		var workflowContextHistory []byte
+		if len(record) > 10 && record[10] != "" {
+			workflowContextHistory = []byte(record[10])
+		}
+		var idempotencyKey *string
+		if len(record) > 11 && record[11] != "" {
+			idempotencyKey = workflow.String(record[11])
+		}
+		workflowVersion := int64(0)
+		if len(record) > 12 {
+			workflowVersion, _ = strconv.ParseInt(record[12], 10, 64)
+		}
+		nextWakeUpAt := int64(0)
+		if len(record) > 13 {
+			nextWakeUpAt, _ = strconv.ParseInt(record[13], 10, 64)
+		}
 
 		instances = append(instances, &workflow.WorkflowInstancePo{
 			ID:              id,
@@ -115,6 +143,13 @@ func (c *CsvRepo) readWorkflowInstances() ([]*workflow.WorkflowInstancePo, error
 			TaskId:          taskID,
 			CreatedAt:       createdAt,
 			UpdatedAt:       updatedAt,
+
+			ParentWorkflowInstanceID: parentWorkflowInstanceID,
+			DeadlineAt:               deadlineAt,
+			WorkflowContextHistory:   workflowContextHistory,
+			IdempotencyKey:           idempotencyKey,
+			WorkflowVersion:          workflowVersion,
+			NextWakeUpAt:             nextWakeUpAt,
 		})
 	}
 	return instances, nil
@@ -132,10 +167,14 @@ func (c *CsvRepo) writeWorkflowInstances(instances []*workflow.WorkflowInstanceP
 	defer writer.Flush()
 
 	// 写入表头
-	writer.Write([]string{"id", "workflow_type", "business_id", "status", "workflow_context", "task_id", "created_at", "updated_at"})
+	writer.Write([]string{"id", "workflow_type", "business_id", "status", "workflow_context", "task_id", "created_at", "updated_at", "parent_workflow_instance_id", "deadline_at", "workflow_context_history", "idempotency_key", "workflow_version", "next_wake_up_at"})
 
 	// 写入数据
 	for _, inst := range instances {
+		idempotencyKey := ""
+		if inst.IdempotencyKey != nil {
+			idempotencyKey = *inst.IdempotencyKey
+		}
 		writer.Write([]string{
 			strconv.FormatInt(inst.ID, 10),
 			inst.WorkflowType,
@@ -145,6 +184,12 @@ func (c *CsvRepo) writeWorkflowInstances(instances []*workflow.WorkflowInstanceP
 			strconv.FormatInt(inst.TaskId, 10),
 			strconv.FormatInt(inst.CreatedAt, 10),
 			strconv.FormatInt(inst.UpdatedAt, 10),
+			strconv.FormatInt(inst.ParentWorkflowInstanceID, 10),
+			strconv.FormatInt(inst.DeadlineAt, 10),
+			string(inst.WorkflowContextHistory),
+			idempotencyKey,
+			strconv.FormatInt(inst.WorkflowVersion, 10),
+			strconv.FormatInt(inst.NextWakeUpAt, 10),
 		})
 	}
 	return nil
@@ -180,6 +225,30 @@ func (c *CsvRepo) readTaskInstances() ([]*workflow.WorkflowTaskInstancePo, error
 		failCount, _ := strconv.ParseInt(record[4], 10, 64)
 		createdAt, _ := strconv.ParseInt(record[6], 10, 64)
 		updatedAt, _ := strconv.ParseInt(record[7], 10, 64)
+		// 兼容旧文件, 新增的列可能不存在
+		nextRetryAt := int64(0)
+		if len(record) > 8 {
+			nextRetryAt, _ = strconv.ParseInt(record[8], 10, 64)
+		}
+		wakeUpAt := int64(0)
+		if len(record) > 9 {
+			wakeUpAt, _ = strconv.ParseInt(record[9], 10, 64)
+		}
+		var itemIndex *int64
+		if len(record) > 10 && record[10] != "" {
+			index, _ := strconv.ParseInt(record[10], 10, 64)
+			itemIndex = &index
+		}
+		generation := int64(0)
+		if len(record) > 11 {
+			generation, _ = strconv.ParseInt(record[11], 10, 64)
+		}
+		completedAt := int64(0)
+		compensationStatus := workflow.CompensationStatusNone
+		if len(record) > 13 {
+			completedAt, _ = strconv.ParseInt(record[12], 10, 64)
+			compensationStatus = record[13]
+		}
 
 		tasks = append(tasks, &workflow.WorkflowTaskInstancePo{
 			ID:                 id,
@@ -190,6 +259,12 @@ func (c *CsvRepo) readTaskInstances() ([]*workflow.WorkflowTaskInstancePo, error
 			NodeContext:        []byte(record[5]),
 			CreatedAt:          createdAt,
 			UpdatedAt:          updatedAt,
+			NextRetryAt:        nextRetryAt,
+			WakeUpAt:           wakeUpAt,
+			ItemIndex:          itemIndex,
+			Generation:         generation,
+			CompletedAt:        completedAt,
+			CompensationStatus: compensationStatus,
 		})
 	}
 	return tasks, nil
@@ -207,10 +282,14 @@ func (c *CsvRepo) writeTaskInstances(tasks []*workflow.WorkflowTaskInstancePo) e
 	defer writer.Flush()
 
 	// 写入表头
-	writer.Write([]string{"id", "workflow_instance_id", "task_type", "status", "fail_count", "node_context", "created_at", "updated_at"})
+	writer.Write([]string{"id", "workflow_instance_id", "task_type", "status", "fail_count", "node_context", "created_at", "updated_at", "next_retry_at", "wake_up_at", "item_index", "generation", "completed_at", "compensation_status"})
 
 	// 写入数据
 	for _, task := range tasks {
+		itemIndex := ""
+		if task.ItemIndex != nil {
+			itemIndex = strconv.FormatInt(*task.ItemIndex, 10)
+		}
 		writer.Write([]string{
 			strconv.FormatInt(task.ID, 10),
 			strconv.FormatInt(task.WorkflowInstanceID, 10),
@@ -220,6 +299,12 @@ func (c *CsvRepo) writeTaskInstances(tasks []*workflow.WorkflowTaskInstancePo) e
 			string(task.NodeContext),
 			strconv.FormatInt(task.CreatedAt, 10),
 			strconv.FormatInt(task.UpdatedAt, 10),
+			strconv.FormatInt(task.NextRetryAt, 10),
+			strconv.FormatInt(task.WakeUpAt, 10),
+			itemIndex,
+			strconv.FormatInt(task.Generation, 10),
+			strconv.FormatInt(task.CompletedAt, 10),
+			task.CompensationStatus,
 		})
 	}
 	return nil
@@ -295,6 +380,18 @@ func (c *CsvRepo) filterWorkflowInstances(instances []*workflow.WorkflowInstance
 		if param.TaskID != nil && inst.TaskId != *param.TaskID {
 			continue
 		}
+		if param.ParentWorkflowInstanceID != nil && inst.ParentWorkflowInstanceID != *param.ParentWorkflowInstanceID {
+			continue
+		}
+		if param.DeadlineAtBefore != nil && (inst.DeadlineAt <= 0 || inst.DeadlineAt > *param.DeadlineAtBefore) {
+			continue
+		}
+		if param.NextWakeUpAtBefore != nil && inst.NextWakeUpAt > *param.NextWakeUpAtBefore {
+			continue
+		}
+		if param.IdempotencyKey != nil && (inst.IdempotencyKey == nil || *inst.IdempotencyKey != *param.IdempotencyKey) {
+			continue
+		}
 		result = append(result, inst)
 	}
 	return result
@@ -313,6 +410,12 @@ func (c *CsvRepo) filterTaskInstances(tasks []*workflow.WorkflowTaskInstancePo,
 		if param.TaskType != nil && task.TaskType != *param.TaskType {
 			continue
 		}
+		if param.IsMapItem != nil && (task.ItemIndex != nil) != *param.IsMapItem {
+			continue
+		}
+		if param.Generation != nil && task.Generation != *param.Generation {
+			continue
+		}
 		if len(param.StatusIn) > 0 {
 			found := false
 			for _, status := range param.StatusIn {
@@ -351,6 +454,76 @@ func (c *CsvRepo) CountWorkflowInstance(ctx context.Context, param *workflow.Que
 	return int64(len(filtered)), nil
 }
 
+// CreateWorkflowDefinition implements workflow.WorkflowRepo.
+func (c *CsvRepo) CreateWorkflowDefinition(ctx context.Context, workflowDefinition *workflow.WorkflowDefinitionPo) (*workflow.WorkflowDefinitionPo, error) {
+	if workflowDefinition == nil {
+		return nil, errors.New("nil WorkflowDefinitionPo")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// 模拟 workflow_type + version 的唯一索引
+	for _, definition := range c.workflowDefinitions {
+		if definition.WorkflowType == workflowDefinition.WorkflowType && definition.Version == workflowDefinition.Version {
+			return nil, errors.Errorf("duplicate workflow definition, workflowType: %s, version: %d", workflowDefinition.WorkflowType, workflowDefinition.Version)
+		}
+	}
+	now := time.Now().Unix()
+	workflowDefinition.ID = int64(len(c.workflowDefinitions) + 1)
+	workflowDefinition.CreatedAt = now
+	workflowDefinition.UpdatedAt = now
+	c.workflowDefinitions = append(c.workflowDefinitions, workflowDefinition)
+	return workflowDefinition, nil
+}
+
+// QueryWorkflowDefinition implements workflow.WorkflowRepo.
+func (c *CsvRepo) QueryWorkflowDefinition(ctx context.Context, param *workflow.QueryWorkflowDefinitionParams) ([]*workflow.WorkflowDefinitionPo, error) {
+	if param == nil {
+		return nil, errors.New("nil QueryWorkflowDefinitionParams")
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	filtered := make([]*workflow.WorkflowDefinitionPo, 0)
+	for _, definition := range c.workflowDefinitions {
+		if param.WorkflowType != nil && definition.WorkflowType != *param.WorkflowType {
+			continue
+		}
+		if param.Version != nil && definition.Version != *param.Version {
+			continue
+		}
+		filtered = append(filtered, definition)
+	}
+	if param.OrderbyVersionAsc != nil {
+		sort.SliceStable(filtered, func(i, j int) bool {
+			if filtered[i].WorkflowType != filtered[j].WorkflowType {
+				return filtered[i].WorkflowType < filtered[j].WorkflowType
+			}
+			if *param.OrderbyVersionAsc {
+				return filtered[i].Version < filtered[j].Version
+			}
+			return filtered[i].Version > filtered[j].Version
+		})
+	}
+	if param.Page != nil && (param.Page.IsNoLimit == nil || !*param.Page.IsNoLimit) && param.Page.Size > 0 {
+		start := int((param.Page.Page - 1) * param.Page.Size)
+		if start < 0 {
+			start = 0
+		}
+		if start >= len(filtered) {
+			return []*workflow.WorkflowDefinitionPo{}, nil
+		}
+		end := start + int(param.Page.Size)
+		if end > len(filtered) {
+			end = len(filtered)
+		}
+		filtered = filtered[start:end]
+	}
+	return filtered, nil
+}
+
 // CreateWorkflowInstance implements workflow.WorkflowRepo.
 func (c *CsvRepo) CreateWorkflowInstance(ctx context.Context, workflowInstance *workflow.WorkflowInstancePo) (*workflow.WorkflowInstancePo, error) {
 	if workflowInstance == nil {
@@ -365,6 +538,15 @@ func (c *CsvRepo) CreateWorkflowInstance(ctx context.Context, workflowInstance *
 		return nil, err
 	}
 
+	// 模拟幂等键的唯一索引
+	if workflowInstance.IdempotencyKey != nil {
+		for _, inst := range instances {
+			if inst.IdempotencyKey != nil && *inst.IdempotencyKey == *workflowInstance.IdempotencyKey {
+				return nil, errors.Errorf("duplicate idempotency_key: %s", *workflowInstance.IdempotencyKey)
+			}
+		}
+	}
+
 	// 生成 ID
 	if workflowInstance.ID == 0 {
 		workflowInstance.ID, err = c.getNextWorkflowInstanceID()
@@ -616,6 +798,21 @@ func (c *CsvRepo) UpdateWorkflowInstance(ctx context.Context, param *workflow.Up
 			if param.Fields.WorkflowContext != nil {
 				inst.WorkflowContext, _ = param.Fields.WorkflowContext.ToBytes()
 			}
+			if param.Fields.WorkflowContextHistory != nil {
+				inst.WorkflowContextHistory = param.Fields.WorkflowContextHistory
+			}
+			if param.Fields.IdempotencyKey != nil {
+				inst.IdempotencyKey = param.Fields.IdempotencyKey
+				if *param.Fields.IdempotencyKey == "" {
+					inst.IdempotencyKey = nil
+				}
+			}
+			if param.Fields.WorkflowVersion != nil {
+				inst.WorkflowVersion = *param.Fields.WorkflowVersion
+			}
+			if param.Fields.NextWakeUpAt != nil {
+				inst.NextWakeUpAt = *param.Fields.NextWakeUpAt
+			}
 			inst.UpdatedAt = now
 			updated++
 			if updated >= param.LimitMax {
@@ -673,6 +870,21 @@ func (c *CsvRepo) UpdateWorkflowTaskInstance(ctx context.Context, param *workflo
 			if param.Fields.FailCount != nil {
 				task.FailCount = *param.Fields.FailCount
 			}
+			if param.Fields.NextRetryAt != nil {
+				task.NextRetryAt = *param.Fields.NextRetryAt
+			}
+			if param.Fields.WakeUpAt != nil {
+				task.WakeUpAt = *param.Fields.WakeUpAt
+			}
+			if param.Fields.CompletedAt != nil {
+				task.CompletedAt = *param.Fields.CompletedAt
+			}
+			if param.Fields.CompensationStatus != nil {
+				task.CompensationStatus = *param.Fields.CompensationStatus
+			}
+			if param.Fields.TaskType != nil {
+				task.TaskType = *param.Fields.TaskType
+			}
 			task.UpdatedAt = now
 			updated++
 			if updated >= param.LimitMax {
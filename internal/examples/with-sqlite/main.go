@@ -24,7 +24,7 @@ func main() {
 
 	// 2. 自动迁移数据库表
 	fmt.Println("正在初始化数据库表...")
-	if err := db.AutoMigrate(&workflow.WorkflowInstancePo{}, &workflow.WorkflowTaskInstancePo{}); err != nil {
+	if err := db.AutoMigrate(&workflow.WorkflowInstancePo{}, &workflow.WorkflowTaskInstancePo{}, &workflow.WorkflowDefinitionPo{}); err != nil {
 		panic(err)
 	}
 
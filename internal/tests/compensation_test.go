@@ -0,0 +1,150 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/blingmoon/simple-workflow/workflow"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// compensatingWorker 实现了补偿接口的工作器
+type compensatingWorker struct {
+	*workflow.NormalTaskWorker
+	compensate func(ctx context.Context, nodeContext *workflow.JSONContext) error
+}
+
+func (w *compensatingWorker) Compensate(ctx context.Context, nodeContext *workflow.JSONContext) error {
+	return w.compensate(ctx, nodeContext)
+}
+
+// setupCompensationWorkflow 结构: reserve -> charge -> ship, reserve 和 charge 支持补偿
+// 返回补偿调用顺序
+func setupCompensationWorkflow(t *testing.T, workflowType string, shipWorker workflow.WorkflowTaskNodeWorker, chargeCompensate func() error) *[]string {
+	loadWorkflowConfigJSON(t, fmt.Sprintf(`{
+		"id": %q,
+		"name": "补偿工作流",
+		"nodes": [
+			{"id": "reserve", "name": "预占库存", "next_nodes": ["charge"]},
+			{"id": "charge", "name": "扣款", "next_nodes": ["ship"]},
+			{"id": "ship", "name": "发货", "next_nodes": []}
+		]
+	}`, workflowType))
+	compensated := make([]string, 0)
+	require.NoError(t, workflow.RegisterWorkflowTask(workflowType, "reserve", &compensatingWorker{
+		NormalTaskWorker: workflow.NewNormalTaskWorker(func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+			nodeContext.Set([]string{"reservation_id"}, "R-1")
+			return nil
+		}, nil),
+		compensate: func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+			reservationID, _ := nodeContext.GetString("reservation_id")
+			compensated = append(compensated, "reserve:"+reservationID)
+			return nil
+		},
+	}))
+	require.NoError(t, workflow.RegisterWorkflowTask(workflowType, "charge", &compensatingWorker{
+		NormalTaskWorker: workflow.NewNormalTaskWorker(func(ctx context.Context, nodeContext *workflow.JSONContext) error { return nil }, nil),
+		compensate: func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+			if err := chargeCompensate(); err != nil {
+				return err
+			}
+			compensated = append(compensated, "charge")
+			nodeContext.Set([]string{"refunded"}, true)
+			return nil
+		},
+	}))
+	require.NoError(t, workflow.RegisterWorkflowTask(workflowType, "ship", shipWorker))
+	return &compensated
+}
+
+// TestCompensationOnFailure 测试节点失败时已完成的节点按照完成顺序倒序补偿
+func TestCompensationOnFailure(t *testing.T) {
+	service := setupTestService(t)
+	ctx := context.Background()
+	compensated := setupCompensationWorkflow(t, "compensation_failure_workflow",
+		workflow.NewNormalTaskWorker(func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+			return errors.WithMessage(workflow.ErrWorkflowTaskFailedWithFailed, "address invalid")
+		}, nil),
+		func() error { return nil })
+
+	instance, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: "compensation_failure_workflow",
+		BusinessID:   "COMP-001",
+	})
+	require.NoError(t, err)
+	assert.ErrorIs(t, service.RunWorkflow(ctx, instance.ID), workflow.ErrWorkflowTaskFailedWithFailed)
+
+	assert.Equal(t, []string{"charge", "reserve:R-1"}, *compensated)
+	charge := queryTaskInstance(t, service, instance.ID, "charge")
+	assert.Equal(t, workflow.CompensationStatusCompleted, charge.CompensationStatus)
+	assert.Greater(t, charge.CompletedAt, int64(0))
+	refunded, _ := charge.NodeContext.GetBool("refunded")
+	assert.True(t, refunded)
+	assert.Equal(t, workflow.CompensationStatusCompleted, queryTaskInstance(t, service, instance.ID, "reserve").CompensationStatus)
+	assert.Equal(t, workflow.CompensationStatusNone, queryTaskInstance(t, service, instance.ID, "ship").CompensationStatus)
+
+	// 已经补偿过的任务实例不会重复补偿
+	require.NoError(t, service.CompensateWorkflowInstance(ctx, instance.ID))
+	assert.Len(t, *compensated, 2)
+}
+
+// TestCompensationResume 测试补偿中断后可以继续补偿, 中断的任务之前的任务不会被跳过
+func TestCompensationResume(t *testing.T) {
+	service := setupTestService(t)
+	ctx := context.Background()
+	chargeErr := errors.New("payment gateway unavailable")
+	compensated := setupCompensationWorkflow(t, "compensation_resume_workflow",
+		workflow.NewNormalTaskWorker(func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+			return errors.WithMessage(workflow.ErrWorkflowTaskFailedWithFailed, "address invalid")
+		}, nil),
+		func() error { return chargeErr })
+
+	instance, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: "compensation_resume_workflow",
+		BusinessID:   "COMP-002",
+	})
+	require.NoError(t, err)
+	assert.ErrorIs(t, service.RunWorkflow(ctx, instance.ID), workflow.ErrWorkflowTaskFailedWithFailed)
+
+	assert.Empty(t, *compensated, "charge补偿失败, reserve需要等待charge补偿完成")
+	charge := queryTaskInstance(t, service, instance.ID, "charge")
+	assert.Equal(t, workflow.CompensationStatusPending, charge.CompensationStatus)
+	lastError, _ := charge.NodeContext.GetString("system", "last_error")
+	assert.Contains(t, lastError, "payment gateway unavailable")
+	assert.Equal(t, workflow.CompensationStatusPending, queryTaskInstance(t, service, instance.ID, "reserve").CompensationStatus)
+
+	chargeErr = nil
+	require.NoError(t, service.CompensateWorkflowInstance(ctx, instance.ID))
+	assert.Equal(t, []string{"charge", "reserve:R-1"}, *compensated)
+	assert.Equal(t, workflow.CompensationStatusCompleted, queryTaskInstance(t, service, instance.ID, "charge").CompensationStatus)
+	assert.Equal(t, workflow.CompensationStatusCompleted, queryTaskInstance(t, service, instance.ID, "reserve").CompensationStatus)
+}
+
+// TestCompensationOnCancel 测试取消工作流时补偿已经完成的任务
+func TestCompensationOnCancel(t *testing.T) {
+	service := setupTestService(t)
+	ctx := context.Background()
+	compensated := setupCompensationWorkflow(t, "compensation_cancel_workflow",
+		workflow.NewNormalTaskWorker(
+			func(ctx context.Context, nodeContext *workflow.JSONContext) error { return nil },
+			func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+				return workflow.ErrorWorkflowTaskInstanceNotReady
+			}),
+		func() error { return nil })
+
+	instance, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: "compensation_cancel_workflow",
+		BusinessID:   "COMP-003",
+		IsRun:        true,
+	})
+	require.NoError(t, err)
+	assert.Empty(t, *compensated)
+	assert.ErrorIs(t, service.CompensateWorkflowInstance(ctx, instance.ID), workflow.ErrWorkflowParamInvalid, "运行中的工作流不能补偿")
+
+	require.NoError(t, service.CancelWorkflowInstance(ctx, instance.ID))
+	assert.Equal(t, []string{"charge", "reserve:R-1"}, *compensated)
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusCancelled, queryTaskInstance(t, service, instance.ID, "ship").Status)
+}
@@ -0,0 +1,252 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/blingmoon/simple-workflow/workflow"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupConcurrencyPolicyWorkflow refund节点记录执行顺序, released为false时一直等待
+func setupConcurrencyPolicyWorkflow(t *testing.T, workflowType string, policy workflow.ConcurrencyPolicy) (*atomic.Bool, func() []string) {
+	loadWorkflowConfigJSON(t, fmt.Sprintf(`{
+		"id": %q,
+		"name": "退款工作流",
+		"concurrency_policy": %q,
+		"nodes": [{"id": "refund", "name": "退款", "next_nodes": []}]
+	}`, workflowType, policy))
+	var released atomic.Bool
+	var mu sync.Mutex
+	order := make([]string, 0)
+	require.NoError(t, workflow.RegisterWorkflowTask(workflowType, "refund",
+		workflow.NewNormalTaskWorker(
+			func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+				refundID, _ := nodeContext.GetString("workflow_context", "refund_id")
+				mu.Lock()
+				defer mu.Unlock()
+				order = append(order, refundID)
+				return nil
+			},
+			func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+				if !released.Load() {
+					return workflow.ErrorWorkflowTaskInstanceNotReady
+				}
+				return nil
+			})))
+	return &released, func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), order...)
+	}
+}
+
+// TestConcurrencyPolicyQueue 测试相同BusinessID的实例排队执行, 前一个结束后下一个自动开始
+func TestConcurrencyPolicyQueue(t *testing.T) {
+	service := setupConcurrentTestService(t)
+	ctx := context.Background()
+	workflowType := "concurrency_queue_workflow"
+	released, executedOrder := setupConcurrencyPolicyWorkflow(t, workflowType, workflow.ConcurrencyPolicyQueue)
+
+	instances := make([]*workflow.WorkflowInstance, 0)
+	for _, refundID := range []string{"R1", "R2", "R3"} {
+		instance, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+			WorkflowType: workflowType,
+			BusinessID:   "ORDER-QUEUE-001",
+			Context:      map[string]any{"refund_id": refundID},
+			IsRun:        true,
+		})
+		require.NoError(t, err)
+		instances = append(instances, instance)
+	}
+	assert.Equal(t, workflow.WorkflowInstanceStatusRunning, queryWorkflowInstanceStatus(t, service, instances[0].ID))
+	assert.Equal(t, workflow.WorkflowInstanceStatusQueued, instances[1].Status)
+	assert.Equal(t, workflow.WorkflowInstanceStatusQueued, queryWorkflowInstanceStatus(t, service, instances[2].ID))
+	assert.Equal(t, []string{"R1"}, executedOrder(), "排队的实例不会执行")
+
+	// 排队中的实例RunWorkflow不会执行
+	require.NoError(t, service.RunWorkflow(ctx, instances[1].ID))
+	assert.Equal(t, workflow.WorkflowInstanceStatusQueued, queryWorkflowInstanceStatus(t, service, instances[1].ID))
+
+	// 不同BusinessID不受影响
+	other, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: workflowType,
+		BusinessID:   "ORDER-QUEUE-002",
+		Context:      map[string]any{"refund_id": "OTHER"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, workflow.WorkflowInstanceStatusInit, other.Status)
+
+	released.Store(true)
+	require.NoError(t, service.RunWorkflow(ctx, instances[0].ID))
+	require.Eventually(t, func() bool {
+		return queryWorkflowInstanceStatus(t, service, instances[2].ID) == workflow.WorkflowInstanceStatusCompleted
+	}, 5*time.Second, 20*time.Millisecond)
+	assert.Equal(t, workflow.WorkflowInstanceStatusCompleted, queryWorkflowInstanceStatus(t, service, instances[1].ID))
+	assert.Equal(t, []string{"R1", "R2", "R3"}, executedOrder())
+}
+
+// TestConcurrencyPolicyQueueCancel 测试取消正在执行的实例后, 排队的实例开始执行
+func TestConcurrencyPolicyQueueCancel(t *testing.T) {
+	service := setupConcurrentTestService(t)
+	ctx := context.Background()
+	workflowType := "concurrency_queue_cancel_workflow"
+	_, executedOrder := setupConcurrencyPolicyWorkflow(t, workflowType, workflow.ConcurrencyPolicyQueue)
+
+	first, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: workflowType,
+		BusinessID:   "ORDER-QUEUE-003",
+		Context:      map[string]any{"refund_id": "R1"},
+		IsRun:        true,
+	})
+	require.NoError(t, err)
+	second, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: workflowType,
+		BusinessID:   "ORDER-QUEUE-003",
+		Context:      map[string]any{"refund_id": "R2"},
+	})
+	require.NoError(t, err)
+	assert.ErrorIs(t, service.PauseWorkflowInstance(ctx, second.ID), workflow.ErrWorkflowParamInvalid, "排队中的实例不能暂停")
+
+	require.NoError(t, service.CancelWorkflowInstance(ctx, first.ID))
+	require.Eventually(t, func() bool {
+		return queryWorkflowInstanceStatus(t, service, second.ID) == workflow.WorkflowInstanceStatusRunning
+	}, 5*time.Second, 20*time.Millisecond)
+	assert.Equal(t, []string{"R1", "R2"}, executedOrder())
+}
+
+// TestConcurrencyPolicyReject 测试已经有未结束的实例时拒绝创建
+func TestConcurrencyPolicyReject(t *testing.T) {
+	service := setupTestService(t)
+	ctx := context.Background()
+	workflowType := "concurrency_reject_workflow"
+	setupConcurrencyPolicyWorkflow(t, workflowType, workflow.ConcurrencyPolicyReject)
+
+	first, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: workflowType,
+		BusinessID:   "ORDER-REJECT-001",
+		IsRun:        true,
+	})
+	require.NoError(t, err)
+	_, err = service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: workflowType,
+		BusinessID:   "ORDER-REJECT-001",
+	})
+	assert.ErrorIs(t, err, workflow.ErrWorkflowConcurrencyRejected)
+
+	// 幂等重试命中已有实例时不会被拒绝
+	retried, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType:      workflowType,
+		BusinessID:        "ORDER-REJECT-002",
+		IdempotencyPolicy: workflow.IdempotencyPolicyActive,
+	})
+	require.NoError(t, err)
+	again, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType:      workflowType,
+		BusinessID:        "ORDER-REJECT-002",
+		IdempotencyPolicy: workflow.IdempotencyPolicyActive,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, retried.ID, again.ID)
+
+	require.NoError(t, service.CancelWorkflowInstance(ctx, first.ID))
+	_, err = service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: workflowType,
+		BusinessID:   "ORDER-REJECT-001",
+	})
+	assert.NoError(t, err, "已经结束的实例不占用名额")
+}
+
+// TestConcurrencyPolicyQueueConcurrentCreate 测试同时创建相同BusinessID的实例, 拿不到业务锁时等待, 只有一个不排队
+func TestConcurrencyPolicyQueueConcurrentCreate(t *testing.T) {
+	service := setupConcurrentTestService(t)
+	ctx := context.Background()
+	workflowType := "concurrency_queue_concurrent_workflow"
+	setupConcurrencyPolicyWorkflow(t, workflowType, workflow.ConcurrencyPolicyQueue)
+
+	var wg sync.WaitGroup
+	var queuedCount, activeCount atomic.Int64
+	errs := make(chan error, 5)
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			instance, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+				WorkflowType: workflowType,
+				BusinessID:   "ORDER-QUEUE-CONCURRENT-001",
+				Context:      map[string]any{"refund_id": fmt.Sprintf("R%d", i)},
+			})
+			if err != nil {
+				errs <- err
+				return
+			}
+			if instance.Status == workflow.WorkflowInstanceStatusQueued {
+				queuedCount.Add(1)
+			} else {
+				activeCount.Add(1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, int64(1), activeCount.Load())
+	assert.Equal(t, int64(4), queuedCount.Load())
+}
+
+// businessLockFailWorkflowLock failing为true时业务锁一直拿不到, 模拟前一个实例结束时没有拿到业务锁
+type businessLockFailWorkflowLock struct {
+	workflow.WorkflowLock
+	failing atomic.Bool
+}
+
+func (l *businessLockFailWorkflowLock) NonBlockingSynchronized(ctx context.Context, key string, maxLockTimeDuration time.Duration, f func(context.Context) error) error {
+	if l.failing.Load() && strings.HasPrefix(key, "workflow_business_") {
+		return workflow.LockFailedError
+	}
+	return l.WorkflowLock.NonBlockingSynchronized(ctx, key, maxLockTimeDuration, f)
+}
+
+// TestConcurrencyPolicyQueueScheduler 测试前一个实例结束时没有开始的排队实例, 由调度器开始执行
+func TestConcurrencyPolicyQueueScheduler(t *testing.T) {
+	lock := &businessLockFailWorkflowLock{WorkflowLock: workflow.NewLocalWorkflowLock()}
+	service := workflow.NewWorkflowService(setupConcurrentTestRepo(t), lock)
+	ctx := context.Background()
+	workflowType := "concurrency_queue_scheduler_workflow"
+	released, executedOrder := setupConcurrencyPolicyWorkflow(t, workflowType, workflow.ConcurrencyPolicyQueue)
+
+	first, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: workflowType,
+		BusinessID:   "ORDER-QUEUE-004",
+		Context:      map[string]any{"refund_id": "R1"},
+		IsRun:        true,
+	})
+	require.NoError(t, err)
+	second, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: workflowType,
+		BusinessID:   "ORDER-QUEUE-004",
+		Context:      map[string]any{"refund_id": "R2"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, workflow.WorkflowInstanceStatusQueued, second.Status)
+
+	lock.failing.Store(true)
+	released.Store(true)
+	require.NoError(t, service.RunWorkflow(ctx, first.ID))
+	assert.Equal(t, workflow.WorkflowInstanceStatusCompleted, queryWorkflowInstanceStatus(t, service, first.ID))
+	assert.Equal(t, workflow.WorkflowInstanceStatusQueued, queryWorkflowInstanceStatus(t, service, second.ID), "没有拿到业务锁, 排队的实例没有开始")
+
+	lock.failing.Store(false)
+	scheduler := workflow.NewScheduler(service, &workflow.SchedulerConfig{WorkflowTypes: []string{workflowType}})
+	require.NoError(t, scheduler.RunOnce(ctx))
+	assert.Equal(t, workflow.WorkflowInstanceStatusCompleted, queryWorkflowInstanceStatus(t, service, second.ID))
+	assert.Equal(t, []string{"R1", "R2"}, executedOrder())
+}
@@ -0,0 +1,104 @@
+package tests
+
+import (
+	"context"
+	"testing"
+
+	"github.com/blingmoon/simple-workflow/workflow"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestConditionalEdges 测试条件分支, 未命中的分支标记为跳过, 汇聚节点把跳过的前置节点当作已满足
+func TestConditionalEdges(t *testing.T) {
+	service := setupTestService(t)
+	ctx := context.Background()
+
+	// 结构: check -> (manager_review | auto_approve) -> notify, auto_approve -> archive -> notify
+	workflowConfigJSON := `{
+		"id": "conditional_edge_workflow",
+		"name": "条件分支工作流",
+		"nodes": [
+			{
+				"id": "check",
+				"name": "检查",
+				"next_nodes": ["manager_review", "auto_approve"],
+				"conditions": {
+					"manager_review": "workflow_context.amount > 10000",
+					"auto_approve": "workflow_context.amount <= 10000"
+				}
+			},
+			{"id": "manager_review", "name": "经理审批", "next_nodes": ["notify"]},
+			{"id": "auto_approve", "name": "自动审批", "next_nodes": ["archive", "notify"]},
+			{"id": "archive", "name": "归档", "next_nodes": ["notify"]},
+			{"id": "notify", "name": "通知", "next_nodes": []}
+		]
+	}`
+	loadWorkflowConfigJSON(t, workflowConfigJSON)
+
+	executed := make(map[string]int)
+	var notifyContext *workflow.JSONContext
+	for _, taskKey := range []string{"check", "manager_review", "auto_approve", "archive", "notify"} {
+		taskKey := taskKey
+		require.NoError(t, workflow.RegisterWorkflowTask("conditional_edge_workflow", taskKey,
+			workflow.NewNormalTaskWorker(
+				func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+					executed[taskKey]++
+					if taskKey == "notify" {
+						notifyContext = nodeContext.Clone()
+					}
+					return nil
+				},
+				nil,
+			)))
+	}
+
+	instance, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: "conditional_edge_workflow",
+		BusinessID:   "CONDITION-001",
+		Context:      map[string]any{"amount": 12000},
+		IsRun:        true,
+	})
+	require.NoError(t, err)
+
+	detail := queryWorkflowInstanceDetail(t, service, instance.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusCompleted, detail.Status)
+	assert.ElementsMatch(t, []string{"auto_approve", "archive"}, detail.SkippedNodes)
+	for _, taskInstance := range detail.TaskInstances {
+		switch taskInstance.TaskType {
+		case "auto_approve", "archive":
+			assert.Equal(t, workflow.WorkflowTaskNodeStatusSkipped, taskInstance.Status)
+		case "check", "manager_review", "notify":
+			assert.Equal(t, workflow.WorkflowTaskNodeStatusCompleted, taskInstance.Status)
+		}
+	}
+	assert.Equal(t, map[string]int{"check": 1, "manager_review": 1, "notify": 1}, executed)
+
+	// 汇聚节点的前置上下文只包含命中分支的节点
+	require.NotNil(t, notifyContext)
+	preNodeContext, ok := notifyContext.Get("pre_node_context")
+	require.True(t, ok)
+	assert.Len(t, preNodeContext, 1)
+	_, ok = notifyContext.Get("pre_node_context", "manager_review")
+	assert.True(t, ok)
+}
+
+// TestConditionalEdgesInvalidConfig 测试条件表达式错误或者条件指向的节点不在next_nodes中
+func TestConditionalEdgesInvalidConfig(t *testing.T) {
+	for workflowType, conditions := range map[string]map[string]string{
+		"conditional_edge_bad_expression": {"next": "workflow_context.amount >"},
+		"conditional_edge_bad_target":     {"other": "true"},
+	} {
+		require.NoError(t, workflow.LoadWorkflowConfig(&workflow.WorkflowConfig{
+			ID:   workflowType,
+			Name: workflowType,
+			Nodes: []*workflow.NodeDefinitionConfig{
+				{ID: "start", Name: "start", NextNodes: []string{"next"}, Conditions: conditions},
+				{ID: "next", Name: "next", NextNodes: []string{}},
+			},
+		}))
+		registerNoopTaskWorkers(t, workflowType, "start", "next")
+		_, err := workflow.GetAndLoadWorkflowDefinition(workflowType)
+		assert.ErrorIs(t, err, workflow.ErrWorkflowParamInvalid, workflowType)
+	}
+}
@@ -0,0 +1,71 @@
+package tests
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/blingmoon/simple-workflow/workflow"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestValidateWorkflowConfig 测试一次返回配置中的所有问题, 严格校验模式下LoadWorkflowConfig失败
+func TestValidateWorkflowConfig(t *testing.T) {
+	config := &workflow.WorkflowConfig{}
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"id": "invalid_config_workflow",
+		"name": "错误配置",
+		"nodes": [
+			{"id": "start", "name": "开始", "next_nodes": ["a", "missing"]},
+			{"id": "a", "name": "A", "next_nodes": ["b"], "fail_max_count": -1},
+			{"id": "b", "name": "B", "next_nodes": ["a"]},
+			{"id": "a", "name": "A2", "next_nodes": []},
+			{"id": "end", "name": "结束", "next_nodes": []},
+			{"id": "", "name": "空", "next_nodes": []},
+			{"id": "x", "name": "X", "next_nodes": ["y"]},
+			{"id": "y", "name": "Y", "next_nodes": ["x", "z"]},
+			{"id": "z", "name": "Z", "next_nodes": []}
+		]
+	}`), config))
+
+	codes := map[string][]string{}
+	for _, problem := range workflow.ValidateWorkflowConfig(config) {
+		codes[problem.Code] = append(codes[problem.Code], problem.NodeID)
+		if problem.Code == workflow.ConfigProblemCycle {
+			assert.Contains(t, problem.Message, "[a b x y]", "环的下游节点不算在环上")
+		}
+	}
+	assert.Equal(t, []string{"a"}, codes[workflow.ConfigProblemDuplicateNode])
+	assert.Equal(t, []string{"end"}, codes[workflow.ConfigProblemReservedID])
+	assert.Equal(t, []string{""}, codes[workflow.ConfigProblemEmptyID])
+	assert.Equal(t, []string{"start"}, codes[workflow.ConfigProblemUnknownNode])
+	assert.Equal(t, []string{"a"}, codes[workflow.ConfigProblemNegativeLimit])
+	assert.Len(t, codes[workflow.ConfigProblemCycle], 1)
+	assert.Equal(t, []string{"z"}, codes[workflow.ConfigProblemUnreachable], "环上的节点只报告环, 环下游的节点报告不可达")
+
+	assert.NoError(t, workflow.LoadWorkflowConfig(config), "默认不做严格校验")
+
+	// 严格校验只对开启的Registry生效
+	registry := workflow.NewRegistry()
+	registry.SetStrictWorkflowConfigValidation(true)
+	config.ID = "invalid_config_workflow_strict"
+	assert.NoError(t, workflow.LoadWorkflowConfig(config), "默认的Registry没有开启严格校验")
+	err := registry.LoadWorkflowConfig(config)
+	assert.ErrorIs(t, err, workflow.ErrWorkflowParamInvalid)
+	var validationErr *workflow.WorkflowConfigValidationError
+	require.True(t, errors.As(err, &validationErr))
+	assert.Equal(t, "invalid_config_workflow_strict", validationErr.WorkflowType)
+	assert.NotEmpty(t, validationErr.Problems)
+
+	valid := &workflow.WorkflowConfig{
+		ID:   "valid_config_workflow_strict",
+		Name: "正确配置",
+		Nodes: []*workflow.NodeDefinitionConfig{
+			{ID: "submit", Name: "提交", NextNodes: []string{"review"}},
+			{ID: "review", Name: "审核", NextNodes: []string{}},
+		},
+	}
+	assert.Empty(t, workflow.ValidateWorkflowConfig(valid))
+	assert.NoError(t, registry.LoadWorkflowConfig(valid))
+}
@@ -0,0 +1,107 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/blingmoon/simple-workflow/workflow"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// buildWideFanOutConfig 构建宽扇出工作流: start -> fan_0..fan_{width-1} -> join
+func buildWideFanOutConfig(workflowType string, width int) *workflow.WorkflowConfig {
+	config := &workflow.WorkflowConfig{ID: workflowType, Name: workflowType}
+	startNextNodes := make([]string, 0, width)
+	for i := 0; i < width; i++ {
+		taskType := fmt.Sprintf("fan_%d", i)
+		startNextNodes = append(startNextNodes, taskType)
+		config.Nodes = append(config.Nodes, &workflow.NodeDefinitionConfig{ID: taskType, Name: taskType, NextNodes: []string{"join"}})
+	}
+	config.Nodes = append(config.Nodes,
+		&workflow.NodeDefinitionConfig{ID: "start", Name: "start", NextNodes: startNextNodes},
+		&workflow.NodeDefinitionConfig{ID: "join", Name: "join", NextNodes: []string{}},
+	)
+	return config
+}
+
+// buildDeepDiamondConfig 构建深度菱形工作流: top_i -> (left_i, right_i) -> top_{i+1}, 最后一个top节点是join
+func buildDeepDiamondConfig(workflowType string, depth int) *workflow.WorkflowConfig {
+	config := &workflow.WorkflowConfig{ID: workflowType, Name: workflowType}
+	for i := 0; i < depth; i++ {
+		top := fmt.Sprintf("top_%d", i)
+		left := fmt.Sprintf("left_%d", i)
+		right := fmt.Sprintf("right_%d", i)
+		next := fmt.Sprintf("top_%d", i+1)
+		if i == depth-1 {
+			next = "join"
+		}
+		config.Nodes = append(config.Nodes,
+			&workflow.NodeDefinitionConfig{ID: top, Name: top, NextNodes: []string{left, right}},
+			&workflow.NodeDefinitionConfig{ID: left, Name: left, NextNodes: []string{next}},
+			&workflow.NodeDefinitionConfig{ID: right, Name: right, NextNodes: []string{next}},
+		)
+	}
+	config.Nodes = append(config.Nodes, &workflow.NodeDefinitionConfig{ID: "join", Name: "join", NextNodes: []string{}})
+	return config
+}
+
+// benchmarkRunStalledWorkflow 所有节点都完成, 只有join节点一直处于等待中, 测试每次RunWorkflow遍历整张图的开销
+func benchmarkRunStalledWorkflow(b *testing.B, config *workflow.WorkflowConfig) {
+	db, _ := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	_ = db.AutoMigrate(&workflow.WorkflowInstancePo{}, &workflow.WorkflowTaskInstancePo{})
+	service := workflow.NewWorkflowService(workflow.NewWorkflowRepo(db), workflow.NewLocalWorkflowLock())
+
+	// 基准测试函数会被多次调用, 重复注册的错误忽略即可
+	_ = workflow.LoadWorkflowConfig(config)
+	for _, node := range config.Nodes {
+		var asynchronousWaitCheck workflow.AsynchronousWaitCheckFunc
+		if node.ID == "join" {
+			asynchronousWaitCheck = func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+				return workflow.ErrorWorkflowTaskInstanceNotReady
+			}
+		}
+		_ = workflow.RegisterWorkflowTask(config.ID, node.ID, workflow.NewNormalTaskWorker(
+			func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+				return nil
+			},
+			asynchronousWaitCheck,
+		))
+	}
+
+	ctx := context.Background()
+	instance, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: config.ID,
+		BusinessID:   "BENCH-DAG",
+		IsRun:        true,
+	})
+	if err != nil {
+		b.Fatalf("CreateWorkflow failed: %v", err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := service.RunWorkflow(ctx, instance.ID); err != nil {
+			b.Fatalf("RunWorkflow failed: %v", err)
+		}
+	}
+}
+
+// BenchmarkRunWorkflowWideFanOut 宽扇出结构, join节点有几百个前置节点
+func BenchmarkRunWorkflowWideFanOut(b *testing.B) {
+	for _, width := range []int{100, 500} {
+		b.Run(fmt.Sprintf("width_%d", width), func(b *testing.B) {
+			benchmarkRunStalledWorkflow(b, buildWideFanOutConfig(fmt.Sprintf("bench_fan_out_%d", width), width))
+		})
+	}
+}
+
+// BenchmarkRunWorkflowDeepDiamond 深度菱形结构, 递归遍历时路径数量随深度指数增长
+func BenchmarkRunWorkflowDeepDiamond(b *testing.B) {
+	for _, depth := range []int{10, 100} {
+		b.Run(fmt.Sprintf("depth_%d", depth), func(b *testing.B) {
+			benchmarkRunStalledWorkflow(b, buildDeepDiamondConfig(fmt.Sprintf("bench_diamond_%d", depth), depth))
+		})
+	}
+}
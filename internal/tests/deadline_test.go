@@ -0,0 +1,169 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/blingmoon/simple-workflow/workflow"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// waitUntilDeadline 等待到截止时间, 截止时间按秒比较
+func waitUntilDeadline(deadlineAt int64) {
+	time.Sleep(time.Until(time.Unix(deadlineAt, 0)))
+}
+
+// TestWorkflowDeadlineTimedOut 测试超过配置的超时时间后, 未完成的任务被取消, 工作流状态变成timed_out
+func TestWorkflowDeadlineTimedOut(t *testing.T) {
+	service := setupTestService(t)
+	ctx := context.Background()
+	workflowType := "deadline_timed_out_workflow"
+	loadWorkflowConfigJSON(t, fmt.Sprintf(`{
+		"id": %q,
+		"name": "入职工作流",
+		"timeout": 1,
+		"nodes": [
+			{"id": "account", "name": "开通账号", "next_nodes": ["training"]},
+			{"id": "training", "name": "完成培训", "next_nodes": []}
+		]
+	}`, workflowType))
+	registerNoopTaskWorkers(t, workflowType, "account")
+	require.NoError(t, workflow.RegisterWorkflowTask(workflowType, "training",
+		workflow.NewNormalTaskWorker(
+			func(ctx context.Context, nodeContext *workflow.JSONContext) error { return nil },
+			func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+				return workflow.ErrorWorkflowTaskInstanceNotReady
+			})))
+
+	instance, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: workflowType,
+		BusinessID:   "DEADLINE-001",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, instance.CreatedAt+1, instance.DeadlineAt)
+
+	require.NoError(t, service.RunWorkflow(ctx, instance.ID))
+	status, taskStatus := queryTaskStatus(t, service, instance.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusRunning, status)
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusPending, taskStatus["training"])
+
+	// 截止时间持久化, 可以查询快要超时的实例
+	overdue, err := service.QueryWorkflowInstancePo(ctx, &workflow.QueryWorkflowInstanceParams{
+		DeadlineAtBefore: workflow.Int64(instance.DeadlineAt),
+		Page:             &workflow.Pager{Page: 1, Size: 10},
+	})
+	require.NoError(t, err)
+	require.Len(t, overdue, 1)
+	assert.Equal(t, instance.ID, overdue[0].ID)
+
+	waitUntilDeadline(instance.DeadlineAt)
+	assert.ErrorIs(t, service.RunWorkflow(ctx, instance.ID), workflow.ErrWorkflowInstanceTimedOut)
+	status, taskStatus = queryTaskStatus(t, service, instance.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusTimedOut, status)
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusCompleted, taskStatus["account"])
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusCancelled, taskStatus["training"])
+
+	// 超时是终止状态, 再次执行不会改变状态
+	assert.ErrorIs(t, service.RunWorkflow(ctx, instance.ID), workflow.ErrWorkflowInstanceTimedOut)
+	status, _ = queryTaskStatus(t, service, instance.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusTimedOut, status)
+}
+
+// TestWorkflowDeadlineTimeoutNode 测试创建时指定截止时间, 超时后执行超时节点, 超时节点结束后工作流状态变成timed_out
+func TestWorkflowDeadlineTimeoutNode(t *testing.T) {
+	service := setupTestService(t)
+	ctx := context.Background()
+	workflowType := "deadline_timeout_node_workflow"
+	loadWorkflowConfigJSON(t, fmt.Sprintf(`{
+		"id": %q,
+		"name": "审批工作流",
+		"timeout_node": "escalate",
+		"nodes": [
+			{"id": "approve", "name": "审批", "next_nodes": []},
+			{"id": "escalate", "name": "升级处理", "next_nodes": []}
+		]
+	}`, workflowType))
+	require.NoError(t, workflow.RegisterWorkflowTask(workflowType, "approve",
+		workflow.NewNormalTaskWorker(
+			func(ctx context.Context, nodeContext *workflow.JSONContext) error { return nil },
+			func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+				return workflow.ErrorWorkflowTaskInstanceNotReady
+			})))
+	escalateChecks := 0
+	require.NoError(t, workflow.RegisterWorkflowTask(workflowType, "escalate",
+		workflow.NewNormalTaskWorker(
+			func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+				applicant, _ := nodeContext.GetString("workflow_context", "applicant")
+				nodeContext.Set([]string{"notified"}, applicant)
+				return nil
+			},
+			func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+				escalateChecks++
+				if escalateChecks == 1 {
+					return workflow.ErrorWorkflowTaskInstanceNotReady
+				}
+				return nil
+			})))
+
+	deadlineAt := time.Now().Unix() + 1
+	instance, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: workflowType,
+		BusinessID:   "DEADLINE-002",
+		Context:      map[string]any{"applicant": "alice"},
+		DeadlineAt:   deadlineAt,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, deadlineAt, instance.DeadlineAt)
+
+	require.NoError(t, service.RunWorkflow(ctx, instance.ID))
+	_, taskStatus := queryTaskStatus(t, service, instance.ID)
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusStatusUnCreated, taskStatus["escalate"], "没有超时之前不会执行超时节点")
+
+	waitUntilDeadline(deadlineAt)
+	// 超时节点还没有结束, 工作流状态不变
+	require.NoError(t, service.RunWorkflow(ctx, instance.ID))
+	status, taskStatus := queryTaskStatus(t, service, instance.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusRunning, status)
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusCancelled, taskStatus["approve"])
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusPending, taskStatus["escalate"])
+
+	assert.ErrorIs(t, service.RunWorkflow(ctx, instance.ID), workflow.ErrWorkflowInstanceTimedOut)
+	status, taskStatus = queryTaskStatus(t, service, instance.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusTimedOut, status)
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusCompleted, taskStatus["escalate"])
+	notified, _ := queryTaskInstance(t, service, instance.ID, "escalate").NodeContext.GetString("notified")
+	assert.Equal(t, "alice", notified)
+}
+
+// TestWorkflowDeadlineInvalidConfig 测试不合法的超时配置会被拒绝
+func TestWorkflowDeadlineInvalidConfig(t *testing.T) {
+	cases := map[string]string{
+		"deadline_invalid_timeout": `"timeout": -1, "nodes": [
+			{"id": "a", "name": "A", "next_nodes": ["b"]},
+			{"id": "b", "name": "B", "next_nodes": []}
+		]`,
+		"deadline_invalid_not_found": `"timeout_node": "c", "nodes": [
+			{"id": "a", "name": "A", "next_nodes": ["b"]},
+			{"id": "b", "name": "B", "next_nodes": []}
+		]`,
+		"deadline_invalid_referenced": `"timeout_node": "b", "nodes": [
+			{"id": "a", "name": "A", "next_nodes": ["b"]},
+			{"id": "b", "name": "B", "next_nodes": []}
+		]`,
+		"deadline_invalid_next_nodes": `"timeout_node": "a", "nodes": [
+			{"id": "a", "name": "A", "next_nodes": ["b"]},
+			{"id": "b", "name": "B", "next_nodes": []}
+		]`,
+	}
+	for workflowType, config := range cases {
+		t.Run(workflowType, func(t *testing.T) {
+			loadWorkflowConfigJSON(t, fmt.Sprintf(`{"id": %q, "name": "非法超时配置", %s}`, workflowType, config))
+			registerNoopTaskWorkers(t, workflowType, "a", "b")
+			_, err := workflow.GetAndLoadWorkflowDefinition(workflowType)
+			assert.ErrorIs(t, err, workflow.ErrWorkflowParamInvalid)
+		})
+	}
+}
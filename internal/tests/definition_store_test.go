@@ -0,0 +1,112 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/blingmoon/simple-workflow/workflow"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestPublishWorkflowDefinition 测试发布工作流配置, 没有加载配置的进程也能创建实例, 执行节点的进程从数据库加载配置
+func TestPublishWorkflowDefinition(t *testing.T) {
+	service := setupTestService(t)
+	ctx := context.Background()
+	workflowType := "published_workflow"
+	config := &workflow.WorkflowConfig{
+		ID:      workflowType,
+		Name:    "发布的工作流",
+		Timeout: 3600,
+		Nodes: []*workflow.NodeDefinitionConfig{
+			{ID: "submit", Name: "提交", NextNodes: []string{}},
+		},
+	}
+	published, err := service.PublishWorkflowDefinition(ctx, config)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), published.Version)
+
+	config.Nodes = []*workflow.NodeDefinitionConfig{
+		{ID: "submit", Name: "提交", NextNodes: []string{"notify"}},
+		{ID: "notify", Name: "通知", NextNodes: []string{}},
+	}
+	published, err = service.PublishWorkflowDefinition(ctx, config)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), published.Version, "没有指定版本号时使用最大版本号加1")
+	assert.Equal(t, int64(0), config.Version, "不会修改传入的配置")
+
+	config.Version = 2
+	_, err = service.PublishWorkflowDefinition(ctx, config)
+	assert.Error(t, err, "相同版本不能重复发布")
+
+	definitions, err := service.ListWorkflowDefinitions(ctx, &workflow.QueryWorkflowDefinitionParams{
+		WorkflowType:      &workflowType,
+		OrderbyVersionAsc: workflow.Bool(true),
+		Page:              &workflow.Pager{IsNoLimit: workflow.Bool(true)},
+	})
+	require.NoError(t, err)
+	require.Len(t, definitions, 2)
+	assert.Equal(t, []int64{1, 2}, []int64{definitions[0].Version, definitions[1].Version})
+
+	latest, err := service.GetPublishedWorkflowConfig(ctx, workflowType, 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), latest.Version)
+	assert.Len(t, latest.Nodes, 2)
+	_, err = service.GetPublishedWorkflowConfig(ctx, "published_workflow_not_exist", 0)
+	assert.ErrorIs(t, err, workflow.ErrWorkflowConfigNotFound)
+
+	// 当前进程还没有加载配置, 使用发布的配置固定版本和计算截止时间
+	instance, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: workflowType,
+		BusinessID:   "PUBLISHED-001",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), instance.WorkflowVersion)
+	assert.Greater(t, instance.DeadlineAt, int64(0))
+
+	notifyCount := 0
+	registerNoopTaskWorkers(t, workflowType, "submit")
+	require.NoError(t, workflow.RegisterWorkflowTask(workflowType, "notify",
+		workflow.NewNormalTaskWorker(func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+			notifyCount++
+			return nil
+		}, nil)))
+	require.NoError(t, service.LoadPublishedWorkflowConfigs(ctx))
+	require.NoError(t, service.LoadPublishedWorkflowConfigs(ctx), "已经加载过的版本跳过")
+
+	require.NoError(t, service.RunWorkflow(ctx, instance.ID))
+	status, taskStatus := queryTaskStatus(t, service, instance.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusCompleted, status)
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusCompleted, taskStatus["notify"])
+	assert.Equal(t, 1, notifyCount)
+}
+
+// TestPublishInvalidWorkflowDefinition 测试没有开启严格校验时, 发布有问题的配置也会返回所有问题
+func TestPublishInvalidWorkflowDefinition(t *testing.T) {
+	service := setupTestService(t)
+	ctx := context.Background()
+	workflowType := "published_invalid_workflow"
+	config := &workflow.WorkflowConfig{
+		ID:   workflowType,
+		Name: "有问题的工作流",
+		Nodes: []*workflow.NodeDefinitionConfig{
+			{ID: "submit", Name: "提交", NextNodes: []string{"review"}},
+			{ID: "review", Name: "审核", NextNodes: []string{"submit"}},
+			{ID: "notify", Name: "通知", NextNodes: []string{"missing"}},
+		},
+	}
+	_, err := service.PublishWorkflowDefinition(ctx, config)
+	assert.ErrorIs(t, err, workflow.ErrWorkflowParamInvalid)
+	var validationErr *workflow.WorkflowConfigValidationError
+	require.True(t, errors.As(err, &validationErr))
+	assert.Equal(t, workflowType, validationErr.WorkflowType)
+	codes := make([]string, 0, len(validationErr.Problems))
+	for _, problem := range validationErr.Problems {
+		codes = append(codes, problem.Code)
+	}
+	assert.ElementsMatch(t, []string{workflow.ConfigProblemCycle, workflow.ConfigProblemUnknownNode}, codes)
+
+	_, err = service.GetPublishedWorkflowConfig(ctx, workflowType, 0)
+	assert.ErrorIs(t, err, workflow.ErrWorkflowConfigNotFound, "有问题的配置不会保存")
+}
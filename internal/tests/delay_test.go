@@ -0,0 +1,80 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/blingmoon/simple-workflow/workflow"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupDelayWorkflow 结构: start -> wait(延时节点) -> remind
+func setupDelayWorkflow(t *testing.T, workflowType string, delay string) {
+	workflowConfigJSON := fmt.Sprintf(`{
+		"id": %q,
+		"name": "延时工作流",
+		"nodes": [
+			{"id": "start", "name": "开始", "next_nodes": ["wait"]},
+			{"id": "wait", "name": "等待", "next_nodes": ["remind"], "kind": "delay", "delay": %s},
+			{"id": "remind", "name": "提醒", "next_nodes": []}
+		]
+	}`, workflowType, delay)
+	loadWorkflowConfigJSON(t, workflowConfigJSON)
+	registerNoopTaskWorkers(t, workflowType, "start", "remind")
+}
+
+// TestDelayNodeDuration 测试固定时长的延时节点, 唤醒时间之前后续节点不会执行
+func TestDelayNodeDuration(t *testing.T) {
+	service := setupTestService(t)
+	ctx := context.Background()
+	setupDelayWorkflow(t, "delay_duration_workflow", `{"duration": 172800}`)
+
+	before := time.Now().Unix()
+	instance, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: "delay_duration_workflow",
+		BusinessID:   "DELAY-001",
+		IsRun:        true,
+	})
+	require.NoError(t, err)
+	require.NoError(t, service.RunWorkflow(ctx, instance.ID))
+
+	wait := queryTaskInstance(t, service, instance.ID, "wait")
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusPending, wait.Status)
+	assert.GreaterOrEqual(t, wait.WakeUpAt, before+172800)
+	assert.LessOrEqual(t, wait.WakeUpAt, time.Now().Unix()+172800)
+	wakeUpAt, ok := wait.NodeContext.GetInt64(workflow.NodeContextKeyDelay, "wake_up_at")
+	assert.True(t, ok)
+	assert.Equal(t, wait.WakeUpAt, wakeUpAt)
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusStatusUnCreated, queryTaskInstance(t, service, instance.ID, "remind").Status)
+}
+
+// TestDelayNodeUntilPath 测试从上下文读取唤醒时间, 时间已经过了直接完成
+func TestDelayNodeUntilPath(t *testing.T) {
+	service := setupTestService(t)
+	ctx := context.Background()
+	setupDelayWorkflow(t, "delay_until_workflow", `{"until_path": "workflow_context.remind_at"}`)
+
+	for i, remindAt := range []any{time.Now().Unix() - 10, time.Now().Add(-time.Minute).Format(time.RFC3339)} {
+		instance, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+			WorkflowType: "delay_until_workflow",
+			BusinessID:   fmt.Sprintf("DELAY-UNTIL-%d", i),
+			Context:      map[string]any{"remind_at": remindAt},
+			IsRun:        true,
+		})
+		require.NoError(t, err)
+		status, _ := queryTaskStatus(t, service, instance.ID)
+		assert.Equal(t, workflow.WorkflowInstanceStatusCompleted, status, "remind_at: %v", remindAt)
+	}
+
+	// 上下文中没有唤醒时间, 工作流失败
+	instance, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: "delay_until_workflow",
+		BusinessID:   "DELAY-UNTIL-MISSING",
+	})
+	require.NoError(t, err)
+	assert.ErrorIs(t, service.RunWorkflow(ctx, instance.ID), workflow.ErrWorkflowTaskFailedWithFailed)
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusFailed, queryTaskInstance(t, service, instance.ID, "wait").Status)
+}
@@ -0,0 +1,90 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/blingmoon/simple-workflow/workflow"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupExecutionTimeoutWorkflow(t *testing.T, workflowType string, callWorker workflow.WorkflowTaskNodeWorker) {
+	loadWorkflowConfigJSON(t, fmt.Sprintf(`{
+		"id": %q,
+		"name": "执行超时工作流",
+		"nodes": [
+			{"id": "call", "name": "调用外部服务", "next_nodes": [], "execution_timeout": 1, "fail_max_count": 2}
+		]
+	}`, workflowType))
+	require.NoError(t, workflow.RegisterWorkflowTask(workflowType, "call", callWorker))
+}
+
+// TestExecutionTimeoutContextDeadline 测试工作器收到带deadline的ctx, 超时按照普通失败处理并计入失败次数
+func TestExecutionTimeoutContextDeadline(t *testing.T) {
+	service := setupTestService(t)
+	ctx := context.Background()
+	var hasDeadline atomic.Bool
+	setupExecutionTimeoutWorkflow(t, "execution_timeout_deadline_workflow",
+		workflow.NewNormalTaskWorker(func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+			_, ok := ctx.Deadline()
+			hasDeadline.Store(ok)
+			<-ctx.Done()
+			return ctx.Err()
+		}, nil))
+
+	instance, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: "execution_timeout_deadline_workflow",
+		BusinessID:   "TIMEOUT-001",
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, service.RunWorkflow(ctx, instance.ID))
+	call := queryTaskInstance(t, service, instance.ID, "call")
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusRunning, call.Status)
+	assert.True(t, hasDeadline.Load())
+	reason, _ := call.NodeContext.GetString(workflow.NodeContextKeyReason)
+	assert.Equal(t, "任务节点执行超时", reason)
+	lastError, _ := call.NodeContext.GetString("system", "last_error")
+	assert.Contains(t, lastError, workflow.ErrWorkflowTaskExecutionTimeout.Error())
+
+	// 第二次超时达到fail_max_count, 工作流失败
+	assert.ErrorIs(t, service.RunWorkflow(ctx, instance.ID), workflow.ErrWorkflowTaskFailedWithFailed)
+	status, taskStatus := queryTaskStatus(t, service, instance.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusFailed, status)
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusFailed, taskStatus["call"])
+}
+
+// TestExecutionTimeoutIgnoredContext 测试工作器不理会ctx时, 超时后不再等待工作器返回
+func TestExecutionTimeoutIgnoredContext(t *testing.T) {
+	service := setupTestService(t)
+	ctx := context.Background()
+	release := make(chan struct{})
+	defer close(release)
+	setupExecutionTimeoutWorkflow(t, "execution_timeout_ignored_workflow",
+		workflow.NewNormalTaskWorker(func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+			<-release
+			nodeContext.Set([]string{"late_write"}, true)
+			return nil
+		}, nil))
+
+	instance, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: "execution_timeout_ignored_workflow",
+		BusinessID:   "TIMEOUT-002",
+	})
+	require.NoError(t, err)
+
+	start := time.Now()
+	require.NoError(t, service.RunWorkflow(ctx, instance.ID))
+	assert.Less(t, time.Since(start), 3*time.Second)
+
+	call := queryTaskInstance(t, service, instance.ID, "call")
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusRunning, call.Status)
+	_, ok := call.NodeContext.Get("late_write")
+	assert.False(t, ok)
+	reason, _ := call.NodeContext.GetString(workflow.NodeContextKeyReason)
+	assert.Equal(t, "任务节点执行超时", reason)
+}
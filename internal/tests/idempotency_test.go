@@ -0,0 +1,157 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/blingmoon/simple-workflow/workflow"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupIdempotencyWorkflow(t *testing.T, workflowType string) *int {
+	loadWorkflowConfigJSON(t, fmt.Sprintf(`{
+		"id": %q,
+		"name": "幂等创建工作流",
+		"nodes": [{"id": "only", "name": "唯一节点", "next_nodes": []}]
+	}`, workflowType))
+	runCount := 0
+	require.NoError(t, workflow.RegisterWorkflowTask(workflowType, "only",
+		workflow.NewNormalTaskWorker(
+			func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+				runCount++
+				return nil
+			},
+			func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+				return workflow.ErrorWorkflowTaskInstanceNotReady
+			})))
+	return &runCount
+}
+
+// TestCreateWorkflowIdempotentActive 测试存在未结束的实例时返回已有实例, 实例结束后可以重新创建
+func TestCreateWorkflowIdempotentActive(t *testing.T) {
+	service := setupTestService(t)
+	ctx := context.Background()
+	workflowType := "idempotent_active_workflow"
+	runCount := setupIdempotencyWorkflow(t, workflowType)
+
+	req := &workflow.CreateWorkflowReq{
+		WorkflowType:      workflowType,
+		BusinessID:        "IDEMPOTENT-001",
+		IsRun:             true,
+		IdempotencyPolicy: workflow.IdempotencyPolicyActive,
+	}
+	first, err := service.CreateWorkflow(ctx, req)
+	require.NoError(t, err)
+	second, err := service.CreateWorkflow(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, first.ID, second.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusRunning, second.Status)
+	assert.Equal(t, 1, *runCount, "命中已有实例时不会再执行")
+	count, err := service.CountWorkflowInstance(ctx, &workflow.QueryWorkflowInstanceParams{BusinessID: &req.BusinessID})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), count)
+
+	// 已经结束的实例不影响创建新的实例
+	require.NoError(t, service.CancelWorkflowInstance(ctx, first.ID))
+	third, err := service.CreateWorkflow(ctx, req)
+	require.NoError(t, err)
+	assert.NotEqual(t, first.ID, third.ID)
+	assert.Equal(t, 2, *runCount)
+	instances, err := service.QueryWorkflowInstancePo(ctx, &workflow.QueryWorkflowInstanceParams{
+		WorkflowInstanceID: &first.ID,
+		Page:               &workflow.Pager{Page: 1, Size: 1},
+	})
+	require.NoError(t, err)
+	require.Len(t, instances, 1)
+	assert.Nil(t, instances[0].IdempotencyKey, "已经结束的实例释放幂等键")
+}
+
+// TestCreateWorkflowIdempotentAny 测试使用显式幂等键时, 任意状态的实例都会被返回
+func TestCreateWorkflowIdempotentAny(t *testing.T) {
+	service := setupTestService(t)
+	ctx := context.Background()
+	workflowType := "idempotent_any_workflow"
+	setupIdempotencyWorkflow(t, workflowType)
+
+	first, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType:      workflowType,
+		BusinessID:        "IDEMPOTENT-002",
+		IdempotencyPolicy: workflow.IdempotencyPolicyAny,
+		IdempotencyKey:    "request-abc",
+	})
+	require.NoError(t, err)
+	require.NoError(t, service.CancelWorkflowInstance(ctx, first.ID))
+
+	// 幂等键相同, 业务ID不同也返回已有实例
+	second, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType:      workflowType,
+		BusinessID:        "IDEMPOTENT-003",
+		IdempotencyPolicy: workflow.IdempotencyPolicyAny,
+		IdempotencyKey:    "request-abc",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, first.ID, second.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusCancelled, second.Status)
+
+	// 没有指定幂等策略时每次都创建新的实例
+	third, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: workflowType,
+		BusinessID:   "IDEMPOTENT-002",
+	})
+	require.NoError(t, err)
+	assert.NotEqual(t, first.ID, third.ID)
+
+	_, err = service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType:      workflowType,
+		BusinessID:        "IDEMPOTENT-004",
+		IdempotencyPolicy: "unknown",
+	})
+	assert.ErrorIs(t, err, workflow.ErrWorkflowParamInvalid)
+}
+
+// TestCreateWorkflowIdempotentConcurrent 测试多个进程同时创建, 唯一索引保证只有一个实例
+func TestCreateWorkflowIdempotentConcurrent(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	require.NoError(t, db.AutoMigrate(&workflow.WorkflowInstancePo{}, &workflow.WorkflowTaskInstancePo{}))
+	workflowType := "idempotent_concurrent_workflow"
+	setupIdempotencyWorkflow(t, workflowType)
+
+	// 每个服务使用独立的锁, 模拟多个进程
+	const workers = 8
+	ids := make([]int64, workers)
+	errs := make([]error, workers)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		service := workflow.NewWorkflowService(workflow.NewWorkflowRepo(db), workflow.NewLocalWorkflowLock())
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			instance, err := service.CreateWorkflow(context.Background(), &workflow.CreateWorkflowReq{
+				WorkflowType:      workflowType,
+				BusinessID:        "IDEMPOTENT-005",
+				IdempotencyPolicy: workflow.IdempotencyPolicyActive,
+			})
+			errs[i] = err
+			if err == nil {
+				ids[i] = instance.ID
+			}
+		}(i)
+	}
+	wg.Wait()
+	for i := 0; i < workers; i++ {
+		require.NoError(t, errs[i])
+		assert.Equal(t, ids[0], ids[i])
+	}
+	var count int64
+	require.NoError(t, db.Model(&workflow.WorkflowInstancePo{}).Where("business_id = ?", "IDEMPOTENT-005").Count(&count).Error)
+	assert.Equal(t, int64(1), count)
+}
@@ -0,0 +1,126 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/blingmoon/simple-workflow/workflow"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupReviewJoinWorkflow 结构: start -> (reviewer_a, reviewer_b, reviewer_c) -> decide
+// reviewer节点在approved中标记之前一直处于等待中
+func setupReviewJoinWorkflow(t *testing.T, workflowType string, join string) (map[string]bool, *workflow.JSONContext) {
+	workflowConfigJSON := fmt.Sprintf(`{
+		"id": %q,
+		"name": "汇聚测试工作流",
+		"nodes": [
+			{"id": "start", "name": "开始", "next_nodes": ["reviewer_a", "reviewer_b", "reviewer_c"]},
+			{"id": "reviewer_a", "name": "审批人A", "next_nodes": ["decide"]},
+			{"id": "reviewer_b", "name": "审批人B", "next_nodes": ["decide"]},
+			{"id": "reviewer_c", "name": "审批人C", "next_nodes": ["decide"]},
+			{"id": "decide", "name": "决策", "next_nodes": [], "join": %s}
+		]
+	}`, workflowType, join)
+	loadWorkflowConfigJSON(t, workflowConfigJSON)
+
+	approved := make(map[string]bool)
+	decideContext := workflow.NewJSONContext(nil)
+	registerNoopTaskWorkers(t, workflowType, "start")
+	for _, taskKey := range []string{"reviewer_a", "reviewer_b", "reviewer_c"} {
+		taskKey := taskKey
+		require.NoError(t, workflow.RegisterWorkflowTask(workflowType, taskKey,
+			workflow.NewNormalTaskWorker(
+				func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+					if !approved[taskKey] {
+						return workflow.ErrorWorkflowTaskInstanceNotReady
+					}
+					nodeContext.Set([]string{"approved"}, true)
+					return nil
+				},
+				nil,
+			)))
+	}
+	require.NoError(t, workflow.RegisterWorkflowTask(workflowType, "decide",
+		workflow.NewNormalTaskWorker(
+			func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+				preNodeContext, _ := nodeContext.Get("pre_node_context")
+				decideContext.Set([]string{"pre_node_context"}, preNodeContext)
+				return nil
+			},
+			nil,
+		)))
+	return approved, decideContext
+}
+
+// TestJoinNOfMCancelRemaining 测试2/3汇聚, 汇聚节点执行后取消剩余的前置节点
+func TestJoinNOfMCancelRemaining(t *testing.T) {
+	service := setupTestService(t)
+	ctx := context.Background()
+	approved, decideContext := setupReviewJoinWorkflow(t, "join_n_of_m_workflow", `{"mode": "n_of_m", "count": 2, "cancel_remaining": true}`)
+
+	instance, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: "join_n_of_m_workflow",
+		BusinessID:   "JOIN-001",
+		IsRun:        true,
+	})
+	require.NoError(t, err)
+
+	// 只有一个审批人通过, 还不满足汇聚条件
+	approved["reviewer_a"] = true
+	require.NoError(t, service.RunWorkflow(ctx, instance.ID))
+	_, taskStatus := queryTaskStatus(t, service, instance.ID)
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusStatusUnCreated, taskStatus["decide"])
+
+	approved["reviewer_c"] = true
+	require.NoError(t, service.RunWorkflow(ctx, instance.ID))
+	workflowStatus, taskStatus := queryTaskStatus(t, service, instance.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusCompleted, workflowStatus)
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusCompleted, taskStatus["decide"])
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusSkipped, taskStatus["reviewer_b"])
+
+	// pre_node_context只包含已经完成的前置节点
+	preNodeContext, ok := decideContext.Get("pre_node_context")
+	require.True(t, ok)
+	assert.Len(t, preNodeContext, 2)
+	_, ok = decideContext.Get("pre_node_context", "reviewer_b")
+	assert.False(t, ok)
+}
+
+// TestJoinAnyLeaveRunning 测试任意一个前置节点完成就执行, 剩余前置节点继续执行
+func TestJoinAnyLeaveRunning(t *testing.T) {
+	service := setupTestService(t)
+	ctx := context.Background()
+	approved, decideContext := setupReviewJoinWorkflow(t, "join_any_workflow", `{"mode": "any"}`)
+
+	approved["reviewer_b"] = true
+	instance, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: "join_any_workflow",
+		BusinessID:   "JOIN-002",
+		IsRun:        true,
+	})
+	require.NoError(t, err)
+
+	_, taskStatus := queryTaskStatus(t, service, instance.ID)
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusCompleted, taskStatus["decide"])
+	assert.NotEqual(t, workflow.WorkflowTaskNodeStatusSkipped, taskStatus["reviewer_a"])
+	assert.NotEqual(t, workflow.WorkflowTaskNodeStatusSkipped, taskStatus["reviewer_c"])
+	preNodeContext, ok := decideContext.Get("pre_node_context")
+	require.True(t, ok)
+	assert.Len(t, preNodeContext, 1)
+
+	// 剩余的前置节点继续执行, 完成后不会再次触发汇聚节点
+	approved["reviewer_a"] = true
+	require.NoError(t, service.RunWorkflow(ctx, instance.ID))
+	_, taskStatus = queryTaskStatus(t, service, instance.ID)
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusCompleted, taskStatus["reviewer_a"])
+}
+
+// TestJoinInvalidConfig 测试n_of_m的数量超过前置节点数量
+func TestJoinInvalidConfig(t *testing.T) {
+	setupReviewJoinWorkflow(t, "join_invalid_workflow", `{"mode": "n_of_m", "count": 4}`)
+	_, err := workflow.GetAndLoadWorkflowDefinition("join_invalid_workflow")
+	assert.ErrorIs(t, err, workflow.ErrWorkflowParamInvalid)
+}
@@ -0,0 +1,196 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/blingmoon/simple-workflow/workflow"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestLoopEdgeReviewRevise 测试 revise -> review -(驳回)-> revise 的循环, 每一轮的任务实例都保留
+func TestLoopEdgeReviewRevise(t *testing.T) {
+	service := setupTestService(t)
+	ctx := context.Background()
+	workflowType := "loop_review_workflow"
+	loadWorkflowConfigJSON(t, fmt.Sprintf(`{
+		"id": %q,
+		"name": "循环审核工作流",
+		"nodes": [
+			{"id": "revise", "name": "修改", "next_nodes": ["review"]},
+			{"id": "review", "name": "审核", "next_nodes": ["publish"],
+				"conditions": {"publish": "approved == true"},
+				"loops": [{"target": "revise", "condition": "approved == false", "max_iterations": 3}]},
+			{"id": "publish", "name": "发布", "next_nodes": []}
+		]
+	}`, workflowType))
+	reviewCount := 0
+	require.NoError(t, workflow.RegisterWorkflowTask(workflowType, "revise",
+		workflow.NewNormalTaskWorker(func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+			nodeContext.Set([]string{"version"}, reviewCount+1)
+			return nil
+		}, nil)))
+	require.NoError(t, workflow.RegisterWorkflowTask(workflowType, "review",
+		workflow.NewNormalTaskWorker(func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+			reviewCount++
+			// 前两次驳回, 第三次通过
+			nodeContext.Set([]string{"approved"}, reviewCount >= 3)
+			return nil
+		}, nil)))
+	registerNoopTaskWorkers(t, workflowType, "publish")
+
+	instance, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: workflowType,
+		BusinessID:   "LOOP-001",
+		IsRun:        true,
+	})
+	require.NoError(t, err)
+
+	status, taskStatus := queryTaskStatus(t, service, instance.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusCompleted, status)
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusCompleted, taskStatus["publish"])
+	assert.Equal(t, 3, reviewCount)
+
+	review := queryTaskInstance(t, service, instance.ID, "review")
+	assert.Equal(t, int64(2), review.Generation)
+	require.Len(t, review.History, 2)
+	for i, history := range review.History {
+		assert.Equal(t, int64(i), history.Generation)
+		assert.Equal(t, workflow.WorkflowTaskNodeStatusCompleted, history.Status)
+		approved, _ := history.NodeContext.GetBool("approved")
+		assert.False(t, approved)
+	}
+	revise := queryTaskInstance(t, service, instance.ID, "revise")
+	assert.Equal(t, int64(2), revise.Generation)
+	require.Len(t, revise.History, 2)
+	version, _ := revise.NodeContext.GetInt64("version")
+	assert.Equal(t, int64(3), version)
+	assert.Empty(t, queryTaskInstance(t, service, instance.ID, "publish").History)
+}
+
+// TestLoopEdgeMaxIterations 测试节点回到自己的循环, 次数用完后按照next_nodes继续执行
+func TestLoopEdgeMaxIterations(t *testing.T) {
+	service := setupTestService(t)
+	ctx := context.Background()
+	workflowType := "loop_max_iterations_workflow"
+	loadWorkflowConfigJSON(t, fmt.Sprintf(`{
+		"id": %q,
+		"name": "重试循环工作流",
+		"nodes": [
+			{"id": "poll", "name": "查询", "next_nodes": ["finish"],
+				"loops": [{"target": "poll", "condition": "ready != true", "max_iterations": 2}]},
+			{"id": "finish", "name": "完成", "next_nodes": []}
+		]
+	}`, workflowType))
+	pollCount := 0
+	require.NoError(t, workflow.RegisterWorkflowTask(workflowType, "poll",
+		workflow.NewNormalTaskWorker(func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+			pollCount++
+			nodeContext.Set([]string{"ready"}, false)
+			return nil
+		}, nil)))
+	registerNoopTaskWorkers(t, workflowType, "finish")
+
+	instance, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: workflowType,
+		BusinessID:   "LOOP-002",
+		IsRun:        true,
+	})
+	require.NoError(t, err)
+
+	status, taskStatus := queryTaskStatus(t, service, instance.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusCompleted, status)
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusCompleted, taskStatus["finish"])
+	assert.Equal(t, 3, pollCount, "首次执行加上两次循环")
+	poll := queryTaskInstance(t, service, instance.ID, "poll")
+	assert.Equal(t, int64(2), poll.Generation)
+	assert.Len(t, poll.History, 2)
+}
+
+// TestLoopEdgeNested 测试循环体中还有循环, 每条循环边的次数单独计算, 外层循环的每一轮内层循环的次数重新计算
+func TestLoopEdgeNested(t *testing.T) {
+	service := setupTestService(t)
+	ctx := context.Background()
+	workflowType := "loop_nested_workflow"
+	loadWorkflowConfigJSON(t, fmt.Sprintf(`{
+		"id": %q,
+		"name": "嵌套循环工作流",
+		"nodes": [
+			{"id": "prepare", "name": "准备", "next_nodes": ["fetch"]},
+			{"id": "fetch", "name": "拉取", "next_nodes": ["check"]},
+			{"id": "check", "name": "检查", "next_nodes": ["settle"],
+				"loops": [{"target": "fetch", "condition": "retry == true", "max_iterations": 2}]},
+			{"id": "settle", "name": "结算", "next_nodes": ["finish"],
+				"loops": [{"target": "prepare", "condition": "again == true", "max_iterations": 2}]},
+			{"id": "finish", "name": "完成", "next_nodes": []}
+		]
+	}`, workflowType))
+	counts := map[string]int{}
+	register := func(taskKey string, key string) {
+		require.NoError(t, workflow.RegisterWorkflowTask(workflowType, taskKey,
+			workflow.NewNormalTaskWorker(func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+				counts[taskKey]++
+				if key != "" {
+					nodeContext.Set([]string{key}, true)
+				}
+				return nil
+			}, nil)))
+	}
+	register("prepare", "")
+	register("fetch", "")
+	register("check", "retry")
+	register("settle", "again")
+	register("finish", "")
+
+	instance, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: workflowType,
+		BusinessID:   "LOOP-003",
+		IsRun:        true,
+	})
+	require.NoError(t, err)
+
+	status, taskStatus := queryTaskStatus(t, service, instance.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusCompleted, status)
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusCompleted, taskStatus["finish"])
+	assert.Equal(t, 3, counts["settle"], "外层循环首次执行加上两次循环")
+	assert.Equal(t, 3, counts["prepare"])
+	assert.Equal(t, 9, counts["check"], "外层循环的每一轮内层循环都执行三次")
+	assert.Equal(t, 9, counts["fetch"])
+	assert.Equal(t, 1, counts["finish"])
+	check := queryTaskInstance(t, service, instance.ID, "check")
+	assert.Equal(t, int64(8), check.Generation)
+	iterations, _ := check.NodeContext.GetInt64("system", "loop_iterations", "fetch")
+	assert.Equal(t, int64(2), iterations)
+}
+
+// TestLoopEdgeInvalid 测试没有标记的环以及不合法的循环边会被拒绝
+func TestLoopEdgeInvalid(t *testing.T) {
+	cases := map[string]string{
+		"loop_invalid_unmarked_cycle": `[
+			{"id": "a", "name": "A", "next_nodes": ["b"]},
+			{"id": "b", "name": "B", "next_nodes": ["a"]}
+		]`,
+		"loop_invalid_not_ancestor": `[
+			{"id": "a", "name": "A", "next_nodes": ["b"], "loops": [{"target": "b", "condition": "x == 1", "max_iterations": 1}]},
+			{"id": "b", "name": "B", "next_nodes": []}
+		]`,
+		"loop_invalid_max_iterations": `[
+			{"id": "a", "name": "A", "next_nodes": ["b"]},
+			{"id": "b", "name": "B", "next_nodes": [], "loops": [{"target": "a", "condition": "x == 1"}]}
+		]`,
+		"loop_invalid_condition": `[
+			{"id": "a", "name": "A", "next_nodes": ["b"]},
+			{"id": "b", "name": "B", "next_nodes": [], "loops": [{"target": "a", "max_iterations": 1}]}
+		]`,
+	}
+	for workflowType, nodes := range cases {
+		t.Run(workflowType, func(t *testing.T) {
+			loadWorkflowConfigJSON(t, fmt.Sprintf(`{"id": %q, "name": "非法循环", "nodes": %s}`, workflowType, nodes))
+			registerNoopTaskWorkers(t, workflowType, "a", "b")
+			_, err := workflow.GetAndLoadWorkflowDefinition(workflowType)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,222 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/blingmoon/simple-workflow/workflow"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupMapWorkflow 结构: start -> ship(map节点) -> notify
+func setupMapWorkflow(t *testing.T, workflowType string, mapConfig string, shipWorker workflow.WorkflowTaskNodeWorker) *workflow.JSONContext {
+	workflowConfigJSON := fmt.Sprintf(`{
+		"id": %q,
+		"name": "map工作流",
+		"nodes": [
+			{"id": "start", "name": "开始", "next_nodes": ["ship"]},
+			{"id": "ship", "name": "发货", "next_nodes": ["notify"], "kind": "map", "map": %s},
+			{"id": "notify", "name": "通知", "next_nodes": []}
+		]
+	}`, workflowType, mapConfig)
+	loadWorkflowConfigJSON(t, workflowConfigJSON)
+	registerNoopTaskWorkers(t, workflowType, "start")
+	require.NoError(t, workflow.RegisterWorkflowTask(workflowType, "ship", shipWorker))
+	notifyContext := workflow.NewJSONContext(nil)
+	require.NoError(t, workflow.RegisterWorkflowTask(workflowType, "notify",
+		workflow.NewNormalTaskWorker(func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+			results, _ := nodeContext.Get(workflow.NodeContextKeyPreNodeContext, "ship", workflow.NodeContextKeyMap, "results")
+			notifyContext.Set([]string{"results"}, results)
+			return nil
+		}, nil)))
+	return notifyContext
+}
+
+// TestMapNodeConcurrency 测试map节点按照并发上限创建子任务, 输出按下标汇总
+func TestMapNodeConcurrency(t *testing.T) {
+	service := setupConcurrentTestService(t)
+	ctx := context.Background()
+	var released atomic.Bool
+	notifyContext := setupMapWorkflow(t, "map_concurrency_workflow", `{"items_path": "workflow_context.orders", "concurrency": 2}`,
+		workflow.NewNormalTaskWorker(
+			func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+				orderID, _ := nodeContext.GetString(workflow.NodeContextKeyMap, "item", "id")
+				nodeContext.Set([]string{"tracking_no"}, "T-"+orderID)
+				return nil
+			},
+			func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+				if !released.Load() {
+					return workflow.ErrorWorkflowTaskInstanceNotReady
+				}
+				return nil
+			},
+		))
+
+	instance, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: "map_concurrency_workflow",
+		BusinessID:   "MAP-001",
+		Context: map[string]any{
+			"orders": []any{map[string]any{"id": "A"}, map[string]any{"id": "B"}, map[string]any{"id": "C"}},
+		},
+		IsRun: true,
+	})
+	require.NoError(t, err)
+
+	ship := queryTaskInstance(t, service, instance.ID, "ship")
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusPending, ship.Status)
+	count, _ := ship.NodeContext.GetInt64(workflow.NodeContextKeyMap, "count")
+	assert.Equal(t, int64(3), count)
+	require.Len(t, ship.Items, 2, "并发上限为2, 只创建前两个子任务")
+	for i, item := range ship.Items {
+		require.NotNil(t, item.ItemIndex)
+		assert.Equal(t, int64(i), *item.ItemIndex)
+		assert.Equal(t, workflow.WorkflowTaskNodeStatusPending, item.Status)
+	}
+
+	released.Store(true)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, service.RunWorkflow(ctx, instance.ID))
+	}
+
+	status, _ := queryTaskStatus(t, service, instance.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusCompleted, status)
+	ship = queryTaskInstance(t, service, instance.ID, "ship")
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusCompleted, ship.Status)
+	require.Len(t, ship.Items, 3)
+	results, ok := notifyContext.Get("results")
+	require.True(t, ok)
+	require.Len(t, results, 3)
+	for i, orderID := range []string{"A", "B", "C"} {
+		output, _ := results.([]any)[i].(map[string]any)
+		assert.Equal(t, "T-"+orderID, output["tracking_no"])
+	}
+}
+
+// TestMapNodeItemFailed 测试子任务失败时map节点和工作流失败
+func TestMapNodeItemFailed(t *testing.T) {
+	service := setupConcurrentTestService(t)
+	ctx := context.Background()
+	setupMapWorkflow(t, "map_failed_workflow", `{"items_path": "workflow_context.orders"}`,
+		workflow.NewNormalTaskWorker(func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+			if index, _ := nodeContext.GetInt64(workflow.NodeContextKeyMap, "item_index"); index == 1 {
+				return errors.WithMessage(workflow.ErrWorkflowTaskFailedWithFailed, "order can not ship")
+			}
+			return nil
+		}, nil))
+
+	instance, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: "map_failed_workflow",
+		BusinessID:   "MAP-002",
+		Context:      map[string]any{"orders": []any{"A", "B"}},
+	})
+	require.NoError(t, err)
+	err = service.RunWorkflow(ctx, instance.ID)
+	assert.ErrorIs(t, err, workflow.ErrWorkflowTaskFailedWithFailed)
+
+	status, taskStatus := queryTaskStatus(t, service, instance.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusFailed, status)
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusFailed, taskStatus["ship"])
+	ship := queryTaskInstance(t, service, instance.ID, "ship")
+	require.Len(t, ship.Items, 2)
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusFailed, ship.Items[1].Status)
+}
+
+// workflowFailedRecordRepo 工作流实例被标记为失败时, 记录当时指定节点的状态
+type workflowFailedRecordRepo struct {
+	workflow.WorkflowRepo
+	taskType                     string
+	taskStatusWhenWorkflowFailed string
+}
+
+func (r *workflowFailedRecordRepo) UpdateWorkflowInstance(ctx context.Context, param *workflow.UpdateWorkflowInstanceParams) error {
+	if param.Fields != nil && param.Fields.Status != nil && *param.Fields.Status == workflow.WorkflowInstanceStatusFailed && len(param.Where.IDIn) == 1 {
+		taskInstances, err := r.WorkflowRepo.QueryWorkflowTaskInstance(ctx, &workflow.QueryWorkflowTaskInstanceParams{
+			WorkflowInstanceID: &param.Where.IDIn[0],
+			TaskType:           &r.taskType,
+			IsMapItem:          workflow.Bool(false),
+			Page:               &workflow.Pager{Page: 1, Size: 1},
+		})
+		if err == nil && len(taskInstances) == 1 {
+			r.taskStatusWhenWorkflowFailed = taskInstances[0].Status
+		}
+	}
+	return r.WorkflowRepo.UpdateWorkflowInstance(ctx, param)
+}
+
+// TestMapNodeItemFailedDecidedByMapNode 测试子任务达到失败次数上限时只更新子任务, 由map节点失败之后再把工作流标记为失败
+func TestMapNodeItemFailedDecidedByMapNode(t *testing.T) {
+	repo := &workflowFailedRecordRepo{WorkflowRepo: setupConcurrentTestRepo(t), taskType: "ship"}
+	service := workflow.NewWorkflowService(repo, workflow.NewLocalWorkflowLock())
+	ctx := context.Background()
+	workflowType := "map_item_fail_max_workflow"
+	loadWorkflowConfigJSON(t, fmt.Sprintf(`{
+		"id": %q,
+		"name": "map子任务失败次数上限",
+		"nodes": [
+			{"id": "ship", "name": "发货", "next_nodes": [], "kind": "map", "fail_max_count": 2, "map": {"items_path": "workflow_context.orders"}}
+		]
+	}`, workflowType))
+	require.NoError(t, workflow.RegisterWorkflowTask(workflowType, "ship",
+		workflow.NewNormalTaskWorker(func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+			if index, _ := nodeContext.GetInt64(workflow.NodeContextKeyMap, "item_index"); index == 0 {
+				return errors.New("ship service unavailable")
+			}
+			return nil
+		}, nil)))
+
+	instance, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: workflowType,
+		BusinessID:   "MAP-005",
+		Context:      map[string]any{"orders": []any{"A", "B"}},
+	})
+	require.NoError(t, err)
+	require.NoError(t, service.RunWorkflow(ctx, instance.ID), "第一次失败等待重试")
+	status, taskStatus := queryTaskStatus(t, service, instance.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusRunning, status)
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusPending, taskStatus["ship"])
+
+	err = service.RunWorkflow(ctx, instance.ID)
+	assert.ErrorIs(t, err, workflow.ErrWorkflowTaskFailedWithFailed)
+	status, taskStatus = queryTaskStatus(t, service, instance.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusFailed, status)
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusFailed, taskStatus["ship"])
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusFailed, repo.taskStatusWhenWorkflowFailed, "map节点先失败, 工作流再失败")
+	ship := queryTaskInstance(t, service, instance.ID, "ship")
+	require.Len(t, ship.Items, 2)
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusFailed, ship.Items[0].Status)
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusCompleted, ship.Items[1].Status)
+	reason, _ := ship.NodeContext.GetString(workflow.NodeContextKeyReason)
+	assert.Equal(t, "map节点的子任务失败", reason)
+}
+
+// TestMapNodeInvalidItems 测试输入不是数组时map节点失败
+func TestMapNodeInvalidItems(t *testing.T) {
+	service := setupConcurrentTestService(t)
+	ctx := context.Background()
+	setupMapWorkflow(t, "map_invalid_items_workflow", `{"items_path": "workflow_context.orders"}`,
+		workflow.NewNormalTaskWorker(func(ctx context.Context, nodeContext *workflow.JSONContext) error { return nil }, nil))
+
+	instance, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: "map_invalid_items_workflow",
+		BusinessID:   "MAP-003",
+		Context:      map[string]any{"orders": "A"},
+	})
+	require.NoError(t, err)
+	assert.ErrorIs(t, service.RunWorkflow(ctx, instance.ID), workflow.ErrWorkflowTaskFailedWithFailed)
+
+	ship := queryTaskInstance(t, service, instance.ID, "ship")
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusFailed, ship.Status)
+	assert.Empty(t, ship.Items)
+}
+
+// TestMapNodeConfigInvalid 测试map配置缺少items_path
+func TestMapNodeConfigInvalid(t *testing.T) {
+	setupMapWorkflow(t, "map_config_invalid_workflow", `{}`,
+		workflow.NewNormalTaskWorker(func(ctx context.Context, nodeContext *workflow.JSONContext) error { return nil }, nil))
+	_, err := workflow.GetAndLoadWorkflowDefinition("map_config_invalid_workflow")
+	assert.ErrorIs(t, err, workflow.ErrWorkflowParamInvalid)
+}
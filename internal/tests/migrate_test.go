@@ -0,0 +1,127 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/blingmoon/simple-workflow/workflow"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestMigrateWorkflowInstance 测试等待审核的实例迁移到新版本: review改名为approve, 新增notify节点
+func TestMigrateWorkflowInstance(t *testing.T) {
+	service := setupTestService(t)
+	ctx := context.Background()
+	workflowType := "migrate_workflow"
+	loadWorkflowConfigJSON(t, fmt.Sprintf(`{
+		"id": %q,
+		"name": "迁移工作流",
+		"version": 1,
+		"nodes": [
+			{"id": "submit", "name": "提交", "next_nodes": ["review"]},
+			{"id": "review", "name": "审核", "next_nodes": ["archive"]},
+			{"id": "archive", "name": "归档", "next_nodes": []}
+		]
+	}`, workflowType))
+	approved, notifyCount := false, 0
+	waitApproved := func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+		if !approved {
+			return workflow.ErrorWorkflowTaskInstanceNotReady
+		}
+		return nil
+	}
+	noop := func(ctx context.Context, nodeContext *workflow.JSONContext) error { return nil }
+	registerNoopTaskWorkers(t, workflowType, "submit", "precheck", "archive")
+	require.NoError(t, workflow.RegisterWorkflowTask(workflowType, "review", workflow.NewNormalTaskWorker(noop, waitApproved)))
+	require.NoError(t, workflow.RegisterWorkflowTask(workflowType, "approve", workflow.NewNormalTaskWorker(noop, waitApproved)))
+	require.NoError(t, workflow.RegisterWorkflowTask(workflowType, "notify",
+		workflow.NewNormalTaskWorker(func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+			notifyCount++
+			return nil
+		}, nil)))
+
+	instance, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: workflowType,
+		BusinessID:   "MIGRATE-001",
+		IsRun:        true,
+	})
+	require.NoError(t, err)
+	_, taskStatus := queryTaskStatus(t, service, instance.ID)
+	require.Equal(t, workflow.WorkflowTaskNodeStatusPending, taskStatus["review"])
+
+	loadWorkflowConfigJSON(t, fmt.Sprintf(`{
+		"id": %q,
+		"name": "迁移工作流",
+		"version": 2,
+		"nodes": [
+			{"id": "submit", "name": "提交", "next_nodes": ["approve"]},
+			{"id": "approve", "name": "审批", "next_nodes": ["notify"]},
+			{"id": "notify", "name": "通知", "next_nodes": ["archive"]},
+			{"id": "archive", "name": "归档", "next_nodes": []}
+		]
+	}`, workflowType))
+	loadWorkflowConfigJSON(t, fmt.Sprintf(`{
+		"id": %q,
+		"name": "迁移工作流",
+		"version": 3,
+		"nodes": [
+			{"id": "precheck", "name": "预检查", "next_nodes": ["submit"]},
+			{"id": "submit", "name": "提交", "next_nodes": ["review"]},
+			{"id": "review", "name": "审核", "next_nodes": ["archive"]},
+			{"id": "archive", "name": "归档", "next_nodes": []}
+		]
+	}`, workflowType))
+
+	t.Run("映射不完整", func(t *testing.T) {
+		_, err := service.MigrateWorkflowInstance(ctx, &workflow.MigrateWorkflowInstanceParams{
+			WorkflowInstanceID: instance.ID,
+			TargetVersion:      2,
+			AddedNodes:         []string{"notify"},
+		})
+		assert.ErrorIs(t, err, workflow.ErrWorkflowParamInvalid)
+	})
+
+	t.Run("新增节点的后续节点已经开始执行", func(t *testing.T) {
+		_, err := service.MigrateWorkflowInstance(ctx, &workflow.MigrateWorkflowInstanceParams{
+			WorkflowInstanceID: instance.ID,
+			TargetVersion:      3,
+			AddedNodes:         []string{"precheck"},
+		})
+		assert.ErrorIs(t, err, workflow.ErrWorkflowParamInvalid)
+	})
+
+	params := &workflow.MigrateWorkflowInstanceParams{
+		WorkflowInstanceID: instance.ID,
+		TargetVersion:      2,
+		NodeMapping:        map[string]string{"review": "approve"},
+		AddedNodes:         []string{"notify"},
+		DryRun:             true,
+	}
+	result, err := service.MigrateWorkflowInstance(ctx, params)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), result.FromVersion)
+	assert.Equal(t, int64(2), result.ToVersion)
+	require.Len(t, result.TaskChanges, 1)
+	assert.Equal(t, "review", result.TaskChanges[0].FromTaskType)
+	assert.Equal(t, "approve", result.TaskChanges[0].ToTaskType)
+	assert.Equal(t, workflow.MigrateTaskActionRename, result.TaskChanges[0].Action)
+	_, taskStatus = queryTaskStatus(t, service, instance.ID)
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusPending, taskStatus["review"], "dry run不会修改任务实例")
+
+	params.DryRun = false
+	_, err = service.MigrateWorkflowInstance(ctx, params)
+	require.NoError(t, err)
+	_, err = service.MigrateWorkflowInstance(ctx, params)
+	assert.ErrorIs(t, err, workflow.ErrWorkflowParamInvalid, "已经是目标版本")
+
+	approved = true
+	require.NoError(t, service.RunWorkflow(ctx, instance.ID))
+	status, taskStatus := queryTaskStatus(t, service, instance.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusCompleted, status)
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusCompleted, taskStatus["approve"])
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusCompleted, taskStatus["notify"])
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusCompleted, taskStatus["archive"])
+	assert.Equal(t, 1, notifyCount)
+}
@@ -0,0 +1,219 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/blingmoon/simple-workflow/workflow"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestCompleteWorkflowNode 测试人工完成等待中的节点, 输出合并到节点上下文, 后置节点继续执行
+func TestCompleteWorkflowNode(t *testing.T) {
+	service := setupTestService(t)
+	ctx := context.Background()
+	workflowType := "operate_complete_workflow"
+	loadWorkflowConfigJSON(t, fmt.Sprintf(`{
+		"id": %q,
+		"name": "人工完成工作流",
+		"nodes": [
+			{"id": "credit_check", "name": "征信查询", "next_nodes": ["notify"]},
+			{"id": "notify", "name": "通知", "next_nodes": []}
+		]
+	}`, workflowType))
+	require.NoError(t, workflow.RegisterWorkflowTask(workflowType, "credit_check",
+		workflow.NewNormalTaskWorker(
+			func(ctx context.Context, nodeContext *workflow.JSONContext) error { return nil },
+			func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+				// 第三方服务不可用, 一直等待
+				return workflow.ErrorWorkflowTaskInstanceNotReady
+			})))
+	notifiedResult := ""
+	require.NoError(t, workflow.RegisterWorkflowTask(workflowType, "notify",
+		workflow.NewNormalTaskWorker(func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+			notifiedResult, _ = nodeContext.GetString("pre_node_context", "credit_check", "result")
+			return nil
+		}, nil)))
+
+	instance, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: workflowType,
+		BusinessID:   "OPERATE-001",
+		IsRun:        true,
+	})
+	require.NoError(t, err)
+	_, taskStatus := queryTaskStatus(t, service, instance.ID)
+	require.Equal(t, workflow.WorkflowTaskNodeStatusPending, taskStatus["credit_check"])
+
+	require.NoError(t, service.CompleteWorkflowNode(ctx, &workflow.OperateWorkflowNodeParams{
+		WorkflowInstanceID: instance.ID,
+		TaskType:           "credit_check",
+		Operator:           "ops-alice",
+		Reason:             "征信服务故障, 人工核验通过",
+		Output:             map[string]any{"result": "manual_pass"},
+	}))
+	creditCheck := queryTaskInstance(t, service, instance.ID, "credit_check")
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusCompleted, creditCheck.Status)
+	assert.Greater(t, creditCheck.CompletedAt, int64(0))
+	operator, _ := creditCheck.NodeContext.GetString("system", "operation", "operator")
+	assert.Equal(t, "ops-alice", operator)
+	action, _ := creditCheck.NodeContext.GetString("system", "operation", "action")
+	assert.Equal(t, "complete", action)
+	reason, _ := creditCheck.NodeContext.GetString("system", "operation", "reason")
+	assert.Equal(t, "征信服务故障, 人工核验通过", reason)
+
+	require.NoError(t, service.RunWorkflow(ctx, instance.ID))
+	status, _ := queryTaskStatus(t, service, instance.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusCompleted, status)
+	assert.Equal(t, "manual_pass", notifiedResult)
+
+	// 已经结束的节点不能再操作
+	assert.ErrorIs(t, service.SkipWorkflowNode(ctx, &workflow.OperateWorkflowNodeParams{
+		WorkflowInstanceID: instance.ID,
+		TaskType:           "credit_check",
+		Operator:           "ops-alice",
+		Reason:             "重复操作",
+	}), workflow.ErrWorkflowParamInvalid)
+}
+
+// TestSkipWorkflowNodeNotCreated 测试任务实例还没有创建时跳过节点, 节点不会执行, 汇聚节点把它当作已完成
+func TestSkipWorkflowNodeNotCreated(t *testing.T) {
+	service := setupTestService(t)
+	ctx := context.Background()
+	workflowType := "operate_skip_workflow"
+	loadWorkflowConfigJSON(t, fmt.Sprintf(`{
+		"id": %q,
+		"name": "人工跳过工作流",
+		"nodes": [
+			{"id": "start", "name": "开始", "next_nodes": ["fast", "slow"]},
+			{"id": "fast", "name": "快速检查", "next_nodes": ["merge"]},
+			{"id": "slow", "name": "慢速检查", "next_nodes": ["merge"]},
+			{"id": "merge", "name": "汇总", "next_nodes": []}
+		]
+	}`, workflowType))
+	slowCount := 0
+	var mergePreNodes map[string]any
+	registerNoopTaskWorkers(t, workflowType, "start", "fast")
+	require.NoError(t, workflow.RegisterWorkflowTask(workflowType, "slow",
+		workflow.NewNormalTaskWorker(func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+			slowCount++
+			return nil
+		}, nil)))
+	require.NoError(t, workflow.RegisterWorkflowTask(workflowType, "merge",
+		workflow.NewNormalTaskWorker(func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+			preNodes, _ := nodeContext.Get("pre_node_context")
+			mergePreNodes, _ = preNodes.(map[string]any)
+			return nil
+		}, nil)))
+
+	instance, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: workflowType,
+		BusinessID:   "OPERATE-002",
+	})
+	require.NoError(t, err)
+	require.NoError(t, service.SkipWorkflowNode(ctx, &workflow.OperateWorkflowNodeParams{
+		WorkflowInstanceID: instance.ID,
+		TaskType:           "slow",
+		Operator:           "ops-bob",
+		Reason:             "慢速检查下线",
+	}))
+
+	require.NoError(t, service.RunWorkflow(ctx, instance.ID))
+	status, taskStatus := queryTaskStatus(t, service, instance.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusCompleted, status)
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusSkipped, taskStatus["slow"])
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusCompleted, taskStatus["merge"])
+	assert.Equal(t, 0, slowCount)
+	assert.Contains(t, mergePreNodes, "fast")
+	assert.Equal(t, map[string]any{}, mergePreNodes["slow"], "人工跳过的节点输出为空")
+	operator, _ := queryTaskInstance(t, service, instance.ID, "slow").NodeContext.GetString("system", "operation", "operator")
+	assert.Equal(t, "ops-bob", operator)
+}
+
+// TestSkipWorkflowNodeLinear 测试线性流程中跳过等待中的节点, 后置节点继续执行, 读取到跳过时指定的输出
+func TestSkipWorkflowNodeLinear(t *testing.T) {
+	service := setupTestService(t)
+	ctx := context.Background()
+	workflowType := "operate_skip_linear_workflow"
+	loadWorkflowConfigJSON(t, fmt.Sprintf(`{
+		"id": %q,
+		"name": "人工跳过线性工作流",
+		"nodes": [
+			{"id": "submit", "name": "提交", "next_nodes": ["review"]},
+			{"id": "review", "name": "审核", "next_nodes": ["notify"]},
+			{"id": "notify", "name": "通知", "next_nodes": ["archive"]},
+			{"id": "archive", "name": "归档", "next_nodes": []}
+		]
+	}`, workflowType))
+	noop := func(ctx context.Context, nodeContext *workflow.JSONContext) error { return nil }
+	registerNoopTaskWorkers(t, workflowType, "submit")
+	require.NoError(t, workflow.RegisterWorkflowTask(workflowType, "review",
+		workflow.NewNormalTaskWorker(noop, func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+			return workflow.ErrorWorkflowTaskInstanceNotReady
+		})))
+	notifiedResult, archiveCount := "", 0
+	require.NoError(t, workflow.RegisterWorkflowTask(workflowType, "notify",
+		workflow.NewNormalTaskWorker(func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+			notifiedResult, _ = nodeContext.GetString("pre_node_context", "review", "result")
+			return nil
+		}, nil)))
+	require.NoError(t, workflow.RegisterWorkflowTask(workflowType, "archive",
+		workflow.NewNormalTaskWorker(func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+			archiveCount++
+			return nil
+		}, nil)))
+
+	instance, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: workflowType,
+		BusinessID:   "OPERATE-004",
+		IsRun:        true,
+	})
+	require.NoError(t, err)
+	require.NoError(t, service.SkipWorkflowNode(ctx, &workflow.OperateWorkflowNodeParams{
+		WorkflowInstanceID: instance.ID,
+		TaskType:           "review",
+		Operator:           "ops-bob",
+		Reason:             "审核系统下线",
+		Output:             map[string]any{"result": "skipped_by_ops"},
+	}))
+
+	require.NoError(t, service.RunWorkflow(ctx, instance.ID))
+	status, taskStatus := queryTaskStatus(t, service, instance.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusCompleted, status)
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusSkipped, taskStatus["review"])
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusCompleted, taskStatus["notify"])
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusCompleted, taskStatus["archive"])
+	assert.Equal(t, "skipped_by_ops", notifiedResult)
+	assert.Equal(t, 1, archiveCount)
+}
+
+// TestOperateWorkflowNodeInvalid 测试人工操作的参数检查
+func TestOperateWorkflowNodeInvalid(t *testing.T) {
+	service := setupTestService(t)
+	ctx := context.Background()
+	workflowType := "operate_invalid_workflow"
+	loadWorkflowConfigJSON(t, fmt.Sprintf(`{
+		"id": %q,
+		"name": "人工操作参数检查",
+		"nodes": [{"id": "only", "name": "唯一节点", "next_nodes": []}]
+	}`, workflowType))
+	registerNoopTaskWorkers(t, workflowType, "only")
+	instance, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: workflowType,
+		BusinessID:   "OPERATE-003",
+	})
+	require.NoError(t, err)
+
+	cases := map[string]*workflow.OperateWorkflowNodeParams{
+		"missing_operator": {WorkflowInstanceID: instance.ID, TaskType: "only", Reason: "原因"},
+		"unknown_node":     {WorkflowInstanceID: instance.ID, TaskType: "unknown", Operator: "ops", Reason: "原因"},
+		"root_node":        {WorkflowInstanceID: instance.ID, TaskType: "root", Operator: "ops", Reason: "原因"},
+		"reserved_output":  {WorkflowInstanceID: instance.ID, TaskType: "only", Operator: "ops", Reason: "原因", Output: map[string]any{"system": 1}},
+	}
+	for name, params := range cases {
+		t.Run(name, func(t *testing.T) {
+			assert.ErrorIs(t, service.CompleteWorkflowNode(ctx, params), workflow.ErrWorkflowParamInvalid)
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package tests
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/blingmoon/simple-workflow/workflow"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestParallelBranches 测试并行执行相互独立的分支
+func TestParallelBranches(t *testing.T) {
+	service := setupConcurrentTestService(t)
+	ctx := context.Background()
+
+	// 结构: start -> (vendor_a, vendor_b, vendor_c) -> join
+	workflowConfigJSON := `{
+		"id": "parallel_workflow",
+		"name": "并行测试工作流",
+		"max_parallelism": 2,
+		"nodes": [
+			{"id": "start", "name": "开始", "next_nodes": ["vendor_a", "vendor_b", "vendor_c"]},
+			{"id": "vendor_a", "name": "供应商A", "next_nodes": ["join"]},
+			{"id": "vendor_b", "name": "供应商B", "next_nodes": ["join"]},
+			{"id": "vendor_c", "name": "供应商C", "next_nodes": ["join"]},
+			{"id": "join", "name": "汇总", "next_nodes": []}
+		]
+	}`
+	loadWorkflowConfigJSON(t, workflowConfigJSON)
+
+	var running, maxRunning, joinCount atomic.Int64
+	slowWorker := workflow.NewNormalTaskWorker(
+		func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+			current := running.Add(1)
+			defer running.Add(-1)
+			for {
+				old := maxRunning.Load()
+				if current <= old || maxRunning.CompareAndSwap(old, current) {
+					break
+				}
+			}
+			time.Sleep(100 * time.Millisecond)
+			nodeContext.Set([]string{"price"}, 100)
+			return nil
+		},
+		nil,
+	)
+	registerNoopTaskWorkers(t, "parallel_workflow", "start")
+	for _, taskKey := range []string{"vendor_a", "vendor_b", "vendor_c"} {
+		require.NoError(t, workflow.RegisterWorkflowTask("parallel_workflow", taskKey, slowWorker))
+	}
+	require.NoError(t, workflow.RegisterWorkflowTask("parallel_workflow", "join",
+		workflow.NewNormalTaskWorker(
+			func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+				joinCount.Add(1)
+				for _, vendor := range []string{"vendor_a", "vendor_b", "vendor_c"} {
+					if _, ok := nodeContext.GetInt64("pre_node_context", vendor, "price"); !ok {
+						return workflow.ErrorWorkflowTaskInstanceNotReady
+					}
+				}
+				return nil
+			},
+			nil,
+		)))
+
+	instance, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: "parallel_workflow",
+		BusinessID:   "PARALLEL-001",
+		IsRun:        true,
+	})
+	require.NoError(t, err)
+
+	detail := queryWorkflowInstanceDetail(t, service, instance.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusCompleted, detail.Status)
+	assert.Equal(t, int64(2), maxRunning.Load(), "同时执行的节点数量应该受max_parallelism限制")
+	assert.Equal(t, int64(1), joinCount.Load(), "汇聚节点只能执行一次")
+}
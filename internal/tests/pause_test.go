@@ -0,0 +1,250 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/blingmoon/simple-workflow/workflow"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestPauseResumeWorkflow 测试暂停期间不会执行也不会检查任何节点, 恢复后从暂停的位置继续执行
+func TestPauseResumeWorkflow(t *testing.T) {
+	service := setupTestService(t)
+	ctx := context.Background()
+	workflowType := "pause_resume_workflow"
+	loadWorkflowConfigJSON(t, fmt.Sprintf(`{
+		"id": %q,
+		"name": "暂停工作流",
+		"nodes": [
+			{"id": "submit", "name": "提交", "next_nodes": ["review"]},
+			{"id": "review", "name": "审核", "next_nodes": ["notify"]},
+			{"id": "notify", "name": "通知", "next_nodes": []}
+		]
+	}`, workflowType))
+	submitCount, reviewChecks, approved := 0, 0, false
+	require.NoError(t, workflow.RegisterWorkflowTask(workflowType, "submit",
+		workflow.NewNormalTaskWorker(func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+			submitCount++
+			return nil
+		}, nil)))
+	require.NoError(t, workflow.RegisterWorkflowTask(workflowType, "review",
+		workflow.NewNormalTaskWorker(
+			func(ctx context.Context, nodeContext *workflow.JSONContext) error { return nil },
+			func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+				reviewChecks++
+				if !approved {
+					return workflow.ErrorWorkflowTaskInstanceNotReady
+				}
+				return nil
+			})))
+	registerNoopTaskWorkers(t, workflowType, "notify")
+
+	instance, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: workflowType,
+		BusinessID:   "PAUSE-001",
+		IsRun:        true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, reviewChecks)
+
+	require.NoError(t, service.PauseWorkflowInstance(ctx, instance.ID))
+	require.NoError(t, service.PauseWorkflowInstance(ctx, instance.ID), "重复暂停直接返回成功")
+	approved = true
+	require.NoError(t, service.RunWorkflow(ctx, instance.ID))
+	status, taskStatus := queryTaskStatus(t, service, instance.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusPaused, status)
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusPending, taskStatus["review"])
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusStatusUnCreated, taskStatus["notify"])
+	assert.Equal(t, 1, reviewChecks, "暂停期间不会检查节点")
+
+	require.NoError(t, service.ResumeWorkflowInstance(ctx, instance.ID))
+	status, _ = queryTaskStatus(t, service, instance.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusRunning, status)
+	require.NoError(t, service.RunWorkflow(ctx, instance.ID))
+	status, taskStatus = queryTaskStatus(t, service, instance.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusCompleted, status)
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusCompleted, taskStatus["notify"])
+	assert.Equal(t, 1, submitCount, "已经完成的节点不会重新执行")
+	assert.Equal(t, 2, reviewChecks)
+
+	// 已经结束的工作流不能暂停, 也不能恢复
+	assert.ErrorIs(t, service.PauseWorkflowInstance(ctx, instance.ID), workflow.ErrWorkflowParamInvalid)
+	assert.ErrorIs(t, service.ResumeWorkflowInstance(ctx, instance.ID), workflow.ErrWorkflowParamInvalid)
+}
+
+// TestPauseResumeInitWorkflow 测试还没有运行过的工作流暂停后恢复为init状态
+func TestPauseResumeInitWorkflow(t *testing.T) {
+	service := setupTestService(t)
+	ctx := context.Background()
+	workflowType := "pause_resume_init_workflow"
+	loadWorkflowConfigJSON(t, fmt.Sprintf(`{
+		"id": %q,
+		"name": "暂停工作流",
+		"nodes": [{"id": "only", "name": "唯一节点", "next_nodes": []}]
+	}`, workflowType))
+	registerNoopTaskWorkers(t, workflowType, "only")
+
+	instance, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: workflowType,
+		BusinessID:   "PAUSE-002",
+	})
+	require.NoError(t, err)
+	require.NoError(t, service.PauseWorkflowInstance(ctx, instance.ID))
+	require.NoError(t, service.RunWorkflow(ctx, instance.ID))
+	_, taskStatus := queryTaskStatus(t, service, instance.ID)
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusStatusUnCreated, taskStatus["root"], "暂停期间不会开始执行")
+
+	require.NoError(t, service.ResumeWorkflowInstance(ctx, instance.ID))
+	status, _ := queryTaskStatus(t, service, instance.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusInit, status)
+	require.NoError(t, service.RunWorkflow(ctx, instance.ID))
+	status, _ = queryTaskStatus(t, service, instance.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusCompleted, status)
+}
+
+// TestPauseResumeSubWorkflow 测试暂停和恢复会级联到子工作流
+func TestPauseResumeSubWorkflow(t *testing.T) {
+	service := setupTestService(t)
+	ctx := context.Background()
+	parentType, verified := setupSubWorkflow(t, "sub_pause")
+
+	parent, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: parentType,
+		BusinessID:   "PAUSE-003",
+		IsRun:        true,
+	})
+	require.NoError(t, err)
+	children := querySubWorkflowInstances(t, service, parent.ID)
+	require.Len(t, children, 1)
+
+	require.NoError(t, service.PauseWorkflowInstance(ctx, parent.ID))
+	children = querySubWorkflowInstances(t, service, parent.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusPaused, children[0].Status)
+
+	// 子工作流暂停时不会推进
+	verified["ok"] = true
+	require.NoError(t, service.RunWorkflow(ctx, children[0].ID))
+	status, _ := queryTaskStatus(t, service, children[0].ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusPaused, status)
+
+	require.NoError(t, service.ResumeWorkflowInstance(ctx, parent.ID))
+	children = querySubWorkflowInstances(t, service, parent.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusRunning, children[0].Status)
+	require.NoError(t, service.RunWorkflow(ctx, parent.ID))
+	status, _ = queryTaskStatus(t, service, parent.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusCompleted, status)
+}
+
+// hookQueryWorkflowRepo 第一次查询工作流实例之后执行afterQuery, 用于模拟读取实例和加锁之间的并发操作
+type hookQueryWorkflowRepo struct {
+	workflow.WorkflowRepo
+	afterQuery func()
+}
+
+func (r *hookQueryWorkflowRepo) QueryWorkflowInstance(ctx context.Context, param *workflow.QueryWorkflowInstanceParams) ([]*workflow.WorkflowInstancePo, error) {
+	pos, err := r.WorkflowRepo.QueryWorkflowInstance(ctx, param)
+	if afterQuery := r.afterQuery; afterQuery != nil {
+		r.afterQuery = nil
+		afterQuery()
+	}
+	return pos, err
+}
+
+// TestRunWorkflowPausedBeforeLock 测试RunWorkflow读取实例之后、加锁之前实例被暂停, 不会执行任何节点
+func TestRunWorkflowPausedBeforeLock(t *testing.T) {
+	repo := &hookQueryWorkflowRepo{WorkflowRepo: setupTestRepo(t)}
+	service := workflow.NewWorkflowService(repo, workflow.NewLocalWorkflowLock())
+	ctx := context.Background()
+	workflowType := "pause_before_lock_workflow"
+	loadWorkflowConfigJSON(t, fmt.Sprintf(`{
+		"id": %q,
+		"name": "加锁前暂停工作流",
+		"nodes": [
+			{"id": "submit", "name": "提交", "next_nodes": []}
+		]
+	}`, workflowType))
+	submitCount := 0
+	require.NoError(t, workflow.RegisterWorkflowTask(workflowType, "submit",
+		workflow.NewNormalTaskWorker(func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+			submitCount++
+			return nil
+		}, nil)))
+
+	instance, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: workflowType,
+		BusinessID:   "PAUSE-BEFORE-LOCK-001",
+	})
+	require.NoError(t, err)
+	repo.afterQuery = func() {
+		require.NoError(t, service.PauseWorkflowInstance(ctx, instance.ID))
+	}
+	require.NoError(t, service.RunWorkflow(ctx, instance.ID))
+	status, taskStatus := queryTaskStatus(t, service, instance.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusPaused, status)
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusStatusUnCreated, taskStatus["submit"])
+	assert.Equal(t, 0, submitCount, "加锁之前被暂停的实例不会执行")
+}
+
+// TestPauseCancelSubWorkflow 测试取消暂停中的父工作流会取消暂停中的子工作流
+func TestPauseCancelSubWorkflow(t *testing.T) {
+	service := setupTestService(t)
+	ctx := context.Background()
+	parentType, _ := setupSubWorkflow(t, "sub_pause_cancel")
+
+	parent, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: parentType,
+		BusinessID:   "PAUSE-004",
+		IsRun:        true,
+	})
+	require.NoError(t, err)
+	require.NoError(t, service.PauseWorkflowInstance(ctx, parent.ID))
+	children := querySubWorkflowInstances(t, service, parent.ID)
+	require.Len(t, children, 1)
+	require.Equal(t, workflow.WorkflowInstanceStatusPaused, children[0].Status)
+
+	require.NoError(t, service.CancelWorkflowInstance(ctx, parent.ID))
+	children = querySubWorkflowInstances(t, service, parent.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusCancelled, children[0].Status)
+}
+
+// TestPauseResumeSubWorkflowRetry 测试级联暂停或者恢复子工作流失败后, 再次调用会继续处理子工作流
+func TestPauseResumeSubWorkflowRetry(t *testing.T) {
+	lock := &instanceLockFailWorkflowLock{WorkflowLock: workflow.NewLocalWorkflowLock()}
+	service := workflow.NewWorkflowService(setupTestRepo(t), lock)
+	ctx := context.Background()
+	parentType, _ := setupSubWorkflow(t, "sub_pause_retry")
+
+	parent, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: parentType,
+		BusinessID:   "PAUSE-005",
+		IsRun:        true,
+	})
+	require.NoError(t, err)
+	children := querySubWorkflowInstances(t, service, parent.ID)
+	require.Len(t, children, 1)
+
+	lock.failingInstanceID.Store(children[0].ID)
+	assert.ErrorIs(t, service.PauseWorkflowInstance(ctx, parent.ID), workflow.LockFailedError)
+	status, _ := queryTaskStatus(t, service, parent.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusPaused, status)
+	children = querySubWorkflowInstances(t, service, parent.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusRunning, children[0].Status)
+	lock.failingInstanceID.Store(0)
+	require.NoError(t, service.PauseWorkflowInstance(ctx, parent.ID))
+	children = querySubWorkflowInstances(t, service, parent.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusPaused, children[0].Status)
+
+	lock.failingInstanceID.Store(children[0].ID)
+	assert.ErrorIs(t, service.ResumeWorkflowInstance(ctx, parent.ID), workflow.LockFailedError)
+	status, _ = queryTaskStatus(t, service, parent.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusRunning, status)
+	children = querySubWorkflowInstances(t, service, parent.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusPaused, children[0].Status)
+	lock.failingInstanceID.Store(0)
+	require.NoError(t, service.ResumeWorkflowInstance(ctx, parent.ID))
+	children = querySubWorkflowInstances(t, service, parent.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusRunning, children[0].Status)
+}
@@ -0,0 +1,51 @@
+package tests
+
+import (
+	"context"
+	"testing"
+
+	"github.com/blingmoon/simple-workflow/workflow"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRegistryIsolation 测试不同的Registry相互隔离, 同一个工作流类型可以分别加载配置和注册不同的工作器
+func TestRegistryIsolation(t *testing.T) {
+	ctx := context.Background()
+	workflowType := "registry_isolation_workflow"
+	executedBy := make([]string, 0)
+	newRegistry := func(tenant string) *workflow.Registry {
+		registry := workflow.NewRegistry()
+		require.NoError(t, registry.LoadWorkflowConfig(&workflow.WorkflowConfig{
+			ID:    workflowType,
+			Name:  "租户工作流",
+			Nodes: []*workflow.NodeDefinitionConfig{{ID: "submit", Name: "提交", NextNodes: []string{}}},
+		}))
+		require.NoError(t, registry.RegisterWorkflowTask(workflowType, "submit",
+			workflow.NewNormalTaskWorker(func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+				executedBy = append(executedBy, tenant)
+				return nil
+			}, nil)))
+		return registry
+	}
+	registryA, registryB := newRegistry("A"), newRegistry("B")
+
+	_, err := workflow.GetAndLoadWorkflowDefinition(workflowType)
+	assert.ErrorIs(t, err, workflow.ErrWorkflowConfigNotFound, "默认的Registry中没有加载")
+	definition, err := registryA.GetAndLoadWorkflowDefinition(workflowType)
+	require.NoError(t, err)
+	assert.Equal(t, workflowType, definition.ID)
+
+	for _, registry := range []*workflow.Registry{registryA, registryB} {
+		service := setupTestService(t, workflow.WithRegistry(registry))
+		instance, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+			WorkflowType: workflowType,
+			BusinessID:   "REGISTRY-001",
+			IsRun:        true,
+		})
+		require.NoError(t, err)
+		status, _ := queryTaskStatus(t, service, instance.ID)
+		assert.Equal(t, workflow.WorkflowInstanceStatusCompleted, status)
+	}
+	assert.Equal(t, []string{"A", "B"}, executedBy)
+}
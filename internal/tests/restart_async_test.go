@@ -0,0 +1,87 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/blingmoon/simple-workflow/workflow"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRestartWorkflowNodeIsRun 测试同步重启节点, 重置和执行在同一次调用中完成
+func TestRestartWorkflowNodeIsRun(t *testing.T) {
+	service := setupTestService(t)
+	ctx := context.Background()
+	workflowType := "restart_node_is_run_workflow"
+	prepareCount, chargedAmount := setupRestartContextWorkflow(t, workflowType)
+
+	instance, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: workflowType,
+		BusinessID:   "RESTART-RUN-001",
+		Context:      map[string]any{"amount": 0},
+	})
+	require.NoError(t, err)
+	assert.ErrorIs(t, service.RunWorkflow(ctx, instance.ID), workflow.ErrWorkflowTaskFailedWithFailed)
+
+	handle, err := service.RestartWorkflowNode(ctx, &workflow.RestartWorkflowNodeParams{
+		WorkflowInstanceID:      instance.ID,
+		TaskType:                "charge",
+		IsForcedRestartWorkflow: true,
+		IsRun:                   true,
+		ContextPatch:            map[string]any{"amount": 8},
+	})
+	require.NoError(t, err)
+	assert.Nil(t, handle, "同步执行不返回句柄")
+	status, _ := queryTaskStatus(t, service, instance.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusCompleted, status)
+	assert.Equal(t, float64(8), *chargedAmount)
+	assert.Equal(t, 1, *prepareCount)
+}
+
+// TestRestartWorkflowNodeAsynchronous 测试异步重启节点, 通过句柄等待或者轮询执行结果
+func TestRestartWorkflowNodeAsynchronous(t *testing.T) {
+	service := setupTestService(t)
+	ctx := context.Background()
+	workflowType := "restart_node_async_workflow"
+	_, chargedAmount := setupRestartContextWorkflow(t, workflowType)
+
+	instance, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: workflowType,
+		BusinessID:   "RESTART-ASYNC-001",
+		Context:      map[string]any{"amount": 0},
+	})
+	require.NoError(t, err)
+	assert.ErrorIs(t, service.RunWorkflow(ctx, instance.ID), workflow.ErrWorkflowTaskFailedWithFailed)
+
+	// 修正后的上下文仍然不合法, 后台执行失败, 错误通过句柄返回
+	handle, err := service.RestartWorkflowNode(ctx, &workflow.RestartWorkflowNodeParams{
+		WorkflowInstanceID:      instance.ID,
+		TaskType:                "charge",
+		IsForcedRestartWorkflow: true,
+		IsAsynchronous:          true,
+		ContextPatch:            map[string]any{"amount": -1},
+	})
+	require.NoError(t, err)
+	require.NotNil(t, handle)
+	assert.Equal(t, instance.ID, handle.WorkflowInstanceID)
+	assert.ErrorIs(t, handle.Wait(ctx), workflow.ErrWorkflowTaskFailedWithFailed)
+	assert.True(t, handle.IsDone())
+	assert.ErrorIs(t, handle.Err(), workflow.ErrWorkflowTaskFailedWithFailed)
+
+	handle, err = service.RestartWorkflowNode(ctx, &workflow.RestartWorkflowNodeParams{
+		WorkflowInstanceID:      instance.ID,
+		TaskType:                "charge",
+		IsForcedRestartWorkflow: true,
+		IsAsynchronous:          true,
+		ContextPatch:            map[string]any{"amount": 9},
+	})
+	require.NoError(t, err)
+	require.NotNil(t, handle)
+	require.Eventually(t, handle.IsDone, 5*time.Second, 10*time.Millisecond)
+	assert.NoError(t, handle.Err())
+	status, _ := queryTaskStatus(t, service, instance.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusCompleted, status)
+	assert.Equal(t, float64(9), *chargedAmount)
+}
@@ -0,0 +1,155 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/blingmoon/simple-workflow/workflow"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupRestartContextWorkflow prepare节点记录执行次数, charge节点在金额不合法时失败
+func setupRestartContextWorkflow(t *testing.T, workflowType string) (*int, *float64) {
+	loadWorkflowConfigJSON(t, fmt.Sprintf(`{
+		"id": %q,
+		"name": "重启修改上下文工作流",
+		"nodes": [
+			{"id": "prepare", "name": "准备", "next_nodes": ["charge"]},
+			{"id": "charge", "name": "扣款", "next_nodes": [], "fail_max_count": 1}
+		]
+	}`, workflowType))
+	prepareCount, chargedAmount := 0, float64(0)
+	require.NoError(t, workflow.RegisterWorkflowTask(workflowType, "prepare",
+		workflow.NewNormalTaskWorker(func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+			prepareCount++
+			return nil
+		}, nil)))
+	require.NoError(t, workflow.RegisterWorkflowTask(workflowType, "charge",
+		workflow.NewNormalTaskWorker(func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+			amount, _ := nodeContext.GetFloat64("workflow_context", "amount")
+			if amount <= 0 {
+				return errors.New("invalid amount")
+			}
+			chargedAmount = amount
+			return nil
+		}, nil)))
+	return &prepareCount, &chargedAmount
+}
+
+// TestRestartWorkflowInstanceWithContextPatch 测试重启工作流时按照JSON Merge Patch修正上下文, 重启的节点使用新的上下文
+func TestRestartWorkflowInstanceWithContextPatch(t *testing.T) {
+	service := setupTestService(t)
+	ctx := context.Background()
+	workflowType := "restart_context_patch_workflow"
+	prepareCount, chargedAmount := setupRestartContextWorkflow(t, workflowType)
+
+	instance, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: workflowType,
+		BusinessID:   "RESTART-CTX-001",
+		Context:      map[string]any{"amount": -1, "user": map[string]any{"id": "u1", "level": "vip"}},
+	})
+	require.NoError(t, err)
+	assert.ErrorIs(t, service.RunWorkflow(ctx, instance.ID), workflow.ErrWorkflowTaskFailedWithFailed)
+	status, _ := queryTaskStatus(t, service, instance.ID)
+	require.Equal(t, workflow.WorkflowInstanceStatusFailed, status)
+
+	require.NoError(t, service.RestartWorkflowInstance(ctx, &workflow.RestartWorkflowParams{
+		WorkflowInstanceID: instance.ID,
+		ContextPatch:       map[string]any{"amount": 100, "user": map[string]any{"level": nil}},
+	}))
+	require.NoError(t, service.RunWorkflow(ctx, instance.ID))
+	status, _ = queryTaskStatus(t, service, instance.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusCompleted, status)
+	assert.Equal(t, float64(100), *chargedAmount)
+	assert.Equal(t, 1, *prepareCount, "没有重启的节点不会重新执行")
+
+	charge := queryTaskInstance(t, service, instance.ID, "charge")
+	amount, _ := charge.NodeContext.GetFloat64("workflow_context", "amount")
+	assert.Equal(t, float64(100), amount)
+	prepare := queryTaskInstance(t, service, instance.ID, "prepare")
+	amount, _ = prepare.NodeContext.GetFloat64("workflow_context", "amount")
+	assert.Equal(t, float64(-1), amount, "没有重启的节点保留原来的上下文")
+
+	detail := queryWorkflowInstanceDetail(t, service, instance.ID)
+	userID, _ := detail.WorkflowContext.GetString("user", "id")
+	assert.Equal(t, "u1", userID)
+	_, hasLevel := detail.WorkflowContext.Get("user", "level")
+	assert.False(t, hasLevel, "值为null的key会被删除")
+	require.Len(t, detail.WorkflowContextHistory, 1)
+	revision := detail.WorkflowContextHistory[0]
+	assert.Equal(t, workflow.WorkflowContextChangeModeMergePatch, revision.Mode)
+	assert.Equal(t, float64(-1), revision.PreviousContext["amount"])
+	assert.Greater(t, revision.ChangedAt, int64(0))
+}
+
+// TestRestartWorkflowNodeWithContext 测试重启节点时整体替换上下文, 多次修改都保留在修改记录中
+func TestRestartWorkflowNodeWithContext(t *testing.T) {
+	service := setupTestService(t)
+	ctx := context.Background()
+	workflowType := "restart_node_context_workflow"
+	prepareCount, chargedAmount := setupRestartContextWorkflow(t, workflowType)
+
+	instance, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: workflowType,
+		BusinessID:   "RESTART-CTX-002",
+		Context:      map[string]any{"amount": 10, "remark": "first"},
+		IsRun:        true,
+	})
+	require.NoError(t, err)
+	require.Equal(t, float64(10), *chargedAmount)
+
+	_, err = service.RestartWorkflowNode(ctx, &workflow.RestartWorkflowNodeParams{
+		WorkflowInstanceID:      instance.ID,
+		TaskType:                "prepare",
+		IsForcedRestartWorkflow: true,
+		Context:                 map[string]any{"amount": 20},
+	})
+	require.NoError(t, err)
+	require.NoError(t, service.RunWorkflow(ctx, instance.ID))
+	status, _ := queryTaskStatus(t, service, instance.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusCompleted, status)
+	assert.Equal(t, 2, *prepareCount)
+	assert.Equal(t, float64(20), *chargedAmount)
+	_, hasRemark := queryTaskInstance(t, service, instance.ID, "prepare").NodeContext.Get("workflow_context", "remark")
+	assert.False(t, hasRemark, "整体替换后原来的key不存在")
+
+	_, err = service.RestartWorkflowNode(ctx, &workflow.RestartWorkflowNodeParams{
+		WorkflowInstanceID:      instance.ID,
+		TaskType:                "charge",
+		IsForcedRestartWorkflow: true,
+		ContextPatch:            map[string]any{"amount": 30},
+	})
+	require.NoError(t, err)
+	require.NoError(t, service.RunWorkflow(ctx, instance.ID))
+	assert.Equal(t, 2, *prepareCount)
+	assert.Equal(t, float64(30), *chargedAmount)
+
+	history := queryWorkflowInstanceDetail(t, service, instance.ID).WorkflowContextHistory
+	require.Len(t, history, 2)
+	assert.Equal(t, workflow.WorkflowContextChangeModeReplace, history[0].Mode)
+	assert.Equal(t, "first", history[0].PreviousContext["remark"])
+	assert.Equal(t, workflow.WorkflowContextChangeModeMergePatch, history[1].Mode)
+	assert.Equal(t, float64(20), history[1].PreviousContext["amount"])
+}
+
+// TestRestartWithContextAndPatch 测试Context和ContextPatch不能同时使用
+func TestRestartWithContextAndPatch(t *testing.T) {
+	service := setupTestService(t)
+	ctx := context.Background()
+	err := service.RestartWorkflowInstance(ctx, &workflow.RestartWorkflowParams{
+		WorkflowInstanceID: 1,
+		Context:            map[string]any{"a": 1},
+		ContextPatch:       map[string]any{"b": 2},
+	})
+	assert.ErrorIs(t, err, workflow.ErrWorkflowParamInvalid)
+	_, err = service.RestartWorkflowNode(ctx, &workflow.RestartWorkflowNodeParams{
+		WorkflowInstanceID: 1,
+		TaskType:           "any",
+		Context:            map[string]any{"a": 1},
+		ContextPatch:       map[string]any{"b": 2},
+	})
+	assert.ErrorIs(t, err, workflow.ErrWorkflowParamInvalid)
+}
@@ -0,0 +1,72 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/blingmoon/simple-workflow/workflow"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRetryPolicy 测试节点失败重试策略
+func TestRetryPolicy(t *testing.T) {
+	service := setupTestService(t)
+	ctx := context.Background()
+
+	workflowConfigJSON := `{
+		"id": "retry_policy_workflow",
+		"name": "重试策略工作流",
+		"nodes": [
+			{
+				"id": "call_vendor",
+				"name": "调用供应商",
+				"next_nodes": [],
+				"fail_max_count": 5,
+				"retry_policy": {"type": "exponential", "initial_interval": 60, "max_interval": 600}
+			}
+		]
+	}`
+	loadWorkflowConfigJSON(t, workflowConfigJSON)
+
+	runCount := 0
+	require.NoError(t, workflow.RegisterWorkflowTask("retry_policy_workflow", "call_vendor",
+		workflow.NewNormalTaskWorker(
+			func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+				runCount++
+				return errors.New("vendor unavailable")
+			},
+			nil,
+		),
+	))
+
+	instance, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: "retry_policy_workflow",
+		BusinessID:   "RETRY-001",
+		IsRun:        true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 1, runCount)
+
+	// 还没有到下次重试时间, 不会再执行
+	require.NoError(t, service.RunWorkflow(ctx, instance.ID))
+	assert.Equal(t, 1, runCount)
+
+	detail := queryWorkflowInstanceDetail(t, service, instance.ID)
+	for _, taskInstance := range detail.TaskInstances {
+		if taskInstance.TaskType == "call_vendor" {
+			assert.InDelta(t, time.Now().Unix()+60, taskInstance.NextRetryAt, 2)
+		}
+	}
+
+	// 重启节点会清空下次重试时间, 立即执行
+	_, err = service.RestartWorkflowNode(ctx, &workflow.RestartWorkflowNodeParams{
+		WorkflowInstanceID: instance.ID,
+		TaskType:           "call_vendor",
+	})
+	require.NoError(t, err)
+	require.NoError(t, service.RunWorkflow(ctx, instance.ID))
+	assert.Equal(t, 2, runCount)
+}
@@ -0,0 +1,151 @@
+package tests
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/blingmoon/simple-workflow/workflow"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestScheduler 测试后台调度器
+func TestScheduler(t *testing.T) {
+	service := setupConcurrentTestService(t)
+	ctx := context.Background()
+
+	workflowConfigJSON := `{
+		"id": "scheduler_workflow",
+		"name": "调度器测试工作流",
+		"nodes": [
+			{"id": "task1", "name": "任务1", "next_nodes": ["task2"]},
+			{"id": "task2", "name": "任务2", "next_nodes": []}
+		]
+	}`
+	loadWorkflowConfigJSON(t, workflowConfigJSON)
+
+	// task1 第一次检查未就绪, 需要调度器再次调度
+	var checkCount atomic.Int64
+	require.NoError(t, workflow.RegisterWorkflowTask("scheduler_workflow", "task1",
+		workflow.NewNormalTaskWorker(
+			func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+				return nil
+			},
+			func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+				if checkCount.Add(1) <= 3 {
+					return workflow.ErrorWorkflowTaskInstanceNotReady
+				}
+				return nil
+			},
+		),
+	))
+	require.NoError(t, workflow.RegisterWorkflowTask("scheduler_workflow", "task2",
+		workflow.NewNormalTaskWorker(
+			func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+				return nil
+			},
+			nil,
+		),
+	))
+
+	instanceIDs := make([]int64, 0)
+	for i := 0; i < 3; i++ {
+		instance, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+			WorkflowType: "scheduler_workflow",
+			BusinessID:   "SCHEDULER-001",
+		})
+		require.NoError(t, err)
+		instanceIDs = append(instanceIDs, instance.ID)
+	}
+
+	t.Run("过滤其他工作流类型", func(t *testing.T) {
+		scheduler := workflow.NewScheduler(service, &workflow.SchedulerConfig{
+			WorkflowTypes: []string{"other_workflow"},
+		})
+		require.NoError(t, scheduler.RunOnce(ctx))
+		pos, err := service.QueryWorkflowInstancePo(ctx, &workflow.QueryWorkflowInstanceParams{
+			WorkflowTypeIn: []string{"scheduler_workflow"},
+			Page:           &workflow.Pager{Page: 1, Size: 10},
+		})
+		require.NoError(t, err)
+		for _, po := range pos {
+			assert.Equal(t, workflow.WorkflowInstanceStatusInit, po.Status)
+		}
+	})
+
+	t.Run("调度直到完成", func(t *testing.T) {
+		scheduler := workflow.NewScheduler(service, &workflow.SchedulerConfig{
+			PollInterval:  20 * time.Millisecond,
+			WorkerCount:   2,
+			PageSize:      2,
+			WorkflowTypes: []string{"scheduler_workflow"},
+		})
+		require.NoError(t, scheduler.Start(ctx))
+		assert.Error(t, scheduler.Start(ctx), "重复启动应该返回错误")
+
+		assert.Eventually(t, func() bool {
+			count, err := service.CountWorkflowInstance(ctx, &workflow.QueryWorkflowInstanceParams{
+				WorkflowTypeIn: []string{"scheduler_workflow"},
+				StatusIn:       []string{workflow.WorkflowInstanceStatusCompleted},
+			})
+			return err == nil && count == int64(len(instanceIDs))
+		}, 5*time.Second, 20*time.Millisecond)
+
+		scheduler.Stop()
+		// 重复停止不会阻塞
+		scheduler.Stop()
+	})
+}
+
+// countRunWorkflowService 记录调度器执行的实例
+type countRunWorkflowService struct {
+	workflow.WorkflowService
+	runCount atomic.Int64
+}
+
+func (s *countRunWorkflowService) RunWorkflow(ctx context.Context, workflowID int64) error {
+	s.runCount.Add(1)
+	return s.WorkflowService.RunWorkflow(ctx, workflowID)
+}
+
+// TestSchedulerSkipsNotDueInstances 测试节点都在等待延时唤醒的实例记录下次执行时间, 调度器跳过还没有到时间的实例, 跳过节点之后重新调度
+func TestSchedulerSkipsNotDueInstances(t *testing.T) {
+	service := &countRunWorkflowService{WorkflowService: setupConcurrentTestService(t)}
+	ctx := context.Background()
+	workflowType := "scheduler_wake_up_workflow"
+	setupDelayWorkflow(t, workflowType, `{"duration": 172800}`)
+
+	instance, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: workflowType,
+		BusinessID:   "SCHEDULER-004",
+		IsRun:        true,
+	})
+	require.NoError(t, err)
+	wait := queryTaskInstance(t, service, instance.ID, "wait")
+	pos, err := service.QueryWorkflowInstancePo(ctx, &workflow.QueryWorkflowInstanceParams{
+		WorkflowInstanceID: &instance.ID,
+		Page:               &workflow.Pager{Page: 1, Size: 1},
+	})
+	require.NoError(t, err)
+	require.Len(t, pos, 1)
+	assert.Equal(t, wait.WakeUpAt, pos[0].NextWakeUpAt, "只有延时节点没有结束, 下次执行时间就是唤醒时间")
+
+	service.runCount.Store(0)
+	scheduler := workflow.NewScheduler(service, &workflow.SchedulerConfig{WorkflowTypes: []string{workflowType}})
+	require.NoError(t, scheduler.RunOnce(ctx))
+	assert.Equal(t, int64(0), service.runCount.Load(), "还没有到唤醒时间, 调度器不执行")
+
+	require.NoError(t, service.SkipWorkflowNode(ctx, &workflow.OperateWorkflowNodeParams{
+		WorkflowInstanceID: instance.ID,
+		TaskType:           "wait",
+		Operator:           "tester",
+		Reason:             "不用等待",
+	}))
+	require.NoError(t, scheduler.RunOnce(ctx))
+	assert.Equal(t, int64(1), service.runCount.Load())
+	status, taskStatus := queryTaskStatus(t, service, instance.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusCompleted, status)
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusCompleted, taskStatus["remind"])
+}
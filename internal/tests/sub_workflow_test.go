@@ -0,0 +1,273 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/blingmoon/simple-workflow/workflow"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupSubWorkflow 子工作流: verify_id -> score, verify_id在verified为true之前一直等待
+// 父工作流: start -> kyc(子工作流) -> finish
+func setupSubWorkflow(t *testing.T, prefix string) (parentType string, verified map[string]bool) {
+	childType := prefix + "_kyc_child"
+	parentType = prefix + "_kyc_parent"
+	childConfigJSON := fmt.Sprintf(`{
+		"id": %q,
+		"name": "KYC子工作流",
+		"nodes": [
+			{"id": "verify_id", "name": "身份校验", "next_nodes": ["score"]},
+			{"id": "score", "name": "评分", "next_nodes": []}
+		]
+	}`, childType)
+	parentConfigJSON := fmt.Sprintf(`{
+		"id": %q,
+		"name": "开户工作流",
+		"nodes": [
+			{"id": "start", "name": "开始", "next_nodes": ["kyc"]},
+			{"id": "kyc", "name": "KYC", "next_nodes": ["finish"], "kind": "sub_workflow", "sub_workflow": {"workflow_type": %q}},
+			{"id": "finish", "name": "结束", "next_nodes": []}
+		]
+	}`, parentType, childType)
+	loadWorkflowConfigJSON(t, childConfigJSON)
+	loadWorkflowConfigJSON(t, parentConfigJSON)
+
+	verified = make(map[string]bool)
+	require.NoError(t, workflow.RegisterWorkflowTask(childType, "verify_id",
+		workflow.NewNormalTaskWorker(
+			func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+				if !verified["ok"] {
+					return workflow.ErrorWorkflowTaskInstanceNotReady
+				}
+				if verified["reject"] {
+					return workflow.ErrWorkflowTaskFailedWithFailed
+				}
+				return nil
+			},
+			nil,
+		)))
+	require.NoError(t, workflow.RegisterWorkflowTask(childType, "score",
+		workflow.NewNormalTaskWorker(
+			func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+				userName, _ := nodeContext.GetString("workflow_context", "user_name")
+				nodeContext.Set([]string{"score"}, 90)
+				nodeContext.Set([]string{"user_name"}, userName)
+				return nil
+			},
+			nil,
+		)))
+	registerNoopTaskWorkers(t, parentType, "start", "finish")
+	return parentType, verified
+}
+
+// TestSubWorkflowCompleted 测试子工作流完成后父工作流继续执行, 子工作流的输出作为节点输出
+func TestSubWorkflowCompleted(t *testing.T) {
+	service := setupTestService(t)
+	ctx := context.Background()
+	parentType, verified := setupSubWorkflow(t, "sub_completed")
+
+	parent, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: parentType,
+		BusinessID:   "SUB-001",
+		Context:      map[string]any{"user_name": "张三"},
+		IsRun:        true,
+	})
+	require.NoError(t, err)
+
+	children := querySubWorkflowInstances(t, service, parent.ID)
+	require.Len(t, children, 1)
+	assert.Equal(t, "SUB-001", children[0].BusinessID)
+	assert.Equal(t, parent.ID, children[0].ParentWorkflowInstanceID)
+
+	status, taskStatus := queryTaskStatus(t, service, parent.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusRunning, status)
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusPending, taskStatus["kyc"])
+
+	// 子工作流完成后, 运行父工作流会推进子工作流并且完成父工作流
+	verified["ok"] = true
+	require.NoError(t, service.RunWorkflow(ctx, parent.ID))
+	status, _ = queryTaskStatus(t, service, parent.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusCompleted, status)
+
+	detail := queryWorkflowInstanceDetail(t, service, parent.ID)
+	for _, taskInstance := range detail.TaskInstances {
+		if taskInstance.TaskType != "kyc" {
+			continue
+		}
+		childID, ok := taskInstance.NodeContext.GetInt64(workflow.NodeContextKeySubWorkflow, "workflow_instance_id")
+		assert.True(t, ok)
+		assert.Equal(t, children[0].ID, childID)
+		score, ok := taskInstance.NodeContext.GetInt64(workflow.NodeContextKeySubWorkflow, "output", "score", "score")
+		assert.True(t, ok)
+		assert.Equal(t, int64(90), score)
+		userName, _ := taskInstance.NodeContext.GetString(workflow.NodeContextKeySubWorkflow, "output", "score", "user_name")
+		assert.Equal(t, "张三", userName)
+	}
+}
+
+// TestSubWorkflowFailed 测试子工作流失败, 父工作流跟着失败
+func TestSubWorkflowFailed(t *testing.T) {
+	service := setupTestService(t)
+	ctx := context.Background()
+	parentType, verified := setupSubWorkflow(t, "sub_failed")
+	verified["ok"] = true
+	verified["reject"] = true
+
+	parent, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: parentType,
+		BusinessID:   "SUB-002",
+	})
+	require.NoError(t, err)
+	err = service.RunWorkflow(ctx, parent.ID)
+	assert.ErrorIs(t, err, workflow.ErrWorkflowTaskFailedWithFailed)
+
+	status, taskStatus := queryTaskStatus(t, service, parent.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusFailed, status)
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusFailed, taskStatus["kyc"])
+}
+
+// TestSubWorkflowCancelCascade 测试取消父工作流会级联取消子工作流, 取消子工作流父工作流也跟着取消
+func TestSubWorkflowCancelCascade(t *testing.T) {
+	service := setupTestService(t)
+	ctx := context.Background()
+	parentType, _ := setupSubWorkflow(t, "sub_cancel")
+
+	parent, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: parentType,
+		BusinessID:   "SUB-003",
+		IsRun:        true,
+	})
+	require.NoError(t, err)
+	children := querySubWorkflowInstances(t, service, parent.ID)
+	require.Len(t, children, 1)
+
+	require.NoError(t, service.CancelWorkflowInstance(ctx, parent.ID))
+	children = querySubWorkflowInstances(t, service, parent.ID)
+	require.Len(t, children, 1)
+	assert.Equal(t, workflow.WorkflowInstanceStatusCancelled, children[0].Status)
+
+	// 取消子工作流, 父工作流节点跟着取消
+	other, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: parentType,
+		BusinessID:   "SUB-004",
+		IsRun:        true,
+	})
+	require.NoError(t, err)
+	children = querySubWorkflowInstances(t, service, other.ID)
+	require.Len(t, children, 1)
+	require.NoError(t, service.CancelWorkflowInstance(ctx, children[0].ID))
+	err = service.RunWorkflow(ctx, other.ID)
+	assert.ErrorIs(t, err, workflow.ErrWorkflowTaskCancelled)
+	status, taskStatus := queryTaskStatus(t, service, other.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusCancelled, status)
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusCancelled, taskStatus["kyc"])
+}
+
+// saveSubWorkflowFailRepo isFailing为true时保存子工作流实例ID都失败, 模拟创建子工作流之后来不及保存节点就崩溃
+type saveSubWorkflowFailRepo struct {
+	workflow.WorkflowRepo
+	isFailing bool
+	failCount int
+}
+
+func (r *saveSubWorkflowFailRepo) UpdateWorkflowTaskInstance(ctx context.Context, params *workflow.UpdateWorkflowTaskInstanceParams) error {
+	if params.Fields.NodeContext != nil && r.isFailing {
+		if _, ok := params.Fields.NodeContext.GetInt64(workflow.NodeContextKeySubWorkflow, "workflow_instance_id"); ok {
+			r.failCount++
+			return errors.New("save sub workflow node failed")
+		}
+	}
+	return r.WorkflowRepo.UpdateWorkflowTaskInstance(ctx, params)
+}
+
+// TestSubWorkflowCreateIdempotent 测试创建子工作流之后保存节点失败, 再次执行时使用已经创建的子工作流, 重启节点时创建新的子工作流
+func TestSubWorkflowCreateIdempotent(t *testing.T) {
+	repo := &saveSubWorkflowFailRepo{WorkflowRepo: setupTestRepo(t), isFailing: true}
+	service := workflow.NewWorkflowService(repo, workflow.NewLocalWorkflowLock())
+	ctx := context.Background()
+	parentType, verified := setupSubWorkflow(t, "sub_idempotent")
+
+	parent, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: parentType,
+		BusinessID:   "SUB-005",
+		IsRun:        true,
+	})
+	require.NoError(t, err)
+	require.Positive(t, repo.failCount)
+	require.Len(t, querySubWorkflowInstances(t, service, parent.ID), 1)
+	repo.isFailing = false
+
+	require.NoError(t, service.RunWorkflow(ctx, parent.ID))
+	children := querySubWorkflowInstances(t, service, parent.ID)
+	require.Len(t, children, 1, "再次执行不会重复创建子工作流")
+	kyc := queryTaskInstance(t, service, parent.ID, "kyc")
+	childID, _ := kyc.NodeContext.GetInt64(workflow.NodeContextKeySubWorkflow, "workflow_instance_id")
+	assert.Equal(t, children[0].ID, childID)
+
+	// 子工作流失败后重启节点, 创建新的子工作流
+	verified["ok"] = true
+	verified["reject"] = true
+	assert.ErrorIs(t, service.RunWorkflow(ctx, parent.ID), workflow.ErrWorkflowTaskFailedWithFailed)
+	verified["reject"] = false
+	_, err = service.RestartWorkflowNode(ctx, &workflow.RestartWorkflowNodeParams{
+		WorkflowInstanceID:      parent.ID,
+		TaskType:                "kyc",
+		IsForcedRestartWorkflow: true,
+		IsRun:                   true,
+	})
+	require.NoError(t, err)
+	require.NoError(t, service.RunWorkflow(ctx, parent.ID))
+	children = querySubWorkflowInstances(t, service, parent.ID)
+	require.Len(t, children, 2)
+	status, _ := queryTaskStatus(t, service, parent.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusCompleted, status)
+}
+
+// instanceLockFailWorkflowLock failingInstanceID对应实例的锁一直拿不到, 模拟级联操作子工作流时子工作流正在执行
+type instanceLockFailWorkflowLock struct {
+	workflow.WorkflowLock
+	failingInstanceID atomic.Int64
+}
+
+func (l *instanceLockFailWorkflowLock) NonBlockingSynchronized(ctx context.Context, key string, maxLockTimeDuration time.Duration, f func(context.Context) error) error {
+	if id := l.failingInstanceID.Load(); id > 0 && key == fmt.Sprintf("workflow_instance_execute_%d", id) {
+		return workflow.LockFailedError
+	}
+	return l.WorkflowLock.NonBlockingSynchronized(ctx, key, maxLockTimeDuration, f)
+}
+
+// TestSubWorkflowCancelCascadeRetry 测试级联取消子工作流失败后, 再次取消已经取消的父工作流会继续取消子工作流
+func TestSubWorkflowCancelCascadeRetry(t *testing.T) {
+	lock := &instanceLockFailWorkflowLock{WorkflowLock: workflow.NewLocalWorkflowLock()}
+	service := workflow.NewWorkflowService(setupTestRepo(t), lock)
+	ctx := context.Background()
+	parentType, _ := setupSubWorkflow(t, "sub_cancel_retry")
+
+	parent, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: parentType,
+		BusinessID:   "SUB-006",
+		IsRun:        true,
+	})
+	require.NoError(t, err)
+	children := querySubWorkflowInstances(t, service, parent.ID)
+	require.Len(t, children, 1)
+
+	lock.failingInstanceID.Store(children[0].ID)
+	assert.ErrorIs(t, service.CancelWorkflowInstance(ctx, parent.ID), workflow.LockFailedError)
+	status, _ := queryTaskStatus(t, service, parent.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusCancelled, status)
+	children = querySubWorkflowInstances(t, service, parent.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusRunning, children[0].Status)
+
+	// 父工作流已经取消, 再次取消继续级联取消子工作流
+	lock.failingInstanceID.Store(0)
+	require.NoError(t, service.CancelWorkflowInstance(ctx, parent.ID))
+	children = querySubWorkflowInstances(t, service, parent.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusCancelled, children[0].Status)
+}
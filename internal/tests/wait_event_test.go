@@ -0,0 +1,123 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/blingmoon/simple-workflow/workflow"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestWaitEventNode 测试等待事件节点, 收到满足过滤条件的事件后完成, 事件内容作为节点输出
+func TestWaitEventNode(t *testing.T) {
+	service := setupTestService(t)
+	ctx := context.Background()
+
+	// 结构: approval(等待审批事件) -> notify, 不需要为approval注册任务处理器
+	workflowConfigJSON := `{
+		"id": "wait_event_workflow",
+		"name": "等待事件工作流",
+		"nodes": [
+			{"id": "approval", "name": "人工审批", "next_nodes": ["notify"], "kind": "wait_event", "wait_event": {"filter": "event.approved == true"}},
+			{"id": "notify", "name": "通知", "next_nodes": []}
+		]
+	}`
+	loadWorkflowConfigJSON(t, workflowConfigJSON)
+	var approver string
+	require.NoError(t, workflow.RegisterWorkflowTask("wait_event_workflow", "notify",
+		workflow.NewNormalTaskWorker(
+			func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+				approver, _ = nodeContext.GetString("pre_node_context", "approval", "wait_event", "payload", "approver")
+				return nil
+			},
+			nil,
+		)))
+
+	instance, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: "wait_event_workflow",
+		BusinessID:   "WAIT-EVENT-001",
+		IsRun:        true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusPending, queryTaskInstance(t, service, instance.ID, "approval").Status)
+
+	addEvent := func(eventTs int64, content string) {
+		require.NoError(t, service.AddNodeExternalEvent(ctx, &workflow.AddNodeExternalEventParams{
+			WorkflowInstanceID: instance.ID,
+			TaskType:           "approval",
+			NodeEvent:          &workflow.NodeExternalEvent{EventTs: eventTs, EventContent: content},
+		}))
+		require.NoError(t, service.RunWorkflow(ctx, instance.ID))
+	}
+
+	// 不满足过滤条件的事件, 继续等待
+	addEvent(1, `{"approved": false, "approver": "李四"}`)
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusPending, queryTaskInstance(t, service, instance.ID, "approval").Status)
+
+	addEvent(2, `{"approved": true, "approver": "王五"}`)
+	status, _ := queryTaskStatus(t, service, instance.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusCompleted, status)
+	assert.Equal(t, "王五", approver)
+}
+
+// TestWaitEventNodeTimeoutBranch 测试等待事件超时后走超时分支
+func TestWaitEventNodeTimeoutBranch(t *testing.T) {
+	service := setupTestService(t)
+	ctx := context.Background()
+
+	workflowConfigJSON := `{
+		"id": "wait_event_timeout_workflow",
+		"name": "等待事件超时工作流",
+		"nodes": [
+			{
+				"id": "approval",
+				"name": "人工审批",
+				"next_nodes": ["approved", "escalate"],
+				"kind": "wait_event",
+				"max_wait_time_ts": 1,
+				"wait_event": {"on_timeout": "branch", "timeout_node": "escalate"}
+			},
+			{"id": "approved", "name": "审批通过", "next_nodes": []},
+			{"id": "escalate", "name": "升级处理", "next_nodes": []}
+		]
+	}`
+	loadWorkflowConfigJSON(t, workflowConfigJSON)
+	registerNoopTaskWorkers(t, "wait_event_timeout_workflow", "approved", "escalate")
+
+	instance, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: "wait_event_timeout_workflow",
+		BusinessID:   "WAIT-EVENT-002",
+		IsRun:        true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusPending, queryTaskInstance(t, service, instance.ID, "approval").Status)
+
+	time.Sleep(2100 * time.Millisecond)
+	require.NoError(t, service.RunWorkflow(ctx, instance.ID))
+	status, taskStatus := queryTaskStatus(t, service, instance.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusCompleted, status)
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusCompleted, taskStatus["escalate"])
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusSkipped, taskStatus["approved"])
+}
+
+// TestWaitEventConfigNotModified 测试构建工作流定义时不修改调用方加载的等待事件配置
+func TestWaitEventConfigNotModified(t *testing.T) {
+	workflowType := "wait_event_config_workflow"
+	waitEvent := &workflow.WaitEventConfig{Filter: "event.approved == true"}
+	original := *waitEvent
+	registry := workflow.NewRegistry()
+	require.NoError(t, registry.LoadWorkflowConfig(&workflow.WorkflowConfig{
+		ID:   workflowType,
+		Name: "等待事件配置",
+		Nodes: []*workflow.NodeDefinitionConfig{
+			{ID: "approval", Name: "人工审批", NextNodes: []string{}, Kind: workflow.NodeKindWaitEvent, WaitEvent: waitEvent},
+		},
+	}))
+	definition, err := registry.GetAndLoadWorkflowDefinition(workflowType)
+	require.NoError(t, err)
+	assert.Equal(t, original, *waitEvent, "解析结果保存在副本中")
+	require.Len(t, definition.RootNode.NextNodes, 1)
+	assert.NotSame(t, waitEvent, definition.RootNode.NextNodes[0].WaitEvent)
+}
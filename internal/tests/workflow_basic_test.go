@@ -12,17 +12,106 @@ import (
 	"gorm.io/gorm"
 )
 
-// setupTestService 创建测试服务
-func setupTestService(t *testing.T) workflow.WorkflowService {
+// setupTestRepo 创建测试使用的内存库
+func setupTestRepo(t *testing.T) workflow.WorkflowRepo {
 	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
 	require.NoError(t, err)
 
-	err = db.AutoMigrate(&workflow.WorkflowInstancePo{}, &workflow.WorkflowTaskInstancePo{})
+	err = db.AutoMigrate(&workflow.WorkflowInstancePo{}, &workflow.WorkflowTaskInstancePo{}, &workflow.WorkflowDefinitionPo{})
 	require.NoError(t, err)
+	return workflow.NewWorkflowRepo(db)
+}
 
-	repo := workflow.NewWorkflowRepo(db)
-	lock := workflow.NewLocalWorkflowLock()
-	return workflow.NewWorkflowService(repo, lock)
+// setupConcurrentTestRepo 创建并发测试使用的内存库
+// sqlite 内存库每个连接都是独立的数据库，并发场景需要限制为单连接
+func setupConcurrentTestRepo(t *testing.T) workflow.WorkflowRepo {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+
+	err = db.AutoMigrate(&workflow.WorkflowInstancePo{}, &workflow.WorkflowTaskInstancePo{}, &workflow.WorkflowDefinitionPo{})
+	require.NoError(t, err)
+	return workflow.NewWorkflowRepo(db)
+}
+
+// setupTestService 创建测试服务
+func setupTestService(t *testing.T, opts ...workflow.WorkflowServiceOption) workflow.WorkflowService {
+	return workflow.NewWorkflowService(setupTestRepo(t), workflow.NewLocalWorkflowLock(), opts...)
+}
+
+// setupConcurrentTestService 创建并发测试服务
+func setupConcurrentTestService(t *testing.T) workflow.WorkflowService {
+	return workflow.NewWorkflowService(setupConcurrentTestRepo(t), workflow.NewLocalWorkflowLock())
+}
+
+// loadWorkflowConfigJSON 解析并加载工作流配置到默认的Registry
+func loadWorkflowConfigJSON(t *testing.T, workflowConfigJSON string) {
+	var config workflow.WorkflowConfig
+	require.NoError(t, json.Unmarshal([]byte(workflowConfigJSON), &config))
+	require.NoError(t, workflow.LoadWorkflowConfig(&config))
+}
+
+// registerNoopTaskWorkers 给不关心执行逻辑的节点注册直接完成的工作器
+func registerNoopTaskWorkers(t *testing.T, workflowType string, taskKeys ...string) {
+	for _, taskKey := range taskKeys {
+		require.NoError(t, workflow.RegisterWorkflowTask(workflowType, taskKey,
+			workflow.NewNormalTaskWorker(func(ctx context.Context, nodeContext *workflow.JSONContext) error { return nil }, nil)))
+	}
+}
+
+// queryWorkflowInstanceStatus 查询工作流实例的状态
+func queryWorkflowInstanceStatus(t *testing.T, service workflow.WorkflowService, workflowInstanceID int64) workflow.WorkflowInstanceStatus {
+	instances, err := service.QueryWorkflowInstancePo(context.Background(), &workflow.QueryWorkflowInstanceParams{
+		WorkflowInstanceID: &workflowInstanceID,
+		Page:               &workflow.Pager{Page: 1, Size: 1},
+	})
+	require.NoError(t, err)
+	require.Len(t, instances, 1)
+	return instances[0].Status
+}
+
+// queryWorkflowInstanceDetail 查询工作流实例和所有任务实例
+func queryWorkflowInstanceDetail(t *testing.T, service workflow.WorkflowService, workflowInstanceID int64) *workflow.WorkflowInstanceDetailEntity {
+	details, err := service.QueryWorkflowInstanceDetail(context.Background(), &workflow.QueryWorkflowInstanceParams{
+		WorkflowInstanceID: &workflowInstanceID,
+		Page:               &workflow.Pager{Page: 1, Size: 1},
+	})
+	require.NoError(t, err)
+	require.Len(t, details, 1)
+	return details[0]
+}
+
+// queryTaskStatus 查询工作流实例的状态和每个节点的状态, 同一个节点有多个任务实例时返回最后一个的状态
+func queryTaskStatus(t *testing.T, service workflow.WorkflowService, workflowInstanceID int64) (string, map[string]string) {
+	detail := queryWorkflowInstanceDetail(t, service, workflowInstanceID)
+	taskStatus := make(map[string]string)
+	for _, taskInstance := range detail.TaskInstances {
+		taskStatus[taskInstance.TaskType] = taskInstance.Status
+	}
+	return detail.Status, taskStatus
+}
+
+// queryTaskInstance 查询节点的任务实例, 同一个节点有多个任务实例时返回第一个
+func queryTaskInstance(t *testing.T, service workflow.WorkflowService, workflowInstanceID int64, taskType string) *workflow.TaskInstanceEntity {
+	for _, taskInstance := range queryWorkflowInstanceDetail(t, service, workflowInstanceID).TaskInstances {
+		if taskInstance.TaskType == taskType {
+			return taskInstance
+		}
+	}
+	t.Fatalf("task instance %s not found", taskType)
+	return nil
+}
+
+// querySubWorkflowInstances 查询父工作流实例创建的子工作流实例
+func querySubWorkflowInstances(t *testing.T, service workflow.WorkflowService, parentID int64) []*workflow.WorkflowInstancePo {
+	children, err := service.QueryWorkflowInstancePo(context.Background(), &workflow.QueryWorkflowInstanceParams{
+		ParentWorkflowInstanceID: &parentID,
+		Page:                     &workflow.Pager{Page: 1, Size: 10},
+	})
+	require.NoError(t, err)
+	return children
 }
 
 // TestWorkflowCreationBasic 测试基础工作流创建
@@ -285,7 +374,7 @@ func TestWorkflowContext(t *testing.T) {
 // BenchmarkWorkflowCreation 性能测试
 func BenchmarkWorkflowCreation(b *testing.B) {
 	db, _ := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
-	_ = db.AutoMigrate(&workflow.WorkflowInstancePo{}, &workflow.WorkflowTaskInstancePo{})
+	_ = db.AutoMigrate(&workflow.WorkflowInstancePo{}, &workflow.WorkflowTaskInstancePo{}, &workflow.WorkflowDefinitionPo{})
 	
 	repo := workflow.NewWorkflowRepo(db)
 	lock := workflow.NewLocalWorkflowLock()
@@ -0,0 +1,180 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/blingmoon/simple-workflow/workflow"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestWorkflowVersionPinned 测试加载新版本后, 已经创建的实例继续按照创建时的版本执行, 新实例使用最新版本
+func TestWorkflowVersionPinned(t *testing.T) {
+	service := setupTestService(t)
+	ctx := context.Background()
+	workflowType := "version_pinned_workflow"
+	loadWorkflowConfigJSON(t, fmt.Sprintf(`{
+		"id": %q,
+		"name": "版本工作流",
+		"nodes": [
+			{"id": "submit", "name": "提交", "next_nodes": ["review"]},
+			{"id": "review", "name": "审核", "next_nodes": []}
+		]
+	}`, workflowType))
+	approved, notifyCount := false, 0
+	registerNoopTaskWorkers(t, workflowType, "submit")
+	require.NoError(t, workflow.RegisterWorkflowTask(workflowType, "review",
+		workflow.NewNormalTaskWorker(
+			func(ctx context.Context, nodeContext *workflow.JSONContext) error { return nil },
+			func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+				if !approved {
+					return workflow.ErrorWorkflowTaskInstanceNotReady
+				}
+				return nil
+			})))
+	require.NoError(t, workflow.RegisterWorkflowTask(workflowType, "notify",
+		workflow.NewNormalTaskWorker(func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+			notifyCount++
+			return nil
+		}, nil)))
+
+	v1Instance, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: workflowType,
+		BusinessID:   "VERSION-001",
+		IsRun:        true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), v1Instance.WorkflowVersion, "没有配置版本号时使用版本1")
+
+	// 已经加载过的版本不能重复加载
+	var duplicated workflow.WorkflowConfig
+	duplicated.ID = workflowType
+	duplicated.Version = 1
+	assert.Error(t, workflow.LoadWorkflowConfig(&duplicated))
+
+	loadWorkflowConfigJSON(t, fmt.Sprintf(`{
+		"id": %q,
+		"name": "版本工作流",
+		"version": 2,
+		"nodes": [
+			{"id": "submit", "name": "提交", "next_nodes": ["review"]},
+			{"id": "review", "name": "审核", "next_nodes": ["notify"]},
+			{"id": "notify", "name": "通知", "next_nodes": []}
+		]
+	}`, workflowType))
+	v2Instance, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: workflowType,
+		BusinessID:   "VERSION-002",
+		IsRun:        true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), v2Instance.WorkflowVersion, "新实例默认使用最新版本")
+	assert.Equal(t, int64(2), v2Instance.Definitions.Version)
+
+	pinnedInstance, err := service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType:    workflowType,
+		BusinessID:      "VERSION-003",
+		WorkflowVersion: 1,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), pinnedInstance.WorkflowVersion, "可以指定旧版本创建实例")
+
+	_, err = service.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType:    workflowType,
+		BusinessID:      "VERSION-004",
+		WorkflowVersion: 3,
+		IsRun:           true,
+	})
+	assert.ErrorIs(t, err, workflow.ErrWorkflowConfigNotFound)
+
+	approved = true
+	for _, instance := range []*workflow.WorkflowInstance{v1Instance, v2Instance, pinnedInstance} {
+		require.NoError(t, service.RunWorkflow(ctx, instance.ID))
+	}
+	status, taskStatus := queryTaskStatus(t, service, v1Instance.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusCompleted, status)
+	_, hasNotify := taskStatus["notify"]
+	assert.False(t, hasNotify, "版本1的实例没有notify节点")
+	status, _ = queryTaskStatus(t, service, pinnedInstance.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusCompleted, status)
+	status, taskStatus = queryTaskStatus(t, service, v2Instance.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusCompleted, status)
+	assert.Equal(t, workflow.WorkflowTaskNodeStatusCompleted, taskStatus["notify"])
+	assert.Equal(t, 1, notifyCount)
+
+	detail := queryWorkflowInstanceDetail(t, service, v1Instance.ID)
+	assert.Equal(t, int64(1), detail.WorkflowVersion)
+	assert.Len(t, detail.TaskInstances, 4, "详情按照实例的版本构建节点")
+}
+
+// TestWorkflowVersionPinnedOnFirstRun 测试创建实例的容器没有工作流定义时, 第一次执行把使用的版本写回实例, 之后加载的新版本不影响这个实例
+func TestWorkflowVersionPinnedOnFirstRun(t *testing.T) {
+	ctx := context.Background()
+	workflowType := "version_first_run_workflow"
+	repo := setupTestRepo(t)
+	lock := workflow.NewLocalWorkflowLock()
+
+	registry := workflow.NewRegistry()
+	config := &workflow.WorkflowConfig{
+		ID:   workflowType,
+		Name: "版本工作流",
+		Nodes: []*workflow.NodeDefinitionConfig{
+			{ID: "submit", Name: "提交", NextNodes: []string{"review"}},
+			{ID: "review", Name: "审核", NextNodes: []string{}},
+		},
+	}
+	require.NoError(t, registry.LoadWorkflowConfig(config))
+	assert.Error(t, registry.LoadWorkflowConfig(config), "没有构建过工作流定义的版本也不能重复加载")
+	approved := false
+	require.NoError(t, registry.RegisterWorkflowTask(workflowType, "submit",
+		workflow.NewNormalTaskWorker(func(ctx context.Context, nodeContext *workflow.JSONContext) error { return nil }, nil)))
+	require.NoError(t, registry.RegisterWorkflowTask(workflowType, "review",
+		workflow.NewNormalTaskWorker(
+			func(ctx context.Context, nodeContext *workflow.JSONContext) error { return nil },
+			func(ctx context.Context, nodeContext *workflow.JSONContext) error {
+				if !approved {
+					return workflow.ErrorWorkflowTaskInstanceNotReady
+				}
+				return nil
+			})))
+	require.NoError(t, registry.RegisterWorkflowTask(workflowType, "notify",
+		workflow.NewNormalTaskWorker(func(ctx context.Context, nodeContext *workflow.JSONContext) error { return nil }, nil)))
+
+	// 创建实例的服务没有加载工作流配置
+	creator := workflow.NewWorkflowService(repo, lock, workflow.WithRegistry(workflow.NewRegistry()))
+	runner := workflow.NewWorkflowService(repo, lock, workflow.WithRegistry(registry))
+	instance, err := creator.CreateWorkflow(ctx, &workflow.CreateWorkflowReq{
+		WorkflowType: workflowType,
+		BusinessID:   "VERSION-005",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), instance.WorkflowVersion)
+
+	require.NoError(t, runner.RunWorkflow(ctx, instance.ID))
+	instances, err := repo.QueryWorkflowInstance(ctx, &workflow.QueryWorkflowInstanceParams{
+		WorkflowInstanceID: &instance.ID,
+		Page:               &workflow.Pager{Page: 1, Size: 1},
+	})
+	require.NoError(t, err)
+	require.Len(t, instances, 1)
+	assert.Equal(t, int64(1), instances[0].WorkflowVersion, "第一次执行时固定版本")
+
+	require.NoError(t, registry.LoadWorkflowConfig(&workflow.WorkflowConfig{
+		ID:      workflowType,
+		Name:    "版本工作流",
+		Version: 2,
+		Nodes: []*workflow.NodeDefinitionConfig{
+			{ID: "submit", Name: "提交", NextNodes: []string{"review"}},
+			{ID: "review", Name: "审核", NextNodes: []string{"notify"}},
+			{ID: "notify", Name: "通知", NextNodes: []string{}},
+		},
+	}))
+	approved = true
+	require.NoError(t, runner.RunWorkflow(ctx, instance.ID))
+	status, taskStatus := queryTaskStatus(t, runner, instance.ID)
+	assert.Equal(t, workflow.WorkflowInstanceStatusCompleted, status)
+	_, hasNotify := taskStatus["notify"]
+	assert.False(t, hasNotify, "加载新版本后继续按照固定的版本1执行")
+}
@@ -0,0 +1,159 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"slices"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func (s *WorkflowServiceImpl) CompensateWorkflowInstance(ctx context.Context, workflowInstanceID int64) error {
+	if workflowInstanceID <= 0 {
+		return errors.Wrapf(ErrWorkflowParamInvalid, "CompensateWorkflowInstance failed, workflowInstanceID: %d", workflowInstanceID)
+	}
+	return s.executeLock.NonBlockingSynchronized(ctx,
+		workflowOpLockKey(workflowInstanceID),
+		10*time.Minute,
+		func(ctx context.Context) error {
+			workflowInstances, err := s.repo.QueryWorkflowInstance(ctx, &QueryWorkflowInstanceParams{
+				WorkflowInstanceID: &workflowInstanceID,
+				Page:               &Pager{Page: 1, Size: 1},
+			})
+			if err != nil {
+				return errors.WithMessagef(err, "QueryWorkflowInstance failed, workflowInstanceID: %d", workflowInstanceID)
+			}
+			if len(workflowInstances) == 0 {
+				return errors.WithMessagef(ErrWorkflowInstanceNotFound, "WorkflowInstance not found, workflowInstanceID: %d", workflowInstanceID)
+			}
+			status := workflowInstances[0].Status
+			if status != WorkflowInstanceStatusFailed && status != WorkflowInstanceStatusCancelled && status != WorkflowInstanceStatusTimedOut {
+				return errors.WithMessagef(ErrWorkflowParamInvalid, "only failed, cancelled or timed out workflow can be compensated, workflowInstanceID: %d, status: %s", workflowInstanceID, status)
+			}
+			workflowDefinition, err := s.getWorkflowDefinitionForInstance(workflowInstances[0])
+			if err != nil {
+				return errors.WithMessagef(err, "GetAndLoadWorkflowDefinition failed, workflowType: %s, version: %d", workflowInstances[0].WorkflowType, workflowInstances[0].WorkflowVersion)
+			}
+			return s.compensateWorkflowInstance(ctx, workflowDefinition, workflowInstanceID)
+		})
+}
+
+// compensateWorkflowInstance 按照完成时间倒序补偿已经完成的任务实例, 调用方需要持有工作流的锁
+// 需要补偿的任务实例先全部标记为pending, 再逐个补偿, 中途失败或者崩溃后再次调用会从pending的任务实例继续
+func (s *WorkflowServiceImpl) compensateWorkflowInstance(ctx context.Context, workflowDefinition *WorkflowDefinition, workflowInstanceID int64) error {
+	nodeMap := make(map[string]*WorkflowTaskNodeDefinition, len(workflowDefinition.Nodes))
+	for _, node := range workflowDefinition.Nodes {
+		nodeMap[node.TaskType] = node
+	}
+	taskInstances, err := s.getAllTaskInstancePo(ctx, workflowInstanceID)
+	if err != nil {
+		return errors.WithMessagef(err, "getAllTaskInstancePo failed, workflowInstanceID: %d", workflowInstanceID)
+	}
+	compensateTasks := make([]*WorkflowTaskInstancePo, 0)
+	newPendingTaskIDs := make([]int64, 0)
+	compensators := make(map[int64]WorkflowTaskNodeCompensator)
+	for _, taskInstance := range taskInstances {
+		if taskInstance.Status != WorkflowTaskNodeStatusCompleted {
+			continue
+		}
+		if taskInstance.CompensationStatus != CompensationStatusNone && taskInstance.CompensationStatus != CompensationStatusPending {
+			continue
+		}
+		node, ok := nodeMap[taskInstance.TaskType]
+		if !ok {
+			continue
+		}
+		if node.Kind != NodeKindNormal && taskInstance.ItemIndex == nil {
+			// 内置类型的节点没有副作用, map节点由子任务各自补偿
+			continue
+		}
+		compensator, ok := node.TaskWorker.(WorkflowTaskNodeCompensator)
+		if !ok {
+			continue
+		}
+		if _, ok := NewByte2StrctPbValue(taskInstance.NodeContext).Get(NodeContextKeySystem, "operation"); ok {
+			// 人工完成的任务实例没有执行过工作器, 不需要补偿
+			continue
+		}
+		compensators[taskInstance.ID] = compensator
+		compensateTasks = append(compensateTasks, taskInstance)
+		if taskInstance.CompensationStatus == CompensationStatusNone {
+			newPendingTaskIDs = append(newPendingTaskIDs, taskInstance.ID)
+		}
+	}
+	if len(newPendingTaskIDs) > 0 {
+		err = s.repo.UpdateWorkflowTaskInstance(ctx, &UpdateWorkflowTaskInstanceParams{
+			Where: &UpdateWorkflowTaskInstanceWhere{
+				IDIn: newPendingTaskIDs,
+			},
+			Fields: &UpdateWorkflowTaskInstanceField{
+				CompensationStatus: String(CompensationStatusPending),
+			},
+			LimitMax: len(newPendingTaskIDs),
+		})
+		if err != nil {
+			return errors.WithMessagef(err, "UpdateWorkflowTaskInstance failed, workflowInstanceID: %d", workflowInstanceID)
+		}
+	}
+	// 后完成的先补偿, 同一秒完成的按照ID倒序
+	slices.SortFunc(compensateTasks, func(a, b *WorkflowTaskInstancePo) int {
+		if a.CompletedAt != b.CompletedAt {
+			return int(b.CompletedAt - a.CompletedAt)
+		}
+		return int(b.ID - a.ID)
+	})
+	for _, taskInstance := range compensateTasks {
+		nodeContext := NewByte2StrctPbValue(taskInstance.NodeContext)
+		compensationStatus := CompensationStatusCompleted
+		err := s.compensateTask(ctx, compensators[taskInstance.ID], nodeContext)
+		if err != nil {
+			s.addTaskNodeContextSystemError(err, nodeContext)
+			if !errors.Is(err, ErrWorkflowTaskFailedWithFailed) {
+				// 补偿中断, 保存错误信息, 下次从这个任务实例继续
+				newErr := s.repo.UpdateWorkflowTaskInstance(ctx, &UpdateWorkflowTaskInstanceParams{
+					Where: &UpdateWorkflowTaskInstanceWhere{
+						IDIn: []int64{taskInstance.ID},
+					},
+					Fields: &UpdateWorkflowTaskInstanceField{
+						NodeContext: nodeContext,
+					},
+					LimitMax: 1,
+				})
+				if newErr != nil {
+					slog.ErrorContext(ctx, fmt.Sprintf("UpdateWorkflowTaskInstance failed,err: %v", newErr))
+				}
+				return errors.WithMessagef(err, "Compensate failed, workflowInstanceID: %d, taskInstanceID: %d, taskType: %s", workflowInstanceID, taskInstance.ID, taskInstance.TaskType)
+			}
+			// 补偿失败并且不再重试, 继续补偿其他的任务实例
+			slog.ErrorContext(ctx, fmt.Sprintf("[error]Compensate failed, workflowInstanceID: %d, taskInstanceID: %d, taskType: %s, err: %v", workflowInstanceID, taskInstance.ID, taskInstance.TaskType, err))
+			compensationStatus = CompensationStatusFailed
+		}
+		err = s.repo.UpdateWorkflowTaskInstance(ctx, &UpdateWorkflowTaskInstanceParams{
+			Where: &UpdateWorkflowTaskInstanceWhere{
+				IDIn: []int64{taskInstance.ID},
+			},
+			Fields: &UpdateWorkflowTaskInstanceField{
+				NodeContext:        nodeContext,
+				CompensationStatus: &compensationStatus,
+			},
+			LimitMax: 1,
+		})
+		if err != nil {
+			return errors.WithMessagef(err, "UpdateWorkflowTaskInstance failed, workflowInstanceID: %d, taskInstanceID: %d", workflowInstanceID, taskInstance.ID)
+		}
+	}
+	return nil
+}
+
+func (s *WorkflowServiceImpl) compensateTask(ctx context.Context, compensator WorkflowTaskNodeCompensator, nodeContext *JSONContext) (err error) {
+	defer func() {
+		// panic 捕捉一下，当作补偿中断处理
+		if r := recover(); r != nil {
+			err = errors.Errorf("Compensate panic: %v, stack: %s", r, string(debug.Stack()))
+		}
+	}()
+	return compensator.Compensate(ctx, nodeContext)
+}
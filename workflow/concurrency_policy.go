@@ -0,0 +1,224 @@
+package workflow
+
+import (
+	"context"
+	goerrors "errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ConcurrencyPolicy 同一个工作流类型下, 相同BusinessID的多个实例之间的并发策略
+type ConcurrencyPolicy string
+
+const (
+	ConcurrencyPolicyAllow  ConcurrencyPolicy = "allow"  // 不限制, 默认策略
+	ConcurrencyPolicyReject ConcurrencyPolicy = "reject" // 已经有未结束的实例时拒绝创建, 返回ErrWorkflowConcurrencyRejected
+	ConcurrencyPolicyQueue  ConcurrencyPolicy = "queue"  // 已经有未结束的实例时创建为queued状态, 前一个实例结束后自动开始
+)
+
+const (
+	defaultBusinessLockRetryCount  = 100                   // 拿不到业务锁时的重试次数
+	defaultBusinessLockRetryPeriod = 10 * time.Millisecond // 拿不到业务锁时的重试间隔, 业务锁只在检查和创建实例时短暂持有
+)
+
+// activeWorkflowInstanceStatuses 占用并发名额的状态, 暂停的实例恢复后会继续执行, 也占用名额
+var activeWorkflowInstanceStatuses = []string{WorkflowInstanceStatusInit, WorkflowInstanceStatusRunning, WorkflowInstanceStatusPaused}
+
+// checkConcurrencyPolicy 检查工作流的并发策略配置
+func (c *WorkflowConfig) checkConcurrencyPolicy() error {
+	switch c.ConcurrencyPolicy {
+	case "", ConcurrencyPolicyAllow, ConcurrencyPolicyReject, ConcurrencyPolicyQueue:
+		return nil
+	}
+	return errors.Errorf("invalid concurrency_policy: %s", c.ConcurrencyPolicy)
+}
+
+// getConcurrencyPolicy 工作流定义不存在(创建和执行不在同一个容器)或者没有业务ID时不做限制
+func getConcurrencyPolicy(definition *WorkflowDefinition, businessID string) ConcurrencyPolicy {
+	if definition == nil || businessID == "" || definition.ConcurrencyPolicy == "" {
+		return ConcurrencyPolicyAllow
+	}
+	return definition.ConcurrencyPolicy
+}
+
+func workflowBusinessLockKey(workflowType string, businessID string) string {
+	return fmt.Sprintf("workflow_business_%s_%s", workflowType, businessID)
+}
+
+// withConcurrencyPolicyLock 需要限制并发时, 检查和创建在同一个业务锁中完成, 多个进程同时创建时依次拿到锁
+func (s *WorkflowServiceImpl) withConcurrencyPolicyLock(ctx context.Context, definition *WorkflowDefinition, workflowType string, businessID string, f func(context.Context) error) error {
+	if getConcurrencyPolicy(definition, businessID) == ConcurrencyPolicyAllow {
+		return f(ctx)
+	}
+	return s.synchronizedWithBusinessLock(ctx, workflowType, businessID, f)
+}
+
+// synchronizedWithBusinessLock 拿不到业务锁时等待一段时间后重试, 避免同时创建的实例直接返回LockFailedError
+// f已经执行过时不会重试, f返回的LockFailedError直接返回
+func (s *WorkflowServiceImpl) synchronizedWithBusinessLock(ctx context.Context, workflowType string, businessID string, f func(context.Context) error) error {
+	var err error
+	for i := 0; i <= defaultBusinessLockRetryCount; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return errors.WithMessagef(err, "ctx done, workflowType: %s, businessID: %s, ctxErr: %v", workflowType, businessID, ctx.Err())
+			case <-time.After(defaultBusinessLockRetryPeriod):
+			}
+		}
+		isEntered := false
+		err = s.executeLock.NonBlockingSynchronized(ctx, workflowBusinessLockKey(workflowType, businessID), time.Minute, func(ctx context.Context) error {
+			isEntered = true
+			return f(ctx)
+		})
+		if isEntered || !errors.Is(err, LockFailedError) {
+			return err
+		}
+	}
+	return err
+}
+
+// applyConcurrencyPolicy 按照并发策略处理即将创建的实例, 调用方需要持有业务锁
+func (s *WorkflowServiceImpl) applyConcurrencyPolicy(ctx context.Context, definition *WorkflowDefinition, workflowInstance *WorkflowInstancePo) error {
+	policy := getConcurrencyPolicy(definition, workflowInstance.BusinessID)
+	if policy == ConcurrencyPolicyAllow {
+		return nil
+	}
+	// 排队中的实例也算, 不能插队
+	count, err := s.repo.CountWorkflowInstance(ctx, &QueryWorkflowInstanceParams{
+		WorkflowTypeIn: []string{workflowInstance.WorkflowType},
+		BusinessID:     &workflowInstance.BusinessID,
+		StatusIn:       append([]string{WorkflowInstanceStatusQueued}, activeWorkflowInstanceStatuses...),
+	})
+	if err != nil {
+		return errors.WithMessagef(err, "CountWorkflowInstance failed, workflowType: %s, businessID: %s", workflowInstance.WorkflowType, workflowInstance.BusinessID)
+	}
+	if count == 0 {
+		return nil
+	}
+	if policy == ConcurrencyPolicyReject {
+		return errors.WithMessagef(ErrWorkflowConcurrencyRejected, "workflowType: %s, businessID: %s, unfinished count: %d", workflowInstance.WorkflowType, workflowInstance.BusinessID, count)
+	}
+	workflowInstance.Status = WorkflowInstanceStatusQueued
+	return nil
+}
+
+// startNextQueuedWorkflowInstance 没有占用并发名额的实例时, 把最早排队的实例改成init并在后台执行
+// 实例结束时调用, 多次调用没有副作用
+func (s *WorkflowServiceImpl) startNextQueuedWorkflowInstance(ctx context.Context, workflowType string, businessID string) error {
+	nextWorkflowInstanceID, err := s.promoteNextQueuedWorkflowInstance(ctx, workflowType, businessID)
+	if err != nil {
+		return errors.WithMessagef(err, "startNextQueuedWorkflowInstance failed, workflowType: %s, businessID: %s", workflowType, businessID)
+	}
+	if nextWorkflowInstanceID > 0 {
+		// 释放业务锁之后再提交, 后台执行时不能带着业务锁的ctx
+		s.runWorkflowAsync(ctx, nextWorkflowInstanceID)
+	}
+	return nil
+}
+
+// promoteNextQueuedWorkflowInstance 没有占用并发名额的实例时, 把最早排队的实例改成init, 返回改成init的实例ID, 没有时返回0
+func (s *WorkflowServiceImpl) promoteNextQueuedWorkflowInstance(ctx context.Context, workflowType string, businessID string) (int64, error) {
+	nextWorkflowInstanceID := int64(0)
+	err := s.synchronizedWithBusinessLock(ctx, workflowType, businessID,
+		func(ctx context.Context) error {
+			activeCount, err := s.repo.CountWorkflowInstance(ctx, &QueryWorkflowInstanceParams{
+				WorkflowTypeIn: []string{workflowType},
+				BusinessID:     &businessID,
+				StatusIn:       activeWorkflowInstanceStatuses,
+			})
+			if err != nil {
+				return errors.WithMessagef(err, "CountWorkflowInstance failed, workflowType: %s, businessID: %s", workflowType, businessID)
+			}
+			if activeCount > 0 {
+				return nil
+			}
+			queued, err := s.repo.QueryWorkflowInstance(ctx, &QueryWorkflowInstanceParams{
+				WorkflowTypeIn: []string{workflowType},
+				BusinessID:     &businessID,
+				StatusIn:       []string{WorkflowInstanceStatusQueued},
+				OrderbyIDAsc:   Bool(true),
+				Page:           &Pager{Page: 1, Size: 1},
+			})
+			if err != nil {
+				return errors.WithMessagef(err, "QueryWorkflowInstance failed, workflowType: %s, businessID: %s", workflowType, businessID)
+			}
+			if len(queued) == 0 {
+				return nil
+			}
+			err = s.repo.UpdateWorkflowInstance(ctx, &UpdateWorkflowInstanceParams{
+				Where: &UpdateWorkflowInstanceWhere{
+					IDIn:     []int64{queued[0].ID},
+					StatusIn: []string{WorkflowInstanceStatusQueued},
+				},
+				Fields: &UpdateWorkflowInstanceField{
+					Status: String(WorkflowInstanceStatusInit),
+				},
+				LimitMax: 1,
+			})
+			if err != nil {
+				return errors.WithMessagef(err, "UpdateWorkflowInstance failed, workflowInstanceID: %d", queued[0].ID)
+			}
+			nextWorkflowInstanceID = queued[0].ID
+			return nil
+		})
+	if err != nil {
+		return 0, err
+	}
+	return nextWorkflowInstanceID, nil
+}
+
+// StartQueuedWorkflowInstances 检查所有排队中的实例, 相同BusinessID没有占用并发名额的实例时把最早排队的实例改成init
+// 实例结束时没有拿到业务锁或者进程退出, 排队的实例由这里兜底, 调度器每一轮调用
+func (s *WorkflowServiceImpl) StartQueuedWorkflowInstances(ctx context.Context, workflowTypes []string) error {
+	checked := make(map[string]struct{})
+	errorlist := make([]error, 0)
+	lastID := int64(0)
+	pageSize := int64(100)
+	for {
+		params := &QueryWorkflowInstanceParams{
+			StatusIn:      []string{WorkflowInstanceStatusQueued},
+			IDGreaterThan: Int64(lastID),
+			OrderbyIDAsc:  Bool(true),
+			Page:          &Pager{Page: 1, Size: pageSize},
+		}
+		if len(workflowTypes) > 0 {
+			params.WorkflowTypeIn = workflowTypes
+		}
+		queued, err := s.repo.QueryWorkflowInstance(ctx, params)
+		if err != nil {
+			return errors.WithMessagef(err, "QueryWorkflowInstance failed, lastID: %d", lastID)
+		}
+		for _, workflowInstance := range queued {
+			lastID = workflowInstance.ID
+			key := workflowBusinessLockKey(workflowInstance.WorkflowType, workflowInstance.BusinessID)
+			if _, ok := checked[key]; ok {
+				continue
+			}
+			checked[key] = struct{}{}
+			_, err := s.promoteNextQueuedWorkflowInstance(ctx, workflowInstance.WorkflowType, workflowInstance.BusinessID)
+			if err != nil && !errors.Is(err, LockFailedError) {
+				errorlist = append(errorlist, errors.WithMessagef(err, "workflowType: %s, businessID: %s", workflowInstance.WorkflowType, workflowInstance.BusinessID))
+			}
+		}
+		if int64(len(queued)) < pageSize {
+			break
+		}
+	}
+	if len(errorlist) > 0 {
+		return goerrors.Join(errorlist...)
+	}
+	return nil
+}
+
+// tryStartNextQueuedWorkflowInstance 实例结束后开始下一个排队的实例, 失败只记录日志, 下一次创建或者结束时会再尝试
+func (s *WorkflowServiceImpl) tryStartNextQueuedWorkflowInstance(ctx context.Context, definition *WorkflowDefinition, workflowType string, businessID string) {
+	if getConcurrencyPolicy(definition, businessID) != ConcurrencyPolicyQueue {
+		return
+	}
+	if err := s.startNextQueuedWorkflowInstance(ctx, workflowType, businessID); err != nil {
+		slog.ErrorContext(ctx, fmt.Sprintf("startNextQueuedWorkflowInstance failed, workflowType: %s, businessID: %s, err: %v", workflowType, businessID, err))
+	}
+}
@@ -0,0 +1,407 @@
+package workflow
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// Condition 条件表达式, 针对节点上下文(JSONContext)求值
+// 支持的语法:
+//   - 路径: workflow_context.amount, pre_node_context.review.result, 按 "." 分隔取值, 不存在时为null
+//   - 字面量: 数字 10000 / 1.5, 字符串 'approved' 或 "approved", true, false, null
+//   - 比较: == != > >= < <=, 数字按数值比较, 字符串按字典序比较
+//   - 逻辑: && || ! 以及括号
+//   - 单独的路径或字面量按真值判断: false/null/0/"" 为假, 其他为真
+//
+// 例如: workflow_context.amount > 10000 && review.result == 'approved'
+type Condition struct {
+	expression string
+	root       conditionExpr
+}
+
+// ParseCondition 解析条件表达式
+func ParseCondition(expression string) (*Condition, error) {
+	tokens, err := tokenizeCondition(expression)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "tokenize condition failed, expression: %s", expression)
+	}
+	parser := &conditionParser{tokens: tokens}
+	root, err := parser.parseOr()
+	if err != nil {
+		return nil, errors.WithMessagef(err, "parse condition failed, expression: %s", expression)
+	}
+	if !parser.isEnd() {
+		return nil, errors.Errorf("parse condition failed, unexpected token %q, expression: %s", parser.peek().text, expression)
+	}
+	return &Condition{expression: expression, root: root}, nil
+}
+
+// Evaluate 使用节点上下文求值
+func (c *Condition) Evaluate(nodeContext *JSONContext) bool {
+	if c == nil {
+		return true
+	}
+	if nodeContext == nil {
+		nodeContext = NewJSONContext(nil)
+	}
+	return isConditionValueTrue(c.root.eval(nodeContext))
+}
+
+func (c *Condition) String() string {
+	if c == nil {
+		return ""
+	}
+	return c.expression
+}
+
+type conditionExpr interface {
+	eval(nodeContext *JSONContext) any
+}
+
+type conditionLiteral struct {
+	value any
+}
+
+func (e *conditionLiteral) eval(nodeContext *JSONContext) any {
+	return e.value
+}
+
+type conditionPath struct {
+	keys []string
+}
+
+func (e *conditionPath) eval(nodeContext *JSONContext) any {
+	value, ok := nodeContext.Get(e.keys...)
+	if !ok {
+		return nil
+	}
+	return value
+}
+
+type conditionNot struct {
+	expr conditionExpr
+}
+
+func (e *conditionNot) eval(nodeContext *JSONContext) any {
+	return !isConditionValueTrue(e.expr.eval(nodeContext))
+}
+
+type conditionLogic struct {
+	op          string
+	left, right conditionExpr
+}
+
+func (e *conditionLogic) eval(nodeContext *JSONContext) any {
+	left := isConditionValueTrue(e.left.eval(nodeContext))
+	if e.op == "&&" {
+		return left && isConditionValueTrue(e.right.eval(nodeContext))
+	}
+	return left || isConditionValueTrue(e.right.eval(nodeContext))
+}
+
+type conditionCompare struct {
+	op          string
+	left, right conditionExpr
+}
+
+func (e *conditionCompare) eval(nodeContext *JSONContext) any {
+	left := e.left.eval(nodeContext)
+	right := e.right.eval(nodeContext)
+	leftNumber, isLeftNumber := toConditionNumber(left)
+	rightNumber, isRightNumber := toConditionNumber(right)
+	if isLeftNumber && isRightNumber {
+		switch e.op {
+		case "==":
+			return leftNumber == rightNumber
+		case "!=":
+			return leftNumber != rightNumber
+		case ">":
+			return leftNumber > rightNumber
+		case ">=":
+			return leftNumber >= rightNumber
+		case "<":
+			return leftNumber < rightNumber
+		case "<=":
+			return leftNumber <= rightNumber
+		}
+		return false
+	}
+	leftString, isLeftString := left.(string)
+	rightString, isRightString := right.(string)
+	if isLeftString && isRightString {
+		switch e.op {
+		case "==":
+			return leftString == rightString
+		case "!=":
+			return leftString != rightString
+		case ">":
+			return leftString > rightString
+		case ">=":
+			return leftString >= rightString
+		case "<":
+			return leftString < rightString
+		case "<=":
+			return leftString <= rightString
+		}
+		return false
+	}
+	// 类型不一致或者是bool/null, 只支持相等判断
+	switch e.op {
+	case "==":
+		return isConditionValueEqual(left, right)
+	case "!=":
+		return !isConditionValueEqual(left, right)
+	}
+	return false
+}
+
+func isConditionValueEqual(left, right any) bool {
+	switch l := left.(type) {
+	case nil:
+		return right == nil
+	case bool:
+		r, ok := right.(bool)
+		return ok && l == r
+	}
+	return false
+}
+
+func toConditionNumber(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	}
+	return 0, false
+}
+
+func isConditionValueTrue(value any) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		return v != ""
+	}
+	if number, ok := toConditionNumber(value); ok {
+		return number != 0
+	}
+	return true
+}
+
+type conditionTokenType int
+
+const (
+	conditionTokenPath conditionTokenType = iota
+	conditionTokenNumber
+	conditionTokenString
+	conditionTokenOperator
+	conditionTokenLeftParen
+	conditionTokenRightParen
+)
+
+type conditionToken struct {
+	tokenType conditionTokenType
+	text      string
+}
+
+func tokenizeCondition(expression string) ([]conditionToken, error) {
+	tokens := make([]conditionToken, 0)
+	runes := []rune(expression)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, conditionToken{tokenType: conditionTokenLeftParen, text: "("})
+			i++
+		case r == ')':
+			tokens = append(tokens, conditionToken{tokenType: conditionTokenRightParen, text: ")"})
+			i++
+		case r == '\'' || r == '"':
+			end := i + 1
+			for end < len(runes) && runes[end] != r {
+				end++
+			}
+			if end >= len(runes) {
+				return nil, errors.Errorf("unterminated string at position %d", i)
+			}
+			tokens = append(tokens, conditionToken{tokenType: conditionTokenString, text: string(runes[i+1 : end])})
+			i = end + 1
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			end := i + 1
+			for end < len(runes) && (unicode.IsDigit(runes[end]) || runes[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, conditionToken{tokenType: conditionTokenNumber, text: string(runes[i:end])})
+			i = end
+		case unicode.IsLetter(r) || r == '_':
+			end := i + 1
+			for end < len(runes) && (unicode.IsLetter(runes[end]) || unicode.IsDigit(runes[end]) || runes[end] == '_' || runes[end] == '.' || runes[end] == '-') {
+				end++
+			}
+			tokens = append(tokens, conditionToken{tokenType: conditionTokenPath, text: string(runes[i:end])})
+			i = end
+		default:
+			operator := ""
+			for _, op := range []string{"==", "!=", ">=", "<=", "&&", "||", ">", "<", "!"} {
+				if strings.HasPrefix(string(runes[i:]), op) {
+					operator = op
+					break
+				}
+			}
+			if operator == "" {
+				return nil, errors.Errorf("unexpected character %q at position %d", r, i)
+			}
+			tokens = append(tokens, conditionToken{tokenType: conditionTokenOperator, text: operator})
+			i += len(operator)
+		}
+	}
+	return tokens, nil
+}
+
+type conditionParser struct {
+	tokens []conditionToken
+	pos    int
+}
+
+func (p *conditionParser) isEnd() bool {
+	return p.pos >= len(p.tokens)
+}
+
+func (p *conditionParser) peek() conditionToken {
+	if p.isEnd() {
+		return conditionToken{}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *conditionParser) isOperator(ops ...string) bool {
+	if p.isEnd() || p.peek().tokenType != conditionTokenOperator {
+		return false
+	}
+	for _, op := range ops {
+		if p.peek().text == op {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *conditionParser) parseOr() (conditionExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOperator("||") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &conditionLogic{op: "||", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *conditionParser) parseAnd() (conditionExpr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOperator("&&") {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &conditionLogic{op: "&&", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *conditionParser) parseUnary() (conditionExpr, error) {
+	if p.isOperator("!") {
+		p.pos++
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &conditionNot{expr: expr}, nil
+	}
+	return p.parseCompare()
+}
+
+func (p *conditionParser) parseCompare() (conditionExpr, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	if p.isOperator("==", "!=", ">", ">=", "<", "<=") {
+		op := p.peek().text
+		p.pos++
+		right, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+		return &conditionCompare{op: op, left: left, right: right}, nil
+	}
+	return left, nil
+}
+
+func (p *conditionParser) parsePrimary() (conditionExpr, error) {
+	if p.isEnd() {
+		return nil, errors.New("unexpected end of expression")
+	}
+	token := p.peek()
+	p.pos++
+	switch token.tokenType {
+	case conditionTokenLeftParen:
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.isEnd() || p.peek().tokenType != conditionTokenRightParen {
+			return nil, errors.New("missing closing parenthesis")
+		}
+		p.pos++
+		return expr, nil
+	case conditionTokenNumber:
+		number, err := strconv.ParseFloat(token.text, 64)
+		if err != nil {
+			return nil, errors.Errorf("invalid number %q", token.text)
+		}
+		return &conditionLiteral{value: number}, nil
+	case conditionTokenString:
+		return &conditionLiteral{value: token.text}, nil
+	case conditionTokenPath:
+		switch token.text {
+		case "true":
+			return &conditionLiteral{value: true}, nil
+		case "false":
+			return &conditionLiteral{value: false}, nil
+		case "null":
+			return &conditionLiteral{value: nil}, nil
+		}
+		keys := strings.Split(token.text, ".")
+		for _, key := range keys {
+			if key == "" {
+				return nil, errors.Errorf("invalid path %q", token.text)
+			}
+		}
+		return &conditionPath{keys: keys}, nil
+	}
+	return nil, errors.Errorf("unexpected token %q", token.text)
+}
@@ -0,0 +1,45 @@
+package workflow
+
+import "testing"
+
+func TestCondition_Evaluate(t *testing.T) {
+	nodeContext := NewJSONContextFromMap(map[string]any{
+		"workflow_context": map[string]any{"amount": 12000, "level": "vip", "urgent": true},
+		"result":           map[string]any{"approved": false},
+	})
+	cases := map[string]bool{
+		"workflow_context.amount > 10000":                                       true,
+		"workflow_context.amount <= 10000":                                      false,
+		"workflow_context.level == 'vip' && workflow_context.urgent":            true,
+		`workflow_context.level != "vip" || result.approved`:                    false,
+		"!result.approved":                                                      true,
+		"(workflow_context.amount >= 20000 || workflow_context.urgent) && true": true,
+		"workflow_context.missing == null":                                      true,
+		"workflow_context.missing > 0":                                          false,
+		"workflow_context.amount == 'vip'":                                      false,
+		"workflow_context.amount > -1.5":                                        true,
+	}
+	for expression, want := range cases {
+		condition, err := ParseCondition(expression)
+		if err != nil {
+			t.Fatalf("ParseCondition(%q) failed: %v", expression, err)
+		}
+		if got := condition.Evaluate(nodeContext); got != want {
+			t.Errorf("Evaluate(%q) expected %v, got %v", expression, want, got)
+		}
+	}
+
+	// nil条件表示无条件分支
+	var condition *Condition
+	if !condition.Evaluate(nodeContext) {
+		t.Errorf("nil condition should be true")
+	}
+}
+
+func TestCondition_ParseError(t *testing.T) {
+	for _, expression := range []string{"", "a >", "(a > 1", "a > 1)", "a = 1", "'abc", "a..b"} {
+		if _, err := ParseCondition(expression); err == nil {
+			t.Errorf("ParseCondition(%q) expected error", expression)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package workflow
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// 工作流配置问题的类型
+const (
+	ConfigProblemEmptyID         = "empty_id"         // 工作流ID或者节点ID为空
+	ConfigProblemReservedID      = "reserved_id"      // 节点ID使用了保留的root/end
+	ConfigProblemDuplicateNode   = "duplicate_node"   // 节点ID重复
+	ConfigProblemUnknownNode     = "unknown_node"     // next_nodes、conditions、timeout_node或者loops引用了不存在的节点
+	ConfigProblemCycle           = "cycle"            // next_nodes中存在环
+	ConfigProblemUnreachable     = "unreachable"      // 从入口节点(没有前置节点的节点)出发到达不了的节点
+	ConfigProblemNegativeLimit   = "negative_limit"   // 次数、时间、并发数等限制为负数
+	ConfigProblemInvalidSettings = "invalid_settings" // 其他字段配置错误, 例如retry_policy、delay、map等
+)
+
+// SetStrictWorkflowConfigValidation 默认的Registry开启或者关闭严格校验, 默认关闭, 关闭时配置错误在第一次GetAndLoadWorkflowDefinition时才会发现
+func SetStrictWorkflowConfigValidation(strict bool) {
+	defaultRegistry.SetStrictWorkflowConfigValidation(strict)
+}
+
+// SetStrictWorkflowConfigValidation 当前Registry开启或者关闭严格校验, 开启后LoadWorkflowConfig发现任何问题都会返回错误
+// PublishWorkflowDefinition不受这个设置影响, 总是做完整的检查
+func (r *Registry) SetStrictWorkflowConfigValidation(strict bool) {
+	r.strictConfigValidation.Store(strict)
+}
+
+// WorkflowConfigProblem 工作流配置中的一个问题
+type WorkflowConfigProblem struct {
+	Code    string // 问题类型, 参考ConfigProblem开头的常量
+	NodeID  string // 出问题的节点ID, 为空表示工作流级别的问题
+	Field   string // 出问题的字段, 例如next_nodes
+	Message string
+}
+
+func (p *WorkflowConfigProblem) String() string {
+	if p.NodeID == "" {
+		return fmt.Sprintf("[%s] %s: %s", p.Code, p.Field, p.Message)
+	}
+	return fmt.Sprintf("[%s] node %s %s: %s", p.Code, p.NodeID, p.Field, p.Message)
+}
+
+// WorkflowConfigValidationError 严格校验失败时LoadWorkflowConfig或者发布配置检查失败时PublishWorkflowDefinition返回的错误, 包含所有问题
+// errors.Is(err, ErrWorkflowParamInvalid) 为true
+type WorkflowConfigValidationError struct {
+	WorkflowType string
+	Problems     []*WorkflowConfigProblem
+}
+
+func (e *WorkflowConfigValidationError) Error() string {
+	problems := make([]string, 0, len(e.Problems))
+	for _, problem := range e.Problems {
+		problems = append(problems, problem.String())
+	}
+	return fmt.Sprintf("workflow config %s is invalid: %s", e.WorkflowType, strings.Join(problems, "; "))
+}
+
+func (e *WorkflowConfigValidationError) Unwrap() error {
+	return ErrWorkflowParamInvalid
+}
+
+// ValidateWorkflowConfig 检查工作流配置, 一次返回所有问题, 没有问题时返回空列表
+// 只检查配置本身, 不检查工作器是否注册
+func ValidateWorkflowConfig(config *WorkflowConfig) []*WorkflowConfigProblem {
+	problems := make([]*WorkflowConfigProblem, 0)
+	if config == nil {
+		return append(problems, &WorkflowConfigProblem{Code: ConfigProblemEmptyID, Field: "id", Message: "config is nil"})
+	}
+	addProblem := func(code string, nodeID string, field string, format string, args ...any) {
+		problems = append(problems, &WorkflowConfigProblem{Code: code, NodeID: nodeID, Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+	addSettingsProblem := func(nodeID string, field string, err error) {
+		if err != nil {
+			addProblem(ConfigProblemInvalidSettings, nodeID, field, "%v", err)
+		}
+	}
+
+	if config.ID == "" {
+		addProblem(ConfigProblemEmptyID, "", "id", "workflow id is empty")
+	}
+	if config.MaxParallelism < 0 {
+		addProblem(ConfigProblemNegativeLimit, "", "max_parallelism", "must be greater than or equal to 0, got: %d", config.MaxParallelism)
+	}
+	if config.Timeout < 0 {
+		addProblem(ConfigProblemNegativeLimit, "", "timeout", "must be greater than or equal to 0, got: %d", config.Timeout)
+	}
+	if config.Version < 0 {
+		addProblem(ConfigProblemNegativeLimit, "", "version", "must be greater than or equal to 0, got: %d", config.Version)
+	}
+	addSettingsProblem("", "concurrency_policy", config.checkConcurrencyPolicy())
+
+	nodes := make(map[string]*NodeDefinitionConfig, len(config.Nodes))
+	for _, node := range config.Nodes {
+		if node == nil || node.ID == "" {
+			addProblem(ConfigProblemEmptyID, "", "nodes", "node id is empty")
+			continue
+		}
+		if node.ID == rootTaskNode || node.ID == endTaskNode {
+			addProblem(ConfigProblemReservedID, node.ID, "id", "%q is reserved", node.ID)
+			continue
+		}
+		if _, ok := nodes[node.ID]; ok {
+			addProblem(ConfigProblemDuplicateNode, node.ID, "id", "node id is duplicated")
+			continue
+		}
+		nodes[node.ID] = node
+	}
+	if config.TimeoutNode != "" {
+		if _, ok := nodes[config.TimeoutNode]; !ok {
+			addProblem(ConfigProblemUnknownNode, "", "timeout_node", "node %s not found", config.TimeoutNode)
+		} else if config.Timeout >= 0 {
+			addSettingsProblem("", "timeout_node", config.checkTimeout())
+		}
+	}
+
+	// 按照配置顺序检查节点, 问题的顺序稳定
+	for _, node := range config.Nodes {
+		if node == nil || nodes[node.ID] != node {
+			continue
+		}
+		for _, nextNodeID := range node.NextNodes {
+			if _, ok := nodes[nextNodeID]; !ok {
+				addProblem(ConfigProblemUnknownNode, node.ID, "next_nodes", "node %s not found", nextNodeID)
+			}
+		}
+		for nextNodeID := range node.Conditions {
+			if !slices.Contains(node.NextNodes, nextNodeID) {
+				addProblem(ConfigProblemUnknownNode, node.ID, "conditions", "condition target %s is not in next_nodes", nextNodeID)
+			}
+		}
+		for _, loop := range node.Loops {
+			if err := loop.check(); err != nil {
+				addSettingsProblem(node.ID, "loops", err)
+				continue
+			}
+			if _, ok := nodes[loop.Target]; !ok {
+				addProblem(ConfigProblemUnknownNode, node.ID, "loops", "loop target %s not found", loop.Target)
+			}
+		}
+		if node.FailMaxCount != nil && *node.FailMaxCount < 0 {
+			addProblem(ConfigProblemNegativeLimit, node.ID, "fail_max_count", "must be greater than or equal to 0, got: %d", *node.FailMaxCount)
+		}
+		if node.MaxWaitTimeTs != nil && *node.MaxWaitTimeTs < 0 {
+			addProblem(ConfigProblemNegativeLimit, node.ID, "max_wait_time_ts", "must be greater than or equal to 0, got: %d", *node.MaxWaitTimeTs)
+		}
+		if node.ExecutionTimeout < 0 {
+			addProblem(ConfigProblemNegativeLimit, node.ID, "execution_timeout", "must be greater than or equal to 0, got: %d", node.ExecutionTimeout)
+		}
+		if node.RetryPolicy != nil {
+			addSettingsProblem(node.ID, "retry_policy", node.RetryPolicy.check())
+		}
+		switch node.Kind {
+		case "", NodeKindNormal, NodeKindWaitEvent:
+		case NodeKindSubWorkflow:
+			addSettingsProblem(node.ID, "sub_workflow", node.SubWorkflow.check(config.ID))
+		case NodeKindDelay:
+			addSettingsProblem(node.ID, "delay", node.Delay.check())
+		case NodeKindMap:
+			addSettingsProblem(node.ID, "map", node.Map.check())
+		default:
+			addProblem(ConfigProblemInvalidSettings, node.ID, "kind", "node kind %q is invalid", node.Kind)
+		}
+	}
+
+	// 超时节点不参与正常的执行流程, 不检查环和可达性
+	graphNodeIDs := make([]string, 0, len(nodes))
+	for _, node := range config.Nodes {
+		if node != nil && nodes[node.ID] == node && node.ID != config.TimeoutNode {
+			graphNodeIDs = append(graphNodeIDs, node.ID)
+		}
+	}
+	inDegree := make(map[string]int, len(graphNodeIDs))
+	for _, nodeID := range graphNodeIDs {
+		inDegree[nodeID] = 0
+	}
+	for _, nodeID := range graphNodeIDs {
+		for _, nextNodeID := range UniqueStr(nodes[nodeID].NextNodes) {
+			if _, ok := nodes[nextNodeID]; ok && nextNodeID != config.TimeoutNode {
+				inDegree[nextNodeID]++
+			}
+		}
+	}
+	// 从入口节点出发检查可达性
+	reachable := make(map[string]struct{}, len(graphNodeIDs))
+	queue := make([]string, 0)
+	for _, nodeID := range graphNodeIDs {
+		if inDegree[nodeID] == 0 {
+			queue = append(queue, nodeID)
+		}
+	}
+	for len(queue) > 0 {
+		nodeID := queue[0]
+		queue = queue[1:]
+		if _, ok := reachable[nodeID]; ok {
+			continue
+		}
+		reachable[nodeID] = struct{}{}
+		for _, nextNodeID := range nodes[nodeID].NextNodes {
+			if _, ok := nodes[nextNodeID]; ok && nextNodeID != config.TimeoutNode {
+				queue = append(queue, nextNodeID)
+			}
+		}
+	}
+	// Kahn算法检查环, 剩下入度不为0的节点都在环上或者在环的下游
+	remaining := make(map[string]int, len(inDegree))
+	for nodeID, degree := range inDegree {
+		remaining[nodeID] = degree
+	}
+	queue = queue[:0]
+	for _, nodeID := range graphNodeIDs {
+		if remaining[nodeID] == 0 {
+			queue = append(queue, nodeID)
+		}
+	}
+	for len(queue) > 0 {
+		nodeID := queue[0]
+		queue = queue[1:]
+		for _, nextNodeID := range UniqueStr(nodes[nodeID].NextNodes) {
+			if _, ok := remaining[nextNodeID]; !ok {
+				continue
+			}
+			remaining[nextNodeID]--
+			if remaining[nextNodeID] == 0 {
+				queue = append(queue, nextNodeID)
+			}
+		}
+	}
+	// 剩下的节点中能回到自己的才在环上, 其他的在环的下游
+	cycleNodeIDs := make([]string, 0)
+	cycleNodes := make(map[string]struct{})
+	for nodeID, degree := range remaining {
+		if degree > 0 && isOnWorkflowConfigCycle(nodes, remaining, nodeID) {
+			cycleNodeIDs = append(cycleNodeIDs, nodeID)
+			cycleNodes[nodeID] = struct{}{}
+		}
+	}
+	if len(cycleNodeIDs) > 0 {
+		sort.Strings(cycleNodeIDs)
+		addProblem(ConfigProblemCycle, "", "next_nodes", "there is a cycle in the workflow, nodes: %v", cycleNodeIDs)
+	}
+	for _, nodeID := range graphNodeIDs {
+		if _, ok := cycleNodes[nodeID]; ok {
+			// 环上的节点已经报告过
+			continue
+		}
+		if _, ok := reachable[nodeID]; !ok {
+			addProblem(ConfigProblemUnreachable, nodeID, "id", "node can not be reached from any entry node")
+		}
+	}
+	return problems
+}
+
+// isOnWorkflowConfigCycle 从节点出发沿着next_nodes能否回到节点自己, 只在Kahn算法剩下的节点中查找
+func isOnWorkflowConfigCycle(nodes map[string]*NodeDefinitionConfig, remaining map[string]int, nodeID string) bool {
+	visited := make(map[string]struct{})
+	queue := []string{nodeID}
+	for len(queue) > 0 {
+		currentID := queue[0]
+		queue = queue[1:]
+		for _, nextNodeID := range nodes[currentID].NextNodes {
+			if remaining[nextNodeID] <= 0 {
+				continue
+			}
+			if nextNodeID == nodeID {
+				return true
+			}
+			if _, ok := visited[nextNodeID]; ok {
+				continue
+			}
+			visited[nextNodeID] = struct{}{}
+			queue = append(queue, nextNodeID)
+		}
+	}
+	return false
+}
+
+// validateWorkflowConfigStrict 严格校验模式下检查配置, 有问题时返回WorkflowConfigValidationError
+func (r *Registry) validateWorkflowConfigStrict(config *WorkflowConfig) error {
+	if !r.strictConfigValidation.Load() {
+		return nil
+	}
+	return validateWorkflowConfig(config)
+}
+
+// validateWorkflowConfig 检查配置, 有问题时返回WorkflowConfigValidationError
+func validateWorkflowConfig(config *WorkflowConfig) error {
+	problems := ValidateWorkflowConfig(config)
+	if len(problems) == 0 {
+		return nil
+	}
+	return errors.WithStack(&WorkflowConfigValidationError{WorkflowType: config.ID, Problems: problems})
+}
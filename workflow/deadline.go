@@ -0,0 +1,182 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// checkTimeout 检查工作流的超时配置
+// 超时节点不参与正常的执行流程, 不能出现在其他节点的next_nodes中, 自己也不能有后置节点和循环边
+func (c *WorkflowConfig) checkTimeout() error {
+	if c.Timeout < 0 {
+		return errors.Errorf("timeout must be greater than or equal to 0, got: %d", c.Timeout)
+	}
+	if c.TimeoutNode == "" {
+		return nil
+	}
+	var timeoutNode *NodeDefinitionConfig
+	for _, node := range c.Nodes {
+		if node.ID == c.TimeoutNode {
+			timeoutNode = node
+		}
+		if slices.Contains(node.NextNodes, c.TimeoutNode) {
+			return errors.Errorf("timeout_node %q can not be the next node of %q", c.TimeoutNode, node.ID)
+		}
+	}
+	if timeoutNode == nil {
+		return errors.Errorf("timeout_node %q not found", c.TimeoutNode)
+	}
+	if timeoutNode.Kind != "" && timeoutNode.Kind != NodeKindNormal {
+		return errors.Errorf("timeout_node %q must be a normal node, got kind: %s", c.TimeoutNode, timeoutNode.Kind)
+	}
+	if len(timeoutNode.NextNodes) > 0 || len(timeoutNode.Loops) > 0 {
+		return errors.Errorf("timeout_node %q can not have next_nodes or loops", c.TimeoutNode)
+	}
+	return nil
+}
+
+// isDeadlineExceeded 工作流实例是否已经超过截止时间, 已经结束的实例不算超时
+func isDeadlineExceeded(workflowInstance *WorkflowInstance) bool {
+	if workflowInstance.DeadlineAt <= 0 || IsOverWorkflowInstanceStatus(workflowInstance.Status) {
+		return false
+	}
+	return time.Now().Unix() >= workflowInstance.DeadlineAt
+}
+
+// timeoutWorkflowInstance 工作流实例超过截止时间后的处理, 调用方需要持有工作流的锁
+// 1. 取消所有未完成的任务实例以及子工作流
+// 2. 配置了超时节点时执行超时节点, 超时节点结束(完成或者失败)之前工作流状态不变, 后续的RunWorkflow继续执行超时节点
+// 3. 工作流状态标记为timed_out, 补偿已经完成的任务
+// 每一步都可以重复执行, 中途失败后下次RunWorkflow会继续处理
+func (s *WorkflowServiceImpl) timeoutWorkflowInstance(ctx context.Context, state *workflowRunState, definition *WorkflowDefinition) error {
+	workflowInstance := state.workflowInstance
+	if workflowInstance.Status == WorkflowInstanceStatusTimedOut {
+		return errors.WithMessagef(ErrWorkflowInstanceTimedOut, "workflowInstanceID: %d, deadlineAt: %d", workflowInstance.ID, workflowInstance.DeadlineAt)
+	}
+	timeoutTaskType := ""
+	if definition.TimeoutNode != nil {
+		timeoutTaskType = definition.TimeoutNode.TaskType
+	}
+	taskInstances, err := s.getAllTaskInstancePo(ctx, workflowInstance.ID)
+	if err != nil {
+		return errors.WithMessagef(err, "getAllTaskInstancePo failed, workflowInstanceID: %d", workflowInstance.ID)
+	}
+	cancelTaskIDs := make([]int64, 0)
+	for _, taskInstance := range taskInstances {
+		if IsOverWorkflowTaskNodeStatus(taskInstance.Status) || taskInstance.TaskType == timeoutTaskType {
+			continue
+		}
+		cancelTaskIDs = append(cancelTaskIDs, taskInstance.ID)
+	}
+	if len(cancelTaskIDs) > 0 {
+		err = s.repo.UpdateWorkflowTaskInstance(ctx, &UpdateWorkflowTaskInstanceParams{
+			Where: &UpdateWorkflowTaskInstanceWhere{
+				IDIn: cancelTaskIDs,
+			},
+			Fields: &UpdateWorkflowTaskInstanceField{
+				Status: String(WorkflowTaskNodeStatusCancelled),
+			},
+			LimitMax: len(cancelTaskIDs),
+		})
+		if err != nil {
+			return errors.WithMessagef(err, "UpdateWorkflowTaskInstance failed, workflowInstanceID: %d", workflowInstance.ID)
+		}
+	}
+	if err := s.cancelSubWorkflowInstances(ctx, workflowInstance.ID); err != nil {
+		return err
+	}
+	if definition.TimeoutNode != nil {
+		isOver, err := s.runTimeoutTaskNode(ctx, state, definition.TimeoutNode)
+		if err != nil {
+			return errors.WithMessagef(err, "runTimeoutTaskNode failed, workflowInstanceID: %d", workflowInstance.ID)
+		}
+		if !isOver {
+			// 超时节点还没有结束, 等待下次RunWorkflow
+			return nil
+		}
+	}
+	workflowInstance.Status = WorkflowInstanceStatusTimedOut
+	workflowInstance.UpdatedAt = time.Now().Unix()
+	err = s.repo.UpdateWorkflowInstance(ctx, &UpdateWorkflowInstanceParams{
+		Where: &UpdateWorkflowInstanceWhere{
+			IDIn: []int64{workflowInstance.ID},
+		},
+		Fields: &UpdateWorkflowInstanceField{
+			Status: &workflowInstance.Status,
+		},
+		LimitMax: 1,
+	})
+	if err != nil {
+		return errors.WithMessagef(err, "UpdateWorkflowInstance failed, workflowInstanceID: %d", workflowInstance.ID)
+	}
+	// 补偿失败不影响返回的错误, 可以通过CompensateWorkflowInstance继续补偿
+	if compensateErr := s.compensateWorkflowInstance(ctx, definition, workflowInstance.ID); compensateErr != nil {
+		slog.ErrorContext(ctx, fmt.Sprintf("compensateWorkflowInstance failed, workflowInstanceID: %d, err: %v", workflowInstance.ID, compensateErr))
+	}
+	return errors.WithMessagef(ErrWorkflowInstanceTimedOut, "workflowInstanceID: %d, deadlineAt: %d", workflowInstance.ID, workflowInstance.DeadlineAt)
+}
+
+// runTimeoutTaskNode 执行超时节点, 返回超时节点是否已经结束
+// 超时节点和普通节点一样计入失败次数, 失败不会改变工作流最终的timed_out状态
+func (s *WorkflowServiceImpl) runTimeoutTaskNode(ctx context.Context, state *workflowRunState, node *WorkflowTaskNodeDefinition) (bool, error) {
+	workflowInstance := state.workflowInstance
+	taskNode, ok := state.getTaskNode(node.TaskType)
+	if !ok {
+		// 超时节点没有前置节点, 上下文中只有工作流上下文
+		taskInstancePo, err := s.repo.CreateWorkflowTaskInstance(ctx, &WorkflowTaskInstancePo{
+			WorkflowInstanceID: workflowInstance.ID,
+			TaskType:           node.TaskType,
+			Status:             WorkflowTaskNodeStatusRunning,
+			NodeContext:        buildTaskNodeContext(workflowInstance, nil).ToBytesWithoutError(),
+			CreatedAt:          time.Now().Unix(),
+			UpdatedAt:          time.Now().Unix(),
+		})
+		if err != nil {
+			return false, errors.WithMessagef(err, "CreateWorkflowTaskInstance failed, workflowInstanceID: %d, taskType: %s", workflowInstance.ID, node.TaskType)
+		}
+		taskNode = &WorkflowTaskNode{
+			ID:                 taskInstancePo.ID,
+			WorkflowInstanceID: taskInstancePo.WorkflowInstanceID,
+			TaskType:           taskInstancePo.TaskType,
+			Status:             taskInstancePo.Status,
+			NodeContext:        NewByte2StrctPbValue(taskInstancePo.NodeContext),
+			CreatedAt:          taskInstancePo.CreatedAt,
+			UpdatedAt:          taskInstancePo.UpdatedAt,
+		}
+		state.setTaskNode(node.TaskType, taskNode)
+	}
+	if IsOverWorkflowTaskNodeStatus(taskNode.Status) {
+		return true, nil
+	}
+	if taskNode.Status == WorkflowTaskNodeStatusRestarting {
+		// 重新启动的超时节点, 重新初始化节点上下文
+		state.setTaskNodeStatus(taskNode, WorkflowTaskNodeStatusRunning)
+		taskNode.NodeContext = buildTaskNodeContext(workflowInstance, nil)
+		err := s.repo.UpdateWorkflowTaskInstance(ctx, &UpdateWorkflowTaskInstanceParams{
+			Where: &UpdateWorkflowTaskInstanceWhere{
+				IDIn: []int64{taskNode.ID},
+			},
+			Fields: &UpdateWorkflowTaskInstanceField{
+				Status:      &taskNode.Status,
+				NodeContext: taskNode.NodeContext,
+			},
+			LimitMax: 1,
+		})
+		if err != nil {
+			return false, errors.WithMessagef(err, "UpdateWorkflowTaskInstance failed, workflowInstanceID: %d, taskType: %s", workflowInstance.ID, node.TaskType)
+		}
+	}
+	if taskNode.NextRetryAt > time.Now().Unix() {
+		return false, nil
+	}
+	err := s.taskRun(ctx, state, node, taskNode)
+	if err != nil && !errors.Is(err, ErrWorkflowTaskFailedWithFailed) {
+		slog.WarnContext(ctx, fmt.Sprintf("[warn]TaskRun failed, workflowInstanceID: %d, taskType: %s, err: %v", workflowInstance.ID, node.TaskType, err))
+	}
+	return IsOverWorkflowTaskNodeStatus(taskNode.Status), nil
+}
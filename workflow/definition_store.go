@@ -0,0 +1,141 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// PublishWorkflowDefinition 把工作流配置保存到数据库, 版本号<=0 时使用已经发布的最大版本号加1
+// 发布时只检查配置本身, 不需要注册工作器, 执行节点的进程通过LoadPublishedWorkflowConfigs加载
+func (s *WorkflowServiceImpl) PublishWorkflowDefinition(ctx context.Context, config *WorkflowConfig) (*WorkflowDefinitionPo, error) {
+	if config == nil || config.ID == "" {
+		return nil, errors.Wrapf(ErrWorkflowParamInvalid, "PublishWorkflowDefinition failed, config or config.id is empty")
+	}
+	if err := config.checkTimeout(); err != nil {
+		return nil, errors.WithMessagef(ErrWorkflowParamInvalid, "workflowType: %s, err: %v", config.ID, err)
+	}
+	if err := config.checkConcurrencyPolicy(); err != nil {
+		return nil, errors.WithMessagef(ErrWorkflowParamInvalid, "workflowType: %s, err: %v", config.ID, err)
+	}
+	// 发布的配置会被其他进程加载, 不管是否开启严格校验都做完整的检查
+	if err := validateWorkflowConfig(config); err != nil {
+		return nil, err
+	}
+	published := *config
+	if published.Version <= 0 {
+		latest, err := s.queryPublishedWorkflowDefinition(ctx, config.ID, 0)
+		if err != nil && !errors.Is(err, ErrWorkflowConfigNotFound) {
+			return nil, err
+		}
+		published.Version = defaultWorkflowVersion
+		if latest != nil {
+			published.Version = latest.Version + 1
+		}
+	}
+	configBytes, err := json.Marshal(&published)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "json.Marshal failed, workflowType: %s", config.ID)
+	}
+	// 相同版本重复发布时唯一索引会冲突
+	po, err := s.repo.CreateWorkflowDefinition(ctx, &WorkflowDefinitionPo{
+		WorkflowType: published.ID,
+		Version:      published.Version,
+		Name:         published.Name,
+		Config:       configBytes,
+	})
+	if err != nil {
+		return nil, errors.WithMessagef(err, "CreateWorkflowDefinition failed, workflowType: %s, version: %d", published.ID, published.Version)
+	}
+	return po, nil
+}
+
+func (s *WorkflowServiceImpl) ListWorkflowDefinitions(ctx context.Context, params *QueryWorkflowDefinitionParams) ([]*WorkflowDefinitionPo, error) {
+	if err := validatorUtil.Struct(params); err != nil {
+		return nil, errors.Wrapf(ErrWorkflowParamInvalid, "ListWorkflowDefinitions failed, params: %v,err: %v", params, err)
+	}
+	pos, err := s.repo.QueryWorkflowDefinition(ctx, params)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "QueryWorkflowDefinition failed, params: %v", params)
+	}
+	return pos, nil
+}
+
+func (s *WorkflowServiceImpl) GetPublishedWorkflowConfig(ctx context.Context, workflowType string, version int64) (*WorkflowConfig, error) {
+	po, err := s.queryPublishedWorkflowDefinition(ctx, workflowType, version)
+	if err != nil {
+		return nil, err
+	}
+	return parseWorkflowDefinitionPo(po)
+}
+
+// LoadPublishedWorkflowConfigs 加载数据库中所有发布的工作流配置, 已经加载过的版本跳过
+// 和LoadWorkflowConfig一样只做存储, 工作器注册之后才能构建工作流定义
+func (s *WorkflowServiceImpl) LoadPublishedWorkflowConfigs(ctx context.Context) error {
+	pos, err := s.repo.QueryWorkflowDefinition(ctx, &QueryWorkflowDefinitionParams{
+		OrderbyVersionAsc: Bool(true),
+		Page:              &Pager{IsNoLimit: Bool(true)},
+	})
+	if err != nil {
+		return errors.WithMessage(err, "QueryWorkflowDefinition failed")
+	}
+	for _, po := range pos {
+		if s.registry.isWorkflowConfigLoaded(po.WorkflowType, po.Version) {
+			continue
+		}
+		config, err := parseWorkflowDefinitionPo(po)
+		if err != nil {
+			return err
+		}
+		if err := s.registry.LoadWorkflowConfig(config); err != nil {
+			return errors.WithMessagef(err, "LoadWorkflowConfig failed, workflowType: %s, version: %d", po.WorkflowType, po.Version)
+		}
+	}
+	return nil
+}
+
+// queryPublishedWorkflowDefinition 查询发布的工作流配置, version<=0 表示最新版本
+func (s *WorkflowServiceImpl) queryPublishedWorkflowDefinition(ctx context.Context, workflowType string, version int64) (*WorkflowDefinitionPo, error) {
+	params := &QueryWorkflowDefinitionParams{
+		WorkflowType:      &workflowType,
+		OrderbyVersionAsc: Bool(false),
+		Page:              &Pager{Page: 1, Size: 1},
+	}
+	if version > 0 {
+		params.Version = &version
+	}
+	pos, err := s.repo.QueryWorkflowDefinition(ctx, params)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "QueryWorkflowDefinition failed, workflowType: %s, version: %d", workflowType, version)
+	}
+	if len(pos) == 0 {
+		return nil, errors.WithMessagef(ErrWorkflowConfigNotFound, "published workflow config not found, workflowType: %s, version: %d", workflowType, version)
+	}
+	return pos[0], nil
+}
+
+func parseWorkflowDefinitionPo(po *WorkflowDefinitionPo) (*WorkflowConfig, error) {
+	config := &WorkflowConfig{}
+	if err := json.Unmarshal(po.Config, config); err != nil {
+		return nil, errors.WithMessagef(err, "json.Unmarshal failed, workflowType: %s, version: %d", po.WorkflowType, po.Version)
+	}
+	config.Version = po.Version
+	return config, nil
+}
+
+// getPublishedWorkflowDefinitionHeader 创建实例的进程没有加载工作流配置时, 使用数据库中发布的配置
+// 返回的定义只有版本号、超时时间和并发策略, 没有节点, 只用于创建实例
+func (s *WorkflowServiceImpl) getPublishedWorkflowDefinitionHeader(ctx context.Context, workflowType string, version int64) (*WorkflowDefinition, error) {
+	config, err := s.GetPublishedWorkflowConfig(ctx, workflowType, version)
+	if err != nil {
+		return nil, err
+	}
+	return &WorkflowDefinition{
+		ID:                config.ID,
+		Name:              config.Name,
+		Version:           config.getVersion(),
+		Timeout:           config.Timeout,
+		ConcurrencyPolicy: config.ConcurrencyPolicy,
+	}, nil
+}
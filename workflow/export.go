@@ -40,8 +40,17 @@ type WorkflowService interface {
 	 * @return error
 	 */
 	RunWorkflow(ctx context.Context, workflowID int64) error
+	/**
+	 * @description: 开始排队中的工作流实例, 相同BusinessID没有未结束的实例时, 最早排队的实例改成init
+	 *				 只修改状态不执行, 调度器每一轮调用, 保证前一个实例结束时没有开始的排队实例最终会执行
+	 * @param ctx context.Context
+	 * @param workflowTypes []string 为空表示所有工作流类型
+	 * @return error
+	 */
+	StartQueuedWorkflowInstances(ctx context.Context, workflowTypes []string) error
 	/**
 	 * @description: 取消工作流，手动取消工作流，目前没有使用，将来使用扩展
+	 *				 未结束的子工作流实例会被级联取消
 	 *				 workflowInstanceID 为工作流实例ID,一个工作流实例只会被一个goroutine运行
 	 *				 如果有其他goroutine正在运行该工作流实例，则返回错误
 	 * @param ctx context.Context
@@ -50,6 +59,38 @@ type WorkflowService interface {
 	 */
 	CancelWorkflowInstance(ctx context.Context, workflowInstanceID int64) error
 
+	/**
+	 * @description: 暂停工作流, 工作流状态变成paused, 暂停期间RunWorkflow不会执行也不会检查任何节点
+	 *				 只有init和running状态的工作流实例可以暂停, 已经暂停的直接返回成功
+	 *				 未结束的子工作流实例会被级联暂停
+	 *				 如果有其他goroutine正在运行该工作流实例，则返回错误
+	 * @param ctx context.Context
+	 * @param workflowInstanceID int64
+	 * @return error
+	 */
+	PauseWorkflowInstance(ctx context.Context, workflowInstanceID int64) error
+
+	/**
+	 * @description: 恢复暂停的工作流, 工作流状态恢复为暂停前的init或者running, 下次RunWorkflow从暂停的位置继续执行
+	 *				 暂停的子工作流实例会被级联恢复, 截止时间不会因为暂停而延长
+	 *				 如果有其他goroutine正在运行该工作流实例，则返回错误
+	 * @param ctx context.Context
+	 * @param workflowInstanceID int64
+	 * @return error
+	 */
+	ResumeWorkflowInstance(ctx context.Context, workflowInstanceID int64) error
+
+	/**
+	 * @description: 补偿工作流, 工作流失败、取消或者超时时会自动补偿, 补偿中断(Compensate返回错误或者进程崩溃)后可以调用这个方法继续补偿
+	 *				 已经完成并且工作器实现了WorkflowTaskNodeCompensator的任务实例, 按照完成时间倒序调用Compensate
+	 *				 每个任务实例的补偿状态会持久化, 已经补偿过的任务实例不会重复补偿
+	 *				 只有失败、取消和超时状态的工作流实例可以补偿
+	 * @param ctx context.Context
+	 * @param workflowInstanceID int64
+	 * @return error
+	 */
+	CompensateWorkflowInstance(ctx context.Context, workflowInstanceID int64) error
+
 	/**
 	 * @description: 添加节点外部事件, 给外部输入使用，会写入节点上下文
 	 * 				  一个工作流实例只会被一个goroutine运行,如果有其他goroutine正在运行该工作流实例，则返回错误
@@ -67,16 +108,89 @@ type WorkflowService interface {
 
 	/**
 	 * @description: 重新开始工作流任务
-	 *                如果isAsynchronous为true,一次性只能有一个进程操作工作流,可能会出现当前工作流正在被其他进程操作的情况
-	 *                所以失败的情况可能会出现的比较频繁
+	 *                一次性只能有一个进程操作工作流,可能会出现当前工作流正在被其他进程操作的情况,所以失败的情况可能会出现的比较频繁
+	 *                IsRun为true时重置节点和执行工作流在同一次加锁中完成, 不会被其他进程插入
+	 *                IsAsynchronous为true时重置节点后把执行提交到后台, 拿不到锁会等待重试, 通过返回的句柄等待或者轮询执行结果
 	 * @param ctx context.Context
 	 * @param restartParams *RestartWorkflowTaskParams
 	 *				  restartWorkflowNodeParams.WorkflowInstanceID 为工作流实例ID
 	 *				  restartWorkflowNodeParams.TaskType 为任务类型
+	 *				  restartWorkflowNodeParams.IsRun 为是否立即执行,如果为true，则等待工作流执行完成再返回
 	 *				  restartWorkflowNodeParams.IsAsynchronous 为是否异步重启,如果为true，则不等待任务执行完成,直接返回
+	 *				  restartWorkflowNodeParams.Context 为工作流上下文,如果有值，则整体替换掉原来的上下文
+	 *				  restartWorkflowNodeParams.ContextPatch 为工作流上下文的JSON Merge Patch,如果有值，则合并到原来的上下文
+	 *				  修改前的上下文会保留在工作流实例的修改记录中, 重启的节点重新初始化时使用新的上下文
+	 * @return *WorkflowRunHandle, error 只有IsAsynchronous为true时返回句柄, 否则为nil
+	 */
+	RestartWorkflowNode(ctx context.Context, restartWorkflowNodeParams *RestartWorkflowNodeParams) (*WorkflowRunHandle, error)
+
+	/**
+	 * @description: 人工跳过工作流节点, 节点状态变成skipped, 节点不会执行
+	 *				 后置节点把它当作已完成, 在下次RunWorkflow时继续执行, 节点的输出只有params.Output
+	 *				 节点的任务实例还没有创建时会直接创建一个跳过状态的任务实例, 已经结束的节点不能跳过
+	 * @param ctx context.Context
+	 * @param params *OperateWorkflowNodeParams
+	 *				  params.Operator 为操作人, params.Reason 为操作原因, 记录在节点上下文的system.operation中
+	 *				  params.Output 为合并到节点上下文的输出, 可以为空
 	 * @return error
 	 */
-	RestartWorkflowNode(ctx context.Context, restartWorkflowNodeParams *RestartWorkflowNodeParams) error
+	SkipWorkflowNode(ctx context.Context, params *OperateWorkflowNodeParams) error
+
+	/**
+	 * @description: 人工完成工作流节点, 节点状态变成completed, 后置节点在下次RunWorkflow时继续执行
+	 *				 节点的任务实例还没有创建时会直接创建一个完成状态的任务实例, 已经结束的节点不能完成
+	 *				 人工完成的节点没有执行过工作器, 工作流失败时不会补偿
+	 * @param ctx context.Context
+	 * @param params *OperateWorkflowNodeParams
+	 *				  params.Operator 为操作人, params.Reason 为操作原因, 记录在节点上下文的system.operation中
+	 *				  params.Output 为合并到节点上下文的输出, 后置节点通过pre_node_context读取
+	 * @return error
+	 */
+	CompleteWorkflowNode(ctx context.Context, params *OperateWorkflowNodeParams) error
+
+	/**
+	 * @description: 迁移未结束的工作流实例到新版本的工作流定义, 实例之后按照目标版本执行
+	 *				 当前版本的每个节点需要对应到目标版本的节点(同名或者通过NodeMapping改名)或者声明为删除
+	 *				 目标版本中没有对应关系的节点需要声明为新增, 新增节点的后续节点不能已经开始执行
+	 *				 任务实例的改名、取消和实例版本号的修改在同一个事务中完成
+	 * @param ctx context.Context
+	 * @param params *MigrateWorkflowInstanceParams
+	 *				  params.DryRun 为true时只检查并返回将要发生的修改, 不写入
+	 * @return *MigrateWorkflowInstanceResult, error
+	 */
+	MigrateWorkflowInstance(ctx context.Context, params *MigrateWorkflowInstanceParams) (*MigrateWorkflowInstanceResult, error)
+
+	/**
+	 * @description: 发布工作流配置到数据库(workflow_definition表), 每个版本一条记录
+	 *				 config.Version<=0 时使用已经发布的最大版本号加1, 相同版本不能重复发布
+	 *				 发布时不需要注册工作器, 任意进程都可以发布和查询
+	 * @param ctx context.Context
+	 * @param config *WorkflowConfig
+	 * @return *WorkflowDefinitionPo, error
+	 */
+	PublishWorkflowDefinition(ctx context.Context, config *WorkflowConfig) (*WorkflowDefinitionPo, error)
+	/**
+	 * @description: 查询发布的工作流配置列表
+	 * @param ctx context.Context
+	 * @param params *QueryWorkflowDefinitionParams
+	 * @return []*WorkflowDefinitionPo, error
+	 */
+	ListWorkflowDefinitions(ctx context.Context, params *QueryWorkflowDefinitionParams) ([]*WorkflowDefinitionPo, error)
+	/**
+	 * @description: 获取发布的工作流配置, 没有发布过返回ErrWorkflowConfigNotFound
+	 * @param ctx context.Context
+	 * @param workflowType string
+	 * @param version int64 <=0 表示最新版本
+	 * @return *WorkflowConfig, error
+	 */
+	GetPublishedWorkflowConfig(ctx context.Context, workflowType string, version int64) (*WorkflowConfig, error)
+	/**
+	 * @description: 加载数据库中所有发布的工作流配置到当前进程, 已经加载过的版本跳过
+	 *				 执行节点的进程注册工作器之后调用, 不需要在代码中维护工作流配置
+	 * @param ctx context.Context
+	 * @return error
+	 */
+	LoadPublishedWorkflowConfigs(ctx context.Context) error
 
 	/**
 	 * @description: 重启工作流实例, 只有失败和取消状态可以重启，正常完成的不能重启
@@ -84,6 +198,8 @@ type WorkflowService interface {
 	 * @param restartWorkflowParams *RestartWorkflowParams 重启工作流参数
 	 *				  restartWorkflowParams.WorkflowInstanceID 为工作流实例ID
 	 *				  restartWorkflowParams.Context 为上下文,如果有值，则覆盖掉原来的上下文
+	 *				  restartWorkflowParams.ContextPatch 为上下文的JSON Merge Patch,如果有值，则合并到原来的上下文, 不能和Context同时使用
+	 *				  修改前的上下文会保留在工作流实例的修改记录中, 重启的节点重新初始化时使用新的上下文
 	 *				  restartWorkflowParams.IsRun 为是否立即执行,如果为true，则立即执行
 	 * @return error 重启工作流实例
 	 */
@@ -92,10 +208,28 @@ type WorkflowService interface {
 
 // WorkflowServiceImpl 工作流服务
 type WorkflowServiceImpl struct {
-	repo        WorkflowRepo
-	executeLock WorkflowLock
+	repo          WorkflowRepo
+	executeLock   WorkflowLock
+	asyncExecutor *asyncRunExecutor // 异步重启时在后台执行工作流
+	registry      *Registry         // 工作流配置、工作器和工作流定义
+}
+
+// WorkflowServiceOption NewWorkflowService的可选配置
+type WorkflowServiceOption func(s *WorkflowServiceImpl)
+
+// WithRegistry 使用指定的Registry, 不指定时使用DefaultRegistry
+func WithRegistry(registry *Registry) WorkflowServiceOption {
+	return func(s *WorkflowServiceImpl) {
+		if registry != nil {
+			s.registry = registry
+		}
+	}
 }
 
-func NewWorkflowService(repo WorkflowRepo, executeLock WorkflowLock) WorkflowService {
-	return &WorkflowServiceImpl{repo: repo, executeLock: executeLock}
+func NewWorkflowService(repo WorkflowRepo, executeLock WorkflowLock, opts ...WorkflowServiceOption) WorkflowService {
+	s := &WorkflowServiceImpl{repo: repo, executeLock: executeLock, asyncExecutor: newAsyncRunExecutor(defaultAsyncRunWorkerCount), registry: defaultRegistry}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
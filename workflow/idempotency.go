@@ -0,0 +1,106 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// IdempotencyPolicy 创建工作流时的幂等策略
+type IdempotencyPolicy string
+
+const (
+	IdempotencyPolicyNone   IdempotencyPolicy = ""       // 不做幂等检查, 每次都创建新的实例
+	IdempotencyPolicyActive IdempotencyPolicy = "active" // 存在未结束的实例时返回已有实例, 已经结束的实例不影响创建新的实例
+	IdempotencyPolicyAny    IdempotencyPolicy = "any"    // 存在任意状态的实例时都返回已有实例
+)
+
+// buildIdempotencyKey 计算存储的幂等键, 同一个工作流类型下唯一, 不需要幂等时返回nil
+// 没有指定IdempotencyKey时使用BusinessID
+func buildIdempotencyKey(req *CreateWorkflowReq) (*string, error) {
+	switch req.IdempotencyPolicy {
+	case IdempotencyPolicyNone:
+		return nil, nil
+	case IdempotencyPolicyActive, IdempotencyPolicyAny:
+	default:
+		return nil, errors.Wrapf(ErrWorkflowParamInvalid, "invalid IdempotencyPolicy: %s", req.IdempotencyPolicy)
+	}
+	key := req.IdempotencyKey
+	if key == "" {
+		key = req.BusinessID
+	}
+	if key == "" {
+		return nil, errors.Wrapf(ErrWorkflowParamInvalid, "IdempotencyKey and BusinessID are both empty, workflowType: %s", req.WorkflowType)
+	}
+	return String(fmt.Sprintf("%s:%s", req.WorkflowType, key)), nil
+}
+
+// createWorkflowInstance 按照幂等策略和并发策略创建工作流实例, 返回的bool表示是否是新创建的实例
+// 命中幂等的已有实例时不检查并发策略, 重试的请求不会被拒绝
+func (s *WorkflowServiceImpl) createWorkflowInstance(ctx context.Context, req *CreateWorkflowReq, definition *WorkflowDefinition, workflowInstance *WorkflowInstancePo) (*WorkflowInstancePo, bool, error) {
+	idempotencyKey, err := buildIdempotencyKey(req)
+	if err != nil {
+		return nil, false, err
+	}
+	if idempotencyKey == nil {
+		if err := s.applyConcurrencyPolicy(ctx, definition, workflowInstance); err != nil {
+			return nil, false, err
+		}
+		created, err := s.repo.CreateWorkflowInstance(ctx, workflowInstance)
+		if err != nil {
+			return nil, false, errors.WithMessagef(err, "CreateWorkflowInstance failed, workflowType: %s", req.WorkflowType)
+		}
+		return created, true, nil
+	}
+	existing, err := s.queryWorkflowInstanceByIdempotencyKey(ctx, *idempotencyKey)
+	if err != nil {
+		return nil, false, err
+	}
+	if existing != nil {
+		if req.IdempotencyPolicy == IdempotencyPolicyAny || !IsOverWorkflowInstanceStatus(existing.Status) {
+			return existing, false, nil
+		}
+		// 已经结束的实例释放幂等键, 多个进程同时释放是一样的结果
+		err = s.repo.UpdateWorkflowInstance(ctx, &UpdateWorkflowInstanceParams{
+			Where: &UpdateWorkflowInstanceWhere{
+				IDIn: []int64{existing.ID},
+			},
+			Fields: &UpdateWorkflowInstanceField{
+				IdempotencyKey: String(""),
+			},
+			LimitMax: 1,
+		})
+		if err != nil {
+			return nil, false, errors.WithMessagef(err, "release IdempotencyKey failed, workflowInstanceID: %d", existing.ID)
+		}
+	}
+	if err := s.applyConcurrencyPolicy(ctx, definition, workflowInstance); err != nil {
+		return nil, false, err
+	}
+	workflowInstance.IdempotencyKey = idempotencyKey
+	created, err := s.repo.CreateWorkflowInstance(ctx, workflowInstance)
+	if err == nil {
+		return created, true, nil
+	}
+	// 唯一索引冲突, 其他进程已经用相同的幂等键创建成功, 返回它创建的实例
+	existing, queryErr := s.queryWorkflowInstanceByIdempotencyKey(ctx, *idempotencyKey)
+	if queryErr == nil && existing != nil {
+		return existing, false, nil
+	}
+	return nil, false, errors.WithMessagef(err, "CreateWorkflowInstance failed, workflowType: %s, idempotencyKey: %s", req.WorkflowType, *idempotencyKey)
+}
+
+func (s *WorkflowServiceImpl) queryWorkflowInstanceByIdempotencyKey(ctx context.Context, idempotencyKey string) (*WorkflowInstancePo, error) {
+	workflowInstances, err := s.repo.QueryWorkflowInstance(ctx, &QueryWorkflowInstanceParams{
+		IdempotencyKey: &idempotencyKey,
+		Page:           &Pager{Page: 1, Size: 1},
+	})
+	if err != nil {
+		return nil, errors.WithMessagef(err, "QueryWorkflowInstance failed, idempotencyKey: %s", idempotencyKey)
+	}
+	if len(workflowInstances) == 0 {
+		return nil, nil
+	}
+	return workflowInstances[0], nil
+}
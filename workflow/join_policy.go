@@ -0,0 +1,59 @@
+package workflow
+
+import (
+	"github.com/pkg/errors"
+)
+
+type JoinMode = string
+
+const (
+	// 所有前置节点都完成或者跳过才执行(默认)
+	JoinModeAll JoinMode = "all"
+	// 任意一个前置节点完成就执行
+	JoinModeAny JoinMode = "any"
+	// 前置节点中有count个完成就执行
+	JoinModeNOfM JoinMode = "n_of_m"
+)
+
+// JoinConfig 汇聚节点配置, 决定有多个前置节点时何时开始执行
+type JoinConfig struct {
+	Mode  JoinMode `json:"mode"`  // 汇聚方式, all/any/n_of_m, 为空等同于all
+	Count int64    `json:"count"` // n_of_m 模式下需要完成的前置节点数量
+	// 汇聚节点开始执行时, 还没有完成的前置节点是否取消
+	// true: 未完成的前置节点标记为跳过, 不再执行; false: 未完成的前置节点继续执行, 结果不会再传递给汇聚节点
+	CancelRemaining bool `json:"cancel_remaining"`
+}
+
+func (c *JoinConfig) check(preNodesCount int) error {
+	if c == nil {
+		return nil
+	}
+	switch c.Mode {
+	case "", JoinModeAll, JoinModeAny:
+		return nil
+	case JoinModeNOfM:
+		if c.Count <= 0 || c.Count > int64(preNodesCount) {
+			return errors.Errorf("join count must be in [1,%d], got: %d", preNodesCount, c.Count)
+		}
+		return nil
+	}
+	return errors.Errorf("join mode %q is invalid", c.Mode)
+}
+
+// requiredCount 汇聚节点开始执行需要完成的前置节点数量, <=0 表示需要所有前置节点都处理完
+func (c *JoinConfig) requiredCount() int {
+	if c == nil {
+		return 0
+	}
+	switch c.Mode {
+	case JoinModeAny:
+		return 1
+	case JoinModeNOfM:
+		return int(c.Count)
+	}
+	return 0
+}
+
+func (c *JoinConfig) isCancelRemaining() bool {
+	return c != nil && c.CancelRemaining
+}
@@ -3,10 +3,13 @@ package workflow
 import (
 	"encoding/json"
 	"fmt"
+	"sync"
 )
 
 // JSONContext 封装 JSON 上下文，提供便捷的读写方法
+// 读写方法都是并发安全的，并行执行分支时可以共享同一个上下文
 type JSONContext struct {
+	mu   sync.RWMutex
 	data map[string]any
 }
 
@@ -35,6 +38,8 @@ func (c *JSONContext) Get(keys ...string) (any, bool) {
 	if len(keys) == 0 {
 		return nil, false
 	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 
 	current := any(c.data)
 	for _, key := range keys {
@@ -116,6 +121,8 @@ func (c *JSONContext) Set(keys []string, value any) error {
 	if len(keys) == 0 {
 		return fmt.Errorf("keys cannot be empty")
 	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
 	// 确保所有中间路径都是 map
 	current := c.data
@@ -144,6 +151,8 @@ func (c *JSONContext) Delete(keys ...string) {
 	if len(keys) == 0 {
 		return
 	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
 	if len(keys) == 1 {
 		delete(c.data, keys[0])
@@ -164,10 +173,12 @@ func (c *JSONContext) Delete(keys ...string) {
 
 // ToBytes 转换为 JSON 字节
 func (c *JSONContext) ToBytes() ([]byte, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
 	return json.Marshal(c.data)
 }
 func (c *JSONContext) ToBytesWithoutError() []byte {
-	bytes, err := json.Marshal(c.data)
+	bytes, err := c.ToBytes()
 	if err != nil {
 		return nil
 	}
@@ -183,7 +194,7 @@ func (c *JSONContext) ToRawMessage() (json.RawMessage, error) {
 	return json.RawMessage(b), nil
 }
 
-// ToMap 返回底层 map（注意：返回的是引用）
+// ToMap 返回底层 map（注意：返回的是引用，并发场景下不受锁保护，需要只读使用）
 func (c *JSONContext) ToMap() map[string]any {
 	return c.data
 }
@@ -208,10 +219,51 @@ func MergeJSONContexts(contexts ...*JSONContext) *JSONContext {
 	result := NewJSONContext(nil)
 	for _, ctx := range contexts {
 		if ctx != nil {
+			ctx.mu.RLock()
 			for k, v := range ctx.data {
 				result.data[k] = v
 			}
+			ctx.mu.RUnlock()
 		}
 	}
 	return result
 }
+
+// MergePatch 按照 JSON Merge Patch(RFC 7386) 合并 patch
+// patch 中值为 null 的 key 会被删除, 对象会递归合并, 其他类型(包括数组)直接覆盖
+func (c *JSONContext) MergePatch(patch map[string]any) error {
+	// 先序列化一次, 避免和调用方共享引用, 数字类型也和从数据库读出来的保持一致
+	b, err := json.Marshal(patch)
+	if err != nil {
+		return fmt.Errorf("marshal patch failed: %w", err)
+	}
+	normalizedPatch := make(map[string]any)
+	if err := json.Unmarshal(b, &normalizedPatch); err != nil {
+		return fmt.Errorf("unmarshal patch failed: %w", err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.data = mergePatchMap(c.data, normalizedPatch)
+	return nil
+}
+
+func mergePatchMap(target map[string]any, patch map[string]any) map[string]any {
+	if target == nil {
+		target = make(map[string]any)
+	}
+	for key, value := range patch {
+		if value == nil {
+			delete(target, key)
+			continue
+		}
+		patchValue, ok := value.(map[string]any)
+		if !ok {
+			target[key] = value
+			continue
+		}
+		// 原来的值不是对象时, 按照空对象合并
+		targetValue, _ := target[key].(map[string]any)
+		target[key] = mergePatchMap(targetValue, patchValue)
+	}
+	return target
+}
@@ -175,6 +175,32 @@ func TestMergeJSONContexts(t *testing.T) {
 	}
 }
 
+func TestJSONContext_MergePatch(t *testing.T) {
+	ctx := NewJSONContext([]byte(`{"a": "b", "c": {"d": "e", "f": "g"}, "tags": [1, 2], "n": 1}`))
+
+	err := ctx.MergePatch(map[string]any{
+		"a":    "z",
+		"c":    map[string]any{"f": nil, "h": "i"},
+		"tags": []any{3},
+		"n":    map[string]any{"m": 1},
+		"x":    map[string]any{"y": nil},
+	})
+	if err != nil {
+		t.Fatalf("MergePatch failed: %v", err)
+	}
+
+	want := `{"a":"z","c":{"d":"e","h":"i"},"n":{"m":1},"tags":[3],"x":{}}`
+	b, _ := ctx.ToBytes()
+	var got, expected map[string]any
+	json.Unmarshal(b, &got)
+	json.Unmarshal([]byte(want), &expected)
+	gotBytes, _ := json.Marshal(got)
+	expectedBytes, _ := json.Marshal(expected)
+	if string(gotBytes) != string(expectedBytes) {
+		t.Errorf("Expected %s, got %s", expectedBytes, gotBytes)
+	}
+}
+
 // 性能测试
 func BenchmarkJSONContext_Get(b *testing.B) {
 	ctx := NewJSONContext([]byte(`{
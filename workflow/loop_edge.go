@@ -0,0 +1,196 @@
+package workflow
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// LoopConfig 循环边配置, 节点完成后条件满足时回到target节点重新执行
+// 循环边不参与环检测, next_nodes 中的环仍然会被拒绝
+type LoopConfig struct {
+	// 回到的节点ID, 必须是当前节点自己或者能到达当前节点的前置节点
+	Target string `json:"target"`
+	// 循环条件, 使用当前节点完成后的上下文求值, 满足时回到target节点, 语法参考Condition
+	Condition string `json:"condition"`
+	// 最多循环次数, 达到后循环边不再生效, 节点按照next_nodes继续执行
+	MaxIterations int64 `json:"max_iterations"`
+}
+
+func (c *LoopConfig) check() error {
+	if c == nil || c.Target == "" {
+		return errors.New("loop.target is required")
+	}
+	if c.Condition == "" {
+		return errors.New("loop.condition is required")
+	}
+	if c.MaxIterations <= 0 {
+		return errors.Errorf("loop.max_iterations must be greater than 0, got: %d", c.MaxIterations)
+	}
+	return nil
+}
+
+// LoopEdgeDefinition 循环边定义entity
+type LoopEdgeDefinition struct {
+	Target        *WorkflowTaskNodeDefinition
+	Condition     *Condition
+	MaxIterations int64
+	// 循环体, target到当前节点路径上的所有节点(包括两端), 按拓扑序排列
+	// 循环生效时这些节点都会创建新一轮的任务实例
+	BodyNodes []*WorkflowTaskNodeDefinition
+}
+
+// buildLoopEdgeDefinition 构建循环边, 需要在工作流图构建完成并且检查无环之后调用
+func buildLoopEdgeDefinition(source *WorkflowTaskNodeDefinition, config *LoopConfig, topologicalNodes []*WorkflowTaskNodeDefinition) (*LoopEdgeDefinition, error) {
+	condition, err := ParseCondition(config.Condition)
+	if err != nil {
+		return nil, errors.WithMessage(err, "loop.condition is invalid")
+	}
+	var target *WorkflowTaskNodeDefinition
+	for _, node := range topologicalNodes {
+		if node.TaskType == config.Target {
+			target = node
+			break
+		}
+	}
+	if target == nil || target.TaskType == rootTaskNode || target.TaskType == endTaskNode {
+		return nil, errors.Errorf("loop.target %q not found", config.Target)
+	}
+	targetChildren := getAllChildrenTaskType(target)
+	if target != source && !slices.Contains(targetChildren, source.TaskType) {
+		return nil, errors.Errorf("loop.target %q can not reach node %q", config.Target, source.TaskType)
+	}
+	bodyNodes := make([]*WorkflowTaskNodeDefinition, 0)
+	for _, node := range topologicalNodes {
+		if node == target || node == source {
+			bodyNodes = append(bodyNodes, node)
+			continue
+		}
+		if slices.Contains(targetChildren, node.TaskType) && slices.Contains(getAllChildrenTaskType(node), source.TaskType) {
+			bodyNodes = append(bodyNodes, node)
+		}
+	}
+	return &LoopEdgeDefinition{
+		Target:        target,
+		Condition:     condition,
+		MaxIterations: config.MaxIterations,
+		BodyNodes:     bodyNodes,
+	}, nil
+}
+
+// nodeContextKeyLoopIterations 每条循环边已经循环的次数, 保存在循环边起点的system中, key为循环边的target
+// 起点被自己的循环边重新创建时次数带到新一轮, 被外层循环重新创建时次数清零
+const nodeContextKeyLoopIterations = "loop_iterations"
+
+// getLoopIterations 获取循环边已经循环的次数
+func getLoopIterations(nodeContext *JSONContext, target string) int64 {
+	if nodeContext == nil {
+		return 0
+	}
+	iterations, _ := nodeContext.GetInt64(NodeContextKeySystem, nodeContextKeyLoopIterations, target)
+	return iterations
+}
+
+// fireLoopEdges 节点完成后检查循环边, 条件满足时循环体的节点创建新一轮的任务实例(状态为restarting)
+// 旧一轮的任务实例保留作为历史, 还没有结束的标记为跳过
+// 返回是否回到了循环的起点, 回到起点时当前节点的后置节点不会执行
+func (s *WorkflowServiceImpl) fireLoopEdges(ctx context.Context, state *workflowRunState, node *WorkflowTaskNodeDefinition, taskNode *WorkflowTaskNode) (bool, error) {
+	for _, loop := range node.Loops {
+		iterations := getLoopIterations(taskNode.NodeContext, loop.Target.TaskType)
+		if iterations >= loop.MaxIterations {
+			// 循环次数已经用完
+			continue
+		}
+		if !loop.Condition.Evaluate(taskNode.NodeContext) {
+			continue
+		}
+		// 起点新一轮的任务实例继承所有循环边的次数, 当前循环边的次数加1
+		sourceContext := NewJSONContext(nil)
+		if loopIterations, ok := taskNode.NodeContext.Get(NodeContextKeySystem, nodeContextKeyLoopIterations); ok {
+			sourceContext.Set([]string{NodeContextKeySystem, nodeContextKeyLoopIterations}, loopIterations)
+		}
+		sourceContext.Set([]string{NodeContextKeySystem, nodeContextKeyLoopIterations, loop.Target.TaskType}, iterations+1)
+		newTaskNodes := make([]*WorkflowTaskNode, 0, len(loop.BodyNodes))
+		err := s.repo.Transaction(ctx, func(ctx context.Context) error {
+			unfinishedTaskIDs := make([]int64, 0)
+			for _, bodyNode := range loop.BodyNodes {
+				// 循环体的节点可能还属于其他循环, 每个节点的轮次单独递增
+				generation := int64(0)
+				if oldTaskNode, ok := state.getTaskNode(bodyNode.TaskType); ok {
+					generation = oldTaskNode.Generation + 1
+					if !IsOverWorkflowTaskNodeStatus(oldTaskNode.Status) {
+						unfinishedTaskIDs = append(unfinishedTaskIDs, oldTaskNode.ID)
+					}
+				}
+				nodeContext := NewJSONContext(nil)
+				if bodyNode == node {
+					nodeContext = sourceContext
+				}
+				taskInstancePo, err := s.repo.CreateWorkflowTaskInstance(ctx, &WorkflowTaskInstancePo{
+					WorkflowInstanceID: state.workflowInstance.ID,
+					TaskType:           bodyNode.TaskType,
+					Status:             WorkflowTaskNodeStatusRestarting,
+					NodeContext:        nodeContext.ToBytesWithoutError(),
+					CreatedAt:          time.Now().Unix(),
+					UpdatedAt:          time.Now().Unix(),
+					Generation:         generation,
+				})
+				if err != nil {
+					return errors.WithMessagef(err, "CreateWorkflowTaskInstance failed, taskType: %s, generation: %d", bodyNode.TaskType, generation)
+				}
+				newTaskNodes = append(newTaskNodes, &WorkflowTaskNode{
+					ID:                 taskInstancePo.ID,
+					WorkflowInstanceID: taskInstancePo.WorkflowInstanceID,
+					TaskType:           taskInstancePo.TaskType,
+					Status:             taskInstancePo.Status,
+					NodeContext:        NewByte2StrctPbValue(taskInstancePo.NodeContext),
+					CreatedAt:          taskInstancePo.CreatedAt,
+					UpdatedAt:          taskInstancePo.UpdatedAt,
+					Generation:         taskInstancePo.Generation,
+				})
+			}
+			if len(unfinishedTaskIDs) == 0 {
+				return nil
+			}
+			return s.repo.UpdateWorkflowTaskInstance(ctx, &UpdateWorkflowTaskInstanceParams{
+				Where: &UpdateWorkflowTaskInstanceWhere{
+					IDIn: unfinishedTaskIDs,
+				},
+				Fields: &UpdateWorkflowTaskInstanceField{
+					Status: String(WorkflowTaskNodeStatusSkipped),
+				},
+				LimitMax: len(unfinishedTaskIDs),
+			})
+		})
+		if err != nil {
+			return false, errors.WithMessagef(err, "fire loop failed, workflowInstanceID: %d, taskType: %s, target: %s", state.workflowInstance.ID, node.TaskType, loop.Target.TaskType)
+		}
+		for _, newTaskNode := range newTaskNodes {
+			state.setTaskNode(newTaskNode.TaskType, newTaskNode)
+		}
+		state.mu.Lock()
+		state.isLoopFired = true
+		state.mu.Unlock()
+		return true, nil
+	}
+	return false, nil
+}
+
+// latestGenerationTaskInstances 过滤出每个节点最新一轮的任务实例(包括map节点的子任务)
+func latestGenerationTaskInstances(taskInstances []*WorkflowTaskInstancePo) []*WorkflowTaskInstancePo {
+	latestGenerations := make(map[string]int64)
+	for _, taskInstance := range taskInstances {
+		if generation, ok := latestGenerations[taskInstance.TaskType]; !ok || taskInstance.Generation > generation {
+			latestGenerations[taskInstance.TaskType] = taskInstance.Generation
+		}
+	}
+	ret := make([]*WorkflowTaskInstancePo, 0, len(taskInstances))
+	for _, taskInstance := range taskInstances {
+		if taskInstance.Generation == latestGenerations[taskInstance.TaskType] {
+			ret = append(ret, taskInstance)
+		}
+	}
+	return ret
+}
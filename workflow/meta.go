@@ -22,6 +22,18 @@ var (
 	// ErrWorkflowTaskFailedWithFailed: 任务实例失败，这个任务失败，整个工作流状态变成failed
 	// 场景&应用: 一些关键参数丢失，整个工作流需要终止，无论重试多少次都不会成功
 	ErrWorkflowTaskFailedWithFailed = errors.New("workflow task failed with termination") // 任务实例失败，但是终止整个工作流，工作流状态变成failed
+	// ErrWorkflowTaskCancelled: 任务实例被取消, 整个工作流状态变成cancelled, 是ErrWorkflowTaskFailedWithFailed的一种
+	// 场景&应用: 子工作流被取消, 父工作流跟着取消
+	ErrWorkflowTaskCancelled = errors.WithMessage(ErrWorkflowTaskFailedWithFailed, "workflow task cancelled")
+	// ErrWorkflowTaskExecutionTimeout: 工作器单次调用超过节点配置的execution_timeout, 按照普通失败处理, 计入失败次数
+	// 场景&应用: 调用外部服务卡住, 不希望一直占用工作流实例的锁
+	ErrWorkflowTaskExecutionTimeout = errors.New("workflow task execution timeout")
+	// ErrWorkflowInstanceTimedOut: 工作流实例超过截止时间, 工作流状态变成timed_out, 是ErrWorkflowTaskFailedWithFailed的一种
+	// 场景&应用: 入职流程需要在7天内完成, 超时后取消未完成的任务
+	ErrWorkflowInstanceTimedOut = errors.WithMessage(ErrWorkflowTaskFailedWithFailed, "workflow instance timed out")
+	// ErrWorkflowConcurrencyRejected: 工作流配置了concurrency_policy为reject, 相同BusinessID已经有未结束的实例, 拒绝创建
+	// 场景&应用: 同一个订单不能同时有两个退款流程
+	ErrWorkflowConcurrencyRejected = errors.New("workflow concurrency rejected")
 
 	// 下面这个两个错误信息给业务上面使用,目前用于报警定义
 	// 如果你希望这种错误在定时脚本打印error 使用errors.Wrapf(ErrWorkBussinessCriticalError, "err message: %s", err)
@@ -46,10 +58,17 @@ const (
 	WorkflowInstanceStatusFailed WorkflowInstanceStatus = "failed"
 	// 取消, 工作流终止状态, 不再重试 普遍含义: 任务执行取消, 手动取消的，和人工手动操作有关系，目前没有使用到
 	WorkflowInstanceStatusCancelled WorkflowInstanceStatus = "canceled"
+	// 超时, 工作流终止状态, 不再重试 普遍含义: 超过截止时间还没有完成, 未完成的任务被取消
+	WorkflowInstanceStatusTimedOut WorkflowInstanceStatus = "timed_out"
+	// 暂停, 非终止状态, RunWorkflow不会执行任何节点, 恢复后从暂停的位置继续执行
+	WorkflowInstanceStatusPaused WorkflowInstanceStatus = "paused"
+	// 排队中, 非终止状态, 工作流配置了concurrency_policy为queue, 相同BusinessID的前一个实例结束后自动变成init开始执行
+	WorkflowInstanceStatusQueued WorkflowInstanceStatus = "queued"
 )
 
 func IsOverWorkflowInstanceStatus(status WorkflowInstanceStatus) bool {
-	return status == WorkflowInstanceStatusFailed || status == WorkflowInstanceStatusCancelled || status == WorkflowInstanceStatusCompleted
+	return status == WorkflowInstanceStatusFailed || status == WorkflowInstanceStatusCancelled || status == WorkflowInstanceStatusCompleted ||
+		status == WorkflowInstanceStatusTimedOut
 }
 
 func GetWorkflowInstanceStatusText(status WorkflowInstanceStatus) string {
@@ -64,6 +83,12 @@ func GetWorkflowInstanceStatusText(status WorkflowInstanceStatus) string {
 		return "失败"
 	case WorkflowInstanceStatusCancelled:
 		return "取消"
+	case WorkflowInstanceStatusTimedOut:
+		return "超时"
+	case WorkflowInstanceStatusPaused:
+		return "暂停"
+	case WorkflowInstanceStatusQueued:
+		return "排队中"
 	}
 	return "未知"
 }
@@ -83,10 +108,27 @@ const (
 	WorkflowTaskNodeStatusFailed WorkflowTaskNodeStatus = "failed"
 	// 取消, 工作流终止状态, 不再重试 普遍含义: 任务执行取消, 手动取消的，和人工手动操作有关系，目前没有使用到
 	WorkflowTaskNodeStatusCancelled WorkflowTaskNodeStatus = "canceled"
+	// 跳过, 工作流终止状态, 不再重试 普遍含义: 条件分支没有命中, 节点不会执行, 后置的汇聚节点把它当作已满足
+	WorkflowTaskNodeStatusSkipped WorkflowTaskNodeStatus = "skipped"
+)
+
+// CompensationStatus 任务实例的补偿状态, 只有实现了WorkflowTaskNodeCompensator并且已经完成的任务实例才会补偿
+type CompensationStatus = string
+
+const (
+	// 不需要补偿(默认)
+	CompensationStatusNone CompensationStatus = ""
+	// 等待补偿, 工作流失败或者取消时所有需要补偿的任务实例先标记为pending, 中途崩溃后可以继续补偿
+	CompensationStatusPending CompensationStatus = "pending"
+	// 补偿完成
+	CompensationStatusCompleted CompensationStatus = "completed"
+	// 补偿失败, Compensate返回ErrWorkflowTaskFailedWithFailed, 不再重试, 需要人工处理
+	CompensationStatusFailed CompensationStatus = "failed"
 )
 
 func IsOverWorkflowTaskNodeStatus(status WorkflowTaskNodeStatus) bool {
-	return status == WorkflowTaskNodeStatusFailed || status == WorkflowTaskNodeStatusCancelled || status == WorkflowTaskNodeStatusCompleted
+	return status == WorkflowTaskNodeStatusFailed || status == WorkflowTaskNodeStatusCancelled || status == WorkflowTaskNodeStatusCompleted ||
+		status == WorkflowTaskNodeStatusSkipped
 }
 
 // NodeContextKey 节点上下文key,用于获取节点上下文中的值
@@ -99,6 +141,14 @@ const (
 	NodeContextKeyWorkflowContext NodeContextKey = "workflow_context"
 	// 备注原因，一般和节点失败相关，表明为什么失败
 	NodeContextKeyReason NodeContextKey = "reason"
+	// 子工作流节点的信息, 包括子工作流实例ID、状态以及子工作流的输出
+	NodeContextKeySubWorkflow NodeContextKey = "sub_workflow"
+	// 延时节点的信息, 包括唤醒时间
+	NodeContextKeyDelay NodeContextKey = "delay"
+	// 等待事件节点的输出, 包括事件内容以及是否超时
+	NodeContextKeyWaitEvent NodeContextKey = "wait_event"
+	// map节点的信息, map节点上有元素数量以及按下标排序的子任务输出, 子任务上有当前元素以及下标
+	NodeContextKeyMap NodeContextKey = "map"
 )
 
 func GetWorkflowTaskNodeStatusText(status WorkflowTaskNodeStatus) string {
@@ -121,6 +171,8 @@ func GetWorkflowTaskNodeStatusText(status WorkflowTaskNodeStatus) string {
 		return "失败"
 	case WorkflowTaskNodeStatusCompleted:
 		return "完成"
+	case WorkflowTaskNodeStatusSkipped:
+		return "跳过"
 	}
 	return "未知"
 }
@@ -0,0 +1,302 @@
+package workflow
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// MigrateWorkflowInstanceParams 迁移工作流实例到新版本的参数
+type MigrateWorkflowInstanceParams struct {
+	WorkflowInstanceID int64 `json:"workflow_instance_id" validate:"gt=0"`
+	TargetVersion      int64 `json:"target_version" validate:"gt=0"` // 目标版本, 需要大于实例当前的版本
+	// 节点映射, key为当前版本的TaskType, value为目标版本的TaskType, 没有出现的节点按照相同的TaskType对应
+	NodeMapping map[string]string `json:"node_mapping"`
+	// 目标版本中删除的节点, 未结束的任务实例会被取消, 已经结束的任务实例保留
+	RemovedNodes []string `json:"removed_nodes"`
+	// 目标版本中新增的节点, 迁移后按照目标版本的工作流图执行
+	AddedNodes []string `json:"added_nodes"`
+	// 只检查并返回会发生的修改, 不写入
+	DryRun bool `json:"dry_run"`
+}
+
+// 任务实例迁移的操作类型
+const (
+	MigrateTaskActionRename = "rename" // 节点改名, 任务实例的TaskType改成目标版本的TaskType
+	MigrateTaskActionCancel = "cancel" // 节点被删除, 未结束的任务实例被取消
+)
+
+// MigrateTaskInstanceChange 单个任务实例的迁移修改
+type MigrateTaskInstanceChange struct {
+	TaskInstanceID int64
+	FromTaskType   string
+	ToTaskType     string // 节点被删除时为空
+	Status         string // 迁移前的任务状态
+	Action         string
+}
+
+// MigrateWorkflowInstanceResult 迁移结果, DryRun时表示将要发生的修改
+type MigrateWorkflowInstanceResult struct {
+	WorkflowInstanceID int64
+	FromVersion        int64
+	ToVersion          int64
+	TaskChanges        []*MigrateTaskInstanceChange // 按照任务实例ID排序
+	AddedNodes         []string                     // 目标版本中新增的节点, 迁移后按照目标版本的工作流图创建任务实例
+	DryRun             bool
+}
+
+// definitionTaskNodeMap 工作流定义中的所有节点, 包括超时节点, 不包括根节点和结束节点
+func definitionTaskNodeMap(definition *WorkflowDefinition) map[string]*WorkflowTaskNodeDefinition {
+	nodeMap := make(map[string]*WorkflowTaskNodeDefinition, len(definition.Nodes))
+	for _, node := range definition.Nodes {
+		if node.TaskType == rootTaskNode || node.TaskType == endTaskNode {
+			continue
+		}
+		nodeMap[node.TaskType] = node
+	}
+	if definition.TimeoutNode != nil {
+		nodeMap[definition.TimeoutNode.TaskType] = definition.TimeoutNode
+	}
+	return nodeMap
+}
+
+// buildMigrateNodeMapping 计算当前版本每个节点在目标版本中对应的节点, 被删除的节点对应空字符串
+// 当前版本的每个节点都需要有对应关系, 目标版本中没有对应关系的节点需要在AddedNodes中声明
+func buildMigrateNodeMapping(params *MigrateWorkflowInstanceParams, fromDefinition *WorkflowDefinition, toDefinition *WorkflowDefinition) (map[string]string, error) {
+	fromNodes := definitionTaskNodeMap(fromDefinition)
+	toNodes := definitionTaskNodeMap(toDefinition)
+	for fromTaskType := range params.NodeMapping {
+		if _, ok := fromNodes[fromTaskType]; !ok {
+			return nil, errors.Errorf("node_mapping key %s not found in version %d", fromTaskType, fromDefinition.Version)
+		}
+		if slices.Contains(params.RemovedNodes, fromTaskType) {
+			return nil, errors.Errorf("node %s can not be both mapped and removed", fromTaskType)
+		}
+	}
+	for _, removed := range params.RemovedNodes {
+		if _, ok := fromNodes[removed]; !ok {
+			return nil, errors.Errorf("removed node %s not found in version %d", removed, fromDefinition.Version)
+		}
+		if _, ok := toNodes[removed]; ok {
+			// 已经结束的任务实例会保留, 目标版本中同名的节点会把它们当作自己的任务实例
+			return nil, errors.Errorf("removed node %s still exists in version %d", removed, toDefinition.Version)
+		}
+	}
+	mapping := make(map[string]string, len(fromNodes))
+	mappedFrom := make(map[string]string, len(fromNodes))
+	for fromTaskType, fromNode := range fromNodes {
+		if slices.Contains(params.RemovedNodes, fromTaskType) {
+			mapping[fromTaskType] = ""
+			continue
+		}
+		toTaskType := fromTaskType
+		if mapped, ok := params.NodeMapping[fromTaskType]; ok {
+			toTaskType = mapped
+		}
+		toNode, ok := toNodes[toTaskType]
+		if !ok {
+			return nil, errors.Errorf("node %s not found in version %d, add it to node_mapping or removed_nodes", toTaskType, toDefinition.Version)
+		}
+		if toNode.Kind != fromNode.Kind {
+			return nil, errors.Errorf("node kind changed from %s to %s, node: %s", fromNode.Kind, toNode.Kind, fromTaskType)
+		}
+		if (fromDefinition.TimeoutNode != nil && fromDefinition.TimeoutNode.TaskType == fromTaskType) !=
+			(toDefinition.TimeoutNode != nil && toDefinition.TimeoutNode.TaskType == toTaskType) {
+			return nil, errors.Errorf("timeout node can only be mapped to timeout node, node: %s", fromTaskType)
+		}
+		if other, ok := mappedFrom[toTaskType]; ok {
+			return nil, errors.Errorf("nodes %s and %s are both mapped to %s", other, fromTaskType, toTaskType)
+		}
+		mappedFrom[toTaskType] = fromTaskType
+		mapping[fromTaskType] = toTaskType
+	}
+	for toTaskType := range toNodes {
+		_, isMapped := mappedFrom[toTaskType]
+		isAdded := slices.Contains(params.AddedNodes, toTaskType)
+		if isMapped && isAdded {
+			return nil, errors.Errorf("added node %s is already mapped from %s", toTaskType, mappedFrom[toTaskType])
+		}
+		if !isMapped && !isAdded {
+			return nil, errors.Errorf("node %s is new in version %d, add it to added_nodes or node_mapping", toTaskType, toDefinition.Version)
+		}
+	}
+	for _, added := range params.AddedNodes {
+		if _, ok := toNodes[added]; !ok {
+			return nil, errors.Errorf("added node %s not found in version %d", added, toDefinition.Version)
+		}
+	}
+	// 根节点和结束节点在所有版本中都存在
+	mapping[rootTaskNode] = rootTaskNode
+	mapping[endTaskNode] = endTaskNode
+	return mapping, nil
+}
+
+// checkMigrateTaskInstances 检查实例当前的状态能否在目标版本的工作流图中表示
+// 目标版本中还没有任务实例的节点, 它的后续节点也不能有任务实例, 否则后续节点在它之前执行过
+func checkMigrateTaskInstances(toDefinition *WorkflowDefinition, mapping map[string]string, taskInstances []*WorkflowTaskInstancePo) error {
+	startedTaskTypes := make(map[string]struct{}, len(taskInstances))
+	for _, taskInstance := range taskInstances {
+		toTaskType, ok := mapping[taskInstance.TaskType]
+		if !ok {
+			// 更早版本遗留的任务实例, 不在当前版本的工作流图中
+			continue
+		}
+		if toTaskType != "" {
+			startedTaskTypes[toTaskType] = struct{}{}
+		}
+	}
+	for _, node := range toDefinition.Nodes {
+		if node.TaskType == rootTaskNode || node.TaskType == endTaskNode {
+			continue
+		}
+		if _, ok := startedTaskTypes[node.TaskType]; ok {
+			continue
+		}
+		for _, childTaskType := range getAllChildrenTaskType(node) {
+			if childTaskType == endTaskNode {
+				continue
+			}
+			if _, ok := startedTaskTypes[childTaskType]; ok {
+				return errors.Errorf("node %s has no task instance but its downstream node %s has already started", node.TaskType, childTaskType)
+			}
+		}
+	}
+	return nil
+}
+
+func (s *WorkflowServiceImpl) MigrateWorkflowInstance(ctx context.Context, params *MigrateWorkflowInstanceParams) (*MigrateWorkflowInstanceResult, error) {
+	if err := validatorUtil.Struct(params); err != nil {
+		return nil, errors.Wrapf(ErrWorkflowParamInvalid, "MigrateWorkflowInstance failed, params: %v,err: %v", params, err)
+	}
+	var result *MigrateWorkflowInstanceResult
+	err := s.executeLock.NonBlockingSynchronized(ctx,
+		workflowOpLockKey(params.WorkflowInstanceID),
+		10*time.Minute,
+		func(ctx context.Context) error {
+			workflowInstancePo, err := s.queryWorkflowInstanceByID(ctx, params.WorkflowInstanceID)
+			if err != nil {
+				return err
+			}
+			if IsOverWorkflowInstanceStatus(workflowInstancePo.Status) {
+				return errors.WithMessagef(ErrWorkflowParamInvalid, "workflow instance is over, workflowInstanceID: %d, status: %s", params.WorkflowInstanceID, workflowInstancePo.Status)
+			}
+			fromDefinition, err := s.getWorkflowDefinitionForInstance(workflowInstancePo)
+			if err != nil {
+				return errors.WithMessagef(err, "GetAndLoadWorkflowDefinition failed, workflowType: %s, version: %d", workflowInstancePo.WorkflowType, workflowInstancePo.WorkflowVersion)
+			}
+			if params.TargetVersion <= fromDefinition.Version {
+				return errors.WithMessagef(ErrWorkflowParamInvalid, "target version must be newer than current version, workflowInstanceID: %d, currentVersion: %d, targetVersion: %d", params.WorkflowInstanceID, fromDefinition.Version, params.TargetVersion)
+			}
+			toDefinition, err := s.registry.GetAndLoadWorkflowDefinitionWithVersion(workflowInstancePo.WorkflowType, params.TargetVersion)
+			if err != nil {
+				return errors.WithMessagef(err, "GetAndLoadWorkflowDefinition failed, workflowType: %s, version: %d", workflowInstancePo.WorkflowType, params.TargetVersion)
+			}
+			mapping, err := buildMigrateNodeMapping(params, fromDefinition, toDefinition)
+			if err != nil {
+				return errors.WithMessagef(ErrWorkflowParamInvalid, "workflowInstanceID: %d, err: %v", params.WorkflowInstanceID, err)
+			}
+			taskInstances, err := s.getAllTaskInstancePo(ctx, params.WorkflowInstanceID)
+			if err != nil {
+				return errors.WithMessagef(err, "getAllTaskInstancePo failed, workflowInstanceID: %d", params.WorkflowInstanceID)
+			}
+			if err := checkMigrateTaskInstances(toDefinition, mapping, taskInstances); err != nil {
+				return errors.WithMessagef(ErrWorkflowParamInvalid, "workflowInstanceID: %d, err: %v", params.WorkflowInstanceID, err)
+			}
+
+			result = &MigrateWorkflowInstanceResult{
+				WorkflowInstanceID: params.WorkflowInstanceID,
+				FromVersion:        fromDefinition.Version,
+				ToVersion:          toDefinition.Version,
+				TaskChanges:        make([]*MigrateTaskInstanceChange, 0),
+				AddedNodes:         append([]string{}, params.AddedNodes...),
+				DryRun:             params.DryRun,
+			}
+			renameTaskIDs := make(map[string][]int64)
+			cancelTaskIDs := make([]int64, 0)
+			for _, taskInstance := range taskInstances {
+				toTaskType, ok := mapping[taskInstance.TaskType]
+				if !ok || toTaskType == taskInstance.TaskType {
+					continue
+				}
+				if toTaskType == "" {
+					if IsOverWorkflowTaskNodeStatus(taskInstance.Status) {
+						// 已经结束的任务实例保留, 用于查询历史
+						continue
+					}
+					cancelTaskIDs = append(cancelTaskIDs, taskInstance.ID)
+					result.TaskChanges = append(result.TaskChanges, &MigrateTaskInstanceChange{
+						TaskInstanceID: taskInstance.ID,
+						FromTaskType:   taskInstance.TaskType,
+						Status:         taskInstance.Status,
+						Action:         MigrateTaskActionCancel,
+					})
+					continue
+				}
+				renameTaskIDs[toTaskType] = append(renameTaskIDs[toTaskType], taskInstance.ID)
+				result.TaskChanges = append(result.TaskChanges, &MigrateTaskInstanceChange{
+					TaskInstanceID: taskInstance.ID,
+					FromTaskType:   taskInstance.TaskType,
+					ToTaskType:     toTaskType,
+					Status:         taskInstance.Status,
+					Action:         MigrateTaskActionRename,
+				})
+			}
+			sort.Slice(result.TaskChanges, func(i, j int) bool {
+				return result.TaskChanges[i].TaskInstanceID < result.TaskChanges[j].TaskInstanceID
+			})
+			if params.DryRun {
+				return nil
+			}
+			return s.repo.Transaction(ctx, func(ctx context.Context) error {
+				for toTaskType, taskIDs := range renameTaskIDs {
+					err := s.repo.UpdateWorkflowTaskInstance(ctx, &UpdateWorkflowTaskInstanceParams{
+						Where: &UpdateWorkflowTaskInstanceWhere{
+							IDIn: taskIDs,
+						},
+						Fields: &UpdateWorkflowTaskInstanceField{
+							TaskType: String(toTaskType),
+						},
+						LimitMax: len(taskIDs),
+					})
+					if err != nil {
+						return errors.WithMessagef(err, "UpdateWorkflowTaskInstance failed, workflowInstanceID: %d, taskType: %s", params.WorkflowInstanceID, toTaskType)
+					}
+				}
+				if len(cancelTaskIDs) > 0 {
+					err := s.repo.UpdateWorkflowTaskInstance(ctx, &UpdateWorkflowTaskInstanceParams{
+						Where: &UpdateWorkflowTaskInstanceWhere{
+							IDIn: cancelTaskIDs,
+						},
+						Fields: &UpdateWorkflowTaskInstanceField{
+							Status: String(WorkflowTaskNodeStatusCancelled),
+						},
+						LimitMax: len(cancelTaskIDs),
+					})
+					if err != nil {
+						return errors.WithMessagef(err, "UpdateWorkflowTaskInstance failed, workflowInstanceID: %d", params.WorkflowInstanceID)
+					}
+				}
+				err := s.repo.UpdateWorkflowInstance(ctx, &UpdateWorkflowInstanceParams{
+					Where: &UpdateWorkflowInstanceWhere{
+						IDIn: []int64{params.WorkflowInstanceID},
+					},
+					Fields: &UpdateWorkflowInstanceField{
+						WorkflowVersion: Int64(toDefinition.Version),
+						NextWakeUpAt:    Int64(0),
+					},
+					LimitMax: 1,
+				})
+				if err != nil {
+					return errors.WithMessagef(err, "UpdateWorkflowInstance failed, workflowInstanceID: %d", params.WorkflowInstanceID)
+				}
+				return nil
+			})
+		})
+	if err != nil {
+		return nil, errors.WithMessagef(err, "MigrateWorkflowInstance failed, workflowInstanceID: %d, targetVersion: %d", params.WorkflowInstanceID, params.TargetVersion)
+	}
+	return result, nil
+}
@@ -0,0 +1,190 @@
+package workflow
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// OperateWorkflowNodeParams 人工操作节点参数, 跳过或者强制完成节点时使用
+type OperateWorkflowNodeParams struct {
+	WorkflowInstanceID int64  `json:"workflow_instance_id" validate:"gt=0"`
+	TaskType           string `json:"task_type" validate:"required"`
+	Operator           string `json:"operator" validate:"required"` // 操作人, 记录在节点上下文的system.operation中
+	Reason             string `json:"reason" validate:"required"`   // 操作原因, 记录在节点上下文的system.operation中
+	// 合并到节点上下文的输出, 后置节点可以通过pre_node_context读取, 不能包含系统保留的key
+	Output map[string]any `json:"output"`
+}
+
+// 人工操作的类型, 记录在节点上下文的system.operation.action中
+const (
+	operateWorkflowNodeActionSkip     = "skip"
+	operateWorkflowNodeActionComplete = "complete"
+)
+
+// isOperatorSkippedTaskNode 节点是否是人工跳过的, 人工跳过的节点对后置节点来说和完成一样, 输出只有人工操作时指定的Output
+// 分支条件没有命中或者汇聚节点取消的节点也是skipped状态, 但是不走后置节点的分支
+func isOperatorSkippedTaskNode(taskNode *WorkflowTaskNode) bool {
+	if taskNode.Status != WorkflowTaskNodeStatusSkipped || taskNode.NodeContext == nil {
+		return false
+	}
+	action, _ := taskNode.NodeContext.GetString(NodeContextKeySystem, "operation", "action")
+	return action == operateWorkflowNodeActionSkip
+}
+
+func (s *WorkflowServiceImpl) SkipWorkflowNode(ctx context.Context, params *OperateWorkflowNodeParams) error {
+	return s.operateWorkflowNode(ctx, params, operateWorkflowNodeActionSkip)
+}
+
+func (s *WorkflowServiceImpl) CompleteWorkflowNode(ctx context.Context, params *OperateWorkflowNodeParams) error {
+	return s.operateWorkflowNode(ctx, params, operateWorkflowNodeActionComplete)
+}
+
+// operateWorkflowNode 人工把节点标记为跳过或者完成, 节点的任务实例还没有创建时直接创建一个结束状态的任务实例
+// 只修改状态和节点上下文, 后续的节点在下次RunWorkflow时继续执行
+func (s *WorkflowServiceImpl) operateWorkflowNode(ctx context.Context, params *OperateWorkflowNodeParams, action string) error {
+	if err := validatorUtil.Struct(params); err != nil {
+		return errors.Wrapf(ErrWorkflowParamInvalid, "operateWorkflowNode failed, action: %s, params: %v,err: %v", action, params, err)
+	}
+	for key := range params.Output {
+		if slices.Contains([]string{NodeContextKeySystem, NodeContextKeyPreNodeContext, NodeContextKeyWorkflowContext}, key) {
+			return errors.Wrapf(ErrWorkflowParamInvalid, "operateWorkflowNode failed, output key %q is reserved", key)
+		}
+	}
+	status := WorkflowTaskNodeStatusCompleted
+	if action == operateWorkflowNodeActionSkip {
+		status = WorkflowTaskNodeStatusSkipped
+	}
+	err := s.executeLock.NonBlockingSynchronized(ctx,
+		workflowOpLockKey(params.WorkflowInstanceID),
+		10*time.Minute,
+		func(ctx context.Context) error {
+			workflowInstancePo, err := s.queryWorkflowInstanceByID(ctx, params.WorkflowInstanceID)
+			if err != nil {
+				return err
+			}
+			if IsOverWorkflowInstanceStatus(workflowInstancePo.Status) {
+				return errors.WithMessagef(ErrWorkflowParamInvalid, "workflow instance is over, workflowInstanceID: %d, status: %s", params.WorkflowInstanceID, workflowInstancePo.Status)
+			}
+			definition, err := s.getWorkflowDefinitionForInstance(workflowInstancePo)
+			if err != nil {
+				return errors.WithMessagef(err, "GetAndLoadWorkflowDefinition failed, workflowType: %s, version: %d", workflowInstancePo.WorkflowType, workflowInstancePo.WorkflowVersion)
+			}
+			isNodeFound := false
+			for _, node := range definition.Nodes {
+				if node.TaskType == params.TaskType && node.TaskType != rootTaskNode && node.TaskType != endTaskNode {
+					isNodeFound = true
+					break
+				}
+			}
+			if !isNodeFound {
+				return errors.WithMessagef(ErrWorkflowParamInvalid, "WorkflowTaskNode not found, workflowInstanceID: %d, taskType: %s", params.WorkflowInstanceID, params.TaskType)
+			}
+			// 后置节点可以执行了, 调度器需要尽快执行
+			if err := s.wakeUpWorkflowInstance(ctx, params.WorkflowInstanceID); err != nil {
+				return err
+			}
+			// 同一个节点可能有多轮任务实例, 按照id倒序第一个就是最新一轮的
+			taskInstances, err := s.repo.QueryWorkflowTaskInstance(ctx, &QueryWorkflowTaskInstanceParams{
+				WorkflowInstanceID: &params.WorkflowInstanceID,
+				TaskType:           &params.TaskType,
+				IsMapItem:          Bool(false),
+				OrderbyIDAsc:       Bool(false),
+				Page:               &Pager{Page: 1, Size: 1},
+			})
+			if err != nil {
+				return errors.WithMessagef(err, "QueryWorkflowTaskInstance failed, workflowInstanceID: %d, taskType: %s", params.WorkflowInstanceID, params.TaskType)
+			}
+			now := time.Now().Unix()
+			buildNodeContext := func(nodeContext *JSONContext) *JSONContext {
+				for key, value := range params.Output {
+					nodeContext.Set([]string{key}, value)
+				}
+				nodeContext.Set([]string{NodeContextKeySystem, "operation"}, map[string]any{
+					"action":      action,
+					"operator":    params.Operator,
+					"reason":      params.Reason,
+					"operated_at": now,
+				})
+				return nodeContext
+			}
+			completedAt := int64(0)
+			if status == WorkflowTaskNodeStatusCompleted {
+				completedAt = now
+			}
+			if len(taskInstances) == 0 {
+				// 任务实例还没有创建, 前置节点可能还没有完成, 上下文中只有工作流上下文
+				workflowInstance := &WorkflowInstance{WorkflowContext: NewByte2StrctPbValue(workflowInstancePo.WorkflowContext)}
+				_, err = s.repo.CreateWorkflowTaskInstance(ctx, &WorkflowTaskInstancePo{
+					WorkflowInstanceID: params.WorkflowInstanceID,
+					TaskType:           params.TaskType,
+					Status:             status,
+					NodeContext:        buildNodeContext(buildTaskNodeContext(workflowInstance, nil)).ToBytesWithoutError(),
+					CompletedAt:        completedAt,
+					CreatedAt:          now,
+					UpdatedAt:          now,
+				})
+				if err != nil {
+					return errors.WithMessagef(err, "CreateWorkflowTaskInstance failed, workflowInstanceID: %d, taskType: %s", params.WorkflowInstanceID, params.TaskType)
+				}
+				return nil
+			}
+			taskInstance := taskInstances[0]
+			if IsOverWorkflowTaskNodeStatus(taskInstance.Status) {
+				return errors.WithMessagef(ErrWorkflowParamInvalid, "WorkflowTaskInstance is over, workflowInstanceID: %d, taskType: %s, status: %s", params.WorkflowInstanceID, params.TaskType, taskInstance.Status)
+			}
+			return s.repo.Transaction(ctx, func(ctx context.Context) error {
+				err := s.repo.UpdateWorkflowTaskInstance(ctx, &UpdateWorkflowTaskInstanceParams{
+					Where: &UpdateWorkflowTaskInstanceWhere{
+						IDIn: []int64{taskInstance.ID},
+					},
+					Fields: &UpdateWorkflowTaskInstanceField{
+						Status:      &status,
+						NodeContext: buildNodeContext(NewByte2StrctPbValue(taskInstance.NodeContext)),
+						CompletedAt: &completedAt,
+						NextRetryAt: Int64(0),
+						WakeUpAt:    Int64(0),
+					},
+					LimitMax: 1,
+				})
+				if err != nil {
+					return errors.WithMessagef(err, "UpdateWorkflowTaskInstance failed, workflowInstanceID: %d, taskType: %s", params.WorkflowInstanceID, params.TaskType)
+				}
+				// map节点的子任务不会再被执行, 未完成的一起标记为跳过
+				items, err := s.repo.QueryWorkflowTaskInstance(ctx, &QueryWorkflowTaskInstanceParams{
+					WorkflowInstanceID: &params.WorkflowInstanceID,
+					TaskType:           &params.TaskType,
+					IsMapItem:          Bool(true),
+					Generation:         &taskInstance.Generation,
+					Page:               &Pager{IsNoLimit: Bool(true)},
+				})
+				if err != nil {
+					return errors.WithMessagef(err, "QueryWorkflowTaskInstance failed, workflowInstanceID: %d, taskType: %s", params.WorkflowInstanceID, params.TaskType)
+				}
+				skipItemIDs := make([]int64, 0)
+				for _, item := range items {
+					if !IsOverWorkflowTaskNodeStatus(item.Status) {
+						skipItemIDs = append(skipItemIDs, item.ID)
+					}
+				}
+				if len(skipItemIDs) == 0 {
+					return nil
+				}
+				return s.repo.UpdateWorkflowTaskInstance(ctx, &UpdateWorkflowTaskInstanceParams{
+					Where: &UpdateWorkflowTaskInstanceWhere{
+						IDIn: skipItemIDs,
+					},
+					Fields: &UpdateWorkflowTaskInstanceField{
+						Status: String(WorkflowTaskNodeStatusSkipped),
+					},
+					LimitMax: len(skipItemIDs),
+				})
+			})
+		})
+	if err != nil {
+		return errors.WithMessagef(err, "operateWorkflowNode failed, action: %s, workflowInstanceID: %d, taskType: %s", action, params.WorkflowInstanceID, params.TaskType)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+package workflow
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func (s *WorkflowServiceImpl) PauseWorkflowInstance(ctx context.Context, workflowInstanceID int64) error {
+	if workflowInstanceID <= 0 {
+		return errors.Wrapf(ErrWorkflowParamInvalid, "PauseWorkflowInstance failed, workflowInstanceID: %d", workflowInstanceID)
+	}
+	return s.executeLock.NonBlockingSynchronized(ctx,
+		workflowOpLockKey(workflowInstanceID),
+		10*time.Minute,
+		func(ctx context.Context) error {
+			workflowInstance, err := s.queryWorkflowInstanceByID(ctx, workflowInstanceID)
+			if err != nil {
+				return err
+			}
+			if workflowInstance.Status == WorkflowInstanceStatusPaused {
+				// 已经暂停过, 上次可能在级联暂停子工作流时失败, 重新执行级联暂停
+				return s.pauseSubWorkflowInstances(ctx, workflowInstanceID)
+			}
+			if IsOverWorkflowInstanceStatus(workflowInstance.Status) || workflowInstance.Status == WorkflowInstanceStatusQueued {
+				return errors.WithMessagef(ErrWorkflowParamInvalid, "workflow instance is over or queued, can not pause, workflowInstanceID: %d, status: %s", workflowInstanceID, workflowInstance.Status)
+			}
+			err = s.repo.UpdateWorkflowInstance(ctx, &UpdateWorkflowInstanceParams{
+				Where: &UpdateWorkflowInstanceWhere{
+					IDIn:     []int64{workflowInstanceID},
+					StatusIn: []string{workflowInstance.Status},
+				},
+				Fields: &UpdateWorkflowInstanceField{
+					Status: String(WorkflowInstanceStatusPaused),
+				},
+				LimitMax: 1,
+			})
+			if err != nil {
+				return errors.WithMessagef(err, "UpdateWorkflowInstance failed, workflowInstanceID: %d", workflowInstanceID)
+			}
+			return s.pauseSubWorkflowInstances(ctx, workflowInstanceID)
+		})
+}
+
+func (s *WorkflowServiceImpl) ResumeWorkflowInstance(ctx context.Context, workflowInstanceID int64) error {
+	if workflowInstanceID <= 0 {
+		return errors.Wrapf(ErrWorkflowParamInvalid, "ResumeWorkflowInstance failed, workflowInstanceID: %d", workflowInstanceID)
+	}
+	return s.executeLock.NonBlockingSynchronized(ctx,
+		workflowOpLockKey(workflowInstanceID),
+		10*time.Minute,
+		func(ctx context.Context) error {
+			workflowInstance, err := s.queryWorkflowInstanceByID(ctx, workflowInstanceID)
+			if err != nil {
+				return err
+			}
+			if workflowInstance.Status == WorkflowInstanceStatusInit || workflowInstance.Status == WorkflowInstanceStatusRunning {
+				// 已经恢复过, 上次可能在级联恢复子工作流时失败, 重新执行级联恢复
+				return s.resumeSubWorkflowInstances(ctx, workflowInstanceID)
+			}
+			if workflowInstance.Status != WorkflowInstanceStatusPaused {
+				return errors.WithMessagef(ErrWorkflowParamInvalid, "workflow instance is not paused, workflowInstanceID: %d, status: %s", workflowInstanceID, workflowInstance.Status)
+			}
+			// 暂停前的状态没有保存, 根节点已经创建说明已经开始运行过
+			resumeStatus := WorkflowInstanceStatusInit
+			rootTaskInstances, err := s.repo.QueryWorkflowTaskInstance(ctx, &QueryWorkflowTaskInstanceParams{
+				WorkflowInstanceID: &workflowInstanceID,
+				TaskType:           String(rootTaskNode),
+				Page:               &Pager{Page: 1, Size: 1},
+			})
+			if err != nil {
+				return errors.WithMessagef(err, "QueryWorkflowTaskInstance failed, workflowInstanceID: %d", workflowInstanceID)
+			}
+			if len(rootTaskInstances) > 0 {
+				resumeStatus = WorkflowInstanceStatusRunning
+			}
+			err = s.repo.UpdateWorkflowInstance(ctx, &UpdateWorkflowInstanceParams{
+				Where: &UpdateWorkflowInstanceWhere{
+					IDIn:     []int64{workflowInstanceID},
+					StatusIn: []string{WorkflowInstanceStatusPaused},
+				},
+				Fields: &UpdateWorkflowInstanceField{
+					Status:       &resumeStatus,
+					NextWakeUpAt: Int64(0),
+				},
+				LimitMax: 1,
+			})
+			if err != nil {
+				return errors.WithMessagef(err, "UpdateWorkflowInstance failed, workflowInstanceID: %d", workflowInstanceID)
+			}
+			return s.resumeSubWorkflowInstances(ctx, workflowInstanceID)
+		})
+}
+
+// pauseSubWorkflowInstances 暂停所有运行中的子工作流实例
+func (s *WorkflowServiceImpl) pauseSubWorkflowInstances(ctx context.Context, parentWorkflowInstanceID int64) error {
+	children, err := s.repo.QueryWorkflowInstance(ctx, &QueryWorkflowInstanceParams{
+		ParentWorkflowInstanceID: &parentWorkflowInstanceID,
+		StatusIn:                 []string{WorkflowInstanceStatusInit, WorkflowInstanceStatusRunning},
+		Page:                     &Pager{IsNoLimit: Bool(true)},
+	})
+	if err != nil {
+		return errors.WithMessagef(err, "QueryWorkflowInstance failed, parentWorkflowInstanceID: %d", parentWorkflowInstanceID)
+	}
+	for _, child := range children {
+		if err := s.PauseWorkflowInstance(ctx, child.ID); err != nil {
+			return errors.WithMessagef(err, "PauseWorkflowInstance sub workflow failed, workflowInstanceID: %d", child.ID)
+		}
+	}
+	return nil
+}
+
+// resumeSubWorkflowInstances 恢复所有暂停的子工作流实例
+func (s *WorkflowServiceImpl) resumeSubWorkflowInstances(ctx context.Context, parentWorkflowInstanceID int64) error {
+	children, err := s.repo.QueryWorkflowInstance(ctx, &QueryWorkflowInstanceParams{
+		ParentWorkflowInstanceID: &parentWorkflowInstanceID,
+		StatusIn:                 []string{WorkflowInstanceStatusPaused},
+		Page:                     &Pager{IsNoLimit: Bool(true)},
+	})
+	if err != nil {
+		return errors.WithMessagef(err, "QueryWorkflowInstance failed, parentWorkflowInstanceID: %d", parentWorkflowInstanceID)
+	}
+	for _, child := range children {
+		if err := s.ResumeWorkflowInstance(ctx, child.ID); err != nil {
+			return errors.WithMessagef(err, "ResumeWorkflowInstance sub workflow failed, workflowInstanceID: %d", child.ID)
+		}
+	}
+	return nil
+}
+
+func (s *WorkflowServiceImpl) queryWorkflowInstanceByID(ctx context.Context, workflowInstanceID int64) (*WorkflowInstancePo, error) {
+	workflowInstances, err := s.repo.QueryWorkflowInstance(ctx, &QueryWorkflowInstanceParams{
+		WorkflowInstanceID: &workflowInstanceID,
+		Page:               &Pager{Page: 1, Size: 1},
+	})
+	if err != nil {
+		return nil, errors.WithMessagef(err, "QueryWorkflowInstance failed, workflowInstanceID: %d", workflowInstanceID)
+	}
+	if len(workflowInstances) == 0 {
+		return nil, errors.WithMessagef(ErrWorkflowInstanceNotFound, "WorkflowInstance not found, workflowInstanceID: %d", workflowInstanceID)
+	}
+	return workflowInstances[0], nil
+}
@@ -0,0 +1,36 @@
+package workflow
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Registry 保存工作流配置、工作器和构建好的工作流定义
+// 不同的Registry之间相互隔离, 同一个工作流类型可以在不同的Registry中分别加载和注册, 例如按租户隔离多个引擎
+// 包级别的LoadWorkflowConfig、RegisterWorkflowTask等函数使用默认的Registry
+type Registry struct {
+	taskWorkers    sync.Map   // key为workflowType_taskKey, value为WorkflowTaskNodeWorker
+	configs        sync.Map   // key为workflowVersionKey, value为*WorkflowConfig
+	definitions    sync.Map   // key为workflowVersionKey, value为*WorkflowDefinition
+	latestVersions sync.Map   // 每个工作流类型已经加载的最大版本号, key为workflowType, value为int64
+	loadLock       sync.Mutex // 加载配置和构建工作流定义时使用
+	// 是否开启严格校验, 参考SetStrictWorkflowConfigValidation
+	strictConfigValidation atomic.Bool
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+var defaultRegistry = NewRegistry()
+
+// DefaultRegistry 包级别函数使用的Registry, NewWorkflowService没有指定Registry时也使用它
+func DefaultRegistry() *Registry {
+	return defaultRegistry
+}
+
+// isWorkflowConfigLoaded 工作流类型的指定版本是否已经加载过配置
+func (r *Registry) isWorkflowConfigLoaded(workflowType string, version int64) bool {
+	_, ok := r.configs.Load(workflowVersionKey(workflowType, version))
+	return ok
+}
@@ -0,0 +1,122 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultAsyncRunWorkerCount     = 4               // 后台同时执行的工作流数量
+	defaultAsyncRunLockRetryCount  = 3               // 拿不到锁时的重试次数, 锁可能被调度器短暂持有
+	defaultAsyncRunLockRetryPeriod = 1 * time.Second // 拿不到锁时的重试间隔
+)
+
+// WorkflowRunHandle 异步执行工作流的句柄, 可以阻塞等待执行结果, 也可以轮询执行状态
+type WorkflowRunHandle struct {
+	WorkflowInstanceID int64
+
+	done chan struct{}
+	err  error
+}
+
+func newWorkflowRunHandle(workflowInstanceID int64) *WorkflowRunHandle {
+	return &WorkflowRunHandle{
+		WorkflowInstanceID: workflowInstanceID,
+		done:               make(chan struct{}),
+	}
+}
+
+// Done 执行结束后关闭, 可以和其他channel一起select
+func (h *WorkflowRunHandle) Done() <-chan struct{} {
+	return h.done
+}
+
+// IsDone 是否已经执行结束, 轮询时使用
+func (h *WorkflowRunHandle) IsDone() bool {
+	select {
+	case <-h.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Err 执行结果, 还没有执行结束时返回nil
+func (h *WorkflowRunHandle) Err() error {
+	if !h.IsDone() {
+		return nil
+	}
+	return h.err
+}
+
+// Wait 阻塞等待执行结束并返回执行结果, ctx被取消时返回ctx的错误, 不影响后台的执行
+func (h *WorkflowRunHandle) Wait(ctx context.Context) error {
+	select {
+	case <-h.done:
+		return h.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (h *WorkflowRunHandle) finish(err error) {
+	h.err = err
+	close(h.done)
+}
+
+// asyncRunExecutor 后台执行工作流, 最多同时执行workerCount个, 超出的排队等待
+type asyncRunExecutor struct {
+	sem chan struct{}
+}
+
+func newAsyncRunExecutor(workerCount int) *asyncRunExecutor {
+	if workerCount <= 0 {
+		workerCount = defaultAsyncRunWorkerCount
+	}
+	return &asyncRunExecutor{sem: make(chan struct{}, workerCount)}
+}
+
+// submit 提交到后台执行, 立即返回句柄
+func (e *asyncRunExecutor) submit(workflowInstanceID int64, f func() error) *WorkflowRunHandle {
+	handle := newWorkflowRunHandle(workflowInstanceID)
+	go func() {
+		e.sem <- struct{}{}
+		defer func() { <-e.sem }()
+		var err error
+		defer func() {
+			if r := recover(); r != nil {
+				err = errors.Errorf("async run panic, workflowInstanceID: %d, panic: %v", workflowInstanceID, r)
+			}
+			handle.finish(err)
+		}()
+		err = f()
+	}()
+	return handle
+}
+
+// runWorkflowAsync 后台执行RunWorkflow, 调用方的ctx结束不影响后台执行
+// 锁被其他进程(比如调度器)持有时, 等待一段时间后重试
+func (s *WorkflowServiceImpl) runWorkflowAsync(ctx context.Context, workflowInstanceID int64) *WorkflowRunHandle {
+	runCtx := context.WithoutCancel(ctx)
+	return s.asyncExecutor.submit(workflowInstanceID, func() error {
+		var err error
+		for i := 0; i <= defaultAsyncRunLockRetryCount; i++ {
+			if i > 0 {
+				time.Sleep(defaultAsyncRunLockRetryPeriod)
+			}
+			err = s.RunWorkflow(runCtx, workflowInstanceID)
+			if !errors.Is(err, LockFailedError) {
+				break
+			}
+			slog.WarnContext(runCtx, fmt.Sprintf("async RunWorkflow lock failed, workflowInstanceID: %d, retry: %d", workflowInstanceID, i))
+		}
+		if err != nil {
+			return errors.WithMessagef(err, "async RunWorkflow failed, workflowInstanceID: %d", workflowInstanceID)
+		}
+		return nil
+	})
+}
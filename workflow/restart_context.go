@@ -0,0 +1,96 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// 重启时修改工作流上下文的方式, 记录在WorkflowContextRevision.Mode中
+const (
+	WorkflowContextChangeModeReplace    = "replace"     // 整体替换
+	WorkflowContextChangeModeMergePatch = "merge_patch" // 按照JSON Merge Patch(RFC 7386)合并
+)
+
+// WorkflowContextRevision 工作流上下文的修改记录, 保留修改前的值用于审计
+type WorkflowContextRevision struct {
+	Mode            string         `json:"mode"`             // 修改方式, replace或者merge_patch
+	PreviousContext map[string]any `json:"previous_context"` // 修改前的工作流上下文
+	Change          map[string]any `json:"change"`           // 替换的上下文或者合并的patch
+	ChangedAt       int64          `json:"changed_at"`       // 修改时间,单位秒
+}
+
+// nodeContextKeyRestartCount 节点重启的次数, 保存在节点上下文的system中, 重新初始化节点上下文时加1
+// 节点的工作器可以用它区分同一个任务实例的不同次执行, 例如子工作流节点每次重启都创建新的子工作流
+const nodeContextKeyRestartCount = "restart_count"
+
+// checkRestartWorkflowContext 整体替换和合并只能二选一
+func checkRestartWorkflowContext(replaceContext map[string]any, contextPatch map[string]any) error {
+	if replaceContext != nil && contextPatch != nil {
+		return errors.WithMessage(ErrWorkflowParamInvalid, "Context and ContextPatch can not be set at the same time")
+	}
+	return nil
+}
+
+// parseWorkflowContextHistory 解析工作流上下文的修改记录, 按照修改时间正序
+func parseWorkflowContextHistory(b []byte) []*WorkflowContextRevision {
+	history := make([]*WorkflowContextRevision, 0)
+	if len(b) > 0 {
+		json.Unmarshal(b, &history)
+	}
+	return history
+}
+
+// updateRestartWorkflowContext 重启时修改工作流上下文, 修改前的值追加到修改记录中
+// 重启的节点会按照restarting状态重新初始化节点上下文, 新的工作流上下文会写入节点的workflow_context
+// 没有重启的节点保留原来的节点上下文
+func (s *WorkflowServiceImpl) updateRestartWorkflowContext(ctx context.Context, workflowInstance *WorkflowInstancePo,
+	replaceContext map[string]any, contextPatch map[string]any) error {
+	if replaceContext == nil && contextPatch == nil {
+		return nil
+	}
+	previousContext := NewByte2StrctPbValue(workflowInstance.WorkflowContext)
+	revision := &WorkflowContextRevision{
+		PreviousContext: previousContext.Clone().ToMap(),
+		ChangedAt:       time.Now().Unix(),
+	}
+	var workflowContext *JSONContext
+	if replaceContext != nil {
+		revision.Mode = WorkflowContextChangeModeReplace
+		revision.Change = replaceContext
+		workflowContext = NewJSONContext(nil)
+		if err := workflowContext.MergePatch(replaceContext); err != nil {
+			return errors.Wrapf(ErrWorkflowParamInvalid, "invalid Context, workflowInstanceID: %d, err: %v", workflowInstance.ID, err)
+		}
+	} else {
+		revision.Mode = WorkflowContextChangeModeMergePatch
+		revision.Change = contextPatch
+		workflowContext = previousContext
+		if err := workflowContext.MergePatch(contextPatch); err != nil {
+			return errors.Wrapf(ErrWorkflowParamInvalid, "invalid ContextPatch, workflowInstanceID: %d, err: %v", workflowInstance.ID, err)
+		}
+	}
+	history := append(parseWorkflowContextHistory(workflowInstance.WorkflowContextHistory), revision)
+	historyBytes, err := json.Marshal(history)
+	if err != nil {
+		return errors.WithMessagef(err, "Marshal WorkflowContextHistory failed, workflowInstanceID: %d", workflowInstance.ID)
+	}
+	err = s.repo.UpdateWorkflowInstance(ctx, &UpdateWorkflowInstanceParams{
+		Where: &UpdateWorkflowInstanceWhere{
+			IDIn: []int64{workflowInstance.ID},
+		},
+		Fields: &UpdateWorkflowInstanceField{
+			WorkflowContext:        workflowContext,
+			WorkflowContextHistory: historyBytes,
+		},
+		LimitMax: 1,
+	})
+	if err != nil {
+		return errors.WithMessagef(err, "UpdateWorkflowInstance failed, workflowInstanceID: %d", workflowInstance.ID)
+	}
+	workflowInstance.WorkflowContext = workflowContext.ToBytesWithoutError()
+	workflowInstance.WorkflowContextHistory = historyBytes
+	return nil
+}
@@ -0,0 +1,67 @@
+package workflow
+
+import (
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type RetryPolicyType = string
+
+const (
+	// 固定间隔重试
+	RetryPolicyTypeFixed RetryPolicyType = "fixed"
+	// 指数退避重试, 间隔 = initial_interval * multiplier^(失败次数-1), 不超过max_interval
+	RetryPolicyTypeExponential RetryPolicyType = "exponential"
+
+	defaultRetryPolicyMultiplier = 2.0
+)
+
+// RetryPolicy 节点失败重试策略, 节点失败后在下次重试时间之前不会被执行
+type RetryPolicy struct {
+	Type            RetryPolicyType `json:"type"`             // 重试策略类型, fixed/exponential
+	InitialInterval int64           `json:"initial_interval"` // 第一次重试间隔，单位秒
+	MaxInterval     int64           `json:"max_interval"`     // 最大重试间隔，单位秒，<=0 不限制
+	Multiplier      float64         `json:"multiplier"`       // 指数退避的倍数, <=1 使用默认值2
+	Jitter          float64         `json:"jitter"`           // 随机抖动比例, 取值[0,1), 实际间隔在 [interval*(1-jitter), interval] 之间
+}
+
+func (p *RetryPolicy) check() error {
+	if p == nil {
+		return nil
+	}
+	if p.Type != RetryPolicyTypeFixed && p.Type != RetryPolicyTypeExponential {
+		return errors.Errorf("retry policy type %q is invalid", p.Type)
+	}
+	if p.InitialInterval <= 0 {
+		return errors.Errorf("retry policy initial_interval must be greater than 0, got: %d", p.InitialInterval)
+	}
+	if p.Jitter < 0 || p.Jitter >= 1 {
+		return errors.Errorf("retry policy jitter must be in [0,1), got: %v", p.Jitter)
+	}
+	return nil
+}
+
+// NextInterval 根据失败次数计算下次重试的间隔, failCount从1开始
+func (p *RetryPolicy) NextInterval(failCount int64) time.Duration {
+	if p == nil || p.InitialInterval <= 0 {
+		return 0
+	}
+	interval := float64(p.InitialInterval)
+	if p.Type == RetryPolicyTypeExponential && failCount > 1 {
+		multiplier := p.Multiplier
+		if multiplier <= 1 {
+			multiplier = defaultRetryPolicyMultiplier
+		}
+		interval = interval * math.Pow(multiplier, float64(failCount-1))
+	}
+	if p.MaxInterval > 0 && interval > float64(p.MaxInterval) {
+		interval = float64(p.MaxInterval)
+	}
+	if p.Jitter > 0 {
+		interval = interval * (1 - p.Jitter*rand.Float64())
+	}
+	return time.Duration(interval * float64(time.Second))
+}
@@ -0,0 +1,52 @@
+package workflow
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryPolicy_NextInterval(t *testing.T) {
+	fixed := &RetryPolicy{Type: RetryPolicyTypeFixed, InitialInterval: 10}
+	for _, failCount := range []int64{1, 2, 5} {
+		if got := fixed.NextInterval(failCount); got != 10*time.Second {
+			t.Errorf("fixed failCount=%d, expected 10s, got %v", failCount, got)
+		}
+	}
+
+	exponential := &RetryPolicy{Type: RetryPolicyTypeExponential, InitialInterval: 1, MaxInterval: 30}
+	expected := map[int64]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 5: 16 * time.Second, 6: 30 * time.Second}
+	for failCount, want := range expected {
+		if got := exponential.NextInterval(failCount); got != want {
+			t.Errorf("exponential failCount=%d, expected %v, got %v", failCount, want, got)
+		}
+	}
+
+	jitter := &RetryPolicy{Type: RetryPolicyTypeExponential, InitialInterval: 10, Multiplier: 3, Jitter: 0.5}
+	for i := 0; i < 100; i++ {
+		got := jitter.NextInterval(2)
+		if got < 15*time.Second || got > 30*time.Second {
+			t.Fatalf("jitter interval out of range, got %v", got)
+		}
+	}
+
+	var nilPolicy *RetryPolicy
+	if got := nilPolicy.NextInterval(3); got != 0 {
+		t.Errorf("nil policy expected 0, got %v", got)
+	}
+}
+
+func TestRetryPolicy_Check(t *testing.T) {
+	invalidPolicies := []*RetryPolicy{
+		{Type: "linear", InitialInterval: 1},
+		{Type: RetryPolicyTypeFixed, InitialInterval: 0},
+		{Type: RetryPolicyTypeExponential, InitialInterval: 1, Jitter: 1},
+	}
+	for _, policy := range invalidPolicies {
+		if err := policy.check(); err == nil {
+			t.Errorf("expected error for policy %+v", policy)
+		}
+	}
+	if err := (&RetryPolicy{Type: RetryPolicyTypeExponential, InitialInterval: 1, Jitter: 0.2}).check(); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -13,4 +13,6 @@ type WorkflowRepo interface {
 	UpdateWorkflowInstance(ctx context.Context, param *UpdateWorkflowInstanceParams) error
 	UpdateWorkflowTaskInstance(ctx context.Context, param *UpdateWorkflowTaskInstanceParams) error
 	Transaction(ctx context.Context, fn func(ctx context.Context) error) error
+	CreateWorkflowDefinition(ctx context.Context, workflowDefinition *WorkflowDefinitionPo) (*WorkflowDefinitionPo, error)
+	QueryWorkflowDefinition(ctx context.Context, param *QueryWorkflowDefinitionParams) ([]*WorkflowDefinitionPo, error)
 }
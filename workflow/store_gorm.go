@@ -10,7 +10,7 @@ import (
 )
 
 type WorkflowInstancePo struct {
-	ID              int64                  `gorm:"column:id;primaryKey;autoIncrement" json:"id"	`
+	ID              int64                  `gorm:"column:id;primaryKey;autoIncrement" json:"id"`
 	WorkflowType    string                 `gorm:"column:workflow_type" json:"workflow_type"`
 	BusinessID      string                 `gorm:"column:business_id" json:"business_id"`
 	Status          WorkflowInstanceStatus `gorm:"column:status" json:"status"`
@@ -18,6 +18,18 @@ type WorkflowInstancePo struct {
 	TaskId          int64                  `gorm:"column:task_id" json:"task_id"`
 	CreatedAt       int64                  `gorm:"column:created_at" json:"created_at"`
 	UpdatedAt       int64                  `gorm:"column:updated_at" json:"updated_at"`
+	// 父工作流实例ID, 由子工作流节点创建的实例才有值, 0表示没有父工作流
+	ParentWorkflowInstanceID int64 `gorm:"column:parent_workflow_instance_id;index" json:"parent_workflow_instance_id"`
+	// 截止时间, 单位秒, 0表示没有截止时间, 超过截止时间还没有结束的实例会被标记为timed_out
+	DeadlineAt int64 `gorm:"column:deadline_at;index" json:"deadline_at"`
+	// 工作流上下文的修改记录, JSON数组, 重启时修改上下文会追加一条记录, 保留修改前的值用于审计
+	WorkflowContextHistory []byte `gorm:"column:workflow_context_history" json:"workflow_context_history"`
+	// 幂等键, 格式为"工作流类型:业务幂等键", nil表示创建时没有指定幂等策略, 唯一索引保证多个进程同时创建时只有一个成功
+	IdempotencyKey *string `gorm:"column:idempotency_key;size:255;uniqueIndex" json:"idempotency_key"`
+	// 工作流配置的版本号, 创建时固定, 执行时一直使用该版本的定义, 0表示创建时没有找到工作流定义, 执行时使用最新版本
+	WorkflowVersion int64 `gorm:"column:workflow_version" json:"workflow_version"`
+	// 下次需要执行的时间, 单位秒, 0表示需要尽快执行, 未结束的节点都在等待重试或者延时唤醒时为其中最早的时间, 调度器跳过还没有到时间的实例
+	NextWakeUpAt int64 `gorm:"column:next_wake_up_at;index" json:"next_wake_up_at"`
 }
 
 func (WorkflowInstancePo) TableName() string {
@@ -30,15 +42,37 @@ type WorkflowTaskInstancePo struct {
 	TaskType           string                 `gorm:"column:task_type"`
 	Status             WorkflowTaskNodeStatus `gorm:"column:status"`
 	FailCount          int64                  `gorm:"column:fail_count"`
-	NodeContext        []byte                 `gorm:"column:node_context"` // 节点上下文, input,output结合在一起
+	NodeContext        []byte                 `gorm:"column:node_context"`  // 节点上下文, input,output结合在一起
+	NextRetryAt        int64                  `gorm:"column:next_retry_at"` // 下次重试时间,单位秒,0表示不需要等待
+	WakeUpAt           int64                  `gorm:"column:wake_up_at"`    // 延时节点的唤醒时间,单位秒,0表示不需要等待
+	ItemIndex          *int64                 `gorm:"column:item_index"`    // map节点子任务对应的元素下标,从0开始,nil表示不是子任务
+	Generation         int64                  `gorm:"column:generation"`    // 循环的轮次,从0开始,每经过一次循环边加1,旧轮次的任务实例保留作为历史
+	CompletedAt        int64                  `gorm:"column:completed_at"`  // 完成时间,单位秒,补偿时按照完成时间倒序执行
 	CreatedAt          int64                  `gorm:"column:created_at"`
 	UpdatedAt          int64                  `gorm:"column:updated_at"`
+	// 补偿状态, 参考CompensationStatus
+	CompensationStatus CompensationStatus `gorm:"column:compensation_status"`
 }
 
 func (WorkflowTaskInstancePo) TableName() string {
 	return "task_instance"
 }
 
+// WorkflowDefinitionPo 发布的工作流配置, 每个版本一条记录, 发布后不再修改
+type WorkflowDefinitionPo struct {
+	ID           int64  `gorm:"column:id;primaryKey;autoIncrement" json:"id"`
+	WorkflowType string `gorm:"column:workflow_type;size:255;uniqueIndex:uk_workflow_type_version" json:"workflow_type"`
+	Version      int64  `gorm:"column:version;uniqueIndex:uk_workflow_type_version" json:"version"`
+	Name         string `gorm:"column:name" json:"name"`
+	Config       []byte `gorm:"column:config" json:"config"` // WorkflowConfig的JSON
+	CreatedAt    int64  `gorm:"column:created_at" json:"created_at"`
+	UpdatedAt    int64  `gorm:"column:updated_at" json:"updated_at"`
+}
+
+func (WorkflowDefinitionPo) TableName() string {
+	return "workflow_definition"
+}
+
 type QueryWorkflowInstanceParams struct {
 	WorkflowInstanceID *int64   `json:"workflow_instance_id"`
 	WorkflowTypeIn     []string `json:"workflow_type_in"`
@@ -48,6 +82,22 @@ type QueryWorkflowInstanceParams struct {
 	TaskID             *int64   `json:"task_id"`
 	OrderbyIDAsc       *bool    `json:"orderby_id_asc"`
 	Page               *Pager   `json:"page"`
+	// 父工作流实例ID, 用于查询子工作流实例
+	ParentWorkflowInstanceID *int64 `json:"parent_workflow_instance_id"`
+	// 截止时间早于等于该时间的实例, 不包括没有截止时间的实例, 用于查询已经超时的实例
+	DeadlineAtBefore *int64 `json:"deadline_at_before"`
+	// 幂等键, 格式为"工作流类型:业务幂等键"
+	IdempotencyKey *string `json:"idempotency_key"`
+	// 下次需要执行的时间早于等于该时间的实例, 包括需要尽快执行的实例, 调度器用来跳过还没有到时间的实例
+	NextWakeUpAtBefore *int64 `json:"next_wake_up_at_before"`
+}
+
+type QueryWorkflowDefinitionParams struct {
+	WorkflowType *string `json:"workflow_type"`
+	Version      *int64  `json:"version"`
+	// 按照版本号排序, nil表示不排序, false表示倒序, 第一条就是最新版本
+	OrderbyVersionAsc *bool  `json:"orderby_version_asc"`
+	Page              *Pager `json:"page"`
 }
 
 type Pager struct {
@@ -64,6 +114,10 @@ type QueryWorkflowTaskInstanceParams struct {
 	IDGreaterThan          *int64   `json:"id_greater_than"`
 	OrderbyIDAsc           *bool    `json:"orderby_id_asc"`
 	Page                   *Pager   `json:"page"`
+	// 是否是map节点的子任务, nil表示不过滤
+	IsMapItem *bool `json:"is_map_item"`
+	// 循环的轮次, nil表示不过滤
+	Generation *int64 `json:"generation"`
 }
 
 type UpdateWorkflowInstanceParams struct {
@@ -80,6 +134,14 @@ type UpdateWorkflowInstanceWhere struct {
 type UpdateWorkflowInstanceField struct {
 	Status          *string      `json:"status"`
 	WorkflowContext *JSONContext `json:"workflow_context"`
+	// 工作流上下文的修改记录, 整体覆盖, JSON数组
+	WorkflowContextHistory []byte `json:"workflow_context_history"`
+	// 幂等键, 空字符串表示清空, 已经结束的实例清空后可以用相同的幂等键创建新的实例
+	IdempotencyKey *string `json:"idempotency_key"`
+	// 工作流配置的版本号, 迁移实例到新版本或者第一次执行固定版本时使用
+	WorkflowVersion *int64 `json:"workflow_version"`
+	// 下次需要执行的时间, 0表示需要尽快执行
+	NextWakeUpAt *int64 `json:"next_wake_up_at"`
 }
 
 type UpdateWorkflowTaskInstanceParams struct {
@@ -96,6 +158,13 @@ type UpdateWorkflowTaskInstanceField struct {
 	Status      *string      `json:"status"`
 	NodeContext *JSONContext `json:"node_context"`
 	FailCount   *int64       `json:"fail_count"`
+	NextRetryAt *int64       `json:"next_retry_at"`
+	WakeUpAt    *int64       `json:"wake_up_at"`
+	CompletedAt *int64       `json:"completed_at"`
+	// 补偿状态
+	CompensationStatus *string `json:"compensation_status"`
+	// 节点类型, 迁移实例到新版本时节点改名使用
+	TaskType *string `json:"task_type"`
 }
 
 type workflowRepo struct {
@@ -120,6 +189,55 @@ func (r *workflowRepo) CreateWorkflowInstance(ctx context.Context, workflowInsta
 	return workflowInstance, nil
 }
 
+func (r *workflowRepo) CreateWorkflowDefinition(ctx context.Context, workflowDefinition *WorkflowDefinitionPo) (*WorkflowDefinitionPo, error) {
+	if workflowDefinition == nil {
+		return nil, errors.New("nil WorkflowDefinitionPo")
+	}
+	workflowDefinition.CreatedAt = time.Now().Unix()
+	workflowDefinition.UpdatedAt = time.Now().Unix()
+	if err := r.GetDBWithContext(ctx).Create(workflowDefinition).Error; err != nil {
+		return nil, errors.WithMessage(err, "CreateWorkflowDefinition failed")
+	}
+	return workflowDefinition, nil
+}
+
+func (r *workflowRepo) QueryWorkflowDefinition(ctx context.Context, param *QueryWorkflowDefinitionParams) ([]*WorkflowDefinitionPo, error) {
+	if param == nil {
+		return nil, errors.New("nil QueryWorkflowDefinitionParams")
+	}
+	db := r.GetDBWithContext(ctx).Model(&WorkflowDefinitionPo{})
+	if param.WorkflowType != nil {
+		db = db.Where("workflow_type = ?", param.WorkflowType)
+	}
+	if param.Version != nil {
+		db = db.Where("version = ?", param.Version)
+	}
+	if param.OrderbyVersionAsc != nil {
+		if *param.OrderbyVersionAsc {
+			db = db.Order("workflow_type asc, version asc")
+		} else {
+			db = db.Order("workflow_type asc, version desc")
+		}
+	}
+	if param.Page == nil {
+		return nil, errors.New("page is nil")
+	}
+	if param.Page.IsNoLimit == nil || !*param.Page.IsNoLimit {
+		if param.Page.Page == 0 {
+			param.Page.Page = 1
+		}
+		if param.Page.Size == 0 {
+			param.Page.Size = 10
+		}
+		db = db.Offset(int(param.Page.Page-1) * int(param.Page.Size)).Limit(int(param.Page.Size))
+	}
+	pos := make([]*WorkflowDefinitionPo, 0)
+	if err := db.Find(&pos).Error; err != nil {
+		return nil, errors.WithMessage(err, "QueryWorkflowDefinition failed")
+	}
+	return pos, nil
+}
+
 func (r *workflowRepo) CreateWorkflowTaskInstance(ctx context.Context, workflowTaskInstance *WorkflowTaskInstancePo) (*WorkflowTaskInstancePo, error) {
 	if workflowTaskInstance == nil {
 		return nil, errors.New("nil WorkflowTaskInstancePo")
@@ -154,6 +272,18 @@ func buildQueryWorkflowInstanceParams(db *gorm.DB, isCount bool, param *QueryWor
 	if param.TaskID != nil {
 		db = db.Where("task_id = ?", param.TaskID)
 	}
+	if param.ParentWorkflowInstanceID != nil {
+		db = db.Where("parent_workflow_instance_id = ?", param.ParentWorkflowInstanceID)
+	}
+	if param.DeadlineAtBefore != nil {
+		db = db.Where("deadline_at > 0 AND deadline_at <= ?", param.DeadlineAtBefore)
+	}
+	if param.NextWakeUpAtBefore != nil {
+		db = db.Where("next_wake_up_at <= ?", param.NextWakeUpAtBefore)
+	}
+	if param.IdempotencyKey != nil {
+		db = db.Where("idempotency_key = ?", param.IdempotencyKey)
+	}
 	if param.OrderbyIDAsc != nil && !isCount {
 		// 排序处理
 		if *param.OrderbyIDAsc {
@@ -225,6 +355,16 @@ func buildQueryWorkflowTaskInstanceParams(db *gorm.DB, isCount bool, param *Quer
 	if param.TaskType != nil {
 		db = db.Where("task_type = ?", param.TaskType)
 	}
+	if param.IsMapItem != nil {
+		if *param.IsMapItem {
+			db = db.Where("item_index IS NOT NULL")
+		} else {
+			db = db.Where("item_index IS NULL")
+		}
+	}
+	if param.Generation != nil {
+		db = db.Where("generation = ?", param.Generation)
+	}
 	if len(param.StatusIn) != 0 {
 		db = db.Where("status IN ?", param.StatusIn)
 	}
@@ -303,7 +443,23 @@ func buildUpdateWorkflowInstanceFields(fields *UpdateWorkflowInstanceField) (map
 		if err != nil {
 			return nil, errors.WithMessage(err, "Marshal fields.Context failed")
 		}
-		updateFields["context"] = jsonData
+		updateFields["workflow_context"] = jsonData
+	}
+	if fields.WorkflowContextHistory != nil {
+		updateFields["workflow_context_history"] = fields.WorkflowContextHistory
+	}
+	if fields.IdempotencyKey != nil {
+		if *fields.IdempotencyKey == "" {
+			updateFields["idempotency_key"] = nil
+		} else {
+			updateFields["idempotency_key"] = *fields.IdempotencyKey
+		}
+	}
+	if fields.WorkflowVersion != nil {
+		updateFields["workflow_version"] = *fields.WorkflowVersion
+	}
+	if fields.NextWakeUpAt != nil {
+		updateFields["next_wake_up_at"] = *fields.NextWakeUpAt
 	}
 	if len(updateFields) == 0 {
 		return nil, errors.New("no fields to update")
@@ -363,6 +519,21 @@ func buildUpdateWorkflowTaskInstanceFields(fields *UpdateWorkflowTaskInstanceFie
 	if fields.FailCount != nil {
 		updateFields["fail_count"] = *fields.FailCount
 	}
+	if fields.NextRetryAt != nil {
+		updateFields["next_retry_at"] = *fields.NextRetryAt
+	}
+	if fields.WakeUpAt != nil {
+		updateFields["wake_up_at"] = *fields.WakeUpAt
+	}
+	if fields.CompletedAt != nil {
+		updateFields["completed_at"] = *fields.CompletedAt
+	}
+	if fields.CompensationStatus != nil {
+		updateFields["compensation_status"] = *fields.CompensationStatus
+	}
+	if fields.TaskType != nil {
+		updateFields["task_type"] = *fields.TaskType
+	}
 	if len(updateFields) == 0 {
 		return nil, errors.New("no fields to update")
 	}
@@ -0,0 +1,50 @@
+package workflow
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// nextWakeUpAt 计算实例下次需要执行的时间
+// 未结束的节点都在等待重试或者延时唤醒时返回其中最早的时间, 有截止时间时不晚于截止时间, 否则返回0表示需要尽快执行
+func (st *workflowRunState) nextWakeUpAt(now int64) int64 {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	nextWakeUpAt := int64(0)
+	for _, taskNode := range st.taskNodeMap {
+		if IsOverWorkflowTaskNodeStatus(taskNode.Status) {
+			continue
+		}
+		wakeUpAt := max(taskNode.NextRetryAt, taskNode.WakeUpAt)
+		if wakeUpAt <= now {
+			// 有节点可以执行
+			return 0
+		}
+		if nextWakeUpAt == 0 || wakeUpAt < nextWakeUpAt {
+			nextWakeUpAt = wakeUpAt
+		}
+	}
+	if deadlineAt := st.workflowInstance.DeadlineAt; deadlineAt > 0 && nextWakeUpAt > deadlineAt {
+		// 截止时间到了需要标记超时
+		nextWakeUpAt = deadlineAt
+	}
+	return nextWakeUpAt
+}
+
+// wakeUpWorkflowInstance 清空实例的下次执行时间, 节点被外部修改之后可能不用再等待, 调度器需要尽快执行
+func (s *WorkflowServiceImpl) wakeUpWorkflowInstance(ctx context.Context, workflowInstanceID int64) error {
+	err := s.repo.UpdateWorkflowInstance(ctx, &UpdateWorkflowInstanceParams{
+		Where: &UpdateWorkflowInstanceWhere{
+			IDIn: []int64{workflowInstanceID},
+		},
+		Fields: &UpdateWorkflowInstanceField{
+			NextWakeUpAt: Int64(0),
+		},
+		LimitMax: 1,
+	})
+	if err != nil {
+		return errors.WithMessagef(err, "UpdateWorkflowInstance failed, workflowInstanceID: %d", workflowInstanceID)
+	}
+	return nil
+}
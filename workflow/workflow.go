@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log/slog"
 	"runtime/debug"
+	"slices"
 	"sync"
 	"time"
 
@@ -15,13 +16,7 @@ import (
 // 辅助函数：替代 String 和 Bool
 func String(s string) *string { return &s }
 func Bool(b bool) *bool       { return &b }
-
-var (
-	workflowTaskWorkers = sync.Map{}
-	workflowConfigs     = sync.Map{}
-	workflowDefinitions = sync.Map{}
-	loadWorkflowLock    = sync.Mutex{}
-)
+func Int64(i int64) *int64    { return &i }
 
 // WorkflowTaskNode 工作流任务节点entity
 type WorkflowTaskNode struct {
@@ -33,26 +28,51 @@ type WorkflowTaskNode struct {
 	CreatedAt          int64
 	UpdatedAt          int64
 	FailCount          int64
+	NextRetryAt        int64  // 下次重试时间,单位秒,0表示不需要等待
+	WakeUpAt           int64  // 延时节点的唤醒时间,单位秒,0表示不需要等待
+	ItemIndex          *int64 // map节点子任务对应的元素下标, nil表示不是子任务
+	Generation         int64  // 循环的轮次, 从0开始
 }
 
 // WorkflowDefinition 工作流定义entity
 type WorkflowDefinition struct {
-	ID         string
-	Name       string
-	NodesCount int64
-	RootNode   *WorkflowTaskNodeDefinition
-	Nodes      []*WorkflowTaskNodeDefinition // 节点列表,冗余字段,方便构建节点详情
+	ID             string
+	Name           string
+	Version        int64 // 工作流配置的版本号, 实例创建时固定使用的版本
+	NodesCount     int64
+	MaxParallelism int64 // 单个实例同时执行的最大节点数量,<=1 表示串行执行
+	RootNode       *WorkflowTaskNodeDefinition
+	Nodes          []*WorkflowTaskNodeDefinition // 节点列表,冗余字段,方便构建节点详情
+	// 节点拓扑序, 根节点在最前, 结束节点在最后, 执行引擎按照这个顺序处理节点
+	topologicalNodes []*WorkflowTaskNodeDefinition
+	// 默认的超时时间, 单位秒, 创建实例时没有指定截止时间则使用创建时间加上超时时间, <=0 表示没有截止时间
+	Timeout int64
+	// 超时节点, 超过截止时间后执行, 不在Nodes中, nil表示超时后直接结束
+	TimeoutNode *WorkflowTaskNodeDefinition
+	// 相同BusinessID的多个实例之间的并发策略, 为空表示不限制
+	ConcurrencyPolicy ConcurrencyPolicy
 }
 
 // WorkflowTaskNodeDefinition 工作流任务节点定义entity
 type WorkflowTaskNodeDefinition struct {
 	TaskType      string
 	TaskName      string
-	FailMaxCount  int64 // 失败次数达到 fail_max_count次后，<0的忽略
-	MaxWaitTimeTs int64 // 最大等待时间，单位秒，<=0 忽略
+	FailMaxCount  int64        // 失败次数达到 fail_max_count次后，<0的忽略
+	MaxWaitTimeTs int64        // 最大等待时间，单位秒，<=0 忽略
+	RetryPolicy   *RetryPolicy // 失败重试策略, nil 表示每次RunWorkflow都会重试
 	PreNodes      []*WorkflowTaskNodeDefinition
 	NextNodes     []*WorkflowTaskNodeDefinition
-	TaskWorker    WorkflowTaskNodeWorker // 工作流任务节点工作器,需要外部实现
+	// 后置节点的分支条件, key为后置节点的TaskType, 没有条件的边总是会走
+	NextNodeConditions map[string]*Condition
+	Join               *JoinConfig            // 汇聚方式, nil 表示所有前置节点都处理完才执行
+	ExecutionTimeout   int64                  // 工作器单次调用的超时时间, 单位秒, <=0 表示不限制
+	Kind               NodeKind               // 节点类型, 内置类型的节点由框架提供工作器
+	SubWorkflow        *SubWorkflowConfig     // 子工作流配置, Kind为sub_workflow时有值
+	Delay              *DelayConfig           // 延时配置, Kind为delay时有值
+	WaitEvent          *WaitEventConfig       // 等待事件配置, Kind为wait_event时有值
+	Map                *MapConfig             // map配置, Kind为map时有值
+	Loops              []*LoopEdgeDefinition  // 循环边, 节点完成后按顺序检查, 第一个满足条件的生效
+	TaskWorker         WorkflowTaskNodeWorker // 工作流任务节点工作器,需要外部实现
 }
 
 type CreateWorkflowReq struct {
@@ -61,10 +81,20 @@ type CreateWorkflowReq struct {
 	Context      map[string]any // 上下文,可以为空
 	IsRun        bool           // 是否立即执行,如果为true，则立即执行
 	TaskId       int64          // 任务id
+	// 父工作流实例ID, 子工作流节点创建子工作流时使用, 取消父工作流会级联取消子工作流
+	ParentWorkflowInstanceID int64
+	// 截止时间, 单位秒, 0表示使用工作流配置的timeout计算, 超过截止时间还没有结束的实例会被标记为timed_out
+	DeadlineAt int64
+	// 幂等策略, 为空表示不做幂等检查, 命中已有实例时直接返回已有实例, IsRun不会生效
+	IdempotencyPolicy IdempotencyPolicy
+	// 幂等键, 同一个工作流类型下唯一, 为空时使用BusinessID
+	IdempotencyKey string
+	// 工作流配置的版本号, 0表示使用最新版本, 实例创建后一直按照该版本执行
+	WorkflowVersion int64
 }
 
-func getWorkflowTaskWorker(workflowType string, taskKey string) (WorkflowTaskNodeWorker, bool) {
-	worker, ok := workflowTaskWorkers.Load(workflowType + "_" + taskKey)
+func (r *Registry) getWorkflowTaskWorker(workflowType string, taskKey string) (WorkflowTaskNodeWorker, bool) {
+	worker, ok := r.taskWorkers.Load(workflowType + "_" + taskKey)
 	if !ok {
 		return defaultEmptyTaskWorker, false
 	}
@@ -76,16 +106,20 @@ func getWorkflowTaskWorker(workflowType string, taskKey string) (WorkflowTaskNod
 }
 
 func getAllChildrenTaskType(node *WorkflowTaskNodeDefinition) []string {
-	if len(node.NextNodes) == 0 {
-		// 没有后置节点，直接返回
-		return []string{}
-	}
+	// 广度优先遍历, 每个节点只访问一次, 避免菱形结构重复遍历
 	ret := make([]string, 0)
-	for _, nextNode := range node.NextNodes {
+	visited := make(map[string]struct{})
+	queue := append([]*WorkflowTaskNodeDefinition{}, node.NextNodes...)
+	for len(queue) > 0 {
+		nextNode := queue[0]
+		queue = queue[1:]
+		if _, ok := visited[nextNode.TaskType]; ok {
+			continue
+		}
+		visited[nextNode.TaskType] = struct{}{}
 		ret = append(ret, nextNode.TaskType)
-		ret = append(ret, getAllChildrenTaskType(nextNode)...)
+		queue = append(queue, nextNode.NextNodes...)
 	}
-	ret = UniqueStr(ret)
 	return ret
 }
 func UniqueStr(arr []string) []string {
@@ -105,6 +139,20 @@ type WorkflowConfig struct {
 	ID    string                  `json:"id"`    // 工作流类型ID, 唯一标识, 用于标识工作流类型
 	Name  string                  `json:"name"`  // 工作流类型名称
 	Nodes []*NodeDefinitionConfig `json:"nodes"` // 构建工作流任务
+	// 并行执行的最大节点数量, 开启后相互独立的兄弟节点会并发执行, <=1 表示串行执行(默认)
+	MaxParallelism int64 `json:"max_parallelism"`
+	// 工作流实例的超时时间, 单位秒, 从创建时间开始计算, <=0 表示不限制, 创建实例时可以指定截止时间覆盖
+	// 超过截止时间后未完成的任务会被取消, 工作流状态变成timed_out
+	Timeout int64 `json:"timeout"`
+	// 超时节点ID, 超过截止时间后执行该节点(例如通知、升级处理), 执行结束后工作流状态变成timed_out
+	// 超时节点不参与正常的执行流程, 不能出现在其他节点的next_nodes中
+	TimeoutNode string `json:"timeout_node"`
+	// 相同BusinessID的多个实例之间的并发策略, allow(默认)/reject/queue
+	// reject: 已经有未结束的实例时拒绝创建; queue: 创建为queued状态, 前一个实例结束后自动开始
+	ConcurrencyPolicy ConcurrencyPolicy `json:"concurrency_policy"`
+	// 配置的版本号, <=0 表示版本1, 同一个工作流类型可以同时加载多个版本
+	// 新实例默认使用最新版本, 已经创建的实例一直按照创建时的版本执行
+	Version int64 `json:"version"`
 }
 
 // NodeDefinitionConfig 节点定义配置
@@ -114,6 +162,29 @@ type NodeDefinitionConfig struct {
 	NextNodes     []string `json:"next_nodes"`       // 后置节点ID列表
 	FailMaxCount  *int64   `json:"fail_max_count"`   // 失败次数达到 fail_max_count次后，<0的忽略
 	MaxWaitTimeTs *int64   `json:"max_wait_time_ts"` // 最大等待时间，单位秒，<=0 忽略
+	// 失败重试策略, 为空表示失败后下次RunWorkflow立即重试
+	RetryPolicy *RetryPolicy `json:"retry_policy"`
+	// 工作器单次调用(Run/AsynchronousWaitCheck)的超时时间, 单位秒, <=0 表示不限制
+	// 超时后传给工作器的ctx会被取消, 不再等待工作器返回, 按照普通失败处理, 计入fail_max_count
+	ExecutionTimeout int64 `json:"execution_timeout"`
+	// 分支条件, key为后置节点ID, value为条件表达式, 节点完成后使用节点上下文求值, 不满足的后置节点会被跳过
+	// 例如: {"manager_review": "workflow_context.amount > 10000", "auto_approve": "workflow_context.amount <= 10000"}
+	Conditions map[string]string `json:"conditions"`
+	// 汇聚方式, 节点有多个前置节点时生效, 为空表示所有前置节点都处理完才执行
+	Join *JoinConfig `json:"join"`
+	// 节点类型, 为空表示普通节点, sub_workflow 表示子工作流节点, 内置类型的节点不需要注册工作器
+	Kind NodeKind `json:"kind"`
+	// 子工作流配置, kind为sub_workflow时必填
+	SubWorkflow *SubWorkflowConfig `json:"sub_workflow"`
+	// 延时配置, kind为delay时必填
+	Delay *DelayConfig `json:"delay"`
+	// 等待事件配置, kind为wait_event时生效, 为空表示收到任意事件就完成, 超时后失败
+	WaitEvent *WaitEventConfig `json:"wait_event"`
+	// map配置, kind为map时必填, 每个元素都会执行一次该节点注册的工作器
+	Map *MapConfig `json:"map"`
+	// 循环边, 节点完成后条件满足时回到target节点重新执行, 每一轮的任务实例都会保留
+	// 例如: [{"target": "revise", "condition": "approved == false", "max_iterations": 3}]
+	Loops []*LoopConfig `json:"loops"`
 }
 
 // RegisterWorkflowTaskParams 注册工作流任务节点参数
@@ -128,19 +199,30 @@ type RegisterWorkflowTaskParams struct {
 *
   - @description: 加载工作流配置
     只做存储使用，config转化会在CreateWorkflow中完成，延迟加载,主要是解决RegisterWorkflowTask的依赖
+    同一个工作流类型可以加载多个版本, 相同版本不能重复加载
   - @param config *WorkflowConfig
   - @return error
 */
 func LoadWorkflowConfig(config *WorkflowConfig) error {
+	return defaultRegistry.LoadWorkflowConfig(config)
+}
+
+// LoadWorkflowConfig 加载工作流配置到当前Registry, 参考包级别的LoadWorkflowConfig
+func (r *Registry) LoadWorkflowConfig(config *WorkflowConfig) error {
 	if config == nil {
 		return errors.New("config is nil")
 	}
-	if _, ok := workflowDefinitions.Load(config.ID); ok {
-		return errors.New(fmt.Sprintf("config already registered, id: %s", config.ID))
+	r.loadLock.Lock()
+	defer r.loadLock.Unlock()
+	if r.isWorkflowConfigLoaded(config.ID, config.getVersion()) {
+		return errors.New(fmt.Sprintf("config already registered, id: %s, version: %d", config.ID, config.getVersion()))
 	}
 
-	//todo 简单检查节点pre和next是否有问题
-	workflowConfigs.Store(config.ID, config)
+	// 严格校验模式下配置有任何问题都不能加载, 否则在第一次GetAndLoadWorkflowDefinition时才会发现
+	if err := r.validateWorkflowConfigStrict(config); err != nil {
+		return err
+	}
+	r.storeWorkflowConfig(config)
 	return nil
 }
 
@@ -155,41 +237,75 @@ func LoadWorkflowConfig(config *WorkflowConfig) error {
     *
 */
 func RegisterWorkflowTask(workflowType string, taskKey string, taskWorker WorkflowTaskNodeWorker) error {
+	return defaultRegistry.RegisterWorkflowTask(workflowType, taskKey, taskWorker)
+}
+
+// RegisterWorkflowTask 注册工作流任务节点到当前Registry, 参考包级别的RegisterWorkflowTask
+func (r *Registry) RegisterWorkflowTask(workflowType string, taskKey string, taskWorker WorkflowTaskNodeWorker) error {
 	if taskWorker == nil {
 		return errors.New("taskWorker is nil")
 	}
-	if _, ok := workflowTaskWorkers.Load(workflowType + "_" + taskKey); ok {
+	if _, ok := r.taskWorkers.Load(workflowType + "_" + taskKey); ok {
 		return errors.New(fmt.Sprintf("taskWorker already registered, workflowType: %s, taskKey: %s", workflowType, taskKey))
 	}
-	workflowTaskWorkers.Store(workflowType+"_"+taskKey, taskWorker)
+	r.taskWorkers.Store(workflowType+"_"+taskKey, taskWorker)
 	return nil
 }
 
+// GetAndLoadWorkflowDefinition 从默认的Registry获取工作流类型最新版本的定义
 func GetAndLoadWorkflowDefinition(workflowType string) (*WorkflowDefinition, error) {
-	if i, ok := workflowDefinitions.Load(workflowType); ok {
+	return defaultRegistry.GetAndLoadWorkflowDefinitionWithVersion(workflowType, 0)
+}
+
+// GetAndLoadWorkflowDefinitionWithVersion 从默认的Registry获取工作流类型指定版本的定义, version<=0 表示最新版本
+func GetAndLoadWorkflowDefinitionWithVersion(workflowType string, version int64) (*WorkflowDefinition, error) {
+	return defaultRegistry.GetAndLoadWorkflowDefinitionWithVersion(workflowType, version)
+}
+
+// GetAndLoadWorkflowDefinition 获取工作流类型最新版本的定义
+func (r *Registry) GetAndLoadWorkflowDefinition(workflowType string) (*WorkflowDefinition, error) {
+	return r.GetAndLoadWorkflowDefinitionWithVersion(workflowType, 0)
+}
+
+// GetAndLoadWorkflowDefinitionWithVersion 获取工作流类型指定版本的定义, version<=0 表示最新版本
+// 第一次获取时使用加载的配置和注册的工作器构建定义
+func (r *Registry) GetAndLoadWorkflowDefinitionWithVersion(workflowType string, version int64) (*WorkflowDefinition, error) {
+	version, ok := r.resolveWorkflowVersion(workflowType, version)
+	if !ok {
+		return nil, errors.WithMessagef(ErrWorkflowConfigNotFound, "workflow config %s not found", workflowType)
+	}
+	versionKey := workflowVersionKey(workflowType, version)
+	if i, ok := r.definitions.Load(versionKey); ok {
 		ret, ok := i.(*WorkflowDefinition)
 		if !ok {
-			return nil, errors.WithMessagef(ErrWorkflowDefinitionNotFound, "workflow definition not found, workflowType: %s, type error,please check code", workflowType)
+			return nil, errors.WithMessagef(ErrWorkflowDefinitionNotFound, "workflow definition not found, workflowType: %s, version: %d, type error,please check code", workflowType, version)
 		}
 		return ret, nil
 	}
-	loadWorkflowLock.Lock()
-	defer loadWorkflowLock.Unlock()
-	if i, ok := workflowDefinitions.Load(workflowType); ok {
+	r.loadLock.Lock()
+	defer r.loadLock.Unlock()
+	if i, ok := r.definitions.Load(versionKey); ok {
 		ret, ok := i.(*WorkflowDefinition)
 		if !ok {
-			return nil, errors.WithMessagef(ErrWorkflowDefinitionNotFound, "workflow definition not found, workflowType: %s, type error,please check code", workflowType)
+			return nil, errors.WithMessagef(ErrWorkflowDefinitionNotFound, "workflow definition not found, workflowType: %s, version: %d, type error,please check code", workflowType, version)
 		}
 		return ret, nil
 	}
 	// 加载配置处理
-	workflowDefinitionCofigInterface, ok := workflowConfigs.Load(workflowType)
+	workflowDefinitionCofigInterface, ok := r.configs.Load(versionKey)
 	if !ok {
-		return nil, errors.WithMessagef(ErrWorkflowConfigNotFound, "workflow config %s not found", workflowType)
+		return nil, errors.WithMessagef(ErrWorkflowConfigNotFound, "workflow config %s not found, version: %d", workflowType, version)
 	}
 	workflowDefinitionCofig, ok := workflowDefinitionCofigInterface.(*WorkflowConfig)
 	if !ok {
-		return nil, errors.WithMessagef(ErrWorkflowConfigNotFound, "workflow config %s not found, type error,please check code", workflowType)
+		return nil, errors.WithMessagef(ErrWorkflowConfigNotFound, "workflow config %s not found, version: %d, type error,please check code", workflowType, version)
+	}
+
+	if err := workflowDefinitionCofig.checkTimeout(); err != nil {
+		return nil, errors.WithMessagef(ErrWorkflowParamInvalid, "workflowType: %s, err: %v", workflowType, err)
+	}
+	if err := workflowDefinitionCofig.checkConcurrencyPolicy(); err != nil {
+		return nil, errors.WithMessagef(ErrWorkflowParamInvalid, "workflowType: %s, err: %v", workflowType, err)
 	}
 
 	rootNode := NewRootTaskNodeDefinition()
@@ -208,6 +324,24 @@ func GetAndLoadWorkflowDefinition(workflowType string) (*WorkflowDefinition, err
 			PreNodes:      make([]*WorkflowTaskNodeDefinition, 0),
 			NextNodes:     make([]*WorkflowTaskNodeDefinition, 0),
 			TaskWorker:    defaultEmptyTaskWorker,
+			Join:          node.Join,
+			Kind:          node.Kind,
+		}
+		if workerFlowNodes.Kind == "" {
+			workerFlowNodes.Kind = NodeKindNormal
+		}
+		for nextNodeID, expression := range node.Conditions {
+			if !slices.Contains(node.NextNodes, nextNodeID) {
+				return nil, errors.WithMessagef(ErrWorkflowParamInvalid, "workflowType: %s, taskKey: %s, condition target %s is not in next_nodes", workflowType, node.ID, nextNodeID)
+			}
+			condition, err := ParseCondition(expression)
+			if err != nil {
+				return nil, errors.WithMessagef(ErrWorkflowParamInvalid, "workflowType: %s, taskKey: %s, err: %v", workflowType, node.ID, err)
+			}
+			if workerFlowNodes.NextNodeConditions == nil {
+				workerFlowNodes.NextNodeConditions = make(map[string]*Condition)
+			}
+			workerFlowNodes.NextNodeConditions[nextNodeID] = condition
 		}
 		if node.FailMaxCount != nil {
 			workerFlowNodes.FailMaxCount = *node.FailMaxCount
@@ -215,16 +349,76 @@ func GetAndLoadWorkflowDefinition(workflowType string) (*WorkflowDefinition, err
 		if node.MaxWaitTimeTs != nil {
 			workerFlowNodes.MaxWaitTimeTs = *node.MaxWaitTimeTs
 		}
+		workerFlowNodes.ExecutionTimeout = node.ExecutionTimeout
+		for _, loop := range node.Loops {
+			if err := loop.check(); err != nil {
+				return nil, errors.WithMessagef(ErrWorkflowParamInvalid, "workflowType: %s, taskKey: %s, err: %v", workflowType, node.ID, err)
+			}
+		}
+		if node.RetryPolicy != nil {
+			if err := node.RetryPolicy.check(); err != nil {
+				return nil, errors.WithMessagef(ErrWorkflowParamInvalid, "workflowType: %s, taskKey: %s, err: %v", workflowType, node.ID, err)
+			}
+			workerFlowNodes.RetryPolicy = node.RetryPolicy
+		}
 
-		workerFlowNodes.TaskWorker, ok = getWorkflowTaskWorker(workflowType, node.ID)
-		if !ok {
-			return nil, errors.WithMessagef(ErrWorkflowTaskWorkerNotFound, "workflow task worker not found, workflowType: %s, taskKey: %s", workflowType, node.ID)
+		switch workerFlowNodes.Kind {
+		case NodeKindNormal:
+			workerFlowNodes.TaskWorker, ok = r.getWorkflowTaskWorker(workflowType, node.ID)
+			if !ok {
+				return nil, errors.WithMessagef(ErrWorkflowTaskWorkerNotFound, "workflow task worker not found, workflowType: %s, taskKey: %s", workflowType, node.ID)
+			}
+		case NodeKindSubWorkflow:
+			// 工作器在执行时绑定到service上
+			if err := node.SubWorkflow.check(workflowType); err != nil {
+				return nil, errors.WithMessagef(ErrWorkflowParamInvalid, "workflowType: %s, taskKey: %s, err: %v", workflowType, node.ID, err)
+			}
+			workerFlowNodes.SubWorkflow = node.SubWorkflow
+		case NodeKindDelay:
+			if err := node.Delay.check(); err != nil {
+				return nil, errors.WithMessagef(ErrWorkflowParamInvalid, "workflowType: %s, taskKey: %s, err: %v", workflowType, node.ID, err)
+			}
+			workerFlowNodes.Delay = node.Delay
+		case NodeKindWaitEvent:
+			// 解析到副本中, 调用方加载的配置不会被修改
+			waitEvent, err := node.WaitEvent.parse(node.NextNodes, workerFlowNodes.MaxWaitTimeTs)
+			if err != nil {
+				return nil, errors.WithMessagef(ErrWorkflowParamInvalid, "workflowType: %s, taskKey: %s, err: %v", workflowType, node.ID, err)
+			}
+			// 超时分支需要覆盖后置节点的分支条件
+			timeoutConditions, err := waitEvent.buildNextNodeConditions(node.NextNodes, node.Conditions)
+			if err != nil {
+				return nil, errors.WithMessagef(ErrWorkflowParamInvalid, "workflowType: %s, taskKey: %s, err: %v", workflowType, node.ID, err)
+			}
+			for nextNodeID, condition := range timeoutConditions {
+				if workerFlowNodes.NextNodeConditions == nil {
+					workerFlowNodes.NextNodeConditions = make(map[string]*Condition)
+				}
+				workerFlowNodes.NextNodeConditions[nextNodeID] = condition
+			}
+			workerFlowNodes.WaitEvent = waitEvent
+		case NodeKindMap:
+			// 子任务使用节点注册的工作器, map节点本身的工作器在执行时绑定
+			workerFlowNodes.TaskWorker, ok = r.getWorkflowTaskWorker(workflowType, node.ID)
+			if !ok {
+				return nil, errors.WithMessagef(ErrWorkflowTaskWorkerNotFound, "workflow task worker not found, workflowType: %s, taskKey: %s", workflowType, node.ID)
+			}
+			if err := node.Map.check(); err != nil {
+				return nil, errors.WithMessagef(ErrWorkflowParamInvalid, "workflowType: %s, taskKey: %s, err: %v", workflowType, node.ID, err)
+			}
+			workerFlowNodes.Map = node.Map
+		default:
+			return nil, errors.WithMessagef(ErrWorkflowParamInvalid, "workflowType: %s, taskKey: %s, node kind %q is invalid", workflowType, node.ID, node.Kind)
 		}
 		nodeDefinitionConfigMap[node.ID] = workerFlowNodes
 	}
 
 	// build 内部树结构, 挂载到endNode下面
 	for _, node := range nodesMaps {
+		if node.ID == workflowDefinitionCofig.TimeoutNode {
+			// 超时节点不挂载到工作流图上
+			continue
+		}
 		// preNodes := make([]*WorkflowTaskNodeDefinition, 0)
 		nextNodes := make([]*WorkflowTaskNodeDefinition, 0)
 		for _, nextNode := range node.NextNodes {
@@ -237,24 +431,31 @@ func GetAndLoadWorkflowDefinition(workflowType string) (*WorkflowDefinition, err
 	}
 	// 挂载到rootNode下面
 	for _, node := range nodeDefinitionConfigMap {
+		if node.TaskType == workflowDefinitionCofig.TimeoutNode {
+			continue
+		}
 		// 如果前置节点为空,则直接挂载到rootNode下面
 		if len(node.PreNodes) == 0 {
 			rootNode.NextNodes = append(rootNode.NextNodes, node)
 			node.PreNodes = append(node.PreNodes, rootNode)
 		}
-	}
-
-	// todo 检查工作流图是否存在环, 所有路径都可到达
-	err := checkNodeDefinitionIsOk(rootNode)
-	if err != nil {
-		return nil, errors.WithMessagef(err, "checkNodeDefinitionIsOk failed, workflowType: %s", workflowType)
+		// 前置节点构建完成之后才能检查汇聚配置
+		if err := node.Join.check(len(node.PreNodes)); err != nil {
+			return nil, errors.WithMessagef(ErrWorkflowParamInvalid, "workflowType: %s, taskKey: %s, err: %v", workflowType, node.TaskType, err)
+		}
 	}
 
 	workflowDefinition := &WorkflowDefinition{
-		ID:         workflowType,
-		Name:       workflowDefinitionCofig.Name,
-		RootNode:   rootNode,
-		NodesCount: nodeCount,
+		ID:             workflowType,
+		Name:           workflowDefinitionCofig.Name,
+		Version:        version,
+		RootNode:       rootNode,
+		NodesCount:     nodeCount,
+		MaxParallelism: workflowDefinitionCofig.MaxParallelism,
+		Timeout:        workflowDefinitionCofig.Timeout,
+		TimeoutNode:    nodeDefinitionConfigMap[workflowDefinitionCofig.TimeoutNode],
+
+		ConcurrencyPolicy: workflowDefinitionCofig.ConcurrencyPolicy,
 	}
 	// 节点展开数组，方便后面节点处理
 	nodes := make([]*WorkflowTaskNodeDefinition, 0)
@@ -264,12 +465,31 @@ func GetAndLoadWorkflowDefinition(workflowType string) (*WorkflowDefinition, err
 		if _, ok := nodeDefinitionConfigMap[node.ID]; !ok {
 			return nil, errors.WithMessagef(ErrWorkflowTaskWorkerNotFound, "workflow task worker not found, workflowType: %s, taskKey: %s", workflowType, node.ID)
 		}
+		if node.ID == workflowDefinitionCofig.TimeoutNode {
+			continue
+		}
 		nodes = append(nodes, nodeDefinitionConfigMap[node.ID])
 	}
 	// 加上结束节点
 	nodes = append(nodes, endNode)
 	workflowDefinition.Nodes = nodes
-	workflowDefinitions.Store(workflowType, workflowDefinition)
+	// 检查工作流图是否存在环, 同时计算拓扑序, 执行时按照拓扑序处理节点
+	topologicalNodes, err := checkNodeDefinitionIsOk(nodes)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "checkNodeDefinitionIsOk failed, workflowType: %s", workflowType)
+	}
+	workflowDefinition.topologicalNodes = topologicalNodes
+	// 循环边不在next_nodes中, 图检查无环之后再构建
+	for _, node := range workflowDefinitionCofig.Nodes {
+		for _, loop := range node.Loops {
+			loopEdge, err := buildLoopEdgeDefinition(nodeDefinitionConfigMap[node.ID], loop, topologicalNodes)
+			if err != nil {
+				return nil, errors.WithMessagef(ErrWorkflowParamInvalid, "workflowType: %s, taskKey: %s, err: %v", workflowType, node.ID, err)
+			}
+			nodeDefinitionConfigMap[node.ID].Loops = append(nodeDefinitionConfigMap[node.ID].Loops, loopEdge)
+		}
+	}
+	r.definitions.Store(versionKey, workflowDefinition)
 	return workflowDefinition, nil
 }
 
@@ -304,11 +524,12 @@ func BuildAddWorkfNode(endNode *WorkflowTaskNodeDefinition, addNode *WorkflowTas
 					break
 				}
 			}
-			if isNeedAddPreNode {
-				nextNode.PreNodes = append(nextNode.PreNodes, addNode)
+			if !isNeedAddPreNode {
+				// 配置中重复的后置节点, 只保留一条边
+				continue
 			}
+			nextNode.PreNodes = append(nextNode.PreNodes, addNode)
 			addNode.NextNodes = append(addNode.NextNodes, nextNode)
-
 		}
 	}
 	return nil
@@ -318,32 +539,64 @@ func (s *WorkflowServiceImpl) CreateWorkflow(ctx context.Context, req *CreateWor
 	if err := validatorUtil.Struct(req); err != nil {
 		return nil, errors.Wrapf(ErrWorkflowParamInvalid, "CreateWorkflow failed, req: %v,err: %v", req, err)
 	}
-	workflowDefinition, err := GetAndLoadWorkflowDefinition(req.WorkflowType)
-	if err != nil && !req.IsRun {
-		// 这里不用返回错误，创建和执行工作流的可能不在同一个容器里面，可能创建的容器中没有定义工作流
-		// 需要记录日志
-		slog.ErrorContext(ctx, "GetAndLoadWorkflowDefinition failed, workflowType: %s, err: %v", req.WorkflowType, err)
-	}
+	workflowDefinition, err := s.registry.GetAndLoadWorkflowDefinitionWithVersion(req.WorkflowType, req.WorkflowVersion)
 	if err != nil && req.IsRun {
 		// 需要立刻执行，说明创建和执行工作流在同一个容器里面，找不到工作流定义需要返回错误
 		return nil, errors.WithMessagef(err, "GetAndLoadWorkflowDefinition failed, workflowType: %s", req.WorkflowType)
 	}
+	// 创建实例需要的版本号、超时时间和并发策略
+	definitionHeader := workflowDefinition
+	if err != nil {
+		// 这里不用返回错误，创建和执行工作流的可能不在同一个容器里面，可能创建的容器中没有定义工作流
+		// 使用数据库中发布的配置, 没有发布过则记录日志
+		var publishedErr error
+		definitionHeader, publishedErr = s.getPublishedWorkflowDefinitionHeader(ctx, req.WorkflowType, req.WorkflowVersion)
+		if publishedErr != nil {
+			slog.ErrorContext(ctx, fmt.Sprintf("GetAndLoadWorkflowDefinition failed, workflowType: %s, err: %v, publishedErr: %v", req.WorkflowType, err, publishedErr))
+		}
+	}
 	jsonContext := NewJSONContextFromMap(req.Context)
-
-	workflowInstance, err := s.repo.CreateWorkflowInstance(ctx, &WorkflowInstancePo{
-		WorkflowType:    req.WorkflowType,
-		BusinessID:      req.BusinessID,
-		WorkflowContext: jsonContext.ToBytesWithoutError(),
-		Status:          WorkflowInstanceStatusInit,
-		TaskId:          req.TaskId,
-		CreatedAt:       time.Now().Unix(),
-		UpdatedAt:       time.Now().Unix(),
+	// 创建时间和截止时间使用同一个时间计算
+	now := time.Now().Unix()
+	deadlineAt := req.DeadlineAt
+	if deadlineAt <= 0 && definitionHeader != nil && definitionHeader.Timeout > 0 {
+		// 没有指定截止时间, 使用工作流配置的超时时间
+		deadlineAt = now + definitionHeader.Timeout
+	}
+	// 固定实例使用的版本, 没有找到工作流定义时使用指定的版本, 都没有则执行时使用最新版本
+	workflowVersion := req.WorkflowVersion
+	if definitionHeader != nil {
+		workflowVersion = definitionHeader.Version
+	}
+
+	var workflowInstance *WorkflowInstancePo
+	isCreated := false
+	err = s.withConcurrencyPolicyLock(ctx, definitionHeader, req.WorkflowType, req.BusinessID, func(ctx context.Context) error {
+		var err error
+		workflowInstance, isCreated, err = s.createWorkflowInstance(ctx, req, definitionHeader, &WorkflowInstancePo{
+			WorkflowType:    req.WorkflowType,
+			BusinessID:      req.BusinessID,
+			WorkflowContext: jsonContext.ToBytesWithoutError(),
+			Status:          WorkflowInstanceStatusInit,
+			TaskId:          req.TaskId,
+			CreatedAt:       now,
+			UpdatedAt:       now,
+
+			ParentWorkflowInstanceID: req.ParentWorkflowInstanceID,
+			DeadlineAt:               deadlineAt,
+			WorkflowVersion:          workflowVersion,
+		})
+		return err
 	})
 	if err != nil {
-		return nil, errors.WithMessagef(err, "CreateWorkflowInstance failed, workflowType: %s", req.WorkflowType)
+		return nil, errors.WithMessagef(err, "createWorkflowInstance failed, workflowType: %s", req.WorkflowType)
+	}
+	if isCreated && workflowInstance.Status == WorkflowInstanceStatusQueued {
+		// 前一个实例结束时可能没有拿到业务锁, 排队的实例没有开始, 这里再尝试一次
+		s.tryStartNextQueuedWorkflowInstance(ctx, definitionHeader, req.WorkflowType, req.BusinessID)
 	}
 
-	if req.IsRun {
+	if req.IsRun && isCreated && workflowInstance.Status != WorkflowInstanceStatusQueued {
 		// 如果需要立即执行，则需要执行工作流
 		err = s.RunWorkflow(ctx, workflowInstance.ID)
 		if err != nil {
@@ -360,6 +613,10 @@ func (s *WorkflowServiceImpl) CreateWorkflow(ctx context.Context, req *CreateWor
 		UpdatedAt:       workflowInstance.UpdatedAt,
 		Definitions:     workflowDefinition,
 		TaskId:          workflowInstance.TaskId,
+
+		ParentWorkflowInstanceID: workflowInstance.ParentWorkflowInstanceID,
+		DeadlineAt:               workflowInstance.DeadlineAt,
+		WorkflowVersion:          workflowInstance.WorkflowVersion,
 	}, nil
 }
 
@@ -414,12 +671,18 @@ func (s *WorkflowServiceImpl) assemblyWorkflowInstanceDetailEntity(ctx context.C
 		Status:          workflowInstance.Status,
 		WorkflowContext: NewByte2StrctPbValue(workflowInstance.WorkflowContext),
 		TaskInstances:   make([]*TaskInstanceEntity, 0),
+		SkippedNodes:    make([]string, 0),
 		CreatedAt:       workflowInstance.CreatedAt,
 		UpdatedAt:       workflowInstance.UpdatedAt,
+
+		ParentWorkflowInstanceID: workflowInstance.ParentWorkflowInstanceID,
+		DeadlineAt:               workflowInstance.DeadlineAt,
+		WorkflowContextHistory:   parseWorkflowContextHistory(workflowInstance.WorkflowContextHistory),
+		WorkflowVersion:          workflowInstance.WorkflowVersion,
 	}
-	workflowDefinition, err := GetAndLoadWorkflowDefinition(workflowInstance.WorkflowType)
+	workflowDefinition, err := s.getWorkflowDefinitionForInstance(workflowInstance)
 	if err != nil {
-		return nil, errors.WithMessagef(err, "GetAndLoadWorkflowDefinition failed, workflowType: %s", workflowInstance.WorkflowType)
+		return nil, errors.WithMessagef(err, "GetAndLoadWorkflowDefinition failed, workflowType: %s, version: %d", workflowInstance.WorkflowType, workflowInstance.WorkflowVersion)
 	}
 	// 查询任务实例
 	taskInstances, err := s.getAllTaskInstancePo(ctx, workflowInstance.ID)
@@ -427,10 +690,64 @@ func (s *WorkflowServiceImpl) assemblyWorkflowInstanceDetailEntity(ctx context.C
 		return nil, errors.WithMessagef(err, "getAllTaskInstancePo failed, workflowInstanceID: %d", workflowInstance.ID)
 	}
 	taskMap := make(map[string]*WorkflowTaskInstancePo, 0)
-	for _, taskInstance := range taskInstances {
+	itemsMap := make(map[string][]*TaskInstanceEntity, 0)
+	for _, taskInstance := range latestGenerationTaskInstances(taskInstances) {
+		if taskInstance.ItemIndex != nil {
+			// map节点的子任务, 挂载到map节点下面
+			itemsMap[taskInstance.TaskType] = append(itemsMap[taskInstance.TaskType], &TaskInstanceEntity{
+				ID:                 taskInstance.ID,
+				WorkflowInstanceID: workflowInstance.ID,
+				TaskType:           taskInstance.TaskType,
+				Status:             taskInstance.Status,
+				NodeContext:        NewByte2StrctPbValue(taskInstance.NodeContext),
+				CreatedAt:          taskInstance.CreatedAt,
+				UpdatedAt:          taskInstance.UpdatedAt,
+				NextRetryAt:        taskInstance.NextRetryAt,
+				ItemIndex:          taskInstance.ItemIndex,
+				Generation:         taskInstance.Generation,
+				CompletedAt:        taskInstance.CompletedAt,
+				CompensationStatus: taskInstance.CompensationStatus,
+			})
+			continue
+		}
 		taskMap[taskInstance.TaskType] = taskInstance
 	}
-	for _, node := range workflowDefinition.Nodes {
+	for _, items := range itemsMap {
+		slices.SortFunc(items, func(a, b *TaskInstanceEntity) int {
+			return int(*a.ItemIndex - *b.ItemIndex)
+		})
+	}
+	// 循环中之前轮次的任务实例, 作为历史挂载到最新一轮的任务实例下面
+	historyMap := make(map[string][]*TaskInstanceEntity, 0)
+	for _, taskInstance := range taskInstances {
+		latest, ok := taskMap[taskInstance.TaskType]
+		if taskInstance.ItemIndex != nil || !ok || taskInstance.Generation >= latest.Generation {
+			continue
+		}
+		historyMap[taskInstance.TaskType] = append(historyMap[taskInstance.TaskType], &TaskInstanceEntity{
+			ID:                 taskInstance.ID,
+			WorkflowInstanceID: workflowInstance.ID,
+			TaskType:           taskInstance.TaskType,
+			Status:             taskInstance.Status,
+			NodeContext:        NewByte2StrctPbValue(taskInstance.NodeContext),
+			CreatedAt:          taskInstance.CreatedAt,
+			UpdatedAt:          taskInstance.UpdatedAt,
+			Generation:         taskInstance.Generation,
+			CompletedAt:        taskInstance.CompletedAt,
+			CompensationStatus: taskInstance.CompensationStatus,
+		})
+	}
+	for _, history := range historyMap {
+		slices.SortFunc(history, func(a, b *TaskInstanceEntity) int {
+			return int(a.Generation - b.Generation)
+		})
+	}
+	detailNodes := workflowDefinition.Nodes
+	if workflowDefinition.TimeoutNode != nil {
+		// 超时节点不在工作流图上, 放在最后展示
+		detailNodes = append(slices.Clone(detailNodes), workflowDefinition.TimeoutNode)
+	}
+	for _, node := range detailNodes {
 		taskInstance, ok := taskMap[node.TaskType]
 		preNodesKeys := make([]string, 0)
 		nextNodesKeys := make([]string, 0)
@@ -441,6 +758,9 @@ func (s *WorkflowServiceImpl) assemblyWorkflowInstanceDetailEntity(ctx context.C
 			nextNodesKeys = append(nextNodesKeys, nextNode.TaskType)
 		}
 		if ok {
+			if taskInstance.Status == WorkflowTaskNodeStatusSkipped {
+				ret.SkippedNodes = append(ret.SkippedNodes, taskInstance.TaskType)
+			}
 			// 任务实例存在,使用任务实例的详情
 			ret.TaskInstances = append(ret.TaskInstances, &TaskInstanceEntity{
 				ID:                 taskInstance.ID,
@@ -451,8 +771,15 @@ func (s *WorkflowServiceImpl) assemblyWorkflowInstanceDetailEntity(ctx context.C
 				NodeContext:        NewByte2StrctPbValue(taskInstance.NodeContext),
 				CreatedAt:          taskInstance.CreatedAt,
 				UpdatedAt:          taskInstance.UpdatedAt,
+				NextRetryAt:        taskInstance.NextRetryAt,
+				WakeUpAt:           taskInstance.WakeUpAt,
+				CompletedAt:        taskInstance.CompletedAt,
+				CompensationStatus: taskInstance.CompensationStatus,
 				PreNodesKeys:       preNodesKeys,
 				NextNodesKeys:      nextNodesKeys,
+				Items:              itemsMap[node.TaskType],
+				Generation:         taskInstance.Generation,
+				History:            historyMap[node.TaskType],
 			})
 		} else {
 			// 任务实例不存在,使用节点定义的详情
@@ -508,21 +835,28 @@ type NodeExternalEvent struct {
 	EventContent string `json:"event_content"`
 }
 type RestartWorkflowNodeParams struct {
-	WorkflowInstanceID      int64  `json:"workflow_instance_id" validate:"gt=0"`
-	TaskType                string `json:"task_type" validate:"required"`
-	IsForcedRestartWorkflow bool   `json:"is_forced_restart_workflow"` // 是否强制重启工作流,如果是true即使工作流实例已经结束,也会重启工作流
-	// IsAsynchronous          bool   `json:"is_asynchronous" `           // 是否异步重启,如果为true，则不等待任务执行完成,直接返回
+	WorkflowInstanceID      int64          `json:"workflow_instance_id" validate:"gt=0"`
+	TaskType                string         `json:"task_type" validate:"required"`
+	IsForcedRestartWorkflow bool           `json:"is_forced_restart_workflow"` // 是否强制重启工作流,如果是true即使工作流实例已经结束,也会重启工作流
+	IsRun                   bool           `json:"is_run"`                     // 是否立即执行,如果为true，则重置节点后在同一次加锁中执行工作流
+	IsAsynchronous          bool           `json:"is_asynchronous"`            // 是否异步重启,如果为true，则重置节点后在后台执行工作流,直接返回句柄
+	Context                 map[string]any `json:"context"`                    // 工作流上下文,如果有值，则整体替换掉原来的上下文
+	ContextPatch            map[string]any `json:"context_patch"`              // 工作流上下文的JSON Merge Patch,如果有值，则合并到原来的上下文, 不能和Context同时使用
 }
 
 type RestartWorkflowParams struct {
-	WorkflowInstanceID int64 `json:"workflow_instance_id" validate:"gt=0"`
-	// Context            map[string]any // 上下文,如果有值，则覆盖掉原来的上下文
-	IsRun bool // 是否立即执行,如果为true，则立即执行
+	WorkflowInstanceID int64          `json:"workflow_instance_id" validate:"gt=0"`
+	Context            map[string]any `json:"context"`       // 工作流上下文,如果有值，则整体替换掉原来的上下文
+	ContextPatch       map[string]any `json:"context_patch"` // 工作流上下文的JSON Merge Patch,如果有值，则合并到原来的上下文, 不能和Context同时使用
+	IsRun              bool           // 是否立即执行,如果为true，则立即执行
 }
 
-func (s *WorkflowServiceImpl) RestartWorkflowNode(ctx context.Context, restartParams *RestartWorkflowNodeParams) error {
+func (s *WorkflowServiceImpl) RestartWorkflowNode(ctx context.Context, restartParams *RestartWorkflowNodeParams) (*WorkflowRunHandle, error) {
 	if err := validatorUtil.Struct(restartParams); err != nil {
-		return errors.Wrapf(ErrWorkflowParamInvalid, "RestartWorkflowNode failed, restartParams: %v,err: %v", restartParams, err)
+		return nil, errors.Wrapf(ErrWorkflowParamInvalid, "RestartWorkflowNode failed, restartParams: %v,err: %v", restartParams, err)
+	}
+	if err := checkRestartWorkflowContext(restartParams.Context, restartParams.ContextPatch); err != nil {
+		return nil, errors.WithMessagef(err, "RestartWorkflowNode failed, workflowInstanceID: %d", restartParams.WorkflowInstanceID)
 	}
 	err := s.executeLock.NonBlockingSynchronized(ctx,
 		workflowOpLockKey(restartParams.WorkflowInstanceID),
@@ -542,9 +876,9 @@ func (s *WorkflowServiceImpl) RestartWorkflowNode(ctx context.Context, restartPa
 			if len(workflowInstance) == 0 {
 				return errors.Errorf("WorkflowInstance not found, workflowInstanceID: %d", restartParams.WorkflowInstanceID)
 			}
-			definition, err := GetAndLoadWorkflowDefinition(workflowInstance[0].WorkflowType)
+			definition, err := s.getWorkflowDefinitionForInstance(workflowInstance[0])
 			if err != nil {
-				return errors.WithMessagef(err, "GetAndLoadWorkflowDefinition failed, workflowType: %s", workflowInstance[0].WorkflowType)
+				return errors.WithMessagef(err, "GetAndLoadWorkflowDefinition failed, workflowType: %s, version: %d", workflowInstance[0].WorkflowType, workflowInstance[0].WorkflowVersion)
 			}
 			hasNode := false
 			currentNode := definition.RootNode
@@ -588,6 +922,16 @@ func (s *WorkflowServiceImpl) RestartWorkflowNode(ctx context.Context, restartPa
 						return errors.WithMessagef(err, "UpdateWorkflowInstance failed, workflowInstanceID: %d", restartParams.WorkflowInstanceID)
 					}
 				}
+				// 修改工作流上下文, 重启的节点重新初始化时使用新的上下文
+				err = s.updateRestartWorkflowContext(ctx, workflowInstance[0], restartParams.Context, restartParams.ContextPatch)
+				if err != nil {
+					return errors.WithMessagef(err, "updateRestartWorkflowContext failed, workflowInstanceID: %d", restartParams.WorkflowInstanceID)
+				}
+				// 重启的节点不用再等待, 调度器需要尽快执行
+				err = s.wakeUpWorkflowInstance(ctx, restartParams.WorkflowInstanceID)
+				if err != nil {
+					return err
+				}
 				// 查询任务实例
 				taskInstances, err := s.getAllTaskInstancePo(ctx, restartParams.WorkflowInstanceID)
 				if err != nil {
@@ -598,7 +942,8 @@ func (s *WorkflowServiceImpl) RestartWorkflowNode(ctx context.Context, restartPa
 					return nil
 				}
 				resetTaskIds := make([]int64, 0)
-				for _, taskInstance := range taskInstances {
+				// 之前轮次的任务实例作为历史保留, 只重启最新一轮的
+				for _, taskInstance := range latestGenerationTaskInstances(taskInstances) {
 					if _, ok := resetTaskTypeMap[taskInstance.TaskType]; ok {
 						resetTaskIds = append(resetTaskIds, taskInstance.ID)
 					}
@@ -609,7 +954,9 @@ func (s *WorkflowServiceImpl) RestartWorkflowNode(ctx context.Context, restartPa
 							IDIn: resetTaskIds,
 						},
 						Fields: &UpdateWorkflowTaskInstanceField{
-							Status: String(WorkflowTaskNodeStatusRestarting),
+							Status:      String(WorkflowTaskNodeStatusRestarting),
+							NextRetryAt: Int64(0),
+							WakeUpAt:    Int64(0),
 						},
 						LimitMax: len(resetTaskIds),
 					})
@@ -622,18 +969,31 @@ func (s *WorkflowServiceImpl) RestartWorkflowNode(ctx context.Context, restartPa
 			if err != nil {
 				return errors.WithMessagef(err, "RestartWorkflowNode failed, restartParams: %v", restartParams)
 			}
+			if restartParams.IsRun && !restartParams.IsAsynchronous {
+				// 锁可以重入, 重置和执行在同一次加锁中完成
+				err = s.RunWorkflow(ctx, restartParams.WorkflowInstanceID)
+				if err != nil {
+					return errors.WithMessagef(err, "RunWorkflow failed, workflowInstanceID: %d", restartParams.WorkflowInstanceID)
+				}
+			}
 			return nil
 		})
 	if err != nil {
-		return errors.WithMessagef(err, "RestartWorkflowNode failed, restartParams: %v", restartParams)
+		return nil, errors.WithMessagef(err, "RestartWorkflowNode failed, restartParams: %v", restartParams)
 	}
-
-	return nil
+	if restartParams.IsAsynchronous {
+		// 释放锁之后再提交到后台执行
+		return s.runWorkflowAsync(ctx, restartParams.WorkflowInstanceID), nil
+	}
+	return nil, nil
 }
 func (s *WorkflowServiceImpl) RestartWorkflowInstance(ctx context.Context, restartParams *RestartWorkflowParams) error {
 	if err := validatorUtil.Struct(restartParams); err != nil {
 		return errors.Wrapf(ErrWorkflowParamInvalid, "RestartWorkflowInstance failed, restartParams: %v,err: %v", restartParams, err)
 	}
+	if err := checkRestartWorkflowContext(restartParams.Context, restartParams.ContextPatch); err != nil {
+		return errors.WithMessagef(err, "RestartWorkflowInstance failed, workflowInstanceID: %d", restartParams.WorkflowInstanceID)
+	}
 	err := s.executeLock.NonBlockingSynchronized(ctx,
 		workflowOpLockKey(restartParams.WorkflowInstanceID),
 		10*time.Minute,
@@ -662,18 +1022,25 @@ func (s *WorkflowServiceImpl) RestartWorkflowInstance(ctx context.Context, resta
 					IDIn: []int64{restartParams.WorkflowInstanceID},
 				},
 				Fields: &UpdateWorkflowInstanceField{
-					Status: &workflowInstance[0].Status,
+					Status:       &workflowInstance[0].Status,
+					NextWakeUpAt: Int64(0),
 				},
+				LimitMax: 1,
 			})
 			if err != nil {
 				return errors.WithMessagef(err, "UpdateWorkflowInstance failed, workflowInstanceID: %d", restartParams.WorkflowInstanceID)
 			}
+			// 修改工作流上下文, 重启的节点重新初始化时使用新的上下文
+			err = s.updateRestartWorkflowContext(ctx, workflowInstance[0], restartParams.Context, restartParams.ContextPatch)
+			if err != nil {
+				return errors.WithMessagef(err, "updateRestartWorkflowContext failed, workflowInstanceID: %d", restartParams.WorkflowInstanceID)
+			}
 			taskInstances, err := s.getAllTaskInstancePo(ctx, restartParams.WorkflowInstanceID)
 			if err != nil {
 				return errors.WithMessagef(err, "QueryWorkflowTaskInstance failed, workflowInstanceID: %d", restartParams.WorkflowInstanceID)
 			}
 			resetTaskIds := make([]int64, 0)
-			for _, taskInstance := range taskInstances {
+			for _, taskInstance := range latestGenerationTaskInstances(taskInstances) {
 				if taskInstance.Status == WorkflowTaskNodeStatusFailed || taskInstance.Status == WorkflowTaskNodeStatusCancelled {
 					resetTaskIds = append(resetTaskIds, taskInstance.ID)
 				}
@@ -685,7 +1052,9 @@ func (s *WorkflowServiceImpl) RestartWorkflowInstance(ctx context.Context, resta
 						IDIn: resetTaskIds,
 					},
 					Fields: &UpdateWorkflowTaskInstanceField{
-						Status: String(WorkflowTaskNodeStatusRestarting),
+						Status:      String(WorkflowTaskNodeStatusRestarting),
+						NextRetryAt: Int64(0),
+						WakeUpAt:    Int64(0),
 					},
 					LimitMax: len(resetTaskIds),
 				})
@@ -720,6 +1089,7 @@ func (s *WorkflowServiceImpl) AddNodeExternalEvent(ctx context.Context, addParam
 			taskInstances, err := s.repo.QueryWorkflowTaskInstance(ctx, &QueryWorkflowTaskInstanceParams{
 				WorkflowInstanceID: &addParams.WorkflowInstanceID,
 				TaskType:           &addParams.TaskType,
+				IsMapItem:          Bool(false),
 				OrderbyIDAsc:       Bool(false),
 				Page: &Pager{
 					Page: 1,
@@ -733,9 +1103,9 @@ func (s *WorkflowServiceImpl) AddNodeExternalEvent(ctx context.Context, addParam
 				// 任务实例不存在，可能没有准备好，需要等他init后
 				return errors.Errorf("WorkflowTaskInstance not found, workflowInstanceID: %d, taskType: %s", addParams.WorkflowInstanceID, addParams.TaskType)
 			}
-			if len(taskInstances) >= 2 {
-				// 任务实例大于2，可能有重复事件，需要开发人员去确认是否有问题的
-				// 后续看迭代升级，目前设计上不存在同一个工作流里面有两个相同的任务
+			if len(taskInstances) >= 2 && taskInstances[0].Generation == taskInstances[1].Generation {
+				// 同一轮有两个相同的任务实例，可能有重复事件，需要开发人员去确认是否有问题的
+				// 循环中的节点每一轮都有一个任务实例, 按照id倒序第一个就是最新一轮的
 				return errors.Errorf("WorkflowTaskInstance is more than 2, please check, workflowInstanceID: %d, taskType: %s", addParams.WorkflowInstanceID, addParams.TaskType)
 			}
 
@@ -782,7 +1152,8 @@ func (s *WorkflowServiceImpl) AddNodeExternalEvent(ctx context.Context, addParam
 			if err != nil {
 				return errors.WithMessagef(err, "UpdateWorkflowTaskInstance failed, workflowInstanceID: %d, taskType: %s", addParams.WorkflowInstanceID, addParams.TaskType)
 			}
-			return nil
+			// 收到事件的节点可能在等待重试, 调度器需要尽快执行
+			return s.wakeUpWorkflowInstance(ctx, addParams.WorkflowInstanceID)
 		})
 	if errors.Is(err, LockFailedError) {
 		return errors.Errorf("LockFailedError,workflowInstanceID: %d, taskType: %s, err: %v", addParams.WorkflowInstanceID, addParams.TaskType, err)
@@ -803,6 +1174,13 @@ type WorkflowInstanceDetailEntity struct {
 	CreatedAt       int64
 	UpdatedAt       int64
 	TaskInstances   []*TaskInstanceEntity
+	SkippedNodes    []string // 因为分支条件不满足而跳过的节点TaskType列表
+
+	ParentWorkflowInstanceID int64 // 父工作流实例ID, 0表示没有父工作流
+	DeadlineAt               int64 // 截止时间,单位秒,0表示没有截止时间
+	// 工作流上下文的修改记录, 按照修改时间正序
+	WorkflowContextHistory []*WorkflowContextRevision
+	WorkflowVersion        int64 // 工作流配置的版本号, 0表示使用最新版本
 }
 
 type TaskInstanceEntity struct {
@@ -814,8 +1192,16 @@ type TaskInstanceEntity struct {
 	NodeContext        *JSONContext
 	CreatedAt          int64
 	UpdatedAt          int64
+	NextRetryAt        int64 // 下次重试时间,单位秒,0表示不需要等待
+	WakeUpAt           int64 // 延时节点的唤醒时间,单位秒,0表示不需要等待
 	PreNodesKeys       []string
 	NextNodesKeys      []string
+	ItemIndex          *int64                // map节点子任务对应的元素下标, nil表示不是子任务
+	Items              []*TaskInstanceEntity // map节点的子任务, 按元素下标排序
+	Generation         int64                 // 循环的轮次, 从0开始
+	History            []*TaskInstanceEntity // 循环中之前轮次的任务实例, 按轮次排序
+	CompletedAt        int64                 // 完成时间,单位秒,0表示还没有完成
+	CompensationStatus CompensationStatus    // 补偿状态, 工作流失败或者取消时才有值
 }
 
 type WorkflowInstance struct {
@@ -828,6 +1214,10 @@ type WorkflowInstance struct {
 	CreatedAt       int64
 	UpdatedAt       int64
 	Definitions     *WorkflowDefinition
+
+	ParentWorkflowInstanceID int64 // 父工作流实例ID, 0表示没有父工作流
+	DeadlineAt               int64 // 截止时间,单位秒,0表示没有截止时间
+	WorkflowVersion          int64 // 工作流配置的版本号, 0表示使用最新版本
 }
 
 func (s *WorkflowServiceImpl) RunWorkflow(ctx context.Context, workflowID int64) error {
@@ -856,10 +1246,15 @@ func (s *WorkflowServiceImpl) RunWorkflow(ctx context.Context, workflowID int64)
 		CreatedAt:       workflowInstances[0].CreatedAt,
 		UpdatedAt:       workflowInstances[0].UpdatedAt,
 		Definitions:     nil,
+
+		ParentWorkflowInstanceID: workflowInstances[0].ParentWorkflowInstanceID,
+		DeadlineAt:               workflowInstances[0].DeadlineAt,
+		WorkflowVersion:          workflowInstances[0].WorkflowVersion,
 	}
-	workflowDefinition, err := GetAndLoadWorkflowDefinition(workflowInstance.WorkflowType)
+	// 按照实例创建时固定的版本执行, 新版本的配置不影响已经创建的实例
+	workflowDefinition, err := s.registry.GetAndLoadWorkflowDefinitionWithVersion(workflowInstance.WorkflowType, workflowInstance.WorkflowVersion)
 	if err != nil {
-		return errors.WithMessagef(err, "GetAndLoadWorkflowDefinition failed, workflowType: %s", workflowInstance.WorkflowType)
+		return errors.WithMessagef(err, "GetAndLoadWorkflowDefinition failed, workflowType: %s, version: %d", workflowInstance.WorkflowType, workflowInstance.WorkflowVersion)
 	}
 	workflowInstance.Definitions = workflowDefinition
 
@@ -867,23 +1262,55 @@ func (s *WorkflowServiceImpl) RunWorkflow(ctx context.Context, workflowID int64)
 		workflowOpLockKey(workflowInstance.ID),
 		10*time.Minute,
 		func(ctx context.Context) error {
-			// 查询任务实例
-			taskInstanceNodes, err := s.repo.QueryWorkflowTaskInstance(ctx, &QueryWorkflowTaskInstanceParams{
-				WorkflowInstanceID: &workflowInstance.ID,
-				Page: &Pager{
-					Page: 1,
-					Size: workflowDefinition.NodesCount + 1,
-				},
-			})
+			// 加锁之前读取的实例可能已经过期, 例如加锁之前实例被暂停, 需要在锁内重新读取状态
+			latestWorkflowInstance, err := s.queryWorkflowInstanceByID(ctx, workflowInstance.ID)
 			if err != nil {
-				return errors.WithMessagef(err, "QueryWorkflowTaskInstance failed, workflowInstanceID: %d", workflowInstance.ID)
+				return err
+			}
+			workflowInstance.Status = latestWorkflowInstance.Status
+			workflowInstance.WorkflowContext = NewByte2StrctPbValue(latestWorkflowInstance.WorkflowContext)
+			workflowInstance.UpdatedAt = latestWorkflowInstance.UpdatedAt
+			workflowInstance.DeadlineAt = latestWorkflowInstance.DeadlineAt
+			if workflowInstance.Status == WorkflowInstanceStatusPaused || workflowInstance.Status == WorkflowInstanceStatusQueued {
+				// 暂停中或者排队中, 不执行也不检查任何节点
+				return nil
 			}
-			if len(taskInstanceNodes) > int(workflowDefinition.NodesCount) {
-				// 如果任务实例节点大于节点数量，目前的框架上是任务有问题的,需要开发人员去确认是否有问题的
-				slog.ErrorContext(ctx, fmt.Sprintf("WorkflowTaskInstance node is more than nodes count,please check, workflowInstanceID: %d", workflowInstance.ID))
+			if latestWorkflowInstance.WorkflowVersion != workflowInstance.WorkflowVersion {
+				// 加锁之前版本已经被固定或者迁移, 按照最新的版本执行
+				workflowInstance.WorkflowVersion = latestWorkflowInstance.WorkflowVersion
+				workflowDefinition, err = s.registry.GetAndLoadWorkflowDefinitionWithVersion(workflowInstance.WorkflowType, workflowInstance.WorkflowVersion)
+				if err != nil {
+					return errors.WithMessagef(err, "GetAndLoadWorkflowDefinition failed, workflowType: %s, version: %d", workflowInstance.WorkflowType, workflowInstance.WorkflowVersion)
+				}
+				workflowInstance.Definitions = workflowDefinition
+			}
+			if workflowInstance.WorkflowVersion == 0 {
+				// 创建实例的容器中没有工作流定义时版本号为0, 第一次执行时固定为当前使用的版本, 之后加载的新版本不影响这个实例
+				err = s.repo.UpdateWorkflowInstance(ctx, &UpdateWorkflowInstanceParams{
+					Where: &UpdateWorkflowInstanceWhere{
+						IDIn: []int64{workflowInstance.ID},
+					},
+					Fields: &UpdateWorkflowInstanceField{
+						WorkflowVersion: Int64(workflowDefinition.Version),
+					},
+					LimitMax: 1,
+				})
+				if err != nil {
+					return errors.WithMessagef(err, "UpdateWorkflowInstance failed, workflowInstanceID: %d", workflowInstance.ID)
+				}
+				workflowInstance.WorkflowVersion = workflowDefinition.Version
+			}
+			// 查询任务实例, 循环中的节点可能有多轮任务实例, 只处理最新一轮的
+			taskInstanceNodes, err := s.getAllTaskInstancePo(ctx, workflowInstance.ID)
+			if err != nil {
+				return errors.WithMessagef(err, "QueryWorkflowTaskInstance failed, workflowInstanceID: %d", workflowInstance.ID)
 			}
 			taskNodeMap := make(map[string]*WorkflowTaskNode, 0)
-			for _, taskInstanceNode := range taskInstanceNodes {
+			for _, taskInstanceNode := range latestGenerationTaskInstances(taskInstanceNodes) {
+				if taskInstanceNode.ItemIndex != nil {
+					// map节点的子任务由map节点自己处理
+					continue
+				}
 				taskNodeMap[taskInstanceNode.TaskType] = &WorkflowTaskNode{
 					ID:                 taskInstanceNode.ID,
 					WorkflowInstanceID: taskInstanceNode.WorkflowInstanceID,
@@ -893,9 +1320,42 @@ func (s *WorkflowServiceImpl) RunWorkflow(ctx context.Context, workflowID int64)
 					CreatedAt:          taskInstanceNode.CreatedAt,
 					UpdatedAt:          taskInstanceNode.UpdatedAt,
 					FailCount:          taskInstanceNode.FailCount,
+					NextRetryAt:        taskInstanceNode.NextRetryAt,
+					WakeUpAt:           taskInstanceNode.WakeUpAt,
+					Generation:         taskInstanceNode.Generation,
+				}
+			}
+			state := newWorkflowRunState(workflowInstance, taskNodeMap, workflowDefinition.MaxParallelism)
+			if workflowInstance.Status == WorkflowInstanceStatusTimedOut || isDeadlineExceeded(workflowInstance) {
+				// 超过截止时间, 不再执行工作流节点
+				return s.timeoutWorkflowInstance(ctx, state, workflowDefinition)
+			}
+			for {
+				err = s.executeWorkflowDAG(ctx, state, workflowDefinition)
+				if err != nil || !state.isLoopFired {
+					break
+				}
+				// 循环边生效后回到的节点在拓扑序的前面, 再执行一轮, 循环次数有上限, 不会一直执行
+				state.isLoopFired = false
+			}
+			if err == nil && !IsOverWorkflowInstanceStatus(workflowInstance.Status) {
+				// 记录下次需要执行的时间, 调度器跳过还没有到时间的实例
+				nextWakeUpAt := state.nextWakeUpAt(time.Now().Unix())
+				if nextWakeUpAt != latestWorkflowInstance.NextWakeUpAt {
+					err = s.repo.UpdateWorkflowInstance(ctx, &UpdateWorkflowInstanceParams{
+						Where: &UpdateWorkflowInstanceWhere{
+							IDIn: []int64{workflowInstance.ID},
+						},
+						Fields: &UpdateWorkflowInstanceField{
+							NextWakeUpAt: &nextWakeUpAt,
+						},
+						LimitMax: 1,
+					})
+					if err != nil {
+						return errors.WithMessagef(err, "UpdateWorkflowInstance failed, workflowInstanceID: %d", workflowInstance.ID)
+					}
 				}
 			}
-			err = s.visitTaskNodeAndExecute(ctx, workflowInstance, workflowDefinition.RootNode, &taskNodeMap)
 			if err != nil && errors.Is(err, ErrWorkflowTaskFailedWithFailed) {
 				// 工作流取消
 				batchCancelTaskIDs := make([]int64, 0)
@@ -917,11 +1377,19 @@ func (s *WorkflowServiceImpl) RunWorkflow(ctx context.Context, workflowID int64)
 						LimitMax: len(batchCancelTaskIDs),
 					})
 				}
+				// 补偿已经完成的任务, 补偿失败不影响返回的错误, 可以通过CompensateWorkflowInstance继续补偿
+				if compensateErr := s.compensateWorkflowInstance(ctx, workflowInstance.Definitions, workflowInstance.ID); compensateErr != nil {
+					slog.ErrorContext(ctx, fmt.Sprintf("compensateWorkflowInstance failed, workflowInstanceID: %d, err: %v", workflowInstance.ID, compensateErr))
+				}
 			}
 
 			return err
 
 		})
+	if IsOverWorkflowInstanceStatus(workflowInstance.Status) {
+		// 实例已经结束, 开始下一个排队的实例
+		s.tryStartNextQueuedWorkflowInstance(ctx, workflowDefinition, workflowInstance.WorkflowType, workflowInstance.BusinessID)
+	}
 	if err != nil {
 		return errors.WithMessagef(err, "NonBlockingSynchronized failed, workflowInstanceID: %d", workflowInstance.ID)
 	}
@@ -932,7 +1400,8 @@ func (s *WorkflowServiceImpl) CancelWorkflowInstance(ctx context.Context, workfl
 	if workflowInstanceID <= 0 {
 		return errors.Wrapf(ErrWorkflowParamInvalid, "CancelWorkflowInstance failed, workflowInstanceID: %d", workflowInstanceID)
 	}
-	return s.executeLock.NonBlockingSynchronized(ctx,
+	var cancelledInstance *WorkflowInstancePo
+	err := s.executeLock.NonBlockingSynchronized(ctx,
 		workflowOpLockKey(workflowInstanceID),
 		10*time.Minute,
 		func(ctx context.Context) error {
@@ -949,9 +1418,14 @@ func (s *WorkflowServiceImpl) CancelWorkflowInstance(ctx context.Context, workfl
 			if len(workflowInstance) == 0 {
 				return errors.WithMessagef(ErrWorkflowInstanceNotFound, "WorkflowInstance not found, workflowInstanceID: %d", workflowInstanceID)
 			}
+			if workflowInstance[0].Status == WorkflowInstanceStatusCancelled {
+				// 已经取消过, 上次可能在级联取消子工作流或者补偿时失败, 重新执行级联取消和补偿
+				return s.cancelSubWorkflowInstancesAndCompensate(ctx, workflowInstance[0])
+			}
 			if IsOverWorkflowInstanceStatus(workflowInstance[0].Status) {
 				return nil
 			}
+			cancelledInstance = workflowInstance[0]
 			err = s.repo.Transaction(ctx, func(ctx context.Context) error {
 				err = s.repo.UpdateWorkflowInstance(ctx, &UpdateWorkflowInstanceParams{
 					Where: &UpdateWorkflowInstanceWhere{
@@ -995,68 +1469,317 @@ func (s *WorkflowServiceImpl) CancelWorkflowInstance(ctx context.Context, workfl
 				}
 				return nil
 			})
-			return nil
+			if err != nil {
+				return errors.WithMessagef(err, "Transaction failed, workflowInstanceID: %d", workflowInstanceID)
+			}
+			return s.cancelSubWorkflowInstancesAndCompensate(ctx, workflowInstance[0])
 		})
+	if cancelledInstance != nil && cancelledInstance.Status != WorkflowInstanceStatusQueued {
+		// 取消占用并发名额的实例后, 开始下一个排队的实例
+		definition, definitionErr := s.getWorkflowDefinitionForInstance(cancelledInstance)
+		if definitionErr == nil {
+			s.tryStartNextQueuedWorkflowInstance(ctx, definition, cancelledInstance.WorkflowType, cancelledInstance.BusinessID)
+		}
+	}
+	return err
 }
 
-// 访问节点并执行
-// 原则：除触发工作流取消情况提前返回，其余情况都会访问所有的可达节点
-// 本函数有递归操作，不要使用defer，不要使用defer，不要使用defer，重要的事情说三遍
-func (s *WorkflowServiceImpl) visitTaskNodeAndExecute(ctx context.Context, workflowInstance *WorkflowInstance, rootNode *WorkflowTaskNodeDefinition, taskNodeMap *map[string]*WorkflowTaskNode) error {
-	if rootNode == nil {
-		// 不会出现这种情况
-		return errors.New("rootNode is nil")
+// cancelSubWorkflowInstancesAndCompensate 级联取消子工作流之后补偿当前工作流, 调用方需要持有工作流的锁
+// 实例已经是取消状态, 失败之后再次调用CancelWorkflowInstance会从这里继续
+func (s *WorkflowServiceImpl) cancelSubWorkflowInstancesAndCompensate(ctx context.Context, workflowInstance *WorkflowInstancePo) error {
+	if err := s.cancelSubWorkflowInstances(ctx, workflowInstance.ID); err != nil {
+		return err
 	}
-	// 先访问当前节点
-	taskNode, ok := (*taskNodeMap)[rootNode.TaskType]
-	if !ok {
-		// 节点不存在,需要节点需要初始化
-		isInit := true
-		preTasklist := make([]*WorkflowTaskNode, 0)
-		for _, preNode := range rootNode.PreNodes {
-			preNodeInstance, ok := (*taskNodeMap)[preNode.TaskType]
-			if !ok {
-				// 前置节点不存在，当前节点不用执行，直接跳过
-				isInit = false
-				break
-			} else {
-				// 前置节点存在,需要检查前置节点是否完成
-				if preNodeInstance.Status != WorkflowTaskNodeStatusCompleted {
-					isInit = false
-					break
+	// 子工作流取消时已经各自补偿, 最后补偿当前工作流
+	definition, err := s.getWorkflowDefinitionForInstance(workflowInstance)
+	if err != nil {
+		return errors.WithMessagef(err, "GetAndLoadWorkflowDefinition failed, workflowType: %s, version: %d", workflowInstance.WorkflowType, workflowInstance.WorkflowVersion)
+	}
+	return s.compensateWorkflowInstance(ctx, definition, workflowInstance.ID)
+}
+
+// cancelSubWorkflowInstances 取消所有未结束的子工作流实例
+func (s *WorkflowServiceImpl) cancelSubWorkflowInstances(ctx context.Context, parentWorkflowInstanceID int64) error {
+	children, err := s.repo.QueryWorkflowInstance(ctx, &QueryWorkflowInstanceParams{
+		ParentWorkflowInstanceID: &parentWorkflowInstanceID,
+		StatusIn:                 []string{WorkflowInstanceStatusInit, WorkflowInstanceStatusRunning, WorkflowInstanceStatusPaused, WorkflowInstanceStatusQueued},
+		Page:                     &Pager{IsNoLimit: Bool(true)},
+	})
+	if err != nil {
+		return errors.WithMessagef(err, "QueryWorkflowInstance failed, parentWorkflowInstanceID: %d", parentWorkflowInstanceID)
+	}
+	for _, child := range children {
+		if err := s.CancelWorkflowInstance(ctx, child.ID); err != nil {
+			return errors.WithMessagef(err, "CancelWorkflowInstance sub workflow failed, workflowInstanceID: %d", child.ID)
+		}
+	}
+	return nil
+}
+
+// workflowRunState 单次RunWorkflow的运行状态,并行执行分支时在多个goroutine之间共享
+type workflowRunState struct {
+	workflowInstance *WorkflowInstance
+	// mu 保护taskNodeMap以及workflowInstance的状态字段
+	mu          sync.Mutex
+	taskNodeMap map[string]*WorkflowTaskNode
+	// parallelSem 并行执行的信号量,控制单个实例同时执行的节点数量,nil表示串行执行
+	parallelSem chan struct{}
+	// isLoopFired 本轮执行中是否有循环边生效, 生效后需要再执行一轮才能处理回到的节点
+	isLoopFired bool
+}
+
+func newWorkflowRunState(workflowInstance *WorkflowInstance, taskNodeMap map[string]*WorkflowTaskNode, maxParallelism int64) *workflowRunState {
+	state := &workflowRunState{
+		workflowInstance: workflowInstance,
+		taskNodeMap:      taskNodeMap,
+	}
+	if maxParallelism > 1 {
+		state.parallelSem = make(chan struct{}, maxParallelism)
+	}
+	return state
+}
+
+func (st *workflowRunState) getTaskNode(taskType string) (*WorkflowTaskNode, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	taskNode, ok := st.taskNodeMap[taskType]
+	return taskNode, ok
+}
+
+func (st *workflowRunState) setTaskNode(taskType string, taskNode *WorkflowTaskNode) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.taskNodeMap[taskType] = taskNode
+}
+
+// setTaskNodeStatus 修改节点状态,其他分支会读取前置节点状态,需要加锁
+func (st *workflowRunState) setTaskNodeStatus(taskNode *WorkflowTaskNode, status WorkflowTaskNodeStatus) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	taskNode.Status = status
+}
+
+// acquire 获取一个并行执行名额,串行模式下直接返回
+func (st *workflowRunState) acquire() func() {
+	if st.parallelSem == nil {
+		return func() {}
+	}
+	st.parallelSem <- struct{}{}
+	return func() { <-st.parallelSem }
+}
+
+// executeWorkflowDAG 按照拓扑序执行工作流节点
+// 原则：每个节点在一次RunWorkflow中最多被处理一次, 节点的所有前置节点都处理完之后才会处理该节点
+// 除触发工作流取消情况提前返回，其余情况都会处理所有的节点
+// 串行模式下按照拓扑序依次处理; 并行模式下前置节点都处理完的节点会并发处理, 并发数受parallelSem限制
+func (s *WorkflowServiceImpl) executeWorkflowDAG(ctx context.Context, state *workflowRunState, definition *WorkflowDefinition) error {
+	if state.parallelSem == nil {
+		for _, node := range definition.topologicalNodes {
+			if _, err := s.executeTaskNode(ctx, state, node); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// 并行模式, 记录每个节点还有多少前置节点没有处理
+	mu := sync.Mutex{}
+	remainingPreNodes := make(map[string]int, len(definition.topologicalNodes))
+	for _, node := range definition.topologicalNodes {
+		remainingPreNodes[node.TaskType] = len(node.PreNodes)
+	}
+	var firstErr error
+	wg := sync.WaitGroup{}
+	var schedule func(node *WorkflowTaskNodeDefinition)
+	schedule = func(node *WorkflowTaskNodeDefinition) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := s.executeTaskNode(ctx, state, node)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+			if firstErr != nil {
+				// 工作流已经失败, 不再处理后续节点
+				return
+			}
+			for _, nextNode := range node.NextNodes {
+				remainingPreNodes[nextNode.TaskType]--
+				if remainingPreNodes[nextNode.TaskType] == 0 {
+					schedule(nextNode)
 				}
-				preTasklist = append(preTasklist, &WorkflowTaskNode{
-					ID:                 preNodeInstance.ID,
-					WorkflowInstanceID: preNodeInstance.WorkflowInstanceID,
-					TaskType:           preNodeInstance.TaskType,
-					Status:             preNodeInstance.Status,
-					NodeContext:        preNodeInstance.NodeContext,
-					UpdatedAt:          preNodeInstance.UpdatedAt,
-					FailCount:          preNodeInstance.FailCount,
-				})
 			}
+		}()
+	}
+	schedule(definition.RootNode)
+	wg.Wait()
+	return firstErr
+}
+
+// preTaskNodesResult 前置节点的汇聚结果
+type preTaskNodesResult struct {
+	preTasklist []*WorkflowTaskNode // 命中分支的前置节点(已完成且分支条件满足)
+	isReady     bool                // 是否满足汇聚条件
+	isSkip      bool                // 汇聚条件无法满足, 当前节点需要跳过
+	// 满足汇聚条件时还没有处理完的前置节点
+	unfinishedPreNodes []*WorkflowTaskNodeDefinition
+}
+
+// collectReadyPreTaskNodes 按照节点的汇聚方式收集前置节点
+// 已完成且分支条件满足的前置节点算作命中, 跳过或者分支条件不满足的前置节点算作已处理但未命中
+// all: 所有前置节点都处理完才算准备好, 没有任何命中时当前节点需要跳过
+// any/n_of_m: 命中数量达到要求就算准备好, 剩余的前置节点即使都命中也达不到要求时当前节点需要跳过
+// 结束节点不会被跳过
+func (st *workflowRunState) collectReadyPreTaskNodes(rootNode *WorkflowTaskNodeDefinition) *preTaskNodesResult {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	ret := &preTaskNodesResult{
+		preTasklist:        make([]*WorkflowTaskNode, 0),
+		unfinishedPreNodes: make([]*WorkflowTaskNodeDefinition, 0),
+	}
+	for _, preNode := range rootNode.PreNodes {
+		preNodeInstance, ok := st.taskNodeMap[preNode.TaskType]
+		if !ok {
+			// 前置节点不存在，还没有处理完
+			ret.unfinishedPreNodes = append(ret.unfinishedPreNodes, preNode)
+			continue
 		}
-		if !isInit {
-			// 当前节点不满足初始化条件，直接跳过
-			return nil
+		isOperatorSkipped := isOperatorSkippedTaskNode(preNodeInstance)
+		if preNodeInstance.Status == WorkflowTaskNodeStatusSkipped && !isOperatorSkipped {
+			// 前置节点被跳过, 当作已满足, 但是不走这条分支
+			continue
 		}
-
-		preNodeAllContext := make(map[string]interface{})
-		for _, preTask := range preTasklist {
-			preNodeMap := preTask.NodeContext.ToMap()
-			// 删除pre_node_context和workflow_context,不用追溯到上层
-			delete(preNodeMap, "pre_node_context") // 不追溯到上层
-			delete(preNodeMap, "workflow_context") // 冗余字段
-			delete(preNodeMap, "system")           // 上层的系统相关参数不用保存
-			preNodeAllContext[preTask.TaskType] = preNodeMap
+		// 前置节点存在,需要检查前置节点是否完成, 人工跳过的节点当作完成处理, 后置节点继续执行
+		if preNodeInstance.Status != WorkflowTaskNodeStatusCompleted && !isOperatorSkipped {
+			ret.unfinishedPreNodes = append(ret.unfinishedPreNodes, preNode)
+			continue
+		}
+		if !preNode.NextNodeConditions[rootNode.TaskType].Evaluate(preNodeInstance.NodeContext) {
+			// 分支条件不满足
+			continue
+		}
+		ret.preTasklist = append(ret.preTasklist, preNodeInstance)
+	}
+	requiredCount := rootNode.Join.requiredCount()
+	if requiredCount <= 0 {
+		// 需要所有前置节点都处理完
+		ret.isReady = len(ret.unfinishedPreNodes) == 0
+		ret.isSkip = ret.isReady && len(rootNode.PreNodes) > 0 && len(ret.preTasklist) == 0
+	} else {
+		ret.isReady = len(ret.preTasklist) >= requiredCount
+		if !ret.isReady && len(ret.preTasklist)+len(ret.unfinishedPreNodes) < requiredCount {
+			// 汇聚条件已经无法满足
+			ret.isReady = true
+			ret.isSkip = true
 		}
+	}
+	if ret.isSkip && rootNode.TaskType == endTaskNode {
+		ret.isSkip = false
+	}
+	return ret
+}
 
-		newNodeContext := NewJSONContextFromMap(map[string]any{
-			"pre_node_context": preNodeAllContext,
-			"workflow_context": workflowInstance.WorkflowContext.ToMap(),
+// cancelUnfinishedPreTaskNodes 汇聚节点开始执行时, 取消还没有处理完的前置节点, 标记为跳过
+func (s *WorkflowServiceImpl) cancelUnfinishedPreTaskNodes(ctx context.Context, state *workflowRunState, rootNode *WorkflowTaskNodeDefinition, preNodes []*WorkflowTaskNodeDefinition) error {
+	workflowInstance := state.workflowInstance
+	reason := fmt.Sprintf("cancelled by join node %s", rootNode.TaskType)
+	for _, preNode := range preNodes {
+		preNodeInstance, ok := state.getTaskNode(preNode.TaskType)
+		if !ok {
+			// 前置节点还没有创建, 直接创建一个跳过的节点, 避免后续被执行
+			nodeContext := NewJSONContext(nil)
+			nodeContext.Set([]string{NodeContextKeyReason}, reason)
+			taskInstancePo, err := s.repo.CreateWorkflowTaskInstance(ctx, &WorkflowTaskInstancePo{
+				WorkflowInstanceID: workflowInstance.ID,
+				TaskType:           preNode.TaskType,
+				Status:             WorkflowTaskNodeStatusSkipped,
+				NodeContext:        nodeContext.ToBytesWithoutError(),
+				CreatedAt:          time.Now().Unix(),
+				UpdatedAt:          time.Now().Unix(),
+			})
+			if err != nil {
+				return errors.WithMessagef(err, "CreateWorkflowTaskInstance failed, workflowInstanceID: %d, taskType: %s", workflowInstance.ID, preNode.TaskType)
+			}
+			state.setTaskNode(preNode.TaskType, &WorkflowTaskNode{
+				ID:                 taskInstancePo.ID,
+				WorkflowInstanceID: taskInstancePo.WorkflowInstanceID,
+				TaskType:           taskInstancePo.TaskType,
+				Status:             taskInstancePo.Status,
+				NodeContext:        nodeContext,
+				CreatedAt:          taskInstancePo.CreatedAt,
+				UpdatedAt:          taskInstancePo.UpdatedAt,
+			})
+			continue
+		}
+		if IsOverWorkflowTaskNodeStatus(preNodeInstance.Status) {
+			continue
+		}
+		preNodeInstance.NodeContext.Set([]string{NodeContextKeyReason}, reason)
+		err := s.repo.UpdateWorkflowTaskInstance(ctx, &UpdateWorkflowTaskInstanceParams{
+			Where: &UpdateWorkflowTaskInstanceWhere{
+				IDIn: []int64{preNodeInstance.ID},
+			},
+			Fields: &UpdateWorkflowTaskInstanceField{
+				Status:      String(WorkflowTaskNodeStatusSkipped),
+				NodeContext: preNodeInstance.NodeContext,
+			},
+			LimitMax: 1,
 		})
+		if err != nil {
+			return errors.WithMessagef(err, "UpdateWorkflowTaskInstance failed, workflowInstanceID: %d, taskType: %s", workflowInstance.ID, preNode.TaskType)
+		}
+		state.setTaskNodeStatus(preNodeInstance, WorkflowTaskNodeStatusSkipped)
+	}
+	return nil
+}
+
+// buildTaskNodeContext 根据前置节点构建节点上下文
+func buildTaskNodeContext(workflowInstance *WorkflowInstance, preTasklist []*WorkflowTaskNode) *JSONContext {
+	preNodeAllContext := make(map[string]interface{})
+	for _, preTask := range preTasklist {
+		preNodeMap := preTask.NodeContext.Clone().ToMap()
+		// 删除pre_node_context和workflow_context,不用追溯到上层
+		delete(preNodeMap, "pre_node_context") // 不追溯到上层
+		delete(preNodeMap, "workflow_context") // 冗余字段
+		delete(preNodeMap, "system")           // 上层的系统相关参数不用保存
+		preNodeAllContext[preTask.TaskType] = preNodeMap
+	}
+	return NewJSONContextFromMap(map[string]any{
+		"pre_node_context": preNodeAllContext,
+		"workflow_context": workflowInstance.WorkflowContext.Clone().ToMap(),
+	})
+}
+
+// executeTaskNode 执行单个节点, 返回节点是否已经完成
+// 调用方保证节点的前置节点都已经处理过
+func (s *WorkflowServiceImpl) executeTaskNode(ctx context.Context, state *workflowRunState, rootNode *WorkflowTaskNodeDefinition) (bool, error) {
+	workflowInstance := state.workflowInstance
+
+	taskNode, ok := state.getTaskNode(rootNode.TaskType)
+	if !ok {
+		// 节点不存在,需要节点需要初始化
+		preResult := state.collectReadyPreTaskNodes(rootNode)
+		if !preResult.isReady {
+			// 当前节点不满足初始化条件，直接跳过
+			return false, nil
+		}
+		isSkip := preResult.isSkip
+		if !isSkip && rootNode.Join.isCancelRemaining() {
+			if err := s.cancelUnfinishedPreTaskNodes(ctx, state, rootNode, preResult.unfinishedPreNodes); err != nil {
+				return false, errors.WithMessagef(err, "cancelUnfinishedPreTaskNodes failed, workflowInstanceID: %d, taskType: %s", workflowInstance.ID, rootNode.TaskType)
+			}
+		}
+		newNodeContext := buildTaskNodeContext(workflowInstance, preResult.preTasklist)
+		status := WorkflowTaskNodeStatusRunning // 直接设置为running即可
+		if isSkip {
+			// 没有命中任何分支, 节点直接标记为跳过, 方便查询详情
+			status = WorkflowTaskNodeStatusSkipped
+		}
 		if rootNode.TaskType == rootTaskNode {
 			// 根节点初始化,需要额外将workflowInstance 状态转化为runing
+			state.mu.Lock()
 			workflowInstance.Status = WorkflowInstanceStatusRunning
 			err := s.repo.UpdateWorkflowInstance(ctx, &UpdateWorkflowInstanceParams{
 				Where: &UpdateWorkflowInstanceWhere{
@@ -1067,24 +1790,25 @@ func (s *WorkflowServiceImpl) visitTaskNodeAndExecute(ctx context.Context, workf
 				},
 				LimitMax: 1,
 			})
+			state.mu.Unlock()
 			if err != nil {
-				return errors.WithMessagef(err, "UpdateWorkflowInstance failed, workflowInstanceID: %d", workflowInstance.ID)
+				return false, errors.WithMessagef(err, "UpdateWorkflowInstance failed, workflowInstanceID: %d", workflowInstance.ID)
 			}
 		}
 		// 创建任务实例
 		taskInstancePo, err := s.repo.CreateWorkflowTaskInstance(ctx, &WorkflowTaskInstancePo{
 			WorkflowInstanceID: workflowInstance.ID,
 			TaskType:           rootNode.TaskType,
-			Status:             WorkflowInstanceStatusRunning, // 直接设置为running即可
+			Status:             status,
 			NodeContext:        newNodeContext.ToBytesWithoutError(),
 			CreatedAt:          time.Now().Unix(),
 			UpdatedAt:          time.Now().Unix(),
 		})
 		if err != nil {
-			return errors.WithMessagef(err, "CreateWorkflowTaskInstance failed, workflowInstanceID: %d, taskType: %s", workflowInstance.ID, rootNode.TaskType)
+			return false, errors.WithMessagef(err, "CreateWorkflowTaskInstance failed, workflowInstanceID: %d, taskType: %s", workflowInstance.ID, rootNode.TaskType)
 		}
 
-		taskInstanceNode := &WorkflowTaskNode{
+		taskNode = &WorkflowTaskNode{
 			ID:                 taskInstancePo.ID,
 			WorkflowInstanceID: taskInstancePo.WorkflowInstanceID,
 			TaskType:           taskInstancePo.TaskType,
@@ -1094,37 +1818,28 @@ func (s *WorkflowServiceImpl) visitTaskNodeAndExecute(ctx context.Context, workf
 			UpdatedAt:          taskInstancePo.UpdatedAt,
 			FailCount:          taskInstancePo.FailCount,
 		}
-		(*taskNodeMap)[rootNode.TaskType] = taskInstanceNode
-		if err := s.taskRun(ctx, workflowInstance, rootNode, taskInstanceNode); err != nil {
-			if errors.Is(err, ErrWorkflowTaskFailedWithFailed) {
-				return errors.WithMessagef(err, "TaskRun failed with cancel, workflowInstanceID: %d, taskType: %s", workflowInstance.ID, rootNode.TaskType)
-			}
-			if IsSeriousError(err) {
-				slog.ErrorContext(ctx, fmt.Sprintf("[error]TaskRun failed, workflowInstanceID: %d, taskType: %s, err: %v", workflowInstance.ID, rootNode.TaskType, err))
-			} else {
-				slog.WarnContext(ctx, fmt.Sprintf("[warn]TaskRun failed, workflowInstanceID: %d, taskType: %s, err: %v", workflowInstance.ID, rootNode.TaskType, err))
-			}
-			// 其他的错误，需要记录日志,不需要返回错误，继续check后续节点
-			return nil
+		state.setTaskNode(rootNode.TaskType, taskNode)
+		if isSkip {
+			return false, nil
 		}
 	} else {
 		// 当前节点存在，需要检查当前节点是否完成
 		if taskNode.Status == WorkflowTaskNodeStatusCompleted {
 			// 当前节点已完成,不需要执行该节点,遍历他的子节点即可
-			for _, nextNode := range rootNode.NextNodes {
-				err := s.visitTaskNodeAndExecute(ctx, workflowInstance, nextNode, taskNodeMap)
-				if err != nil {
-					return errors.WithMessagef(err, "visitTaskNodeAndExecute failed, workflowInstanceID: %d, taskType: %s", workflowInstance.ID, rootNode.TaskType)
-				}
-			}
-			return nil
+			return true, nil
+		}
+		if taskNode.Status == WorkflowTaskNodeStatusSkipped {
+			// 当前节点已跳过,不需要执行
+			return false, nil
 		}
 		// 当前节点未完成，需要执行该节点
 		if taskNode.Status == WorkflowTaskNodeStatusCancelled || taskNode.Status == WorkflowTaskNodeStatusFailed {
 			// 当前节点已取消或者失败，直接返回,不需要再处理
+			state.mu.Lock()
+			defer state.mu.Unlock()
 			if workflowInstance.Status == WorkflowInstanceStatusCancelled || workflowInstance.Status == WorkflowInstanceStatusFailed {
 				// 工作流已经取消，直接返回
-				return errors.WithMessagef(ErrWorkflowTaskFailedWithFailed, "TaskRun failed with cancel, workflowInstanceID: %d, taskType: %s", workflowInstance.ID, rootNode.TaskType)
+				return false, errors.WithMessagef(ErrWorkflowTaskFailedWithFailed, "TaskRun failed with cancel, workflowInstanceID: %d, taskType: %s", workflowInstance.ID, rootNode.TaskType)
 			}
 			originalStatus := workflowInstance.Status
 			if taskNode.Status == WorkflowTaskNodeStatusCancelled {
@@ -1148,94 +1863,94 @@ func (s *WorkflowServiceImpl) visitTaskNodeAndExecute(ctx context.Context, workf
 				workflowInstance.Status = originalStatus
 				slog.ErrorContext(ctx, fmt.Sprintf("UpdateWorkflowInstance failed,err: %v", newErr))
 			}
-			return errors.WithMessagef(ErrWorkflowTaskFailedWithFailed, "TaskRun failed with cancel, workflowInstanceID: %d, taskType: %s", workflowInstance.ID, rootNode.TaskType)
+			return false, errors.WithMessagef(ErrWorkflowTaskFailedWithFailed, "TaskRun failed with cancel, workflowInstanceID: %d, taskType: %s", workflowInstance.ID, rootNode.TaskType)
 		}
 		// 重新启动状态，当作重新初始化处理
 		if taskNode.Status == WorkflowTaskNodeStatusRestarting {
-
 			// 任务实例初始化状态,需要重新启动
-			isReady := true
-			preTasklist := make([]*WorkflowTaskNode, 0)
-			for _, preNode := range rootNode.PreNodes {
-				preNodeInstance, ok := (*taskNodeMap)[preNode.TaskType]
-				if !ok {
-					// 前置节点不存在，当前节点不用执行，直接跳过
-					isReady = false
-					break
-				} else {
-					// 前置节点存在,需要检查前置节点是否完成
-					if preNodeInstance.Status != WorkflowTaskNodeStatusCompleted {
-						isReady = false
-						break
-					}
-					preTasklist = append(preTasklist, preNodeInstance)
+			preResult := state.collectReadyPreTaskNodes(rootNode)
+			if !preResult.isReady {
+				// 任务实例未准备好，直接返回
+				return false, nil
+			}
+			isSkip := preResult.isSkip
+			if !isSkip && rootNode.Join.isCancelRemaining() {
+				if err := s.cancelUnfinishedPreTaskNodes(ctx, state, rootNode, preResult.unfinishedPreNodes); err != nil {
+					return false, errors.WithMessagef(err, "cancelUnfinishedPreTaskNodes failed, workflowInstanceID: %d, taskType: %s", workflowInstance.ID, rootNode.TaskType)
 				}
 			}
-			if !isReady {
-				// 任务实例未准备好，直接返回
-				return nil
+			// 重新初始化节点上下文, 分支条件需要重新求值
+			if isSkip {
+				state.setTaskNodeStatus(taskNode, WorkflowTaskNodeStatusSkipped)
+			} else {
+				state.setTaskNodeStatus(taskNode, WorkflowTaskNodeStatusRunning)
 			}
-			// 重新初始化节点上下文
-			taskNode.Status = WorkflowTaskNodeStatusRunning
-			preNodeAllContext := make(map[string]interface{})
-			for _, preTask := range preTasklist {
-				preNodeMap := preTask.NodeContext.ToMap()
-				delete(preNodeMap, "pre_node_context") // 不追溯到上层
-				delete(preNodeMap, "workflow_context") // 冗余字段
-				delete(preNodeMap, "system")           // 上层的系统相关参数不用保存
-				preNodeAllContext[preTask.TaskType] = preNodeMap
-			}
-			newNodeContext := NewJSONContextFromMap(map[string]any{
-				"pre_node_context": preNodeAllContext,
-				"workflow_context": workflowInstance.WorkflowContext.ToMap(),
-			})
+			loopIterations, hasLoopIterations := taskNode.NodeContext.Get(NodeContextKeySystem, nodeContextKeyLoopIterations)
+			restartCount, _ := taskNode.NodeContext.GetInt64(NodeContextKeySystem, nodeContextKeyRestartCount)
+			taskNode.NodeContext = buildTaskNodeContext(workflowInstance, preResult.preTasklist)
+			if hasLoopIterations {
+				// 循环次数需要保留, 否则循环边的次数上限不会生效
+				taskNode.NodeContext.Set([]string{NodeContextKeySystem, nodeContextKeyLoopIterations}, loopIterations)
+			}
+			taskNode.NodeContext.Set([]string{NodeContextKeySystem, nodeContextKeyRestartCount}, restartCount+1)
 			err := s.repo.UpdateWorkflowTaskInstance(ctx, &UpdateWorkflowTaskInstanceParams{
 				Where: &UpdateWorkflowTaskInstanceWhere{
 					IDIn: []int64{taskNode.ID},
 				},
 				Fields: &UpdateWorkflowTaskInstanceField{
-					NodeContext: newNodeContext,
+					NodeContext: taskNode.NodeContext,
 					Status:      &taskNode.Status,
 				},
 			})
 			if err != nil {
-				return errors.WithMessagef(err, "CreateWorkflowTaskInstance failed, workflowInstanceID: %d, taskType: %s", workflowInstance.ID, rootNode.TaskType)
-			}
-
-		}
-		err := s.taskRun(ctx, workflowInstance, rootNode, taskNode)
-		if err != nil {
-			if errors.Is(err, ErrWorkflowTaskFailedWithFailed) {
-				return errors.WithMessagef(err, "TaskRun failed with cancel, workflowInstanceID: %d, taskType: %s", workflowInstance.ID, rootNode.TaskType)
+				return false, errors.WithMessagef(err, "CreateWorkflowTaskInstance failed, workflowInstanceID: %d, taskType: %s", workflowInstance.ID, rootNode.TaskType)
 			}
-			if IsSeriousError(err) {
-				slog.ErrorContext(ctx, fmt.Sprintf("[error]TaskRun failed, workflowInstanceID: %d, taskType: %s, err: %v", workflowInstance.ID, rootNode.TaskType, err))
-			} else {
-				slog.WarnContext(ctx, fmt.Sprintf("[warn]TaskRun failed, workflowInstanceID: %d, taskType: %s, err: %v", workflowInstance.ID, rootNode.TaskType, err))
+			if isSkip {
+				return false, nil
 			}
-			// 其他的错误，需要记录日志,不需要返回错误，继续check后续节点
-			return nil
 		}
 	}
-	// 当前节点完成，不需要再处理，处理后续节点
-	for _, nextNode := range rootNode.NextNodes {
-		err := s.visitTaskNodeAndExecute(ctx, workflowInstance, nextNode, taskNodeMap)
+	if taskNode.NextRetryAt > time.Now().Unix() || taskNode.WakeUpAt > time.Now().Unix() {
+		// 还没有到下次重试时间或者延时节点的唤醒时间, 本次不执行
+		return false, nil
+	}
+	release := state.acquire()
+	err := s.taskRun(ctx, state, rootNode, taskNode)
+	release()
+	if err != nil {
+		if errors.Is(err, ErrWorkflowTaskFailedWithFailed) {
+			return false, errors.WithMessagef(err, "TaskRun failed with cancel, workflowInstanceID: %d, taskType: %s", workflowInstance.ID, rootNode.TaskType)
+		}
+		if IsSeriousError(err) {
+			slog.ErrorContext(ctx, fmt.Sprintf("[error]TaskRun failed, workflowInstanceID: %d, taskType: %s, err: %v", workflowInstance.ID, rootNode.TaskType, err))
+		} else {
+			slog.WarnContext(ctx, fmt.Sprintf("[warn]TaskRun failed, workflowInstanceID: %d, taskType: %s, err: %v", workflowInstance.ID, rootNode.TaskType, err))
+		}
+		// 其他的错误，需要记录日志,不需要返回错误，继续check后续节点
+		return false, nil
+	}
+	if taskNode.Status == WorkflowTaskNodeStatusCompleted && len(rootNode.Loops) > 0 {
+		// 节点刚完成, 检查是否需要回到循环的起点
+		isFired, err := s.fireLoopEdges(ctx, state, rootNode, taskNode)
 		if err != nil {
-			return errors.WithMessagef(err, "visitTaskNodeAndExecute failed, workflowInstanceID: %d, taskType: %s", workflowInstance.ID, rootNode.TaskType)
+			slog.ErrorContext(ctx, fmt.Sprintf("[error]fireLoopEdges failed, workflowInstanceID: %d, taskType: %s, err: %v", workflowInstance.ID, rootNode.TaskType, err))
+			return false, nil
+		}
+		if isFired {
+			return false, nil
 		}
 	}
-
-	return nil
-
+	return taskNode.Status == WorkflowTaskNodeStatusCompleted, nil
 }
 
-func (s *WorkflowServiceImpl) taskRun(ctx context.Context, workflowInstance *WorkflowInstance, taskNode *WorkflowTaskNodeDefinition, taskInstance *WorkflowTaskNode) (err error) {
+func (s *WorkflowServiceImpl) taskRun(ctx context.Context, state *workflowRunState, taskNode *WorkflowTaskNodeDefinition, taskInstance *WorkflowTaskNode) (err error) {
 	if taskNode == nil {
 		return errors.New("taskNode is nil")
 	}
 	if taskInstance == nil {
 		return errors.New("taskInstance is nil")
 	}
+	workflowInstance := state.workflowInstance
 	// 针对不同的错误给不同的处理逻辑
 	defer func() {
 		// panic 捕捉一下，返回给上方
@@ -1277,7 +1992,7 @@ func (s *WorkflowServiceImpl) taskRun(ctx context.Context, workflowInstance *Wor
 			}
 			// 任务实例失败，但是可以继续执行，当作完成处理
 			if errors.Is(err, ErrorWorkflowTaskFailedWithContinue) {
-				taskInstance.Status = WorkflowTaskNodeStatusCompleted
+				state.setTaskNodeStatus(taskInstance, WorkflowTaskNodeStatusCompleted)
 				taskInstance.UpdatedAt = time.Now().Unix()
 				taskInstance.FailCount++
 				// 	这个有报错，就不处理了
@@ -1289,6 +2004,7 @@ func (s *WorkflowServiceImpl) taskRun(ctx context.Context, workflowInstance *Wor
 						Status:      &taskInstance.Status,
 						NodeContext: taskInstance.NodeContext,
 						FailCount:   &taskInstance.FailCount,
+						CompletedAt: &taskInstance.UpdatedAt,
 					},
 					LimitMax: 1,
 				})
@@ -1307,7 +2023,12 @@ func (s *WorkflowServiceImpl) taskRun(ctx context.Context, workflowInstance *Wor
 			if errors.Is(err, ErrWorkflowTaskFailedWithFailed) {
 				// task 任务转化为cancle
 				originalStatus := taskInstance.Status
-				taskInstance.Status = WorkflowTaskNodeStatusFailed
+				taskStatus, workflowStatus := WorkflowTaskNodeStatusFailed, WorkflowInstanceStatusFailed
+				if errors.Is(err, ErrWorkflowTaskCancelled) {
+					// 任务被取消, 工作流也标记为取消
+					taskStatus, workflowStatus = WorkflowTaskNodeStatusCancelled, WorkflowInstanceStatusCancelled
+				}
+				state.setTaskNodeStatus(taskInstance, taskStatus)
 				taskInstance.FailCount++
 				taskInstance.UpdatedAt = time.Now().Unix()
 				newErr := s.repo.UpdateWorkflowTaskInstance(ctx, &UpdateWorkflowTaskInstanceParams{
@@ -1322,11 +2043,19 @@ func (s *WorkflowServiceImpl) taskRun(ctx context.Context, workflowInstance *Wor
 					LimitMax: 1,
 				})
 				if newErr != nil {
-					taskInstance.Status = originalStatus
+					state.setTaskNodeStatus(taskInstance, originalStatus)
 					err = errors.WithMessagef(err, "UpdateWorkflowTaskInstance failed,err: %v", newErr)
 					return
 				}
+				if taskInstance.ItemIndex != nil {
+					// map节点的子任务只更新子任务自己, map节点和工作流实例的状态由map节点决定
+					err = errors.WithMessagef(err, "TaskRun map item failed, workflowInstanceID: %d, taskType: %s, itemIndex: %d", workflowInstance.ID, taskNode.TaskType, *taskInstance.ItemIndex)
+					return
+				}
 
+				// 并行分支可能同时失败，工作流状态的修改需要加锁
+				state.mu.Lock()
+				defer state.mu.Unlock()
 				if workflowInstance.Status == WorkflowInstanceStatusCancelled || workflowInstance.Status == WorkflowInstanceStatusFailed {
 					// 工作流已经取消，直接返回nil
 					err = errors.WithMessagef(err, "TaskRun failed with cancel, workflowInstanceID: %d, taskType: %s", workflowInstance.ID, taskNode.TaskType)
@@ -1334,7 +2063,7 @@ func (s *WorkflowServiceImpl) taskRun(ctx context.Context, workflowInstance *Wor
 				}
 
 				originalStatus = workflowInstance.Status
-				workflowInstance.Status = WorkflowInstanceStatusFailed
+				workflowInstance.Status = workflowStatus
 				workflowInstance.UpdatedAt = time.Now().Unix()
 				newErr = s.repo.UpdateWorkflowInstance(ctx, &UpdateWorkflowInstanceParams{
 					Where: &UpdateWorkflowInstanceWhere{
@@ -1357,6 +2086,10 @@ func (s *WorkflowServiceImpl) taskRun(ctx context.Context, workflowInstance *Wor
 			// 其他的err 情况，需要记录失败次数
 			taskInstance.FailCount++
 			taskInstance.UpdatedAt = time.Now().Unix()
+			if taskNode.RetryPolicy != nil {
+				// 按照重试策略计算下次重试时间, 到期之前不会再执行
+				taskInstance.NextRetryAt = time.Now().Add(taskNode.RetryPolicy.NextInterval(taskInstance.FailCount)).Unix()
+			}
 			newErr := s.repo.UpdateWorkflowTaskInstance(ctx, &UpdateWorkflowTaskInstanceParams{
 				Where: &UpdateWorkflowTaskInstanceWhere{
 					IDIn: []int64{taskInstance.ID},
@@ -1364,6 +2097,7 @@ func (s *WorkflowServiceImpl) taskRun(ctx context.Context, workflowInstance *Wor
 				Fields: &UpdateWorkflowTaskInstanceField{
 					FailCount:   &taskInstance.FailCount,
 					NodeContext: taskInstance.NodeContext,
+					NextRetryAt: &taskInstance.NextRetryAt,
 				},
 				LimitMax: 1,
 			})
@@ -1374,13 +2108,14 @@ func (s *WorkflowServiceImpl) taskRun(ctx context.Context, workflowInstance *Wor
 
 		}
 	}()
+	taskWorker := s.getTaskWorker(state, taskNode, taskInstance)
 	if taskInstance.Status == WorkflowTaskNodeStatusRunning {
-		err := taskNode.TaskWorker.Run(ctx, taskInstance.NodeContext)
+		err := s.callTaskWorker(ctx, taskNode, taskInstance, taskWorker.Run)
 		if err != nil {
 			return errors.WithMessagef(err, "Run failed, workflowInstanceID: %d, taskType: %s", workflowInstance.ID, taskNode.TaskType)
 		}
 		// 更新任务实例状态到pending
-		taskInstance.Status = WorkflowTaskNodeStatusPending
+		state.setTaskNodeStatus(taskInstance, WorkflowTaskNodeStatusPending)
 		taskInstance.UpdatedAt = time.Now().Unix()
 		err = s.repo.UpdateWorkflowTaskInstance(ctx, &UpdateWorkflowTaskInstanceParams{
 			Where: &UpdateWorkflowTaskInstanceWhere{
@@ -1389,6 +2124,7 @@ func (s *WorkflowServiceImpl) taskRun(ctx context.Context, workflowInstance *Wor
 			Fields: &UpdateWorkflowTaskInstanceField{
 				Status:      &taskInstance.Status,
 				NodeContext: taskInstance.NodeContext,
+				WakeUpAt:    &taskInstance.WakeUpAt,
 			},
 			LimitMax: 1,
 		})
@@ -1397,11 +2133,11 @@ func (s *WorkflowServiceImpl) taskRun(ctx context.Context, workflowInstance *Wor
 		}
 	}
 	if taskInstance.Status == WorkflowTaskNodeStatusPending {
-		err := taskNode.TaskWorker.AsynchronousWaitCheck(ctx, taskInstance.NodeContext)
+		err := s.callTaskWorker(ctx, taskNode, taskInstance, taskWorker.AsynchronousWaitCheck)
 		if err != nil {
 			return errors.WithMessagef(err, "AsynchronousWaitCheck failed, workflowInstanceID: %d, taskType: %s", workflowInstance.ID, taskNode.TaskType)
 		}
-		taskInstance.Status = WorkflowTaskNodeStatusFinishing
+		state.setTaskNodeStatus(taskInstance, WorkflowTaskNodeStatusFinishing)
 		taskInstance.UpdatedAt = time.Now().Unix()
 
 		err = s.repo.UpdateWorkflowTaskInstance(ctx, &UpdateWorkflowTaskInstanceParams{
@@ -1419,14 +2155,15 @@ func (s *WorkflowServiceImpl) taskRun(ctx context.Context, workflowInstance *Wor
 		}
 	}
 	if taskInstance.Status == WorkflowTaskNodeStatusFinishing {
-		taskInstance.Status = WorkflowTaskNodeStatusCompleted
+		state.setTaskNodeStatus(taskInstance, WorkflowTaskNodeStatusCompleted)
 		taskInstance.UpdatedAt = time.Now().Unix()
 		err := s.repo.UpdateWorkflowTaskInstance(ctx, &UpdateWorkflowTaskInstanceParams{
 			Where: &UpdateWorkflowTaskInstanceWhere{
 				IDIn: []int64{taskInstance.ID},
 			},
 			Fields: &UpdateWorkflowTaskInstanceField{
-				Status: &taskInstance.Status,
+				Status:      &taskInstance.Status,
+				CompletedAt: &taskInstance.UpdatedAt,
 			},
 			LimitMax: 1,
 		})
@@ -1435,6 +2172,8 @@ func (s *WorkflowServiceImpl) taskRun(ctx context.Context, workflowInstance *Wor
 		}
 		if taskNode.TaskType == endTaskNode {
 			// 根节点完成,需要额外将workflowInstance 状态转化为completed
+			state.mu.Lock()
+			defer state.mu.Unlock()
 			workflowInstance.Status = WorkflowInstanceStatusCompleted
 			workflowInstance.UpdatedAt = time.Now().Unix()
 			err := s.repo.UpdateWorkflowInstance(ctx, &UpdateWorkflowInstanceParams{
@@ -1454,6 +2193,54 @@ func (s *WorkflowServiceImpl) taskRun(ctx context.Context, workflowInstance *Wor
 	return nil
 }
 
+// getTaskWorker 获取节点的工作器, 内置类型的节点需要绑定当前service、工作流实例以及任务实例
+func (s *WorkflowServiceImpl) getTaskWorker(state *workflowRunState, taskNode *WorkflowTaskNodeDefinition, taskInstance *WorkflowTaskNode) WorkflowTaskNodeWorker {
+	switch taskNode.Kind {
+	case NodeKindSubWorkflow:
+		return &subWorkflowTaskWorker{service: s, workflowInstance: state.workflowInstance, taskInstance: taskInstance, config: taskNode.SubWorkflow}
+	case NodeKindDelay:
+		return &delayTaskWorker{taskInstance: taskInstance, config: taskNode.Delay}
+	case NodeKindWaitEvent:
+		return &waitEventTaskWorker{taskInstance: taskInstance, maxWaitTimeTs: taskNode.MaxWaitTimeTs, config: taskNode.WaitEvent}
+	case NodeKindMap:
+		return &mapTaskWorker{service: s, state: state, taskNode: taskNode, taskInstance: taskInstance}
+	}
+	return taskNode.TaskWorker
+}
+
+// callTaskWorker 调用工作器, 节点配置了执行超时时ctx带上deadline, 超时后不再等待工作器返回
+// 超时的情况下工作器可能还在执行, 工作器使用的是节点上下文的副本, 超时之后的修改不会保存
+// 内置类型的节点由框架实现, 不受执行超时限制, map节点的子任务使用map节点的执行超时
+func (s *WorkflowServiceImpl) callTaskWorker(ctx context.Context, taskNode *WorkflowTaskNodeDefinition, taskInstance *WorkflowTaskNode, call func(ctx context.Context, nodeContext *JSONContext) error) error {
+	if taskNode.ExecutionTimeout <= 0 || taskNode.Kind != NodeKindNormal {
+		return call(ctx, taskInstance.NodeContext)
+	}
+	timeoutCtx, cancel := context.WithTimeout(ctx, time.Duration(taskNode.ExecutionTimeout)*time.Second)
+	defer cancel()
+	nodeContext := taskInstance.NodeContext.Clone()
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			// 不在taskRun的goroutine中, panic需要单独捕捉
+			if r := recover(); r != nil {
+				done <- errors.Errorf("taskRun panic: %v, stack: %s", r, string(debug.Stack()))
+			}
+		}()
+		done <- call(timeoutCtx, nodeContext)
+	}()
+	select {
+	case err := <-done:
+		taskInstance.NodeContext = nodeContext
+		if err == nil || timeoutCtx.Err() == nil {
+			return err
+		}
+		// 工作器感知到ctx超时后返回, 同样当作执行超时处理
+	case <-timeoutCtx.Done():
+	}
+	taskInstance.NodeContext.Set([]string{NodeContextKeyReason}, "任务节点执行超时")
+	return errors.WithMessagef(ErrWorkflowTaskExecutionTimeout, "execution timeout: %ds, err: %v", taskNode.ExecutionTimeout, timeoutCtx.Err())
+}
+
 func (s *WorkflowServiceImpl) addTaskNodeContextSystemError(err error, nodeContext *JSONContext) {
 	if nodeContext == nil {
 		return
@@ -1466,63 +2253,76 @@ func (s *WorkflowServiceImpl) addTaskNodeContextSystemError(err error, nodeConte
 	nodeContext.Set([]string{"system", "last_error_time"}, time.Now().Format(time.RFC3339))
 }
 
-func checkNodeDefinitionIsOk(rootNode *WorkflowTaskNodeDefinition) error {
-	if rootNode == nil {
-		return errors.New("rootNode is nil")
+// checkNodeDefinitionIsOk 检查工作流图是否存在环, 并返回节点的拓扑序
+// nodes 需要包含根节点和结束节点, 拓扑序中同一层级的节点保持nodes中的顺序
+func checkNodeDefinitionIsOk(nodes []*WorkflowTaskNodeDefinition) ([]*WorkflowTaskNodeDefinition, error) {
+	if len(nodes) == 0 {
+		return nil, errors.New("nodes is empty")
 	}
-	visitMap := make(map[string]bool)
-	return visitNodeDefinition(rootNode, visitMap)
-}
-func visitNodeDefinition(rootNode *WorkflowTaskNodeDefinition, visitMap map[string]bool) error {
-	if rootNode == nil {
-		return errors.New("rootNode is nil")
+	inDegree := make(map[string]int, len(nodes))
+	for _, node := range nodes {
+		inDegree[node.TaskType] = len(node.PreNodes)
 	}
-	if rootNode.TaskType == endTaskNode {
-		// 达到终点，返回,说明没有环，可以正常执行正常的路径
-		return nil
+	queue := make([]*WorkflowTaskNodeDefinition, 0, len(nodes))
+	for _, node := range nodes {
+		if inDegree[node.TaskType] == 0 {
+			queue = append(queue, node)
+		}
 	}
-	// 不是终点，则需要检查是否已经访问过
-	if visitMap[rootNode.TaskType] {
-		// 已经访问过，说明有环，不能正常执行，返回错误
-		return errors.New("rootNode，taskType: " + rootNode.TaskType + " is already visited, there is a cycle in the workflow")
+	sortedNodes := make([]*WorkflowTaskNodeDefinition, 0, len(nodes))
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		sortedNodes = append(sortedNodes, node)
+		for _, nextNode := range node.NextNodes {
+			inDegree[nextNode.TaskType]--
+			if inDegree[nextNode.TaskType] == 0 {
+				queue = append(queue, nextNode)
+			}
+		}
 	}
-
-	// 没有访问过，则需要标记为已访问
-	visitMap[rootNode.TaskType] = true
-
-	// 递归访问下一个节点
-	for _, nextNode := range rootNode.NextNodes {
-		err := visitNodeDefinition(nextNode, visitMap)
-		if err != nil {
-			return errors.WithMessagef(err, "visitNodeDefinition failed, rootNode: %s, nextNode: %s", rootNode.TaskType, nextNode.TaskType)
+	if len(sortedNodes) != len(nodes) {
+		// 有节点的入度始终不为0, 说明有环, 不能正常执行
+		cycleTaskTypes := make([]string, 0)
+		for _, node := range nodes {
+			if inDegree[node.TaskType] > 0 {
+				cycleTaskTypes = append(cycleTaskTypes, node.TaskType)
+			}
 		}
+		return nil, errors.Errorf("there is a cycle in the workflow, taskTypes: %v", cycleTaskTypes)
 	}
-	visitMap[rootNode.TaskType] = false
-	return nil
+	return sortedNodes, nil
 }
 
 func NewByte2StrctPbValue(b []byte) *JSONContext {
 	return NewJSONContext(b)
 }
 
+// PreloadingWorkflowDefinition 预加载默认Registry中所有的工作流定义
 func PreloadingWorkflowDefinition() error {
-	allWorkflowTypes := make([]string, 0)
+	return defaultRegistry.PreloadingWorkflowDefinition()
+}
+
+// PreloadingWorkflowDefinition 预加载当前Registry中所有的工作流定义, 提前发现配置和工作器的问题
+func (r *Registry) PreloadingWorkflowDefinition() error {
+	// 所有已经加载的版本都需要预加载, 已经创建的实例可能使用旧版本
+	allWorkflowConfigs := make([]*WorkflowConfig, 0)
 	errorlist := make([]error, 0)
 	var err error
-	workflowConfigs.Range(func(key, value interface{}) bool {
-		workflowType, ok := key.(string)
+	r.configs.Range(func(key, value interface{}) bool {
+		config, ok := value.(*WorkflowConfig)
 		if !ok {
-			err = errors.New("workflowType is not string")
+			err = errors.New("workflowConfig is not *WorkflowConfig")
 			return true
 		}
-		allWorkflowTypes = append(allWorkflowTypes, workflowType)
+		allWorkflowConfigs = append(allWorkflowConfigs, config)
 		return true
 	})
 	if err != nil {
 		return errors.WithMessagef(err, "PreloadingWorkflowDefinition failed")
 	}
-	for _, workflowType := range allWorkflowTypes {
-		_, err := GetAndLoadWorkflowDefinition(workflowType)
+	for _, config := range allWorkflowConfigs {
+		_, err := r.GetAndLoadWorkflowDefinitionWithVersion(config.ID, config.getVersion())
 		if err != nil {
 			errorlist = append(errorlist, err)
 		}
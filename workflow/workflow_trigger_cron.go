@@ -0,0 +1,192 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultSchedulerPollInterval = 10 * time.Second
+	defaultSchedulerWorkerCount  = 4
+	defaultSchedulerPageSize     = 100
+)
+
+// SchedulerConfig 后台调度器配置
+type SchedulerConfig struct {
+	PollInterval  time.Duration // 轮询间隔, <=0 使用默认值10s
+	WorkerCount   int           // 并发执行RunWorkflow的worker数量, <=0 使用默认值4
+	PageSize      int64         // 每次分页拉取的工作流实例数量, <=0 使用默认值100
+	WorkflowTypes []string      // 只调度指定的工作流类型, 为空表示调度全部类型
+}
+
+// Scheduler 后台调度器, 定时拉取init/running状态的工作流实例并执行RunWorkflow
+// 多个进程同时调度同一个实例时，依赖WorkflowLock保证同一时刻只有一个进程在执行
+type Scheduler struct {
+	service WorkflowService
+	config  SchedulerConfig
+
+	mu      sync.Mutex
+	running bool
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+func NewScheduler(service WorkflowService, config *SchedulerConfig) *Scheduler {
+	cfg := SchedulerConfig{}
+	if config != nil {
+		cfg = *config
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = defaultSchedulerPollInterval
+	}
+	if cfg.WorkerCount <= 0 {
+		cfg.WorkerCount = defaultSchedulerWorkerCount
+	}
+	if cfg.PageSize <= 0 {
+		cfg.PageSize = defaultSchedulerPageSize
+	}
+	return &Scheduler{service: service, config: cfg}
+}
+
+/**
+ * @description: 启动调度器, 非阻塞, 后台goroutine按照PollInterval轮询
+ *               ctx被取消或者调用Stop都会停止轮询
+ * @param ctx context.Context
+ * @return error 调度器已经启动时返回错误
+ */
+func (s *Scheduler) Start(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running {
+		return errors.New("scheduler already started")
+	}
+	loopCtx, cancel := context.WithCancel(ctx)
+	s.running = true
+	s.cancel = cancel
+	s.done = make(chan struct{})
+	go s.loop(loopCtx, s.done)
+	return nil
+}
+
+/**
+ * @description: 停止调度器, 阻塞直到正在执行的RunWorkflow全部结束
+ */
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	if !s.running {
+		s.mu.Unlock()
+		return
+	}
+	s.running = false
+	cancel, done := s.cancel, s.done
+	s.mu.Unlock()
+
+	cancel()
+	<-done
+}
+
+func (s *Scheduler) loop(ctx context.Context, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(s.config.PollInterval)
+	defer ticker.Stop()
+	for {
+		if err := s.RunOnce(ctx); err != nil && ctx.Err() == nil {
+			slog.ErrorContext(ctx, fmt.Sprintf("[error]Scheduler RunOnce failed, err: %v", err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+/**
+ * @description: 执行一轮调度, 分页拉取所有待执行的工作流实例并交给worker池执行
+ *               本轮派发的实例全部执行完才返回; ctx取消后不再派发新的实例, 但是已经派发的会执行完
+ * @param ctx context.Context
+ * @return error
+ */
+func (s *Scheduler) RunOnce(ctx context.Context) error {
+	// 前一个实例结束时没有开始的排队实例, 改成init之后在本轮执行
+	if err := s.service.StartQueuedWorkflowInstances(ctx, s.config.WorkflowTypes); err != nil {
+		slog.WarnContext(ctx, fmt.Sprintf("[warn]Scheduler StartQueuedWorkflowInstances failed, err: %v", err))
+	}
+	jobs := make(chan int64)
+	wg := sync.WaitGroup{}
+	// 已经派发的实例需要执行完, 不能因为调度器停止而中断
+	runCtx := context.WithoutCancel(ctx)
+	for i := 0; i < s.config.WorkerCount; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for workflowInstanceID := range jobs {
+				s.runWorkflow(runCtx, workflowInstanceID)
+			}
+		}()
+	}
+	err := s.dispatch(ctx, jobs)
+	close(jobs)
+	wg.Wait()
+	return err
+}
+
+func (s *Scheduler) dispatch(ctx context.Context, jobs chan<- int64) error {
+	lastID := int64(0)
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+		queryParams := &QueryWorkflowInstanceParams{
+			StatusIn:      []string{WorkflowInstanceStatusInit, WorkflowInstanceStatusRunning},
+			IDGreaterThan: Int64(lastID),
+			OrderbyIDAsc:  Bool(true),
+			// 节点都在等待重试或者延时唤醒的实例还没有到时间, 不用执行
+			NextWakeUpAtBefore: Int64(time.Now().Unix()),
+			Page: &Pager{
+				Page: 1,
+				Size: s.config.PageSize,
+			},
+		}
+		if len(s.config.WorkflowTypes) > 0 {
+			queryParams.WorkflowTypeIn = s.config.WorkflowTypes
+		}
+		workflowInstances, err := s.service.QueryWorkflowInstancePo(ctx, queryParams)
+		if err != nil {
+			return errors.WithMessagef(err, "QueryWorkflowInstancePo failed, lastID: %d", lastID)
+		}
+		for _, workflowInstance := range workflowInstances {
+			select {
+			case <-ctx.Done():
+				return nil
+			case jobs <- workflowInstance.ID:
+			}
+			lastID = workflowInstance.ID
+		}
+		if int64(len(workflowInstances)) < s.config.PageSize {
+			return nil
+		}
+	}
+}
+
+func (s *Scheduler) runWorkflow(ctx context.Context, workflowInstanceID int64) {
+	err := s.service.RunWorkflow(ctx, workflowInstanceID)
+	if err == nil {
+		return
+	}
+	if errors.Is(err, LockFailedError) {
+		// 其他进程正在执行该实例, 跳过即可
+		slog.DebugContext(ctx, fmt.Sprintf("Scheduler RunWorkflow skipped, locked by others, workflowInstanceID: %d", workflowInstanceID))
+		return
+	}
+	if IsSeriousError(err) {
+		slog.ErrorContext(ctx, fmt.Sprintf("[error]Scheduler RunWorkflow failed, workflowInstanceID: %d, err: %v", workflowInstanceID, err))
+		return
+	}
+	slog.WarnContext(ctx, fmt.Sprintf("[warn]Scheduler RunWorkflow failed, workflowInstanceID: %d, err: %v", workflowInstanceID, err))
+}
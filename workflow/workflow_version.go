@@ -0,0 +1,51 @@
+package workflow
+
+import "fmt"
+
+// defaultWorkflowVersion 工作流配置没有指定版本号时使用的版本
+const defaultWorkflowVersion int64 = 1
+
+// getVersion 没有指定版本号时使用默认版本, 兼容没有版本号的旧配置
+func (c *WorkflowConfig) getVersion() int64 {
+	if c.Version <= 0 {
+		return defaultWorkflowVersion
+	}
+	return c.Version
+}
+
+func workflowVersionKey(workflowType string, version int64) string {
+	return fmt.Sprintf("%s@v%d", workflowType, version)
+}
+
+// getLatestWorkflowVersion 获取工作流类型已经加载的最大版本号
+func (r *Registry) getLatestWorkflowVersion(workflowType string) (int64, bool) {
+	i, ok := r.latestVersions.Load(workflowType)
+	if !ok {
+		return 0, false
+	}
+	version, ok := i.(int64)
+	return version, ok
+}
+
+// resolveWorkflowVersion version<=0 表示使用最新版本, 工作流类型没有加载过配置时返回false
+func (r *Registry) resolveWorkflowVersion(workflowType string, version int64) (int64, bool) {
+	if version > 0 {
+		return version, true
+	}
+	return r.getLatestWorkflowVersion(workflowType)
+}
+
+// storeWorkflowConfig 保存一个版本的工作流配置, 同时更新最新版本号, 调用方需要持有loadLock
+func (r *Registry) storeWorkflowConfig(config *WorkflowConfig) {
+	version := config.getVersion()
+	r.configs.Store(workflowVersionKey(config.ID, version), config)
+	if latest, ok := r.getLatestWorkflowVersion(config.ID); !ok || version > latest {
+		r.latestVersions.Store(config.ID, version)
+	}
+}
+
+// getWorkflowDefinitionForInstance 按照实例创建时固定的版本获取工作流定义
+// 实例的版本号为0表示创建时没有找到工作流定义, 使用最新版本
+func (s *WorkflowServiceImpl) getWorkflowDefinitionForInstance(workflowInstance *WorkflowInstancePo) (*WorkflowDefinition, error) {
+	return s.registry.GetAndLoadWorkflowDefinitionWithVersion(workflowInstance.WorkflowType, workflowInstance.WorkflowVersion)
+}
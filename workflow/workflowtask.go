@@ -24,6 +24,35 @@ type WorkflowTaskNodeWorker interface {
 	AsynchronousWaitCheck(ctx context.Context, nodeContext *JSONContext) error
 }
 
+// WorkflowTaskNodeCompensator 工作流任务节点补偿器, 可选接口, 工作器实现后支持saga式的补偿
+// 工作流失败或者取消时, 已经完成的任务实例按照完成时间倒序调用Compensate, 用于撤销扣款、释放预占等副作用
+type WorkflowTaskNodeCompensator interface {
+	/**
+	 * @description:  补偿已经完成的任务
+	 * @return error nil表示补偿成功; ErrWorkflowTaskFailedWithFailed 表示补偿失败并且不再重试;
+	 *               其他错误会中断补偿, 下次补偿时从这个任务继续
+	 * @param ctx context.Context 上下文
+	 * @param nodeContext *JSONContext 任务完成时的节点上下文, 补偿中更改了nodeContext,那么就会同步更改到数据库里面
+	 */
+	Compensate(ctx context.Context, nodeContext *JSONContext) error
+}
+
+// NodeKind 节点类型, 内置类型的节点不需要注册工作器, 由框架实现
+type NodeKind = string
+
+const (
+	// 普通节点, 需要通过RegisterWorkflowTask注册工作器(默认)
+	NodeKindNormal NodeKind = "normal"
+	// 子工作流节点, 创建并等待一个子工作流实例完成
+	NodeKindSubWorkflow NodeKind = "sub_workflow"
+	// 延时节点, 等待固定时长或者到达上下文中指定的时间后完成
+	NodeKindDelay NodeKind = "delay"
+	// 等待事件节点, 等待AddNodeExternalEvent写入满足条件的事件后完成
+	NodeKindWaitEvent NodeKind = "wait_event"
+	// map节点, 对上下文中数组的每个元素执行一次注册的工作器, 输出按下标汇总成数组
+	NodeKindMap NodeKind = "map"
+)
+
 var defaultEmptyTaskWorker WorkflowTaskNodeWorker = &EmptyTaskWorker{}
 
 type EmptyTaskWorker struct {
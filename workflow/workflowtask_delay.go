@@ -0,0 +1,81 @@
+package workflow
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DelayConfig 延时节点配置, duration 和 until_path 二选一
+type DelayConfig struct {
+	Duration int64 `json:"duration"` // 固定延时, 单位秒, 从节点开始执行时计算
+	// 唤醒时间在节点上下文中的路径, 按 "." 分隔, 例如 workflow_context.remind_at
+	// 值可以是秒级时间戳或者RFC3339格式的时间字符串
+	UntilPath string `json:"until_path"`
+}
+
+func (c *DelayConfig) check() error {
+	if c == nil {
+		return errors.New("delay config is required")
+	}
+	if (c.Duration > 0) == (c.UntilPath != "") {
+		return errors.New("exactly one of delay.duration and delay.until_path must be set")
+	}
+	return nil
+}
+
+// wakeUpAt 计算唤醒时间, 单位秒
+func (c *DelayConfig) wakeUpAt(nodeContext *JSONContext) (int64, error) {
+	if c.Duration > 0 {
+		return time.Now().Unix() + c.Duration, nil
+	}
+	value, ok := nodeContext.Get(strings.Split(c.UntilPath, ".")...)
+	if !ok {
+		return 0, errors.Errorf("delay until_path %s not found in node context", c.UntilPath)
+	}
+	if timeStr, ok := value.(string); ok {
+		t, err := time.Parse(time.RFC3339, timeStr)
+		if err != nil {
+			return 0, errors.Errorf("delay until_path %s is not a RFC3339 time: %s", c.UntilPath, timeStr)
+		}
+		return t.Unix(), nil
+	}
+	ts, ok := nodeContext.GetInt64(strings.Split(c.UntilPath, ".")...)
+	if !ok {
+		return 0, errors.Errorf("delay until_path %s is not a timestamp", c.UntilPath)
+	}
+	return ts, nil
+}
+
+// delayTaskWorker 延时节点工作器
+// Run 计算唤醒时间, 记录在任务实例的wake_up_at以及节点上下文的 delay.wake_up_at 中
+// 唤醒时间之前执行引擎不会处理这个节点, AsynchronousWaitCheck 到时间后完成
+type delayTaskWorker struct {
+	taskInstance *WorkflowTaskNode
+	config       *DelayConfig
+}
+
+func (w *delayTaskWorker) Run(ctx context.Context, nodeContext *JSONContext) error {
+	wakeUpAt, err := w.config.wakeUpAt(nodeContext)
+	if err != nil {
+		// 配置或者上下文有问题, 重试也不会成功
+		nodeContext.Set([]string{NodeContextKeyReason}, "延时节点唤醒时间不正确")
+		return errors.WithMessagef(ErrWorkflowTaskFailedWithFailed, "%v", err)
+	}
+	w.taskInstance.WakeUpAt = wakeUpAt
+	nodeContext.Set([]string{NodeContextKeyDelay, "wake_up_at"}, wakeUpAt)
+	return nil
+}
+
+func (w *delayTaskWorker) AsynchronousWaitCheck(ctx context.Context, nodeContext *JSONContext) error {
+	wakeUpAt, ok := nodeContext.GetInt64(NodeContextKeyDelay, "wake_up_at")
+	if !ok {
+		wakeUpAt = w.taskInstance.WakeUpAt
+	}
+	if time.Now().Unix() < wakeUpAt {
+		return ErrorWorkflowTaskInstanceNotReady
+	}
+	return nil
+}
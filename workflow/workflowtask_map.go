@@ -0,0 +1,308 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// MapConfig map节点配置, 节点注册的工作器会对数组中的每个元素执行一次
+// 每个元素对应一个子任务实例(任务实例的item_index为元素下标), 子任务全部完成后map节点完成
+type MapConfig struct {
+	// 数组在节点上下文中的路径, 按 "." 分隔, 例如 workflow_context.orders
+	ItemsPath string `json:"items_path"`
+	// 同时执行的子任务数量上限, <=0 表示不限制
+	Concurrency int64 `json:"concurrency"`
+}
+
+func (c *MapConfig) check() error {
+	if c == nil || c.ItemsPath == "" {
+		return errors.New("map.items_path is required")
+	}
+	return nil
+}
+
+// mapTaskWorker map节点工作器
+// Run 检查输入是否是数组, 元素数量记录在节点上下文的 map.count 中
+// AsynchronousWaitCheck 按照并发上限创建并执行子任务, 子任务全部完成后输出按下标汇总到 map.results 中
+// 子任务的上下文: workflow_context, pre_node_context 和map节点一致, map.item 为当前元素, map.item_index 为元素下标
+// 子任务的输出: 子任务上下文中除去输入之外的内容
+type mapTaskWorker struct {
+	service      *WorkflowServiceImpl
+	state        *workflowRunState
+	taskNode     *WorkflowTaskNodeDefinition
+	taskInstance *WorkflowTaskNode
+}
+
+func (w *mapTaskWorker) Run(ctx context.Context, nodeContext *JSONContext) error {
+	items, err := w.getItems(nodeContext)
+	if err != nil {
+		// 上下文有问题, 重试也不会成功
+		nodeContext.Set([]string{NodeContextKeyReason}, "map节点的输入不是数组")
+		return errors.WithMessagef(ErrWorkflowTaskFailedWithFailed, "%v", err)
+	}
+	nodeContext.Set([]string{NodeContextKeyMap, "count"}, len(items))
+	return nil
+}
+
+func (w *mapTaskWorker) AsynchronousWaitCheck(ctx context.Context, nodeContext *JSONContext) error {
+	items, err := w.getItems(nodeContext)
+	if err != nil {
+		nodeContext.Set([]string{NodeContextKeyReason}, "map节点的输入不是数组")
+		return errors.WithMessagef(ErrWorkflowTaskFailedWithFailed, "%v", err)
+	}
+	itemInstances, err := w.queryItemInstances(ctx)
+	if err != nil {
+		return err
+	}
+	limit := len(items)
+	if w.taskNode.Map.Concurrency > 0 && int(w.taskNode.Map.Concurrency) < limit {
+		limit = int(w.taskNode.Map.Concurrency)
+	}
+	runningCount := 0
+	for index := range items {
+		itemInstance, ok := itemInstances[int64(index)]
+		if !ok {
+			continue
+		}
+		if itemInstance.Status == WorkflowTaskNodeStatusFailed || itemInstance.Status == WorkflowTaskNodeStatusCancelled {
+			// 子任务失败, map节点跟着失败
+			return w.failed(ctx, nodeContext, itemInstances, errors.WithMessagef(ErrWorkflowTaskFailedWithFailed, "map item %d is %s", index, itemInstance.Status))
+		}
+		if !IsOverWorkflowTaskNodeStatus(itemInstance.Status) {
+			runningCount++
+		}
+	}
+	// 按照下标顺序创建子任务, 执行中的子任务数量不超过并发上限
+	for index, item := range items {
+		if runningCount >= limit {
+			break
+		}
+		if _, ok := itemInstances[int64(index)]; ok {
+			continue
+		}
+		itemInstance, err := w.createItemInstance(ctx, nodeContext, int64(index), item)
+		if err != nil {
+			return err
+		}
+		itemInstances[int64(index)] = itemInstance
+		runningCount++
+	}
+
+	// 并发执行未结束的子任务
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var fatalErr error
+	for index, item := range items {
+		itemInstance, ok := itemInstances[int64(index)]
+		if !ok || IsOverWorkflowTaskNodeStatus(itemInstance.Status) {
+			continue
+		}
+		if itemInstance.NextRetryAt > time.Now().Unix() {
+			continue
+		}
+		wg.Add(1)
+		go func(index int, item any, itemInstance *WorkflowTaskNode) {
+			defer wg.Done()
+			err := w.runItem(ctx, nodeContext, item, itemInstance)
+			if err == nil {
+				return
+			}
+			if errors.Is(err, ErrWorkflowTaskFailedWithFailed) {
+				mu.Lock()
+				if fatalErr == nil {
+					fatalErr = errors.WithMessagef(err, "map item %d failed", index)
+				}
+				mu.Unlock()
+				return
+			}
+			// 其他错误等待下次重试
+			slog.WarnContext(ctx, fmt.Sprintf("[warn]map item run failed, workflowInstanceID: %d, taskType: %s, itemIndex: %d, err: %v", w.taskInstance.WorkflowInstanceID, w.taskNode.TaskType, index, err))
+		}(index, item, itemInstance)
+	}
+	wg.Wait()
+	if fatalErr != nil {
+		return w.failed(ctx, nodeContext, itemInstances, fatalErr)
+	}
+
+	results := make([]any, 0, len(items))
+	for index := range items {
+		itemInstance, ok := itemInstances[int64(index)]
+		if !ok || itemInstance.Status != WorkflowTaskNodeStatusCompleted {
+			return ErrorWorkflowTaskInstanceNotReady
+		}
+		results = append(results, buildMapItemOutput(itemInstance.NodeContext))
+	}
+	nodeContext.Set([]string{NodeContextKeyMap, "results"}, results)
+	return nil
+}
+
+func (w *mapTaskWorker) getItems(nodeContext *JSONContext) ([]any, error) {
+	value, ok := nodeContext.Get(strings.Split(w.taskNode.Map.ItemsPath, ".")...)
+	if !ok {
+		return nil, errors.Errorf("map items_path %s not found in node context", w.taskNode.Map.ItemsPath)
+	}
+	items, ok := value.([]any)
+	if !ok {
+		return nil, errors.Errorf("map items_path %s is not an array", w.taskNode.Map.ItemsPath)
+	}
+	return items, nil
+}
+
+// queryItemInstances 查询map节点的所有子任务, key为元素下标
+func (w *mapTaskWorker) queryItemInstances(ctx context.Context) (map[int64]*WorkflowTaskNode, error) {
+	fetchCount := 100
+	page := 1
+	ret := make(map[int64]*WorkflowTaskNode)
+	for {
+		taskInstances, err := w.service.repo.QueryWorkflowTaskInstance(ctx, &QueryWorkflowTaskInstanceParams{
+			WorkflowInstanceID: &w.taskInstance.WorkflowInstanceID,
+			TaskType:           &w.taskNode.TaskType,
+			IsMapItem:          Bool(true),
+			Generation:         &w.taskInstance.Generation,
+			OrderbyIDAsc:       Bool(true),
+			Page: &Pager{
+				Page: int64(page),
+				Size: int64(fetchCount),
+			},
+		})
+		if err != nil {
+			return nil, errors.WithMessagef(err, "QueryWorkflowTaskInstance failed, workflowInstanceID: %d, taskType: %s", w.taskInstance.WorkflowInstanceID, w.taskNode.TaskType)
+		}
+		for _, taskInstance := range taskInstances {
+			ret[*taskInstance.ItemIndex] = &WorkflowTaskNode{
+				ID:                 taskInstance.ID,
+				WorkflowInstanceID: taskInstance.WorkflowInstanceID,
+				TaskType:           taskInstance.TaskType,
+				Status:             taskInstance.Status,
+				NodeContext:        NewByte2StrctPbValue(taskInstance.NodeContext),
+				CreatedAt:          taskInstance.CreatedAt,
+				UpdatedAt:          taskInstance.UpdatedAt,
+				FailCount:          taskInstance.FailCount,
+				NextRetryAt:        taskInstance.NextRetryAt,
+				WakeUpAt:           taskInstance.WakeUpAt,
+				ItemIndex:          taskInstance.ItemIndex,
+				Generation:         taskInstance.Generation,
+			}
+		}
+		if len(taskInstances) < fetchCount {
+			break
+		}
+		page++
+	}
+	return ret, nil
+}
+
+func (w *mapTaskWorker) buildItemContext(nodeContext *JSONContext, index int64, item any) *JSONContext {
+	itemContext := NewJSONContext(nil)
+	input := nodeContext.Clone()
+	for _, key := range []string{NodeContextKeyWorkflowContext, NodeContextKeyPreNodeContext} {
+		if value, ok := input.Get(key); ok {
+			itemContext.Set([]string{key}, value)
+		}
+	}
+	itemContext.Set([]string{NodeContextKeyMap, "item"}, item)
+	itemContext.Set([]string{NodeContextKeyMap, "item_index"}, index)
+	return itemContext
+}
+
+func (w *mapTaskWorker) createItemInstance(ctx context.Context, nodeContext *JSONContext, index int64, item any) (*WorkflowTaskNode, error) {
+	taskInstancePo, err := w.service.repo.CreateWorkflowTaskInstance(ctx, &WorkflowTaskInstancePo{
+		WorkflowInstanceID: w.taskInstance.WorkflowInstanceID,
+		TaskType:           w.taskNode.TaskType,
+		Status:             WorkflowTaskNodeStatusRunning,
+		NodeContext:        w.buildItemContext(nodeContext, index, item).ToBytesWithoutError(),
+		CreatedAt:          time.Now().Unix(),
+		UpdatedAt:          time.Now().Unix(),
+		ItemIndex:          Int64(index),
+		Generation:         w.taskInstance.Generation,
+	})
+	if err != nil {
+		return nil, errors.WithMessagef(err, "CreateWorkflowTaskInstance failed, workflowInstanceID: %d, taskType: %s, itemIndex: %d", w.taskInstance.WorkflowInstanceID, w.taskNode.TaskType, index)
+	}
+	return &WorkflowTaskNode{
+		ID:                 taskInstancePo.ID,
+		WorkflowInstanceID: taskInstancePo.WorkflowInstanceID,
+		TaskType:           taskInstancePo.TaskType,
+		Status:             taskInstancePo.Status,
+		NodeContext:        NewByte2StrctPbValue(taskInstancePo.NodeContext),
+		CreatedAt:          taskInstancePo.CreatedAt,
+		UpdatedAt:          taskInstancePo.UpdatedAt,
+		ItemIndex:          taskInstancePo.ItemIndex,
+		Generation:         taskInstancePo.Generation,
+	}, nil
+}
+
+// runItem 执行单个子任务, 复用普通节点的执行流程, 失败次数和重试策略使用map节点的配置
+// 子任务失败时只更新子任务自己, 由AsynchronousWaitCheck决定map节点的结果
+func (w *mapTaskWorker) runItem(ctx context.Context, nodeContext *JSONContext, item any, itemInstance *WorkflowTaskNode) error {
+	if itemInstance.Status == WorkflowTaskNodeStatusRestarting {
+		// map节点被重启, 子任务重新初始化上下文
+		w.state.setTaskNodeStatus(itemInstance, WorkflowTaskNodeStatusRunning)
+		itemInstance.NodeContext = w.buildItemContext(nodeContext, *itemInstance.ItemIndex, item)
+		err := w.service.repo.UpdateWorkflowTaskInstance(ctx, &UpdateWorkflowTaskInstanceParams{
+			Where: &UpdateWorkflowTaskInstanceWhere{
+				IDIn: []int64{itemInstance.ID},
+			},
+			Fields: &UpdateWorkflowTaskInstanceField{
+				NodeContext: itemInstance.NodeContext,
+				Status:      &itemInstance.Status,
+			},
+			LimitMax: 1,
+		})
+		if err != nil {
+			return errors.WithMessagef(err, "UpdateWorkflowTaskInstance failed, taskInstanceID: %d", itemInstance.ID)
+		}
+	}
+	itemNode := &WorkflowTaskNodeDefinition{
+		TaskType:         w.taskNode.TaskType,
+		TaskName:         w.taskNode.TaskName,
+		FailMaxCount:     w.taskNode.FailMaxCount,
+		RetryPolicy:      w.taskNode.RetryPolicy,
+		ExecutionTimeout: w.taskNode.ExecutionTimeout, // 执行超时限制的是每个子任务的工作器调用
+		Kind:             NodeKindNormal,
+		TaskWorker:       w.taskNode.TaskWorker,
+	}
+	return w.service.taskRun(ctx, w.state, itemNode, itemInstance)
+}
+
+// failed 子任务失败时取消其他还没有结束的子任务, 返回map节点的失败错误
+func (w *mapTaskWorker) failed(ctx context.Context, nodeContext *JSONContext, itemInstances map[int64]*WorkflowTaskNode, err error) error {
+	nodeContext.Set([]string{NodeContextKeyReason}, "map节点的子任务失败")
+	cancelIDs := make([]int64, 0)
+	for _, itemInstance := range itemInstances {
+		if !IsOverWorkflowTaskNodeStatus(itemInstance.Status) {
+			cancelIDs = append(cancelIDs, itemInstance.ID)
+		}
+	}
+	if len(cancelIDs) > 0 {
+		newErr := w.service.repo.UpdateWorkflowTaskInstance(ctx, &UpdateWorkflowTaskInstanceParams{
+			Where: &UpdateWorkflowTaskInstanceWhere{
+				IDIn: cancelIDs,
+			},
+			Fields: &UpdateWorkflowTaskInstanceField{
+				Status: String(WorkflowTaskNodeStatusCancelled),
+			},
+			LimitMax: len(cancelIDs),
+		})
+		if newErr != nil {
+			slog.ErrorContext(ctx, fmt.Sprintf("UpdateWorkflowTaskInstance failed,err: %v", newErr))
+		}
+	}
+	return err
+}
+
+// buildMapItemOutput 子任务的输出, 去掉子任务上下文中的输入和系统信息
+func buildMapItemOutput(itemContext *JSONContext) map[string]any {
+	output := itemContext.Clone()
+	output.Delete(NodeContextKeyWorkflowContext)
+	output.Delete(NodeContextKeyPreNodeContext)
+	output.Delete(NodeContextKeyMap)
+	output.Delete(NodeContextKeySystem)
+	return output.ToMap()
+}
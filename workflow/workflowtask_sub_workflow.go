@@ -0,0 +1,140 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// SubWorkflowConfig 子工作流节点配置
+type SubWorkflowConfig struct {
+	WorkflowType string `json:"workflow_type"` // 子工作流类型
+	// 子工作流上下文在节点上下文中的路径, 按 "." 分隔, 例如 pre_node_context.collect_info
+	// 为空时使用父工作流的上下文
+	InputPath string `json:"input_path"`
+}
+
+func (c *SubWorkflowConfig) check(workflowType string) error {
+	if c == nil || c.WorkflowType == "" {
+		return errors.New("sub_workflow.workflow_type is required")
+	}
+	if c.WorkflowType == workflowType {
+		return errors.Errorf("sub workflow can not be the workflow itself, workflowType: %s", workflowType)
+	}
+	return nil
+}
+
+// subWorkflowTaskWorker 子工作流节点工作器
+// Run 创建子工作流实例, 实例ID记录在节点上下文的 sub_workflow.workflow_instance_id 中
+// AsynchronousWaitCheck 推进子工作流并检查状态, 子工作流完成时节点完成, 失败或者取消时节点跟着失败或者取消
+type subWorkflowTaskWorker struct {
+	service          *WorkflowServiceImpl
+	workflowInstance *WorkflowInstance // 父工作流实例
+	taskInstance     *WorkflowTaskNode // 子工作流节点的任务实例
+	config           *SubWorkflowConfig
+}
+
+func (w *subWorkflowTaskWorker) Run(ctx context.Context, nodeContext *JSONContext) error {
+	if childID, ok := nodeContext.GetInt64(NodeContextKeySubWorkflow, "workflow_instance_id"); ok && childID > 0 {
+		// 子工作流已经创建过了, 不重复创建
+		return nil
+	}
+	var input map[string]any
+	if w.config.InputPath == "" {
+		input = w.workflowInstance.WorkflowContext.Clone().ToMap()
+	} else {
+		value, ok := nodeContext.Get(strings.Split(w.config.InputPath, ".")...)
+		if ok {
+			input, ok = value.(map[string]any)
+		}
+		if !ok {
+			return errors.WithMessagef(ErrWorkflowTaskFailedWithFailed, "sub workflow input %s is not an object", w.config.InputPath)
+		}
+	}
+	// 子工作流实例ID在Run返回之后才保存到节点上下文, 中途崩溃或者保存失败后再次执行时返回已经创建的子工作流
+	// 循环的每一轮是新的任务实例, 重启时重启次数加1, 都会创建新的子工作流
+	restartCount, _ := nodeContext.GetInt64(NodeContextKeySystem, nodeContextKeyRestartCount)
+	child, err := w.service.CreateWorkflow(ctx, &CreateWorkflowReq{
+		WorkflowType:             w.config.WorkflowType,
+		BusinessID:               w.workflowInstance.BusinessID,
+		Context:                  input,
+		ParentWorkflowInstanceID: w.workflowInstance.ID,
+		IdempotencyPolicy:        IdempotencyPolicyAny,
+		IdempotencyKey:           fmt.Sprintf("sub_workflow:%d:%d:%d", w.workflowInstance.ID, w.taskInstance.ID, restartCount),
+	})
+	if err != nil {
+		return errors.WithMessagef(err, "CreateWorkflow failed, subWorkflowType: %s", w.config.WorkflowType)
+	}
+	nodeContext.Set([]string{NodeContextKeySubWorkflow, "workflow_type"}, child.WorkflowType)
+	nodeContext.Set([]string{NodeContextKeySubWorkflow, "workflow_instance_id"}, child.ID)
+	nodeContext.Set([]string{NodeContextKeySubWorkflow, "status"}, child.Status)
+	return nil
+}
+
+func (w *subWorkflowTaskWorker) AsynchronousWaitCheck(ctx context.Context, nodeContext *JSONContext) error {
+	childID, ok := nodeContext.GetInt64(NodeContextKeySubWorkflow, "workflow_instance_id")
+	if !ok || childID <= 0 {
+		return errors.WithMessage(ErrWorkflowTaskFailedWithFailed, "sub workflow instance id not found in node context")
+	}
+	child, err := w.querySubWorkflowInstance(ctx, childID)
+	if err != nil {
+		return err
+	}
+	if !IsOverWorkflowInstanceStatus(child.Status) {
+		// 子工作流还没有结束, 顺便推进一下子工作流
+		err = w.service.RunWorkflow(ctx, childID)
+		if err != nil && !errors.Is(err, LockFailedError) {
+			slog.WarnContext(ctx, fmt.Sprintf("RunWorkflow sub workflow failed, workflowInstanceID: %d, err: %v", childID, err))
+		}
+		child, err = w.querySubWorkflowInstance(ctx, childID)
+		if err != nil {
+			return err
+		}
+	}
+	nodeContext.Set([]string{NodeContextKeySubWorkflow, "status"}, child.Status)
+	switch child.Status {
+	case WorkflowInstanceStatusCompleted:
+		// 子工作流的最终上下文作为节点的输出
+		nodeContext.Set([]string{NodeContextKeySubWorkflow, "workflow_context"}, NewByte2StrctPbValue(child.WorkflowContext).ToMap())
+		endTaskInstances, err := w.service.repo.QueryWorkflowTaskInstance(ctx, &QueryWorkflowTaskInstanceParams{
+			WorkflowInstanceID: &childID,
+			TaskType:           String(endTaskNode),
+			Page:               &Pager{Page: 1, Size: 1},
+		})
+		if err != nil {
+			return errors.WithMessagef(err, "QueryWorkflowTaskInstance failed, workflowInstanceID: %d", childID)
+		}
+		if len(endTaskInstances) > 0 {
+			output, _ := NewByte2StrctPbValue(endTaskInstances[0].NodeContext).Get(NodeContextKeyPreNodeContext)
+			nodeContext.Set([]string{NodeContextKeySubWorkflow, "output"}, output)
+		}
+		return nil
+	case WorkflowInstanceStatusCancelled:
+		nodeContext.Set([]string{NodeContextKeyReason}, "子工作流被取消")
+		return errors.WithMessagef(ErrWorkflowTaskCancelled, "sub workflow cancelled, workflowInstanceID: %d", childID)
+	case WorkflowInstanceStatusFailed:
+		nodeContext.Set([]string{NodeContextKeyReason}, "子工作流失败")
+		return errors.WithMessagef(ErrWorkflowTaskFailedWithFailed, "sub workflow failed, workflowInstanceID: %d", childID)
+	case WorkflowInstanceStatusTimedOut:
+		nodeContext.Set([]string{NodeContextKeyReason}, "子工作流超时")
+		return errors.WithMessagef(ErrWorkflowTaskFailedWithFailed, "sub workflow timed out, workflowInstanceID: %d", childID)
+	}
+	return ErrorWorkflowTaskInstanceNotReady
+}
+
+func (w *subWorkflowTaskWorker) querySubWorkflowInstance(ctx context.Context, childID int64) (*WorkflowInstancePo, error) {
+	children, err := w.service.repo.QueryWorkflowInstance(ctx, &QueryWorkflowInstanceParams{
+		WorkflowInstanceID: &childID,
+		Page:               &Pager{Page: 1, Size: 1},
+	})
+	if err != nil {
+		return nil, errors.WithMessagef(err, "QueryWorkflowInstance failed, workflowInstanceID: %d", childID)
+	}
+	if len(children) == 0 {
+		return nil, errors.WithMessagef(ErrWorkflowTaskFailedWithFailed, "sub workflow instance not found, workflowInstanceID: %d", childID)
+	}
+	return children[0], nil
+}
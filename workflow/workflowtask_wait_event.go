@@ -0,0 +1,126 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// 超时后节点失败, 工作流失败(默认)
+	WaitEventOnTimeoutFail = "fail"
+	// 超时后节点完成, 只走timeout_node分支
+	WaitEventOnTimeoutBranch = "branch"
+)
+
+// WaitEventConfig 等待事件节点配置, 节点一直等待直到通过AddNodeExternalEvent收到满足filter的事件
+// 超时时间使用节点的max_wait_time_ts
+type WaitEventConfig struct {
+	// 事件过滤条件, 为空表示收到任意事件都完成, 语法参考Condition
+	// 可以使用的路径: event(事件内容,json会被解析), event_ts(事件时间), 例如 event.approved == true
+	Filter string `json:"filter"`
+	// 超时处理方式, fail/branch, 为空等同于fail
+	OnTimeout string `json:"on_timeout"`
+	// 超时分支节点ID, on_timeout为branch时必填, 必须在next_nodes中
+	// 超时后只走这个分支, 正常收到事件时不走这个分支
+	TimeoutNode string `json:"timeout_node"`
+
+	filter *Condition
+}
+
+// parse 检查配置并解析过滤条件, 返回解析后的副本, 不修改调用方的配置, 同一份配置可以用于多个版本的工作流定义
+// c为nil时使用默认配置
+func (c *WaitEventConfig) parse(nextNodes []string, maxWaitTimeTs int64) (*WaitEventConfig, error) {
+	parsed := &WaitEventConfig{}
+	if c != nil {
+		parsed.Filter, parsed.OnTimeout, parsed.TimeoutNode = c.Filter, c.OnTimeout, c.TimeoutNode
+	}
+	if parsed.Filter != "" {
+		filter, err := ParseCondition(parsed.Filter)
+		if err != nil {
+			return nil, errors.WithMessage(err, "wait_event.filter is invalid")
+		}
+		parsed.filter = filter
+	}
+	switch parsed.OnTimeout {
+	case "", WaitEventOnTimeoutFail:
+		return parsed, nil
+	case WaitEventOnTimeoutBranch:
+		if !slices.Contains(nextNodes, parsed.TimeoutNode) {
+			return nil, errors.Errorf("wait_event.timeout_node %q is not in next_nodes", parsed.TimeoutNode)
+		}
+		if maxWaitTimeTs <= 0 {
+			return nil, errors.New("max_wait_time_ts is required when wait_event.on_timeout is branch")
+		}
+		return parsed, nil
+	}
+	return nil, errors.Errorf("wait_event.on_timeout %q is invalid", parsed.OnTimeout)
+}
+
+// buildNextNodeConditions 超时分支模式下, 超时只走timeout_node, 否则不走timeout_node, 会和节点自己配置的分支条件合并
+func (c *WaitEventConfig) buildNextNodeConditions(nextNodes []string, conditions map[string]string) (map[string]*Condition, error) {
+	ret := make(map[string]*Condition)
+	if c == nil || c.OnTimeout != WaitEventOnTimeoutBranch {
+		return ret, nil
+	}
+	for _, nextNodeID := range nextNodes {
+		expression := "!" + NodeContextKeyWaitEvent + ".timed_out"
+		if nextNodeID == c.TimeoutNode {
+			expression = NodeContextKeyWaitEvent + ".timed_out"
+		}
+		if userExpression, ok := conditions[nextNodeID]; ok {
+			expression = "(" + userExpression + ") && " + expression
+		}
+		condition, err := ParseCondition(expression)
+		if err != nil {
+			return nil, err
+		}
+		ret[nextNodeID] = condition
+	}
+	return ret, nil
+}
+
+// waitEventTaskWorker 等待事件节点工作器
+// Run 不做任何事情, 节点直接进入等待状态
+// AsynchronousWaitCheck 检查节点上下文中的node_event是否满足过滤条件, 满足时事件内容作为节点输出
+type waitEventTaskWorker struct {
+	taskInstance  *WorkflowTaskNode
+	maxWaitTimeTs int64
+	config        *WaitEventConfig
+}
+
+func (w *waitEventTaskWorker) Run(ctx context.Context, nodeContext *JSONContext) error {
+	return nil
+}
+
+func (w *waitEventTaskWorker) AsynchronousWaitCheck(ctx context.Context, nodeContext *JSONContext) error {
+	if eventValue, ok := nodeContext.Get(NodeContextKeyNodeEvent); ok {
+		eventMap, _ := eventValue.(map[string]any)
+		content, _ := eventMap["event_content"].(string)
+		var payload any = content
+		var parsed any
+		if err := json.Unmarshal([]byte(content), &parsed); err == nil {
+			payload = parsed
+		}
+		eventContext := NewJSONContextFromMap(map[string]any{
+			"event":    payload,
+			"event_ts": eventMap["event_ts"],
+		})
+		if w.config.filter.Evaluate(eventContext) {
+			nodeContext.Set([]string{NodeContextKeyWaitEvent, "payload"}, payload)
+			nodeContext.Set([]string{NodeContextKeyWaitEvent, "event_ts"}, eventMap["event_ts"])
+			nodeContext.Set([]string{NodeContextKeyWaitEvent, "timed_out"}, false)
+			return nil
+		}
+	}
+	if w.config.OnTimeout == WaitEventOnTimeoutBranch && time.Now().Unix()-w.taskInstance.CreatedAt > w.maxWaitTimeTs {
+		// 超时走超时分支
+		nodeContext.Set([]string{NodeContextKeyWaitEvent, "timed_out"}, true)
+		return nil
+	}
+	// 超时失败由taskRun中的max_wait_time_ts统一处理
+	return ErrorWorkflowTaskInstanceNotReady
+}